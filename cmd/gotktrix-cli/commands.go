@@ -0,0 +1,506 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/diamondburned/gotktrix/internal/gotktrix"
+	"github.com/diamondburned/gotktrix/internal/gotktrix/events/m"
+	"github.com/diamondburned/gotktrix/internal/gotktrix/events/sys"
+	"github.com/diamondburned/gotrix/api"
+	"github.com/diamondburned/gotrix/event"
+	"github.com/diamondburned/gotrix/matrix"
+	"github.com/pkg/errors"
+)
+
+// newFlagSet creates a flag set for the given command.
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s\n", commands[name].usage)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// resolveRoom resolves the given room ID, room alias or room name.
+func resolveRoom(client *gotktrix.Client, room string) (matrix.RoomID, error) {
+	switch {
+	case strings.HasPrefix(room, "!"):
+		return matrix.RoomID(room), nil
+	case strings.HasPrefix(room, "#"):
+		resp, err := client.RoomAlias(room)
+		if err != nil {
+			return "", errors.Wrapf(err, "cannot resolve alias %q", room)
+		}
+		return resp.RoomID, nil
+	}
+
+	roomIDs, err := client.Rooms()
+	if err != nil {
+		return "", errors.Wrap(err, "cannot get rooms")
+	}
+
+	var found []matrix.RoomID
+	for _, roomID := range roomIDs {
+		name, _ := client.RoomName(roomID)
+		if strings.EqualFold(name, room) {
+			found = append(found, roomID)
+		}
+	}
+
+	switch len(found) {
+	case 0:
+		return "", fmt.Errorf("no joined room is named %q", room)
+	case 1:
+		return found[0], nil
+	default:
+		return "", fmt.Errorf("%d rooms are named %q; use a room ID instead", len(found), room)
+	}
+}
+
+func cmdAccounts(ctx context.Context, s *session, args []string) error {
+	p := s.printer()
+
+	for _, acc := range s.accounts {
+		p.print(
+			accountJSON{
+				UserID:   acc.UserID,
+				DeviceID: acc.DeviceID,
+				Server:   acc.Server,
+				Storage:  acc.driver,
+			},
+			"%s\t%s\t%s", acc.UserID, acc.Server, acc.driver,
+		)
+	}
+
+	return p.flush()
+}
+
+func cmdRooms(ctx context.Context, s *session, args []string) error {
+	return listRooms(ctx, s, false)
+}
+
+func cmdUnread(ctx context.Context, s *session, args []string) error {
+	return listRooms(ctx, s, true)
+}
+
+func listRooms(ctx context.Context, s *session, unreadOnly bool) error {
+	client := s.client.Offline()
+	if s.shared {
+		client = s.client.WithContext(ctx)
+	}
+
+	roomIDs, err := s.loadRooms(client)
+	if err != nil {
+		return errors.Wrap(err, "cannot get rooms")
+	}
+
+	p := s.printer()
+
+	for _, roomID := range roomIDs {
+		var unread *int
+		var more bool
+		// Unread counts are only known from syncing.
+		count := "-"
+
+		if !s.shared {
+			n, nMore := client.RoomCountUnread(roomID)
+			if unreadOnly && n == 0 {
+				continue
+			}
+
+			unread, more = &n, nMore
+			count = fmt.Sprint(n)
+			if more {
+				count += "+"
+			}
+		}
+
+		name, _ := client.RoomName(roomID)
+
+		p.print(
+			roomJSON{
+				RoomID:     roomID,
+				Name:       name,
+				Unread:     unread,
+				MoreUnread: more,
+				Encrypted:  client.RoomIsEncrypted(roomID),
+				Space:      client.RoomIsSpace(roomID),
+			},
+			"%s\t%s\t%s", roomID, count, name,
+		)
+	}
+
+	return p.flush()
+}
+
+func cmdMembers(ctx context.Context, s *session, args []string) error {
+	fs := newFlagSet("members")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("missing room")
+	}
+
+	client := s.client.WithContext(ctx)
+
+	roomID, err := s.resolveRoom(client, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	if err := client.RoomEnsureMembers(roomID); err != nil {
+		fmt.Fprintln(os.Stderr, "cannot fetch all members, the list may be incomplete:", err)
+	}
+
+	members, err := client.RoomMembers(roomID)
+	if err != nil {
+		return errors.Wrap(err, "cannot get members")
+	}
+
+	p := s.printer()
+
+	for _, member := range members {
+		var name string
+		if member.DisplayName != nil {
+			name = *member.DisplayName
+		}
+
+		p.print(
+			memberJSON{
+				UserID:      member.UserID,
+				DisplayName: name,
+				Membership:  member.NewState,
+			},
+			"%s\t%s\t%s", member.UserID, member.NewState, name,
+		)
+	}
+
+	return p.flush()
+}
+
+func cmdTail(ctx context.Context, s *session, args []string) error {
+	fs := newFlagSet("tail")
+	count := fs.Int("n", 10, "the number of messages to print")
+	follow := fs.Bool("f", false, "keep printing new messages until interrupted")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("missing room")
+	}
+
+	if *follow && s.shared {
+		return errors.New("cannot follow new messages while gotktrix is running, since that needs syncing")
+	}
+
+	client := s.client.WithContext(ctx)
+
+	roomID, err := s.resolveRoom(client, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	paginate := client.RoomPaginator(roomID, *count).Paginate
+	if s.shared {
+		// The temporary state has no timeline, since it's not synced.
+		paginate = remotePaginator(client, roomID, *count)
+	}
+
+	events, err := latestMessages(ctx, paginate, *count)
+	if err != nil {
+		return err
+	}
+
+	p := s.printer()
+	for _, ev := range events {
+		p.printEvent(client, ev)
+	}
+
+	if err := p.flush(); err != nil || !*follow {
+		return err
+	}
+
+	if s.opts.offline {
+		return errors.New("cannot follow new messages offline")
+	}
+
+	evCh := make(chan event.RoomEvent, 64)
+	unsub := client.SubscribeTimeline(roomID, func(ev event.RoomEvent) {
+		select {
+		case evCh <- ev:
+		case <-ctx.Done():
+		}
+	})
+	defer unsub()
+
+	for {
+		select {
+		case ev := <-evCh:
+			if isMessage(ev) {
+				p.printEvent(client, ev)
+				if err := p.flush(); err != nil {
+					return err
+				}
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// paginateFunc returns the next older events of a room, latest last. It returns
+// no events once there are no more.
+type paginateFunc func(context.Context) ([]event.RoomEvent, error)
+
+// latestMessages returns the latest count messages of the room, latest last.
+// Older messages are paginated until there are enough of them.
+func latestMessages(ctx context.Context, paginate paginateFunc, count int) ([]event.RoomEvent, error) {
+	if count < 1 {
+		return nil, nil
+	}
+
+	var messages []event.RoomEvent
+
+	for len(messages) < count {
+		events, err := paginate(ctx)
+		if err != nil {
+			if len(messages) > 0 {
+				fmt.Fprintln(os.Stderr, "cannot get older messages:", err)
+				break
+			}
+			return nil, errors.Wrap(err, "cannot get messages")
+		}
+		if len(events) == 0 {
+			break
+		}
+
+		// Events are latest last, so walk backwards.
+		for i := len(events) - 1; i >= 0 && len(messages) < count; i-- {
+			if isMessage(events[i]) {
+				messages = append(messages, events[i])
+			}
+		}
+	}
+
+	// Flip the messages so that the latest one is last.
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+
+	return messages, nil
+}
+
+// remotePaginator paginates the messages of the room from the latest one using
+// only the homeserver's /messages endpoint.
+func remotePaginator(client *gotktrix.Client, roomID matrix.RoomID, limit int) paginateFunc {
+	var from string
+	var done bool
+
+	return func(ctx context.Context) ([]event.RoomEvent, error) {
+		if done {
+			return nil, nil
+		}
+
+		resp, err := client.WithContext(ctx).Client.RoomMessages(roomID, api.RoomMessagesQuery{
+			From:      from, // empty for the latest messages
+			Direction: api.RoomMessagesBackward,
+			Limit:     limit,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		from = resp.End
+		done = from == "" || len(resp.Chunk) == 0
+
+		// The chunk is latest first, so flip it.
+		events := sys.ParseAllTimeline(resp.Chunk, roomID)
+		for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+			events[i], events[j] = events[j], events[i]
+		}
+
+		return events, nil
+	}
+}
+
+// isMessage returns true if the event is shown as a message.
+func isMessage(ev event.RoomEvent) bool {
+	switch ev.(type) {
+	case *event.RoomMessageEvent, *m.StickerEvent, *m.EncryptedEvent:
+		return true
+	default:
+		return false
+	}
+}
+
+func cmdSend(ctx context.Context, s *session, args []string) error {
+	fs := newFlagSet("send")
+	emote := fs.Bool("emote", false, "send the message as an emote")
+	notice := fs.Bool("notice", false, "send the message as a notice, which is meant for bots")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() < 1 {
+		fs.Usage()
+		return errors.New("missing room")
+	}
+
+	client := s.client.WithContext(ctx)
+
+	roomID, err := s.resolveRoom(client, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	body := strings.Join(fs.Args()[1:], " ")
+	if body == "" || body == "-" {
+		b, err := io.ReadAll(bufio.NewReader(os.Stdin))
+		if err != nil {
+			return errors.Wrap(err, "cannot read message from stdin")
+		}
+		body = strings.TrimSuffix(string(b), "\n")
+	}
+
+	if strings.TrimSpace(body) == "" {
+		return errors.New("message is empty")
+	}
+
+	msg := event.RoomMessageEvent{
+		RoomEventInfo: event.RoomEventInfo{
+			EventInfo: event.EventInfo{Type: event.TypeRoomMessage},
+			RoomID:    roomID,
+		},
+		Body:        body,
+		MessageType: event.RoomMessageText,
+	}
+
+	switch {
+	case *emote:
+		msg.MessageType = event.RoomMessageEmote
+	case *notice:
+		msg.MessageType = event.RoomMessageNotice
+	}
+
+	eventID, err := client.RoomEventSend(roomID, msg.Type, msg)
+	if err != nil {
+		return err
+	}
+
+	p := s.printer()
+	p.print(sentJSON{RoomID: roomID, EventID: eventID}, "%s", eventID)
+	return p.flush()
+}
+
+func cmdUpload(ctx context.Context, s *session, args []string) error {
+	fs := newFlagSet("upload")
+	caption := fs.String("caption", "", "the message body (default: the file name)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return errors.New("missing room or file")
+	}
+
+	client := s.client.WithContext(ctx)
+
+	roomID, err := s.resolveRoom(client, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	if client.RoomIsEncrypted(roomID) {
+		// Attachments would have to be encrypted as well, which isn't
+		// supported yet.
+		return errors.New("cannot upload into encrypted rooms")
+	}
+
+	f, err := os.Open(fs.Arg(1))
+	if err != nil {
+		return errors.Wrap(err, "cannot open file")
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return errors.Wrap(err, "cannot stat file")
+	}
+
+	name := filepath.Base(f.Name())
+	mimeType := fileMIMEType(f)
+
+	url, err := client.MediaUpload(mimeType, name, f)
+	if err != nil {
+		return errors.Wrap(err, "cannot upload file")
+	}
+
+	info, _ := json.Marshal(map[string]interface{}{
+		"mimetype": mimeType,
+		"size":     stat.Size(),
+	})
+
+	msg := event.RoomMessageEvent{
+		RoomEventInfo: event.RoomEventInfo{
+			EventInfo: event.EventInfo{Type: event.TypeRoomMessage},
+			RoomID:    roomID,
+		},
+		Body:           name,
+		MessageType:    fileMessageType(mimeType),
+		URL:            url,
+		AdditionalInfo: info,
+	}
+
+	if *caption != "" {
+		msg.Body = *caption
+	}
+
+	eventID, err := client.RoomEventSend(roomID, msg.Type, msg)
+	if err != nil {
+		return err
+	}
+
+	p := s.printer()
+	p.print(sentJSON{RoomID: roomID, EventID: eventID, URL: url}, "%s\t%s", eventID, url)
+	return p.flush()
+}
+
+// fileMIMEType guesses the MIME type of the file from its extension or its
+// content. The file is rewound afterwards.
+func fileMIMEType(f *os.File) string {
+	if t := mime.TypeByExtension(filepath.Ext(f.Name())); t != "" {
+		return t
+	}
+
+	b := make([]byte, 512)
+	n, _ := io.ReadFull(f, b)
+	f.Seek(0, io.SeekStart)
+
+	return http.DetectContentType(b[:n])
+}
+
+func fileMessageType(mimeType string) event.MessageType {
+	switch strings.Split(mimeType, "/")[0] {
+	case "image":
+		return event.RoomMessageImage
+	case "audio":
+		return event.RoomMessageAudio
+	case "video":
+		return event.RoomMessageVideo
+	default:
+		return event.RoomMessageFile
+	}
+}
@@ -0,0 +1,192 @@
+// Command gotktrix-cli is a headless companion to gotktrix. It uses the
+// accounts and the state that gotktrix keeps to send messages, upload files and
+// print rooms, members and timelines from shell scripts.
+//
+// If gotktrix is running, then its databases are locked, and syncing on its
+// device would take the to-device events, such as room keys, away from it. The
+// homeserver is then asked directly instead, and unread counts, following new
+// messages and end-to-end encryption are unavailable.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// appID is the application ID of gotktrix. The secrets of the accounts are
+// stored under it.
+const appID = "com.github.diamondburned.gotktrix"
+
+// globalOpts is the options that apply to all commands.
+type globalOpts struct {
+	account    string
+	configDir  string
+	json       bool
+	offline    bool
+	verbose    bool
+	passphrase string
+}
+
+// command is a subcommand.
+type command struct {
+	usage string
+	desc  string
+	run   func(ctx context.Context, s *session, args []string) error
+	// noClient is true if the command doesn't need a client. The session
+	// won't have one in that case.
+	noClient bool
+	// needsSync is true if the command needs the synced state, so it can't be
+	// used while gotktrix is running.
+	needsSync bool
+}
+
+// commands is filled in init, since the commands refer to it for their usage.
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"accounts": {
+			usage:    "accounts",
+			desc:     "list the stored accounts",
+			run:      cmdAccounts,
+			noClient: true,
+		},
+		"rooms": {
+			usage: "rooms",
+			desc:  "list the joined rooms and their unread counts",
+			run:   cmdRooms,
+		},
+		"unread": {
+			usage:     "unread",
+			desc:      "list the rooms with unread messages",
+			run:       cmdUnread,
+			needsSync: true,
+		},
+		"members": {
+			usage: "members <room>",
+			desc:  "list the members of a room",
+			run:   cmdMembers,
+		},
+		"tail": {
+			usage: "tail [-n count] [-f] <room>",
+			desc:  "print the latest messages of a room, optionally following new ones",
+			run:   cmdTail,
+		},
+		"send": {
+			usage: "send [-emote|-notice] <room> [message...]",
+			desc:  "send a message, read from stdin if none is given",
+			run:   cmdSend,
+		},
+		"upload": {
+			usage: "upload [-caption text] <room> <file>",
+			desc:  "upload a file into a room",
+			run:   cmdUpload,
+		},
+	}
+}
+
+func main() {
+	opts := globalOpts{
+		configDir:  defaultConfigDir(),
+		passphrase: os.Getenv("GOTKTRIX_PASSPHRASE"),
+	}
+
+	flag.StringVar(&opts.account, "account", "", "the user ID of the account to use (default: the only account)")
+	flag.StringVar(&opts.configDir, "config", opts.configDir, "the config directory of gotktrix")
+	flag.BoolVar(&opts.json, "json", false, "print JSON instead of text")
+	flag.BoolVar(&opts.offline, "offline", false, "use the stored state without syncing first")
+	flag.BoolVar(&opts.verbose, "v", false, "print logs to stderr")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", flag.Arg(0))
+		usage()
+		os.Exit(2)
+	}
+
+	if !opts.verbose {
+		log.SetOutput(io.Discard)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	if err := run(ctx, opts, cmd, flag.Args()[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, opts globalOpts, cmd command, args []string) error {
+	s, err := newSession(opts)
+	if err != nil {
+		return err
+	}
+
+	if !cmd.noClient {
+		if err := s.open(ctx); err != nil {
+			return err
+		}
+		defer s.close()
+
+		if cmd.needsSync && s.shared {
+			return errors.New("cannot be used while gotktrix is running, since that needs syncing")
+		}
+	}
+
+	return cmd.run(ctx, s, args)
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+
+	fmt.Fprintf(out, "usage: %s [flags] <command> [args...]\n\n", filepath.Base(os.Args[0]))
+	fmt.Fprintln(out, "commands:")
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		cmd := commands[name]
+		fmt.Fprintf(out, "  %-40s %s\n", cmd.usage, cmd.desc)
+	}
+
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "A room is given as a room ID, a room alias or the name of a joined room.")
+	fmt.Fprintln(out, "If the stored accounts are encrypted, then the passphrase is read from")
+	fmt.Fprintln(out, "$GOTKTRIX_PASSPHRASE.")
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "flags:")
+	flag.PrintDefaults()
+}
+
+// defaultConfigDir returns the config directory that gotktrix uses.
+func defaultConfigDir() string {
+	d, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	// gotktrix uses the last part of its application ID.
+	return filepath.Join(d, appID[strings.LastIndexByte(appID, '.')+1:])
+}
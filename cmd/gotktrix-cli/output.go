@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/diamondburned/gotktrix/internal/gotktrix"
+	"github.com/diamondburned/gotktrix/internal/gotktrix/events/m"
+	"github.com/diamondburned/gotrix/event"
+	"github.com/diamondburned/gotrix/matrix"
+	"github.com/pkg/errors"
+)
+
+type accountJSON struct {
+	UserID   string `json:"user_id"`
+	DeviceID string `json:"device_id,omitempty"`
+	Server   string `json:"server"`
+	Storage  string `json:"storage"`
+}
+
+type roomJSON struct {
+	RoomID     matrix.RoomID `json:"room_id"`
+	Name       string        `json:"name"`
+	Unread     *int          `json:"unread,omitempty"`
+	MoreUnread bool          `json:"more_unread"`
+	Encrypted  bool          `json:"encrypted"`
+	Space      bool          `json:"space"`
+}
+
+type memberJSON struct {
+	UserID      matrix.UserID    `json:"user_id"`
+	DisplayName string           `json:"display_name,omitempty"`
+	Membership  event.MemberType `json:"membership"`
+}
+
+type sentJSON struct {
+	RoomID  matrix.RoomID  `json:"room_id"`
+	EventID matrix.EventID `json:"event_id"`
+	URL     matrix.URL     `json:"url,omitempty"`
+}
+
+// printer prints either lines of text or lines of JSON values into stdout.
+type printer struct {
+	w    *bufio.Writer
+	enc  *json.Encoder
+	err  error
+	json bool
+}
+
+func (s *session) printer() *printer {
+	w := bufio.NewWriter(os.Stdout)
+	return &printer{
+		w:    w,
+		enc:  json.NewEncoder(w),
+		json: s.opts.json,
+	}
+}
+
+// print prints v as a line of JSON if -json is given, or the formatted text
+// otherwise.
+func (p *printer) print(v interface{}, f string, args ...interface{}) {
+	if p.err != nil {
+		return
+	}
+
+	if p.json {
+		p.err = p.enc.Encode(v)
+		return
+	}
+
+	_, p.err = fmt.Fprintf(p.w, f+"\n", args...)
+}
+
+// printEvent prints the given room event. The raw event is printed if -json is
+// given.
+func (p *printer) printEvent(client *gotktrix.Client, ev event.RoomEvent) {
+	if p.json {
+		p.print(rawEvent(ev), "")
+		return
+	}
+
+	info := ev.RoomInfo()
+
+	name := string(info.Sender)
+	if n, err := client.MemberName(info.RoomID, info.Sender, false); err == nil {
+		name = n.Name
+	}
+
+	ts := time.UnixMilli(int64(info.OriginServerTime)).Format("2006-01-02 15:04:05")
+	p.print(nil, "[%s] %s", ts, eventText(name, ev))
+}
+
+// eventText formats the event as a line of text, with the continuing lines
+// indented.
+func eventText(name string, ev event.RoomEvent) string {
+	var text string
+
+	switch ev := ev.(type) {
+	case *event.RoomMessageEvent:
+		switch ev.MessageType {
+		case event.RoomMessageEmote:
+			text = fmt.Sprintf("* %s %s", name, ev.Body)
+		case event.RoomMessageNotice:
+			text = fmt.Sprintf("-%s- %s", name, ev.Body)
+		case event.RoomMessageText:
+			text = fmt.Sprintf("<%s> %s", name, ev.Body)
+		default:
+			text = fmt.Sprintf("<%s> [%s] %s", name, ev.Body, ev.URL)
+		}
+	case *m.StickerEvent:
+		text = fmt.Sprintf("<%s> [sticker: %s]", name, ev.Body)
+	case *m.EncryptedEvent:
+		text = fmt.Sprintf("<%s> [encrypted message]", name)
+	default:
+		text = fmt.Sprintf("<%s> [%s]", name, ev.Info().Type)
+	}
+
+	return strings.ReplaceAll(text, "\n", "\n    ")
+}
+
+// rawEvent returns the raw JSON of the event.
+func rawEvent(ev event.RoomEvent) json.RawMessage {
+	if raw := ev.Info().Raw; len(raw) > 0 {
+		return json.RawMessage(raw)
+	}
+
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return json.RawMessage("null")
+	}
+	return b
+}
+
+// flush flushes everything printed so far, returning the first error.
+func (p *printer) flush() error {
+	if p.err != nil {
+		return errors.Wrap(p.err, "cannot print")
+	}
+	if err := p.w.Flush(); err != nil {
+		return errors.Wrap(err, "cannot print")
+	}
+	return nil
+}
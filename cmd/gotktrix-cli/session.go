@@ -0,0 +1,357 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/diamondburned/gotktrix/internal/accounts"
+	"github.com/diamondburned/gotktrix/internal/gotktrix"
+	"github.com/diamondburned/gotktrix/internal/secret"
+	"github.com/diamondburned/gotrix/api"
+	"github.com/diamondburned/gotrix/api/httputil"
+	"github.com/diamondburned/gotrix/matrix"
+	"github.com/pkg/errors"
+)
+
+// syncTimeout is how long to wait for the first sync before using the stored
+// state anyway.
+const syncTimeout = 30 * time.Second
+
+// configDir implements gotktrix.ConfigPather.
+type configDir string
+
+func (d configDir) ConfigPath(tails ...string) string {
+	return filepath.Join(append([]string{string(d)}, tails...)...)
+}
+
+// storedAccount is an account along with the driver that it's stored in.
+type storedAccount struct {
+	accounts.Account
+	driver string
+	store  secret.Driver
+}
+
+// session is the state of a command.
+type session struct {
+	opts     globalOpts
+	accounts []storedAccount
+
+	account *storedAccount
+	client  *gotktrix.Client
+	// tempDir is the directory of the temporary state, if any.
+	tempDir string
+	// shared is true if gotktrix is running on the same device. The client
+	// must not sync then, since that would acknowledge the to-device events,
+	// such as room keys, that gotktrix hasn't received yet. Only the state
+	// that is fetched from the homeserver directly is used.
+	shared bool
+	loaded map[matrix.RoomID]bool
+}
+
+func newSession(opts globalOpts) (*session, error) {
+	s := &session{opts: opts}
+
+	if err := s.loadAccounts(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// loadAccounts loads the accounts from the same secret drivers as gotktrix.
+func (s *session) loadAccounts() error {
+	type driver struct {
+		name string
+		secret.Driver
+	}
+
+	drivers := []driver{
+		{"keyring", secret.KeyringDriver(appID + ".secrets")},
+	}
+
+	encryptPath := filepath.Join(s.opts.configDir, "secrets")
+	if secret.PathIsEncrypted(encryptPath) {
+		if s.opts.passphrase != "" {
+			drivers = append(drivers, driver{
+				"file", secret.EncryptedFileDriver(s.opts.passphrase, encryptPath),
+			})
+		} else {
+			log.Println("accounts in", encryptPath, "are encrypted, but $GOTKTRIX_PASSPHRASE is empty")
+		}
+	}
+
+	var errs []string
+
+	for _, driver := range drivers {
+		accs, err := accounts.Load(driver)
+		if err != nil {
+			if errors.Is(err, secret.ErrUnsupportedPlatform) {
+				continue
+			}
+			errs = append(errs, fmt.Sprintf("%s: %v", driver.name, err))
+		}
+
+	accountLoop:
+		for _, acc := range accs {
+			for _, existing := range s.accounts {
+				if existing.UserID == acc.UserID {
+					continue accountLoop
+				}
+			}
+			s.accounts = append(s.accounts, storedAccount{acc, driver.name, driver.Driver})
+		}
+	}
+
+	if len(s.accounts) == 0 && len(errs) > 0 {
+		return fmt.Errorf("cannot load accounts: %s", strings.Join(errs, "; "))
+	}
+
+	for _, err := range errs {
+		log.Println("cannot load some accounts:", err)
+	}
+
+	return nil
+}
+
+// chooseAccount chooses the account given by the user.
+func (s *session) chooseAccount() (*storedAccount, error) {
+	switch {
+	case len(s.accounts) == 0:
+		return nil, errors.New("no stored accounts; log in using gotktrix first")
+	case s.opts.account == "" && len(s.accounts) == 1:
+		return &s.accounts[0], nil
+	case s.opts.account == "":
+		return nil, errors.New("more than one account is stored; choose one using -account")
+	}
+
+	for i, acc := range s.accounts {
+		if acc.UserID == s.opts.account || acc.Username == s.opts.account {
+			return &s.accounts[i], nil
+		}
+	}
+
+	return nil, fmt.Errorf("unknown account %q", s.opts.account)
+}
+
+// open opens the client of the chosen account and waits for it to sync.
+func (s *session) open(ctx context.Context) error {
+	acc, err := s.chooseAccount()
+	if err != nil {
+		return err
+	}
+	s.account = acc
+
+	userID := matrix.UserID(acc.UserID)
+	opts := gotktrix.Opts{
+		Client:     httputil.NewClient(),
+		ConfigPath: configDir(s.opts.configDir),
+		Secrets:    acc.store,
+	}
+
+	if gotktrix.DatabaseInUse(opts.ConfigPath, userID) {
+		// gotktrix is running, and only one process can open the databases.
+		// Use a temporary state instead, and don't touch the device's keys,
+		// since they're in gotktrix's database.
+		//
+		// Refreshing the access token would rotate the refresh token that
+		// gotktrix holds, which would log it out, so only the stored access
+		// token is used.
+		if !acc.TokenExpiry.IsZero() && time.Now().After(acc.TokenExpiry) {
+			return errors.New("the access token has expired; " +
+				"it's refreshed by gotktrix, so try again while it's online")
+		}
+
+		s.tempDir, err = os.MkdirTemp("", "gotktrix-cli-*")
+		if err != nil {
+			return errors.Wrap(err, "failed to make temporary state directory")
+		}
+
+		opts.ConfigPath = configDir(s.tempDir)
+		opts.NoCrypto = true
+
+		s.shared = true
+		s.loaded = make(map[matrix.RoomID]bool)
+
+		fmt.Fprintln(os.Stderr,
+			"gotktrix is running, so the homeserver is asked directly without syncing;"+
+				" unread counts, new messages and encrypted rooms are unavailable")
+	}
+
+	if acc.DeviceID != "" {
+		s.client, err = gotktrix.NewCached(acc.Server, acc.Token, userID, matrix.DeviceID(acc.DeviceID), opts)
+	} else {
+		s.client, err = gotktrix.New(acc.Server, acc.Token, opts)
+	}
+	if err != nil {
+		s.removeTemp()
+		return errors.Wrap(err, "failed to open account")
+	}
+
+	if s.shared {
+		return nil
+	}
+
+	// Keep the stored account working for gotktrix if the homeserver rotates
+	// the refresh token.
+	s.client.UseRefreshToken(acc.RefreshToken, acc.TokenExpiry)
+	s.client.OnSessionRefresh(func(session gotktrix.Session) {
+		updated := acc.Account
+		updated.Token = session.AccessToken
+		updated.RefreshToken = session.RefreshToken
+		updated.TokenExpiry = session.Expiry
+
+		if err := accounts.Save(acc.store, &updated); err != nil {
+			log.Println("cannot save refreshed session:", err)
+		}
+	})
+
+	_, hasSynced := s.client.State.NextBatch()
+	if s.opts.offline && hasSynced {
+		return nil
+	}
+
+	return s.sync(ctx, hasSynced)
+}
+
+// sync opens the sync loop and waits for the first sync. If the homeserver
+// isn't reachable but the state has synced before, then the stored state is
+// used.
+func (s *session) sync(ctx context.Context, hasSynced bool) error {
+	syncCh := make(chan *api.SyncResponse, 1)
+	offlineCh := make(chan error, 1)
+
+	syncCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	s.client.OnSyncCh(syncCtx, syncCh)
+
+	removeIntercept := s.client.AddSyncInterceptFull(func(
+		r *http.Request, next func() (*http.Response, error)) (*http.Response, error) {
+
+		resp, err := next()
+		if gotktrix.IsOffline(err) {
+			select {
+			case offlineCh <- err:
+			default:
+			}
+		}
+		return resp, err
+	})
+	defer removeIntercept()
+
+	if err := s.client.Open(); err != nil {
+		s.client.Close()
+		s.removeTemp()
+		return errors.Wrap(err, "failed to sync")
+	}
+
+	timeout := time.NewTimer(syncTimeout)
+	defer timeout.Stop()
+
+	for {
+		select {
+		case <-syncCh:
+			return nil
+		case err := <-offlineCh:
+			if hasSynced {
+				fmt.Fprintln(os.Stderr, "cannot reach the homeserver, using the stored state:", err)
+				return nil
+			}
+		case <-timeout.C:
+			if hasSynced {
+				fmt.Fprintln(os.Stderr, "syncing is taking too long, using the stored state")
+				return nil
+			}
+		case <-ctx.Done():
+			s.close()
+			return ctx.Err()
+		}
+	}
+}
+
+// resolveRoom resolves the given room like resolveRoom. If gotktrix is
+// running, then the state of the room is fetched first, so that it's known
+// whether the room is encrypted.
+func (s *session) resolveRoom(client *gotktrix.Client, room string) (matrix.RoomID, error) {
+	if s.shared && !strings.HasPrefix(room, "!") && !strings.HasPrefix(room, "#") {
+		// Room names are looked up in the state.
+		if _, err := s.loadRooms(client); err != nil {
+			return "", err
+		}
+	}
+
+	roomID, err := resolveRoom(client, room)
+	if err != nil {
+		return "", err
+	}
+
+	if err := s.loadRoomState(client, roomID); err != nil {
+		return "", err
+	}
+
+	return roomID, nil
+}
+
+// loadRooms fetches the state of all joined rooms if gotktrix is running. The
+// joined rooms are returned.
+func (s *session) loadRooms(client *gotktrix.Client) ([]matrix.RoomID, error) {
+	if !s.shared {
+		return client.Rooms()
+	}
+
+	roomIDs, err := client.Client.Rooms()
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot get rooms")
+	}
+
+	for _, roomID := range roomIDs {
+		if err := s.loadRoomState(client, roomID); err != nil {
+			return nil, err
+		}
+	}
+
+	return roomIDs, nil
+}
+
+// loadRoomState fetches the state of the room into the temporary state if
+// gotktrix is running, since the temporary state isn't synced.
+func (s *session) loadRoomState(client *gotktrix.Client, roomID matrix.RoomID) error {
+	if !s.shared || s.loaded[roomID] {
+		return nil
+	}
+
+	events, err := client.Client.RoomStates(roomID)
+	if err != nil {
+		return errors.Wrapf(err, "cannot get state of room %q", roomID)
+	}
+
+	client.State.AddRoomEvents(roomID, events)
+	s.loaded[roomID] = true
+
+	return nil
+}
+
+// close closes the client and removes the temporary state, if any.
+func (s *session) close() {
+	if s.client != nil {
+		if err := s.client.Close(); err != nil {
+			log.Println("failed to close client:", err)
+		}
+		s.client = nil
+	}
+
+	s.removeTemp()
+}
+
+func (s *session) removeTemp() {
+	if s.tempDir != "" {
+		os.RemoveAll(s.tempDir)
+		s.tempDir = ""
+	}
+}
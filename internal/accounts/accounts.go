@@ -0,0 +1,173 @@
+// Package accounts stores the accounts that the user has logged into using a
+// secret driver.
+package accounts
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/diamondburned/gotktrix/internal/secret"
+	"github.com/diamondburned/gotrix/matrix"
+	"github.com/pkg/errors"
+)
+
+// Account is a stored account.
+type Account struct {
+	Server    string `json:"server"`
+	Token     string `json:"token"`
+	UserID    string `json:"user_id"`
+	DeviceID  string `json:"device_id,omitempty"`
+	Username  string `json:"username"`
+	AvatarURL string `json:"avatar_url"`
+	// RefreshToken and TokenExpiry are only set if the homeserver supports
+	// refreshing the access token.
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	TokenExpiry  time.Time `json:"token_expiry"`
+}
+
+// Save saves the given account into the driver. An existing account with the
+// same user ID is overridden.
+func Save(driver secret.Driver, a *Account) error {
+	accIDs, _ := ListIDs(driver)
+
+	for _, id := range accIDs {
+		if id == matrix.UserID(a.UserID) {
+			// Account is already in the list. We only need to override the
+			// data.
+			goto added
+		}
+	}
+
+	accIDs = append(accIDs, matrix.UserID(a.UserID))
+	if err := saveIDs(driver, accIDs); err != nil {
+		return errors.Wrap(err, "failed to save account IDs")
+	}
+
+added:
+	b, err := json.Marshal(a)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal account")
+	}
+
+	if err := driver.Set("account:"+a.UserID, b); err != nil {
+		return errors.Wrap(err, "failed to set account secret")
+	}
+
+	return nil
+}
+
+// Delete deletes the account with the given user ID from the driver. The ID is
+// also removed from the list of accounts if the driver can be read, but Load
+// skips IDs without an account anyway.
+func Delete(driver secret.Driver, userID matrix.UserID) error {
+	err := driver.Delete("account:" + string(userID))
+	if err != nil && !errors.Is(err, secret.ErrNotFound) {
+		return errors.Wrap(err, "failed to delete account secret")
+	}
+
+	accIDs, err := ListIDs(driver)
+	if err != nil {
+		return nil
+	}
+
+	filtered := accIDs[:0]
+	for _, id := range accIDs {
+		if id != userID {
+			filtered = append(filtered, id)
+		}
+	}
+
+	if len(filtered) == len(accIDs) {
+		return nil
+	}
+
+	if err := saveIDs(driver, filtered); err != nil {
+		return errors.Wrap(err, "failed to save account IDs")
+	}
+
+	return nil
+}
+
+func saveIDs(driver secret.Driver, ids []matrix.UserID) error {
+	b, err := json.Marshal(ids)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal")
+	}
+
+	if err := driver.Set("accounts", b); err != nil {
+		return errors.Wrap(err, "failed to set secret")
+	}
+
+	return nil
+}
+
+// LoadErrors is returned by Load if some of the accounts cannot be loaded.
+type LoadErrors []error
+
+// Error implements error.
+func (errs LoadErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("encountered %d error(s): %s", len(errs), strings.Join(msgs, "; "))
+}
+
+// Load loads all accounts from the driver. Accounts that cannot be loaded are
+// skipped, in which case the accounts that can are returned alongside a
+// LoadErrors.
+func Load(driver secret.Driver) ([]Account, error) {
+	accIDs, err := ListIDs(driver)
+	if err != nil || len(accIDs) == 0 {
+		return nil, err
+	}
+
+	var accounts []Account
+	var errs LoadErrors
+
+	for _, id := range accIDs {
+		b, err := driver.Get("account:" + string(id))
+		if err != nil {
+			if errors.Is(err, secret.ErrNotFound) {
+				// Ignore.
+				continue
+			}
+			errs = append(errs, err)
+			continue
+		}
+
+		var acc Account
+		if err := json.Unmarshal(b, &acc); err != nil {
+			errs = append(errs, errors.Wrap(err, "failed to decode account JSON"))
+			continue
+		}
+
+		accounts = append(accounts, acc)
+	}
+
+	if len(errs) > 0 {
+		return accounts, errs
+	}
+
+	return accounts, nil
+}
+
+// ListIDs lists the user IDs of the stored accounts.
+func ListIDs(driver secret.Driver) ([]matrix.UserID, error) {
+	b, err := driver.Get("accounts")
+	if err != nil {
+		if errors.Is(err, secret.ErrNotFound) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "failed to read accounts file")
+	}
+
+	var ids []matrix.UserID
+	if err := json.Unmarshal(b, &ids); err != nil {
+		return nil, errors.Wrap(err, "failed to decode []UserID JSON")
+	}
+
+	return ids, nil
+}
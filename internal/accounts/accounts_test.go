@@ -0,0 +1,111 @@
+package accounts
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/diamondburned/gotktrix/internal/secret"
+	"github.com/diamondburned/gotrix/matrix"
+)
+
+type mapDriver map[string][]byte
+
+func (d mapDriver) Get(k string) ([]byte, error) {
+	b, ok := d[k]
+	if !ok {
+		return nil, secret.ErrNotFound
+	}
+	return b, nil
+}
+
+func (d mapDriver) Set(k string, v []byte) error {
+	d[k] = v
+	return nil
+}
+
+func (d mapDriver) Delete(k string) error {
+	if _, ok := d[k]; !ok {
+		return secret.ErrNotFound
+	}
+	delete(d, k)
+	return nil
+}
+
+func TestSaveLoad(t *testing.T) {
+	driver := mapDriver{}
+
+	accs, err := Load(driver)
+	if err != nil || len(accs) != 0 {
+		t.Fatalf("unexpected load from empty driver: %v, %v", accs, err)
+	}
+
+	a := Account{Server: "https://a.example", Token: "a", UserID: "@a:a.example"}
+	b := Account{Server: "https://b.example", Token: "b", UserID: "@b:b.example"}
+
+	for _, acc := range []Account{a, b} {
+		if err := Save(driver, &acc); err != nil {
+			t.Fatal("cannot save:", err)
+		}
+	}
+
+	// Saving again overrides the account.
+	a.Token = "a2"
+	if err := Save(driver, &a); err != nil {
+		t.Fatal("cannot save:", err)
+	}
+
+	accs, err = Load(driver)
+	if err != nil {
+		t.Fatal("cannot load:", err)
+	}
+
+	if expect := []Account{a, b}; !reflect.DeepEqual(accs, expect) {
+		t.Fatalf("unexpected accounts\nexpected %#v\ngot      %#v", expect, accs)
+	}
+
+	// Break one of the accounts.
+	driver["account:"+b.UserID] = []byte("{")
+
+	accs, err = Load(driver)
+	if !errors.As(err, new(LoadErrors)) {
+		t.Fatalf("expected LoadErrors, got %v", err)
+	}
+	if expect := []Account{a}; !reflect.DeepEqual(accs, expect) {
+		t.Fatalf("unexpected accounts\nexpected %#v\ngot      %#v", expect, accs)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	driver := mapDriver{}
+
+	a := Account{Server: "https://a.example", Token: "a", UserID: "@a:a.example"}
+	b := Account{Server: "https://b.example", Token: "b", UserID: "@b:b.example"}
+
+	for _, acc := range []Account{a, b} {
+		if err := Save(driver, &acc); err != nil {
+			t.Fatal("cannot save:", err)
+		}
+	}
+
+	if err := Delete(driver, "@a:a.example"); err != nil {
+		t.Fatal("cannot delete:", err)
+	}
+
+	if _, ok := driver["account:@a:a.example"]; ok {
+		t.Fatal("account secret is not deleted")
+	}
+
+	ids, err := ListIDs(driver)
+	if err != nil {
+		t.Fatal("cannot list IDs:", err)
+	}
+	if expect := []matrix.UserID{"@b:b.example"}; !reflect.DeepEqual(ids, expect) {
+		t.Fatalf("unexpected IDs\nexpected %v\ngot      %v", expect, ids)
+	}
+
+	// Deleting an unknown account is fine.
+	if err := Delete(driver, "@c:c.example"); err != nil {
+		t.Fatal("cannot delete unknown account:", err)
+	}
+}
@@ -2,24 +2,22 @@ package auth
 
 import (
 	"context"
-	"encoding/json"
+	"fmt"
+	"log"
 	"strings"
 
+	"github.com/diamondburned/gotkit/app"
 	"github.com/diamondburned/gotkit/app/locale"
 	"github.com/diamondburned/gotkit/gtkutil"
+	"github.com/diamondburned/gotktrix/internal/accounts"
 	"github.com/diamondburned/gotktrix/internal/gotktrix"
 	"github.com/diamondburned/gotktrix/internal/secret"
 	"github.com/diamondburned/gotrix/matrix"
 	"github.com/pkg/errors"
 )
 
-type Account struct {
-	Server    string `json:"server"`
-	Token     string `json:"token"`
-	UserID    string `json:"user_id"`
-	Username  string `json:"username"`
-	AvatarURL string `json:"avatar_url"`
-}
+// Account is a stored account.
+type Account = accounts.Account
 
 func copyAccount(client *gotktrix.Client) (*Account, error) {
 	id, err := client.Whoami()
@@ -32,93 +30,88 @@ func copyAccount(client *gotktrix.Client) (*Account, error) {
 		return nil, errors.Wrap(err, "failed to parse user ID")
 	}
 
+	mxc, err := client.AvatarURL(client.UserID)
+	if gotktrix.IsOffline(err) {
+		// Don't override the saved avatar with nothing.
+		return nil, errors.Wrap(err, "failed to get avatar")
+	}
+
 	var avatarURL string
-	if mxc, _ := client.AvatarURL(client.UserID); mxc != nil {
+	if mxc != nil {
 		avatarURL, _ = client.SquareThumbnail(*mxc, avatarSize, gtkutil.ScaleFactor())
 	}
 
+	session := client.Session()
+
 	return &Account{
-		Server:    client.HomeServerScheme + "://" + client.HomeServer,
-		Token:     client.AccessToken,
-		UserID:    string(client.UserID),
-		Username:  username,
-		AvatarURL: avatarURL,
+		Server:       client.HomeServerScheme + "://" + client.HomeServer,
+		Token:        session.AccessToken,
+		UserID:       string(client.UserID),
+		DeviceID:     string(client.DeviceID),
+		Username:     username,
+		AvatarURL:    avatarURL,
+		RefreshToken: session.RefreshToken,
+		TokenExpiry:  session.Expiry,
 	}, nil
 }
 
 func saveAccount(driver secret.Driver, a *Account) error {
-	accIDs, _ := listAccountIDs(driver)
+	return accounts.Save(driver, a)
+}
 
-	for _, id := range accIDs {
-		if id == matrix.UserID(a.UserID) {
-			// Account is already in the list. We only need to override the
-			// data.
-			goto added
-		}
+// persistSession keeps the stored account updated with the client's tokens
+// every time they're refreshed, since the old refresh token may stop working.
+func persistSession(c *gotktrix.Client, acc Account, drivers ...secret.Driver) {
+	if len(drivers) == 0 {
+		return
 	}
 
-	accIDs = append(accIDs, matrix.UserID(a.UserID))
-	if err := saveAccountIDs(driver, accIDs); err != nil {
-		return errors.Wrap(err, "failed to save account IDs")
-	}
+	c.OnSessionRefresh(func(s gotktrix.Session) {
+		acc.Token = s.AccessToken
+		acc.RefreshToken = s.RefreshToken
+		acc.TokenExpiry = s.Expiry
 
-added:
-	b, err := json.Marshal(a)
-	if err != nil {
-		return errors.Wrap(err, "failed to marshal account")
-	}
+		for _, driver := range drivers {
+			if err := saveAccount(driver, &acc); err != nil {
+				log.Println("cannot save refreshed session:", err)
+			}
+		}
+	})
+}
+
+// ForgetAccount deletes the stored account with the given user ID from both
+// the keyring and the encrypted file. It's used when logging out.
+func ForgetAccount(ctx context.Context, userID matrix.UserID) error {
+	app := app.FromContext(ctx)
 
-	if err := driver.Set("account:"+a.UserID, b); err != nil {
-		return errors.Wrap(err, "failed to set account secret")
+	drivers := []secret.Driver{secret.KeyringDriver(app.IDDot("secrets"))}
+	if path := app.ConfigPath("secrets"); secret.PathIsEncrypted(path) {
+		// The passphrase isn't needed to delete the account's file.
+		drivers = append(drivers, secret.SaltedFileDriver(path))
 	}
 
-	return nil
-}
+	var errs []string
 
-func saveAccountIDs(driver secret.Driver, ids []matrix.UserID) error {
-	b, err := json.Marshal(ids)
-	if err != nil {
-		return errors.Wrap(err, "failed to marshal")
+	for _, driver := range drivers {
+		err := accounts.Delete(driver, userID)
+		if err != nil && !errors.Is(err, secret.ErrUnsupportedPlatform) {
+			errs = append(errs, err.Error())
+		}
 	}
 
-	if err := driver.Set("accounts", b); err != nil {
-		return errors.Wrap(err, "failed to set secret")
+	if len(errs) > 0 {
+		return fmt.Errorf("cannot forget account: %s", strings.Join(errs, "; "))
 	}
 
 	return nil
 }
 
 func loadAccounts(ctx context.Context, driver secret.Driver) ([]Account, error) {
-	accIDs, err := listAccountIDs(driver)
-	if err != nil || len(accIDs) == 0 {
-		return nil, err
-	}
-
-	var accounts []Account
-	var errs []error
-
-	for _, id := range accIDs {
-		b, err := driver.Get("account:" + string(id))
-		if err != nil {
-			if errors.Is(err, secret.ErrNotFound) {
-				// Ignore.
-				continue
-			}
-			errs = append(errs, err)
-			continue
-		}
-
-		var acc Account
-		if err := json.Unmarshal(b, &acc); err != nil {
-			errs = append(errs, errors.Wrap(err, "failed to decode account JSON"))
-			continue
-		}
-
-		accounts = append(accounts, acc)
-	}
+	accs, err := accounts.Load(driver)
 
-	if len(errs) == 0 {
-		return accounts, nil
+	var errs accounts.LoadErrors
+	if !errors.As(err, &errs) {
+		return accs, err
 	}
 
 	errMsg := strings.Builder{}
@@ -131,22 +124,5 @@ func loadAccounts(ctx context.Context, driver secret.Driver) ([]Account, error)
 		errMsg.WriteByte('\n')
 	}
 
-	return accounts, errors.New(strings.TrimSuffix(errMsg.String(), "\n"))
-}
-
-func listAccountIDs(driver secret.Driver) ([]matrix.UserID, error) {
-	b, err := driver.Get("accounts")
-	if err != nil {
-		if errors.Is(err, secret.ErrNotFound) {
-			return nil, nil
-		}
-		return nil, errors.Wrap(err, "failed to read accounts file")
-	}
-
-	var ids []matrix.UserID
-	if err := json.Unmarshal(b, &ids); err != nil {
-		return nil, errors.Wrap(err, "failed to decode []UserID JSON")
-	}
-
-	return ids, nil
+	return accs, errors.New(strings.TrimSuffix(errMsg.String(), "\n"))
 }
@@ -23,6 +23,9 @@ type Assistant struct {
 	client httputil.Client
 
 	onConnect func(*gotktrix.Client, *Account)
+	// isOpen returns true if the account with the given user ID is already
+	// open, which disables it.
+	isOpen func(matrix.UserID) bool
 
 	// states, can be nil depending on the steps
 	accounts      []assistantAccount
@@ -34,6 +37,8 @@ type Assistant struct {
 
 	// hasConnected is true if the connection has already been connected.
 	hasConnected bool
+	// dialog is true if the assistant is shown in its own dialog.
+	dialog bool
 }
 
 type assistantAccount struct {
@@ -52,6 +57,22 @@ func ShowWithClient(ctx context.Context, client httputil.Client) *Assistant {
 	ass := assistant.Use(app.GTKWindowFromContext(ctx), nil)
 	ass.SetTitle("Getting Started")
 
+	return show(ctx, client, ass)
+}
+
+// ShowDialog creates a new authentication assistant in a dialog on top of the
+// window. Use this to add an account into a window that is already showing
+// one; the dialog closes itself once an account is chosen.
+func ShowDialog(ctx context.Context) *Assistant {
+	ass := assistant.New(app.GTKWindowFromContext(ctx), nil)
+	ass.SetTitle("Add Account")
+
+	a := show(ctx, httputil.NewClient(), ass)
+	a.dialog = true
+	return a
+}
+
+func show(ctx context.Context, client httputil.Client, ass *assistant.Assistant) *Assistant {
 	app := app.FromContext(ctx)
 
 	a := Assistant{
@@ -67,6 +88,16 @@ func ShowWithClient(ctx context.Context, client httputil.Client) *Assistant {
 	return &a
 }
 
+// secrets returns the secret drivers that a new account's crypto key can be
+// kept in, which are the ones that the account may be saved into.
+func (a *Assistant) secrets() secret.Driver {
+	drivers := []secret.Driver{a.keyring}
+	if a.encrypt != nil {
+		drivers = append(drivers, a.encrypt)
+	}
+	return secret.New(drivers...)
+}
+
 // OnConnect sets the handler that is called when the user chooses an account or
 // logs in. If this method has already been called before with a non-nil
 // function, it will panic.
@@ -78,6 +109,20 @@ func (a *Assistant) OnConnect(f func(*gotktrix.Client, *Account)) {
 	a.onConnect = f
 }
 
+// DisableOpenAccounts disables the saved accounts for which isOpen returns
+// true, since an account that is already open has its database in use. It must
+// be called right after the assistant is shown, before the saved accounts are
+// loaded.
+func (a *Assistant) DisableOpenAccounts(isOpen func(matrix.UserID) bool) {
+	a.isOpen = isOpen
+}
+
+// accountIsOpen returns true if the account with the given user ID is already
+// open.
+func (a *Assistant) accountIsOpen(userID matrix.UserID) bool {
+	return a.isOpen != nil && a.isOpen(userID)
+}
+
 // step 1 activate
 func (a *Assistant) signinPage() {
 	step2 := homeserverStep(a)
@@ -94,6 +139,13 @@ func (a *Assistant) chooseHomeserver(client *gotktrix.ClientAuth, methods []matr
 	a.SetStep(step3)
 }
 
+// step 3 activate
+func (a *Assistant) registerPage() {
+	step4 := registerStep(a)
+	a.AddStep(step4)
+	a.SetStep(step4)
+}
+
 // step 3 activate
 func (a *Assistant) chooseLoginMethod(method matrix.LoginMethod) {
 	step4 := loginStep(a, method)
@@ -114,6 +166,10 @@ func (a *Assistant) finish(c *gotktrix.Client, acc *Account) {
 	a.hasConnected = true
 	a.Continue()
 	a.onConnect(c, acc)
+
+	if a.dialog {
+		a.Close()
+	}
 }
 
 var inputBoxCSS = cssutil.Applier("auth-input-box", `
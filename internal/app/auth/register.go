@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"github.com/diamondburned/gotk4/pkg/core/glib"
+	"github.com/diamondburned/gotk4/pkg/gtk/v4"
+	"github.com/diamondburned/gotkit/app/locale"
+	"github.com/diamondburned/gotkit/components/errpopup"
+	"github.com/diamondburned/gotkit/gtkutil/textutil"
+	"github.com/diamondburned/gotktrix/internal/app/uiauth"
+	"github.com/diamondburned/gotktrix/internal/components/assistant"
+	"github.com/diamondburned/gotktrix/internal/gotktrix"
+	"github.com/pkg/errors"
+)
+
+func registerStep(a *Assistant) *assistant.Step {
+	inputBox, inputs := a.makeInputs("Username", "Password", "Confirm Password")
+	inputs[0].SetInputPurpose(gtk.InputPurposeName)
+	inputs[1].SetInputPurpose(gtk.InputPurposePassword)
+	inputs[1].SetVisibility(false)
+	inputs[2].SetInputPurpose(gtk.InputPurposePassword)
+	inputs[2].SetVisibility(false)
+
+	errLabel := makeErrorLabel()
+	errLabel.Hide()
+
+	rememberMe := newRememberMeBox(a)
+
+	step := assistant.NewStep(locale.S(a.ctx, "Register"), locale.S(a.ctx, "Register"))
+	step.CanBack = true
+
+	content := step.ContentArea()
+	content.SetOrientation(gtk.OrientationVertical)
+	content.Append(inputBox)
+	content.Append(errLabel)
+	content.Append(rememberMe)
+
+	onError := func(err error) {
+		errLabel.SetMarkup(textutil.ErrorMarkup(err.Error()))
+		errLabel.Show()
+		a.Continue()
+	}
+
+	step.Done = func(step *assistant.Step) {
+		username := inputs[0].Text()
+		password := inputs[1].Text()
+
+		if password != inputs[2].Text() {
+			onError(errors.New(locale.S(a.ctx, "The passwords don't match.")))
+			return
+		}
+
+		// The stages of the registration may take a while, so the request
+		// can't be cancelled using the assistant's context.
+		a.Busy()
+
+		go func() {
+			reg, err := a.currentClient.Register(username, password)
+			if err != nil {
+				glib.IdleAdd(func() { onError(err) })
+				return
+			}
+
+			glib.IdleAdd(func() {
+				uiauth.Run(a.ctx, a.Assistant, reg.InteractiveAuth, func() {
+					finishRegistration(a, reg, rememberMe)
+				})
+			})
+		}()
+	}
+
+	return step
+}
+
+// finishRegistration logs in to the newly registered account. The assistant
+// must be busy.
+func finishRegistration(a *Assistant, reg *gotktrix.Registration, rememberMe *rememberMeBox) {
+	go func() {
+		onError := func(err error) {
+			glib.IdleAdd(func() {
+				// The account is already registered at this point, so the
+				// user can still log in to it normally.
+				errpopup.Show(a.Window, []error{err}, a.Continue)
+			})
+		}
+
+		c, err := reg.Client()
+		if err != nil {
+			onError(err)
+			return
+		}
+
+		acc, err := copyAccount(c)
+		if err != nil {
+			onError(err)
+			return
+		}
+
+		glib.IdleAdd(func() {
+			// Assistant is still busy at this point.
+			rememberMe.saveAndFinish(c, a, acc)
+		})
+	}()
+}
@@ -16,6 +16,7 @@ import (
 	"github.com/diamondburned/gotktrix/internal/components/assistant"
 	"github.com/diamondburned/gotktrix/internal/gotktrix"
 	"github.com/diamondburned/gotktrix/internal/secret"
+	"github.com/diamondburned/gotrix/matrix"
 	"github.com/pkg/errors"
 )
 
@@ -232,13 +233,37 @@ func accountChooserStep(a *Assistant) *assistant.Step {
 
 	useExistingAccount := func(row *gtk.ListBoxRow) {
 		acc := a.accounts[row.Index()]
+
+		// Opening the account again would wait on its database, which is
+		// locked, before failing.
+		if a.accountIsOpen(matrix.UserID(acc.UserID)) {
+			row.SetSensitive(false)
+			onError(errors.New("account is already open"))
+			return
+		}
+
 		ctx := a.CancellableBusy(a.ctx)
 
 		go func() {
-			c, err := gotktrix.New(acc.Server, acc.Token, gotktrix.Opts{
+			opts := gotktrix.Opts{
 				Client:     a.client.WithContext(ctx),
 				ConfigPath: app.FromContext(ctx),
-			})
+				Secrets:    acc.src,
+			}
+
+			var c *gotktrix.Client
+			var err error
+
+			if acc.UserID != "" && acc.DeviceID != "" {
+				// Use the saved IDs, so the account can be opened offline.
+				c, err = gotktrix.NewCached(
+					acc.Server, acc.Token,
+					matrix.UserID(acc.UserID), matrix.DeviceID(acc.DeviceID), opts,
+				)
+			} else {
+				c, err = gotktrix.New(acc.Server, acc.Token, opts)
+			}
+
 			if err != nil {
 				err = errors.Wrap(err, "server error")
 				glib.IdleAdd(func() {
@@ -250,15 +275,20 @@ func accountChooserStep(a *Assistant) *assistant.Step {
 				return
 			}
 
-			if newAcc, err := copyAccount(c); err == nil {
-				if err := saveAccount(acc.src, newAcc); err != nil {
-					log.Println("error updating old account:", err)
-				}
-			}
+			c.UseRefreshToken(acc.RefreshToken, acc.TokenExpiry)
+			persistSession(c, *acc.Account, acc.src)
 
 			glib.IdleAdd(func() {
 				a.finish(c.WithContext(a.ctx), acc.Account)
 			})
+
+			// Update the saved account in the background, since the homeserver
+			// may not be reachable.
+			if newAcc, err := copyAccount(c.WithContext(a.ctx)); err == nil {
+				if err := saveAccount(acc.src, newAcc); err != nil {
+					log.Println("error updating old account:", err)
+				}
+			}
 		}()
 	}
 
@@ -330,6 +360,12 @@ func addAccounts(a *Assistant, accountList *gtk.ListBox, src secret.Driver, acco
 			Account: account,
 			src:     src,
 		}}, a.accounts...)
-		accountList.Prepend(newAccountEntry(a.ctx, account))
+
+		row := newAccountEntry(a.ctx, account)
+		if a.accountIsOpen(matrix.UserID(account.UserID)) {
+			row.SetSensitive(false)
+			row.SetTooltipText("This account is already open.")
+		}
+		accountList.Prepend(row)
 	}
 }
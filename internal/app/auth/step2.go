@@ -46,6 +46,7 @@ func homeserverStep(a *Assistant) *assistant.Step {
 			c, err := gotktrix.Discover(inputs[0].Text(), gotktrix.Opts{
 				Client:     a.client.WithContext(ctx),
 				ConfigPath: app.FromContext(ctx),
+				Secrets:    a.secrets(),
 			})
 			if err != nil {
 				onErr(err)
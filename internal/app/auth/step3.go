@@ -47,6 +47,16 @@ func chooseLoginStep(a *Assistant, methods []matrix.LoginMethod) *assistant.Step
 		))
 	}
 
+	register := gtk.NewButton()
+	register.SetChild(bigSmallTitleBox(
+		"Create an Account",
+		"Register a new account on this homeserver.",
+	))
+	register.ConnectClicked(a.registerPage)
+
+	content.Append(gtk.NewSeparator(gtk.OrientationHorizontal))
+	content.Append(register)
+
 	return step
 }
 
@@ -357,19 +357,26 @@ func newRememberMeBox(a *Assistant) *rememberMeBox {
 func (r *rememberMeBox) saveAndFinish(c *gotktrix.Client, a *Assistant, acc *Account) {
 	go func() {
 		var errors []error
+		var drivers []secret.Driver
 
 		if r.keyring && a.keyring != nil {
 			if err := saveAccount(a.keyring, acc); err != nil {
 				errors = append(errors, err)
+			} else {
+				drivers = append(drivers, a.keyring)
 			}
 		}
 
 		if r.encrypt && a.encrypt != nil {
 			if err := saveAccount(a.encrypt, acc); err != nil {
 				errors = append(errors, err)
+			} else {
+				drivers = append(drivers, a.encrypt)
 			}
 		}
 
+		persistSession(c, *acc, drivers...)
+
 		glib.IdleAdd(func() {
 			errpopup.Show(a.Window, errors, func() {
 				a.Continue()
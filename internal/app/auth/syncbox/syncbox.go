@@ -4,6 +4,7 @@ import (
 	"context"
 	"log"
 	"math"
+	"net/http"
 
 	"github.com/diamondburned/gotk4/pkg/core/glib"
 	"github.com/diamondburned/gotk4/pkg/gtk/v4"
@@ -29,7 +30,9 @@ var popupCSS = cssutil.Applier("syncbox-popup", `
 	}
 `)
 
+// Popup is the widget shown while the client is being synchronized.
 type Popup struct {
+	*gtk.Box
 	win     *app.Window
 	spinner *gtk.Spinner
 	label   *gtk.Label
@@ -73,7 +76,18 @@ func OpenThen(ctx context.Context, acc *auth.Account, f func()) {
 		panic("given callback must not be nil")
 	}
 
-	openThen(ctx, acc, f)
+	openThen(ctx, acc, nil, f)
+}
+
+// OpenInThen is similar to OpenThen, except the popup is given to attach
+// instead of replacing the window's content. This is useful when the window is
+// already showing other accounts.
+func OpenInThen(ctx context.Context, acc *auth.Account, attach func(*Popup), f func()) {
+	if f == nil {
+		panic("given callback must not be nil")
+	}
+
+	openThen(ctx, acc, attach, f)
 }
 
 // Open shows a popup while opening the client in the background. Once the
@@ -81,22 +95,61 @@ func OpenThen(ctx context.Context, acc *auth.Account, f func()) {
 // Note that Open will block until the synchronization is done, so it should
 // only be called in a goroutine.
 func Open(ctx context.Context, acc *auth.Account) *Popup {
-	return openThen(ctx, acc, nil)
+	return openThen(ctx, acc, nil, nil)
 }
 
-func openThen(ctx context.Context, acc *auth.Account, f func()) *Popup {
+func openThen(ctx context.Context, acc *auth.Account, attach func(*Popup), f func()) *Popup {
 	client := gotktrix.FromContext(ctx)
 	syncCh := make(chan *api.SyncResponse, 1)
+	offlineCh := make(chan error, 1)
 
 	ctx, cancel := context.WithCancel(ctx)
 	client.OnSyncCh(ctx, syncCh)
 
+	_, hasSynced := client.State.NextBatch()
+
 	var popup *Popup
 
+	removeIntercept := client.AddSyncInterceptFull(func(
+		r *http.Request, next func() (*http.Response, error)) (*http.Response, error) {
+
+		resp, err := next()
+		if !gotktrix.IsOffline(err) {
+			return resp, err
+		}
+
+		if hasSynced {
+			// Everything can be shown from the state database, so there's no
+			// need to wait for the homeserver.
+			select {
+			case offlineCh <- err:
+			default:
+			}
+		} else {
+			popup.QueueSetLabel(locale.S(ctx, "Waiting for the homeserver..."))
+		}
+
+		return resp, err
+	})
+
+	wait := func() {
+		select {
+		case <-syncCh:
+		case err := <-offlineCh:
+			log.Println("cannot sync, continuing offline:", err)
+		}
+		cancel()
+		removeIntercept()
+	}
+
 	glib.IdleAdd(func() {
-		popup = Show(ctx, acc)
+		if attach != nil {
+			popup = NewPopup(ctx, acc)
+			attach(popup)
+		} else {
+			popup = Show(ctx, acc)
+		}
 
-		_, hasSynced := client.State.NextBatch()
 		if hasSynced {
 			popup.SetLabel(locale.S(ctx, "Syncing..."))
 		} else {
@@ -121,26 +174,35 @@ func openThen(ctx context.Context, acc *auth.Account, f func()) *Popup {
 			})
 
 			if f != nil {
-				<-syncCh
-				cancel()
+				wait()
 				glib.IdleAdd(f)
 			}
 		}()
 	})
 
 	if f == nil {
-		// This channel will only unblock once Open() is done syncing, which
-		// means popup would've already been set.
-		<-syncCh
-		cancel()
+		// This will only unblock once Open() is done syncing or the homeserver
+		// is found to be unreachable, which means popup would've already been
+		// set.
+		wait()
 		return popup
 	}
 
 	return nil
 }
 
-// Show shows a popup.
+// Show shows a popup as the window's content.
 func Show(ctx context.Context, account *auth.Account) *Popup {
+	p := NewPopup(ctx, account)
+
+	p.win = app.WindowFromContext(ctx)
+	p.win.SetChild(p)
+
+	return p
+}
+
+// NewPopup creates a new popup without showing it anywhere.
+func NewPopup(ctx context.Context, account *auth.Account) *Popup {
 	spinner := gtk.NewSpinner()
 	spinner.SetSizeRequest(18, 18)
 
@@ -161,22 +223,23 @@ func Show(ctx context.Context, account *auth.Account) *Popup {
 	content.SetVAlign(gtk.AlignCenter)
 	popupCSS(content)
 
-	win := app.WindowFromContext(ctx)
-	win.SetChild(content)
-	win.NotifyChild(true, spinner.Stop)
+	content.ConnectUnrealize(spinner.Stop)
 
 	spinner.Start()
 
 	return &Popup{
-		win:     win,
+		Box:     content,
 		spinner: spinner,
 		label:   loadLabel,
 	}
 }
 
-// SetLabel sets the popup's label.
+// SetLabel sets the popup's label. The window title is also set if the popup
+// is the window's content.
 func (p *Popup) SetLabel(text string) {
-	p.win.SetTitle(text)
+	if p.win != nil {
+		p.win.SetTitle(text)
+	}
 	p.label.SetLabel(text)
 }
 
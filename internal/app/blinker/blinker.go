@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/diamondburned/gotk4/pkg/core/glib"
+	"github.com/diamondburned/gotk4/pkg/gio/v2"
 	"github.com/diamondburned/gotk4/pkg/gtk/v4"
 	"github.com/diamondburned/gotkit/app/locale"
 	"github.com/diamondburned/gotkit/gtkutil"
@@ -23,6 +24,7 @@ const (
 	blinkerSyncing
 	blinkerDownloading
 	blinkerError
+	blinkerOffline
 )
 
 const blinkerStayTime = 200 // ms
@@ -37,6 +39,8 @@ func (s blinkerState) Icon() string {
 		return "network-receive-symbolic"
 	case blinkerError:
 		return "network-error-symbolic"
+	case blinkerOffline:
+		return "network-offline-symbolic"
 	default:
 		return ""
 	}
@@ -52,6 +56,8 @@ func (s blinkerState) Class() string {
 		return "blinker-downloading"
 	case blinkerError:
 		return "blinker-error"
+	case blinkerOffline:
+		return "blinker-offline"
 	default:
 		return ""
 	}
@@ -84,7 +90,8 @@ var blinkerCSS = cssutil.Applier("blinker", `
 	.blinker-sync,
 	.blinker-syncing,
 	.blinker-downloading,
-	.blinker-error {
+	.blinker-error,
+	.blinker-offline {
 		transition: linear 100ms;
 	}
 	.blinker-sync {
@@ -99,6 +106,10 @@ var blinkerCSS = cssutil.Applier("blinker", `
 		color:   red;
 		opacity: 1;
 	}
+	.blinker-offline {
+		color:   alpha(@theme_fg_color, 0.75);
+		opacity: 1;
+	}
 `)
 
 // New creates a new blinker.
@@ -115,21 +126,26 @@ func New(ctx context.Context) *Blinker {
 	client := gotktrix.FromContext(ctx)
 	b.rctx, b.rcancel = context.WithCancel(ctx)
 
+	monitor := gio.NetworkMonitorGetDefault()
+
 	gtkutil.BindSubscribe(b, func() func() {
+		// Break the ongoing request once the network is back, since it's
+		// likely stuck on a dead connection. The sync loop will retry.
+		h := monitor.ConnectNetworkChanged(func(available bool) {
+			if available && b.state == blinkerOffline {
+				b.stopRequest()
+			}
+		})
+
 		return gtkutil.FuncBatcher(
 			client.AddSyncInterceptFull(b.onRequest),
 			client.OnSync(b.onSynced),
+			func() { monitor.HandlerDisconnect(h) },
 		)
 	})
 
 	gtkutil.BindActionMap(b, map[string]func(){
-		"blinker.stop-request": func() {
-			b.rmut.Lock()
-			defer b.rmut.Unlock()
-
-			b.rcancel()
-			b.rctx, b.rcancel = context.WithCancel(ctx)
-		},
+		"blinker.stop-request": b.stopRequest,
 	})
 
 	gtkutil.BindPopoverMenu(b, gtk.PosBottom, [][2]string{
@@ -139,6 +155,15 @@ func New(ctx context.Context) *Blinker {
 	return b
 }
 
+// stopRequest cancels the ongoing sync request.
+func (b *Blinker) stopRequest() {
+	b.rmut.Lock()
+	defer b.rmut.Unlock()
+
+	b.rcancel()
+	b.rctx, b.rcancel = context.WithCancel(b.ctx)
+}
+
 func (b *Blinker) onRequest(
 	req *http.Request,
 	next func() (*http.Response, error)) (*http.Response, error) {
@@ -153,7 +178,14 @@ func (b *Blinker) onRequest(
 
 	r, err := next()
 	if err != nil {
-		glib.IdleAdd(func() { b.error(err) })
+		switch {
+		case ctx.Err() != nil:
+			// Broken by the user or by the network coming back.
+		case gotktrix.IsOffline(err):
+			glib.IdleAdd(b.offline)
+		default:
+			glib.IdleAdd(func() { b.error(err) })
+		}
 		return r, err
 	}
 
@@ -194,6 +226,16 @@ func (b *Blinker) error(err error) {
 	))
 }
 
+func (b *Blinker) offline() {
+	b.set(blinkerOffline)
+
+	text := locale.S(b.ctx, "Offline, reconnecting...")
+	if last := b.tooltipText(); last != "" {
+		text += "\n" + last
+	}
+	b.SetTooltipText(text)
+}
+
 func (b *Blinker) cas(ifThis, thenState blinkerState) bool {
 	if b.state == ifThis {
 		b.set(thenState)
@@ -1,6 +1,8 @@
 package emojiview
 
 import (
+	"encoding/json"
+
 	"github.com/diamondburned/gotk4/pkg/gtk/v4"
 	"github.com/diamondburned/gotk4/pkg/pango"
 	"github.com/diamondburned/gotkit/gtkutil/cssutil"
@@ -13,11 +15,21 @@ type emoji struct {
 	*gtk.ListBoxRow
 	emoji *gtk.Image
 	name  *gtk.Label
+	usage *gtk.DropDown
 
 	// states
-	mxc matrix.URL
+	mxc  matrix.URL
+	body string
+	info json.RawMessage
 }
 
+// Usage options in the order that they're shown in the drop-down.
+const (
+	emoteOption uint = iota
+	stickerOption
+	bothOption
+)
+
 var emojiCSS = cssutil.Applier("emojiview-emoji", `
 	.emojiview-emoji {
 		padding: 8px;
@@ -37,9 +49,15 @@ func newEmptyEmoji(name emojis.EmojiName) emoji {
 	label.SetEllipsize(pango.EllipsizeEnd)
 	label.SetTooltipText(string(name))
 
+	usage := gtk.NewDropDownFromStrings([]string{"Emote", "Sticker", "Both"})
+	usage.SetTooltipText("Use as")
+	usage.SetVAlign(gtk.AlignCenter)
+	usage.SetSelected(bothOption)
+
 	box := gtk.NewBox(gtk.OrientationHorizontal, 0)
 	box.Append(img)
 	box.Append(label)
+	box.Append(usage)
 	emojiCSS(box)
 
 	row := gtk.NewListBoxRow()
@@ -50,7 +68,71 @@ func newEmptyEmoji(name emojis.EmojiName) emoji {
 		ListBoxRow: row,
 		emoji:      img,
 		name:       label,
+		usage:      usage,
+	}
+}
+
+// setUsage selects the option in the usage drop-down that matches the given
+// emoji and pack usage.
+func (e *emoji) setUsage(emoji emojis.Emoji, pack []emojis.EmojiUsage) {
+	emote := emoji.HasUsage(emojis.EmoticonUsage, pack)
+	sticker := emoji.HasUsage(emojis.StickerUsage, pack)
+
+	switch {
+	case emote && !sticker:
+		e.usage.SetSelected(emoteOption)
+	case sticker && !emote:
+		e.usage.SetSelected(stickerOption)
+	default:
+		e.usage.SetSelected(bothOption)
+	}
+}
+
+// data returns the emoji as it should be saved. The usage is only written if
+// it differs from the given pack usage.
+func (e *emoji) data(pack []emojis.EmojiUsage) emojis.Emoji {
+	emoji := emojis.Emoji{
+		URL:  e.mxc,
+		Body: e.body,
+		Info: e.info,
+	}
+
+	switch e.usage.Selected() {
+	case emoteOption:
+		emoji.Usage = []emojis.EmojiUsage{emojis.EmoticonUsage}
+	case stickerOption:
+		emoji.Usage = []emojis.EmojiUsage{emojis.StickerUsage}
+	default:
+		// An empty usage means both, unless the pack says otherwise.
+		if len(pack) > 0 {
+			emoji.Usage = []emojis.EmojiUsage{emojis.EmoticonUsage, emojis.StickerUsage}
+		}
+	}
+
+	if len(pack) > 0 && sameUsage(emoji.Usage, pack) {
+		emoji.Usage = nil
+	}
+
+	return emoji
+}
+
+func sameUsage(a, b []emojis.EmojiUsage) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, u := range a {
+		var found bool
+		for _, v := range b {
+			if u == v {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
 	}
+	return true
 }
 
 func (e *emoji) Rename(name emojis.EmojiName) {
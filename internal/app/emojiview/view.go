@@ -35,6 +35,7 @@ type View struct {
 
 	search string
 	emojis map[emojis.EmojiName]emoji
+	pack   *emojis.PackInfo
 	roomID matrix.RoomID // empty if user, constant
 
 	ctx    gtkutil.Canceller
@@ -274,12 +275,21 @@ func (v *View) onlineFetch() {
 	}()
 }
 
-func fetchEmotes(client *gotktrix.Client, roomID matrix.RoomID) (emojis.EmojiMap, error) {
+func fetchEmotes(client *gotktrix.Client, roomID matrix.RoomID) (*emojis.EmoticonEventData, error) {
+	var d *emojis.EmoticonEventData
+	var err error
+
 	if roomID != "" {
-		return emojis.RoomEmotes(client, roomID)
+		d, err = emojis.RoomPack(client, roomID)
 	} else {
-		return emojis.UserEmotes(client)
+		d, err = emojis.UserPack(client)
+	}
+
+	if err == nil && d == nil {
+		err = errors.New("unexpected emoji event")
 	}
+
+	return d, err
 }
 
 // ToData converts View's internal state to an EmoticonEventData type.
@@ -287,16 +297,22 @@ func (v *View) ToData() emojis.EmoticonEventData {
 	emoticons := make(map[emojis.EmojiName]emojis.Emoji, len(v.emojis))
 
 	for name, emoji := range v.emojis {
-		emoticons[name] = emojis.Emoji{
-			URL: emoji.mxc,
-		}
+		emoticons[name] = emoji.data(v.packUsage())
 	}
 
 	return emojis.EmoticonEventData{
 		Emoticons: emoticons,
+		Pack:      v.pack,
 	}
 }
 
+func (v *View) packUsage() []emojis.EmojiUsage {
+	if v.pack == nil {
+		return nil
+	}
+	return v.pack.Usage
+}
+
 func (v *View) syncEmojis(busy *gtk.Spinner) {
 	ctx := v.ctx.Take()
 	client := v.client.WithContext(ctx)
@@ -372,7 +388,10 @@ func (v *View) renameEmoji(old, new emojis.EmojiName) {
 	v.emojis[new] = emoji
 }
 
-func (v *View) useEmoticonEvent(emojiMap emojis.EmojiMap) {
+func (v *View) useEmoticonEvent(data *emojis.EmoticonEventData) {
+	v.pack = data.Pack
+	emojiMap := data.Emoticons
+
 	// Check for existing emojis.
 	for name, emoji := range emojiMap {
 		old, ok := v.emojis[name]
@@ -381,6 +400,11 @@ func (v *View) useEmoticonEvent(emojiMap emojis.EmojiMap) {
 			continue
 		}
 
+		old.body = emoji.Body
+		old.info = emoji.Info
+		old.setUsage(emoji, v.packUsage())
+		v.emojis[name] = old
+
 		if old.mxc == emoji.URL {
 			// Same URL. Skip.
 			continue
@@ -412,13 +436,17 @@ func (v *View) useEmoticonEvent(emojiMap emojis.EmojiMap) {
 
 	// Add missing emojis.
 	for name, emoji := range emojiMap {
-		v.addEmoji(name, emoji.URL)
+		v.addEmoji(name, emoji)
 	}
 }
 
-func (v *View) addEmoji(name emojis.EmojiName, mxc matrix.URL) emoji {
+func (v *View) addEmoji(name emojis.EmojiName, data emojis.Emoji) emoji {
 	emoji := newEmptyEmoji(name)
-	emoji.mxc = mxc
+	emoji.mxc = data.URL
+	emoji.body = data.Body
+	emoji.info = data.Info
+	emoji.setUsage(data, v.packUsage())
+	emoji.usage.NotifyProperty("selected", func() { v.sync.SetSensitive(true) })
 
 	url, _ := v.client.SquareThumbnail(emoji.mxc, EmojiSize, gtkutil.ScaleFactor())
 	imgutil.AsyncGET(v.ctx.Take(), url, imgutil.ImageSetterFromImage(emoji.emoji))
@@ -485,7 +513,7 @@ func (v *View) addEmotesFromfile(path string) {
 
 		glib.IdleAdd(func() {
 			v.list.Remove(emoji)
-			v.addEmoji(name, u)
+			v.addEmoji(name, emojis.Emoji{URL: u})
 			v.sync.SetSensitive(true)
 		})
 	}()
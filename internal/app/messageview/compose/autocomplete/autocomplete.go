@@ -184,6 +184,12 @@ func (a *Autocompleter) Autocomplete() {
 		return
 	}
 
+	// Commands are only valid at the start of the message.
+	if _, ok := searcher.(*commandSearcher); ok && !a.start.IsStart() {
+		a.hide()
+		return
+	}
+
 	// cancelled on next run
 	ctx, cancel := context.WithCancel(a.parent)
 	a.cancel = cancel
@@ -155,8 +155,14 @@ func (s *emojiSearcher) update() {
 
 	s.updated = now
 
-	userEmotes, _ := emojis.UserEmotes(s.client.Offline())
-	roomEmotes, _ := emojis.RoomEmotes(s.client.Offline(), s.roomID)
+	// Stickers can't be used inline.
+	var userEmotes, roomEmotes emojis.EmojiMap
+	if d, _ := emojis.UserPack(s.client.Offline()); d != nil {
+		userEmotes = d.Filter(emojis.EmoticonUsage)
+	}
+	if d, _ := emojis.RoomPack(s.client.Offline(), s.roomID); d != nil {
+		roomEmotes = d.Filter(emojis.EmoticonUsage)
+	}
 
 	if len(userEmotes)+len(roomEmotes) == 0 {
 		s.emotes = nil
@@ -298,3 +304,87 @@ func (d EmojiData) Row(ctx context.Context) *gtk.ListBoxRow {
 
 	return r
 }
+
+// CommandData is the Data structure for each slash command.
+type CommandData struct {
+	Name string
+	// Args is the usage string of the command's arguments, such as
+	// "<user> [reason]".
+	Args        string
+	Description string
+}
+
+// Row implements Data.
+func (d CommandData) Row(ctx context.Context) *gtk.ListBoxRow {
+	usage := "/" + d.Name
+	if d.Args != "" {
+		usage += " " + d.Args
+	}
+
+	name := gtk.NewLabel(usage)
+	name.SetEllipsize(pango.EllipsizeEnd)
+	name.SetXAlign(0)
+
+	desc := gtk.NewLabel(d.Description)
+	desc.SetEllipsize(pango.EllipsizeEnd)
+	desc.SetXAlign(0)
+	desc.SetAttributes(subNameAttrs)
+
+	box := gtk.NewBox(gtk.OrientationVertical, 0)
+	box.Append(name)
+	box.Append(desc)
+
+	row := gtk.NewListBoxRow()
+	row.SetChild(box)
+	row.AddCSSClass("autocomplete-command")
+
+	return row
+}
+
+// NewCommandSearcher creates a new searcher that searches the given list of
+// commands. It matches using '/', but only at the start of the message.
+func NewCommandSearcher(commands []CommandData) Searcher {
+	names := make([]string, len(commands))
+	for i, cmd := range commands {
+		names[i] = cmd.Name
+	}
+
+	return &commandSearcher{
+		commands: commands,
+		names:    names,
+		res:      make(dataList, 0, MaxResults),
+	}
+}
+
+type commandSearcher struct {
+	commands []CommandData
+	names    []string
+	res      dataList
+}
+
+func (s *commandSearcher) Rune() rune { return '/' }
+
+func (s *commandSearcher) Search(ctx context.Context, str string) []Data {
+	s.res.clear()
+
+	if str == "" {
+		for _, cmd := range s.commands {
+			if len(s.res) == MaxResults {
+				break
+			}
+			s.res.add(cmd)
+		}
+		return s.res
+	}
+
+	matches := fuzzy.Find(str, s.names)
+	if len(matches) > MaxResults {
+		matches = matches[:MaxResults]
+	}
+
+	for _, match := range matches {
+		s.res.add(s.commands[match.Index])
+	}
+
+	return s.res
+}
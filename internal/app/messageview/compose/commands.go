@@ -0,0 +1,383 @@
+package compose
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/diamondburned/gotktrix/internal/app/messageview/compose/autocomplete"
+	"github.com/diamondburned/gotktrix/internal/gotktrix"
+	"github.com/diamondburned/gotrix/api"
+	"github.com/diamondburned/gotrix/event"
+	"github.com/diamondburned/gotrix/matrix"
+	"github.com/pkg/errors"
+)
+
+// Command is a slash command that can be typed into the composer.
+type Command struct {
+	// Name is the name of the command without the slash.
+	Name string
+	// Args is the usage string of the arguments, such as "<user> [reason]".
+	Args string
+	// Description is a short description of what the command does.
+	Description string
+	// MinArgs is the minimum number of arguments that the command needs.
+	MinArgs int
+	// Run runs the command. It is called in a goroutine.
+	Run func(*CommandContext) error
+}
+
+// CommandContext is the context given to a command when it is run.
+type CommandContext struct {
+	context.Context
+	Client *gotktrix.Client
+	RoomID matrix.RoomID
+	// Args is the whole argument string in plain text.
+	Args string
+	// Argv is Args split into words. Words can be quoted using double quotes.
+	Argv []string
+
+	data inputData
+}
+
+// SendMessage sends a message of the given type into the room. The message is
+// given in Markdown and is rendered the same way as a normal message, so
+// replies and edits are kept.
+func (c *CommandContext) SendMessage(typ event.MessageType, plain, markdown string) error {
+	data := c.data
+	data.plain = plain
+	data.html = markdown
+
+	ev := data.put(c.Client)
+	ev.MessageType = typ
+	if ev.NewContent != nil {
+		ev.NewContent.MessageType = typ
+	}
+
+	_, err := c.Client.Outbox.Enqueue(ev.RoomID, ev.Type, ev)
+	return err
+}
+
+var commands = map[string]Command{}
+
+// RegisterCommand registers the given command. It overrides any existing
+// command with the same name. It should only be called on init.
+func RegisterCommand(cmd Command) {
+	commands[cmd.Name] = cmd
+}
+
+// LookupCommand looks up the command with the given name.
+func LookupCommand(name string) (Command, bool) {
+	cmd, ok := commands[name]
+	return cmd, ok
+}
+
+// Commands returns all registered commands sorted by name.
+func Commands() []Command {
+	cmds := make([]Command, 0, len(commands))
+	for _, cmd := range commands {
+		cmds = append(cmds, cmd)
+	}
+
+	sort.Slice(cmds, func(i, j int) bool { return cmds[i].Name < cmds[j].Name })
+	return cmds
+}
+
+func commandData() []autocomplete.CommandData {
+	cmds := Commands()
+
+	data := make([]autocomplete.CommandData, len(cmds))
+	for i, cmd := range cmds {
+		data[i] = autocomplete.CommandData{
+			Name:        cmd.Name,
+			Args:        cmd.Args,
+			Description: cmd.Description,
+		}
+	}
+
+	return data
+}
+
+// command splits the command off the input data. If the input isn't a command,
+// then ok is false. Messages starting with "//" aren't commands; they're sent
+// with the first slash removed.
+func (data *inputData) command() (name string, ok bool) {
+	if !strings.HasPrefix(data.plain, "/") {
+		return "", false
+	}
+
+	if strings.HasPrefix(data.plain, "//") {
+		data.plain = strings.TrimPrefix(data.plain, "/")
+		data.html = strings.TrimPrefix(data.html, "/")
+		return "", false
+	}
+
+	name = strings.TrimPrefix(data.plain, "/")
+	if i := strings.IndexFunc(name, unicode.IsSpace); i != -1 {
+		name = name[:i]
+	}
+
+	data.plain = strings.TrimSpace(strings.TrimPrefix(data.plain, "/"+name))
+	data.html = strings.TrimSpace(strings.TrimPrefix(data.html, "/"+name))
+
+	return name, true
+}
+
+// runCommand runs the command with the given name using the input data, which
+// must already have the command name removed.
+func runCommand(ctx context.Context, name string, data inputData) error {
+	cmd, ok := LookupCommand(name)
+	if !ok {
+		return errors.Errorf("unknown command /%s", name)
+	}
+
+	cmdCtx := CommandContext{
+		Context: ctx,
+		Client:  gotktrix.FromContext(ctx),
+		RoomID:  data.roomID,
+		Args:    data.plain,
+		Argv:    splitArgs(data.plain),
+		data:    data,
+	}
+
+	if len(cmdCtx.Argv) < cmd.MinArgs {
+		return errors.Errorf("usage: /%s %s", cmd.Name, cmd.Args)
+	}
+
+	return cmd.Run(&cmdCtx)
+}
+
+// splitArgs splits the given string into words. Words that are surrounded by
+// double quotes may contain spaces, and a backslash escapes the next
+// character.
+func splitArgs(str string) []string {
+	var args []string
+	var word strings.Builder
+	var inWord, quoted, escaped bool
+
+	for _, r := range str {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			escaped = true
+			inWord = true
+		case r == '"':
+			quoted = !quoted
+			inWord = true
+		case unicode.IsSpace(r) && !quoted:
+			if inWord {
+				args = append(args, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+
+	if inWord {
+		args = append(args, word.String())
+	}
+
+	return args
+}
+
+// restArgs returns the argument string after the first n words.
+func restArgs(str string, n int) string {
+	for i := 0; i < n; i++ {
+		str = strings.TrimLeftFunc(str, unicode.IsSpace)
+		if j := strings.IndexFunc(str, unicode.IsSpace); j != -1 {
+			str = str[j:]
+		} else {
+			str = ""
+		}
+	}
+	return strings.TrimSpace(str)
+}
+
+const shrug = `¯\_(ツ)_/¯`
+
+func init() {
+	RegisterCommand(Command{
+		Name:        "me",
+		Args:        "<message>",
+		Description: "Send an emote message",
+		MinArgs:     1,
+		Run: func(c *CommandContext) error {
+			return c.SendMessage(event.RoomMessageEmote, c.Args, c.data.html)
+		},
+	})
+
+	RegisterCommand(Command{
+		Name:        "shrug",
+		Args:        "[message]",
+		Description: "Append " + shrug + " to the message",
+		Run: func(c *CommandContext) error {
+			plain := strings.TrimSpace(c.Args + " " + shrug)
+			// Escape the backslash and the underscores for Markdown.
+			markdown := strings.TrimSpace(c.data.html + ` ¯\\\_(ツ)\_/¯`)
+			return c.SendMessage(event.RoomMessageText, plain, markdown)
+		},
+	})
+
+	RegisterCommand(Command{
+		Name:        "rainbow",
+		Args:        "<message>",
+		Description: "Send a rainbow-colored message",
+		MinArgs:     1,
+		Run: func(c *CommandContext) error {
+			return c.SendMessage(event.RoomMessageText, c.Args, rainbowMarkdown(c.Args))
+		},
+	})
+
+	RegisterCommand(Command{
+		Name:        "topic",
+		Args:        "<topic>",
+		Description: "Set the room topic",
+		MinArgs:     1,
+		Run: func(c *CommandContext) error {
+			_, err := c.Client.RoomStateSend(c.RoomID, api.RoomStateSendArg{
+				Type:    event.TypeRoomTopic,
+				Content: event.RoomTopicEvent{Topic: c.Args},
+			})
+			return err
+		},
+	})
+
+	RegisterCommand(Command{
+		Name:        "nick",
+		Args:        "<name>",
+		Description: "Set your display name in this room",
+		MinArgs:     1,
+		Run: func(c *CommandContext) error {
+			st, err := c.Client.RoomState(c.RoomID, event.TypeRoomMember, string(c.Client.UserID))
+			if err != nil {
+				return errors.Wrap(err, "failed to get own member state")
+			}
+
+			var raw struct {
+				Content map[string]json.RawMessage `json:"content"`
+			}
+
+			// Only change the display name, so that the other fields, such as
+			// is_direct and the ones of extensions, are kept.
+			if err := json.Unmarshal(st.Info().Raw, &raw); err != nil || raw.Content == nil {
+				return errors.New("invalid own member state")
+			}
+
+			content := raw.Content
+			content["displayname"], _ = json.Marshal(c.Args)
+
+			_, err = c.Client.RoomStateSend(c.RoomID, api.RoomStateSendArg{
+				Type:     event.TypeRoomMember,
+				StateKey: string(c.Client.UserID),
+				Content:  content,
+			})
+			return err
+		},
+	})
+
+	RegisterCommand(Command{
+		Name:        "invite",
+		Args:        "<user> [reason]",
+		Description: "Invite a user into this room",
+		MinArgs:     1,
+		Run: func(c *CommandContext) error {
+			userID := matrix.UserID(c.Argv[0])
+			if _, _, err := userID.Parse(); err != nil {
+				return errors.Wrap(err, "invalid user ID")
+			}
+			return c.Client.Invite(c.RoomID, userID, restArgs(c.Args, 1))
+		},
+	})
+
+	RegisterCommand(Command{
+		Name:        "join",
+		Args:        "<room>",
+		Description: "Join a room by its ID, alias or link",
+		MinArgs:     1,
+		Run: func(c *CommandContext) error {
+			link, err := gotktrix.ParseRoomLink(c.Argv[0])
+			if err != nil {
+				return err
+			}
+
+			_, err = c.Client.JoinRoom(link.IDOrAlias, link.Via)
+			return err
+		},
+	})
+
+	RegisterCommand(Command{
+		Name:        "leave",
+		Args:        "[reason]",
+		Description: "Leave this room",
+		Run: func(c *CommandContext) error {
+			return c.Client.RoomLeave(c.RoomID, c.Args)
+		},
+	})
+}
+
+// rainbowMarkdown colors each character of the given string in a different
+// color of the rainbow. The returned string is Markdown with inline HTML.
+func rainbowMarkdown(str string) string {
+	runes := []rune(str)
+
+	var b strings.Builder
+	for i, r := range runes {
+		if unicode.IsSpace(r) {
+			b.WriteRune(r)
+			continue
+		}
+
+		hue := float64(i) / float64(len(runes))
+		fmt.Fprintf(&b, `<font data-mx-color="%s">`, hueColor(hue))
+
+		switch {
+		case strings.ContainsRune(`<>&"'`, r):
+			b.WriteString(html.EscapeString(string(r)))
+		case r < unicode.MaxASCII && (unicode.IsPunct(r) || unicode.IsSymbol(r)):
+			// Escape Markdown syntax.
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		default:
+			b.WriteRune(r)
+		}
+
+		b.WriteString("</font>")
+	}
+
+	return b.String()
+}
+
+// hueColor converts the given hue within [0, 1) into a fully saturated hex
+// color.
+func hueColor(hue float64) string {
+	h := hue * 6
+	x := 1 - math.Abs(math.Mod(h, 2)-1)
+
+	var r, g, b float64
+	switch int(h) {
+	case 0:
+		r, g, b = 1, x, 0
+	case 1:
+		r, g, b = x, 1, 0
+	case 2:
+		r, g, b = 0, 1, x
+	case 3:
+		r, g, b = 0, x, 1
+	case 4:
+		r, g, b = x, 0, 1
+	default:
+		r, g, b = 1, 0, x
+	}
+
+	return fmt.Sprintf("#%02x%02x%02x", int(r*255), int(g*255), int(b*255))
+}
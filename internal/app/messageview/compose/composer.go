@@ -5,6 +5,7 @@ import (
 	"context"
 	"html"
 
+	"github.com/diamondburned/gotk4/pkg/core/glib"
 	"github.com/diamondburned/gotk4/pkg/gtk/v4"
 	"github.com/diamondburned/gotk4/pkg/pango"
 	"github.com/diamondburned/gotkit/app/locale"
@@ -22,6 +23,7 @@ type Composer struct {
 	iscroll     *gtk.ScrolledWindow
 	input       *Input
 	send        *gtk.Button
+	stickers    *gtk.MenuButton
 	placeholder *gtk.Label
 
 	ctx    context.Context
@@ -33,6 +35,11 @@ type Composer struct {
 		current func()
 	}
 	editing bool
+
+	// drafting is true once the draft is restored.
+	drafting    bool
+	draftHandle glib.SourceHandle
+	lastDraft   string
 }
 
 // Controller describes the parent component that the Composer controls.
@@ -143,6 +150,7 @@ func New(ctx context.Context, ctrl Controller, roomID matrix.RoomID) *Composer {
 		start, end := c.input.buffer.Bounds()
 		// Reveal if the buffer has 0 length.
 		revealer.SetRevealChild(start.Offset() == end.Offset())
+		c.queueDraft()
 	})
 
 	c.iscroll = gtk.NewScrolledWindow()
@@ -158,9 +166,18 @@ func New(ctx context.Context, ctrl Controller, roomID matrix.RoomID) *Composer {
 	c.send.ConnectClicked(func() { c.input.Send() })
 	sendCSS(c.send)
 
+	c.stickers = gtk.NewMenuButton()
+	c.stickers.SetIconName("face-smile-big-symbolic")
+	c.stickers.SetTooltipText(locale.S(ctx, "Stickers"))
+	c.stickers.SetHasFrame(false)
+	c.stickers.SetDirection(gtk.ArrowUp)
+	c.stickers.SetPopover(newStickerPicker(ctx, &c))
+	sendCSS(c.stickers)
+
 	c.Box = gtk.NewBox(gtk.OrientationHorizontal, 0)
 	c.Append(c.action)
 	c.Append(c.iscroll)
+	c.Append(c.stickers)
 	c.Append(c.send)
 	c.SetFocusChild(c.iscroll)
 	composerCSS(c.Box)
@@ -171,6 +188,10 @@ func New(ctx context.Context, ctrl Controller, roomID matrix.RoomID) *Composer {
 	// 	"stop-editing":  func() { ctrl.Edit("") },
 	// })
 
+	// Save the draft right away when the composer goes away, since the room
+	// is likely being switched.
+	c.ConnectUnmap(c.saveDraft)
+
 	c.action.ConnectClicked(func() { c.action.current() })
 	c.resetAction()
 	c.SetPlaceholder("")
@@ -182,8 +203,15 @@ func New(ctx context.Context, ctrl Controller, roomID matrix.RoomID) *Composer {
 // empty string is given.
 func (c *Composer) SetPlaceholder(markup string) {
 	if markup == "" {
-		roomName, _ := gotktrix.FromContext(c.ctx).Offline().RoomName(c.roomID)
-		markup = locale.Sprintf(c.ctx, "Message %s", html.EscapeString(roomName))
+		client := gotktrix.FromContext(c.ctx).Offline()
+		roomName, _ := client.RoomName(c.roomID)
+		if c.input.thread != "" {
+			markup = locale.S(c.ctx, "Reply in thread")
+		} else if client.RoomIsEncrypted(c.roomID) {
+			markup = locale.Sprintf(c.ctx, "Encrypted message to %s", html.EscapeString(roomName))
+		} else {
+			markup = locale.Sprintf(c.ctx, "Message %s", html.EscapeString(roomName))
+		}
 	}
 	c.placeholder.SetMarkup(markup)
 }
@@ -196,6 +224,13 @@ func (c *Composer) uploader() uploader {
 	}
 }
 
+// SetThread makes the composer send all messages into the thread with the
+// given root. An empty string sends messages into the main timeline again.
+func (c *Composer) SetThread(rootID matrix.EventID) {
+	c.input.thread = rootID
+	c.SetPlaceholder("")
+}
+
 // Input returns the composer's input.
 func (c *Composer) Input() *Input {
 	return c.input
@@ -240,6 +275,7 @@ func (c *Composer) Edit(eventID matrix.EventID) bool {
 		c.resetAction()
 		c.SetPlaceholder("")
 	}
+	c.queueDraft()
 	return c.editing
 }
 
@@ -300,6 +336,8 @@ func (c *Composer) ReplyTo(eventID matrix.EventID) bool {
 	c.input.editing = ""
 	c.input.replyingTo = eventID
 
+	defer c.queueDraft()
+
 	if c.input.replyingTo == "" {
 		c.send.SetIconName(sendIcon)
 		c.resetAction()
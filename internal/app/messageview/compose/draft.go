@@ -0,0 +1,165 @@
+package compose
+
+import (
+	"log"
+	"strings"
+
+	"github.com/diamondburned/gotk4/pkg/core/glib"
+	"github.com/diamondburned/gotktrix/internal/gotktrix"
+)
+
+// draftDelay is the delay in milliseconds after the last change before the
+// draft is saved.
+const draftDelay = 750
+
+// RestoreDraft restores the room's saved draft into the composer, including
+// the message that it was replying to or editing. The composer saves its draft
+// only after this is called, so the caller should call it once the messages
+// that the draft may refer to are loaded.
+func (c *Composer) RestoreDraft() {
+	if c.drafting {
+		return
+	}
+
+	client := gotktrix.FromContext(c.ctx).Offline()
+	draft, ok := client.RoomDraft(c.roomID)
+
+	if ok {
+		// Editing replaces the input text, so restore the text after.
+		switch {
+		case draft.Editing != "":
+			c.ctrl.Edit(draft.Editing)
+		case draft.ReplyingTo != "":
+			c.ctrl.ReplyTo(draft.ReplyingTo)
+		}
+
+		c.input.restoreDraft(draft)
+	}
+
+	c.drafting = true
+}
+
+// queueDraft queues the composer's content to be saved as the room's draft.
+func (c *Composer) queueDraft() {
+	if !c.drafting || c.draftHandle != 0 {
+		return
+	}
+
+	c.draftHandle = glib.TimeoutAdd(draftDelay, func() {
+		c.draftHandle = 0
+		c.saveDraft()
+	})
+}
+
+// saveDraft saves the composer's content as the room's draft immediately.
+func (c *Composer) saveDraft() {
+	if !c.drafting {
+		return
+	}
+
+	if c.draftHandle != 0 {
+		glib.SourceRemove(c.draftHandle)
+		c.draftHandle = 0
+	}
+
+	draft, key := c.input.draft()
+	if key == c.lastDraft {
+		return
+	}
+	c.lastDraft = key
+
+	client := gotktrix.FromContext(c.ctx).Offline()
+	roomID := c.roomID
+
+	go func() {
+		if err := client.SetRoomDraft(roomID, draft); err != nil {
+			log.Printf("failed to save draft for room %q: %v", roomID, err)
+		}
+	}()
+}
+
+// draft returns the input's current content as a draft. A key that is the same
+// for equal drafts is also returned.
+func (i *Input) draft() (gotktrix.Draft, string) {
+	start, end := i.buffer.Bounds()
+
+	draft := gotktrix.Draft{
+		// Slice keeps the U+FFFC characters of the anchors.
+		Text:       i.buffer.Slice(start, end, true),
+		ReplyingTo: i.replyingTo,
+		Editing:    i.editing,
+	}
+
+	var key strings.Builder
+	key.WriteString(draft.Text)
+	key.WriteByte(0)
+	key.WriteString(string(draft.ReplyingTo))
+	key.WriteByte(0)
+	key.WriteString(string(draft.Editing))
+
+	for elem := i.anchors.Front(); elem != nil; elem = elem.Next() {
+		anchor := elem.Value.(anchorPiece)
+		if anchor.anchor.Deleted() {
+			continue
+		}
+
+		iter := i.buffer.IterAtChildAnchor(anchor.anchor)
+
+		draft.Anchors = append(draft.Anchors, gotktrix.DraftAnchor{
+			Offset:  iter.Offset(),
+			HTML:    anchor.html,
+			Text:    anchor.text,
+			Mention: anchor.mention,
+			Emoji:   anchor.emoji,
+		})
+
+		key.WriteByte(0)
+		key.WriteString(anchor.html)
+	}
+
+	return draft, key.String()
+}
+
+// restoreDraft replaces the input's content with the given draft's text and
+// anchors.
+func (i *Input) restoreDraft(draft gotktrix.Draft) {
+	i.SetText("")
+	i.anchors.Init()
+
+	anchors := make(map[int]gotktrix.DraftAnchor, len(draft.Anchors))
+	for _, anchor := range draft.Anchors {
+		anchors[anchor.Offset] = anchor
+	}
+
+	iter := i.buffer.StartIter()
+
+	var text strings.Builder
+	flush := func() {
+		if text.Len() > 0 {
+			i.buffer.Insert(iter, text.String())
+			text.Reset()
+		}
+	}
+
+	var offset int
+	for _, r := range draft.Text {
+		anchor, ok := anchors[offset]
+		offset++
+
+		if !ok || r != '\uFFFC' {
+			text.WriteRune(r)
+			continue
+		}
+
+		flush()
+
+		switch {
+		case anchor.Mention != "":
+			i.insertMention(iter, anchor.Mention)
+		case anchor.Emoji != "":
+			i.insertEmoji(iter, anchor.Text, anchor.Emoji, anchor.HTML)
+		}
+	}
+
+	flush()
+}
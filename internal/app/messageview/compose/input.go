@@ -11,7 +11,6 @@ import (
 	"time"
 
 	"github.com/diamondburned/gotk4/pkg/gdk/v4"
-	"github.com/diamondburned/gotk4/pkg/glib/v2"
 	"github.com/diamondburned/gotk4/pkg/gtk/v4"
 	"github.com/diamondburned/gotkit/app"
 	"github.com/diamondburned/gotkit/gtkutil"
@@ -21,6 +20,7 @@ import (
 	"github.com/diamondburned/gotktrix/internal/app/messageview/compose/autocomplete"
 	"github.com/diamondburned/gotktrix/internal/app/messageview/message/mauthor"
 	"github.com/diamondburned/gotktrix/internal/gotktrix"
+	"github.com/diamondburned/gotktrix/internal/gotktrix/events/m"
 	"github.com/diamondburned/gotktrix/internal/md"
 	"github.com/diamondburned/gotrix/event"
 	"github.com/diamondburned/gotrix/matrix"
@@ -51,12 +51,17 @@ type Input struct {
 type inputState struct {
 	editing    matrix.EventID
 	replyingTo matrix.EventID
+	// thread is the root of the thread that messages are sent into.
+	thread matrix.EventID
 }
 
 type anchorPiece struct {
 	anchor *gtk.TextChildAnchor
 	html   string
 	text   string
+	// mention and emoji are used to recreate the anchor from a draft.
+	mention matrix.UserID
+	emoji   matrix.URL
 }
 
 var inputCSS = cssutil.Applier("composer-input", `
@@ -99,6 +104,7 @@ func NewInput(ctx context.Context, ctrl InputController, roomID matrix.RoomID) *
 	i.acomp.Use(
 		autocomplete.NewRoomMemberSearcher(ctx, roomID), // @
 		autocomplete.NewEmojiSearcher(ctx, roomID),      // :
+		autocomplete.NewCommandSearcher(commandData()),  // /
 	)
 
 	i.buffer = i.TextView.Buffer()
@@ -148,44 +154,14 @@ func (i *Input) onAutocompleted(row autocomplete.SelectedData) bool {
 
 	switch data := row.Data.(type) {
 	case autocomplete.RoomMemberData:
-		chip := mauthor.NewChip(i.ctx, data.Room, data.ID)
-		anchor := chip.InsertText(i.TextView, row.Bounds[1])
-
-		// Register the anchor.
-		i.anchors.PushBack(anchorPiece{
-			anchor: anchor,
-			html: fmt.Sprintf(
-				`<a href="https://matrix.to/#/%s">%s</a>`,
-				html.EscapeString(string(data.ID)), html.EscapeString(chip.Name()),
-			),
-			text: string(data.ID),
-		})
+		i.insertMention(row.Bounds[1], data.ID)
 
 	case autocomplete.EmojiData:
 		if data.Unicode != "" {
 			// Unicode emoji means we can just insert it in plain text.
 			i.buffer.Insert(row.Bounds[1], data.Unicode)
 		} else {
-			anchor := i.buffer.CreateChildAnchor(row.Bounds[1])
-
-			image := md.InsertImageWidget(i.TextView, anchor)
-			image.AddCSSClass("compose-inline-emoji")
-			image.SetSizeRequest(inlineEmojiSize, inlineEmojiSize)
-			image.SetName(data.Name)
-
-			client := gotktrix.FromContext(i.ctx).Offline()
-			url, _ := client.SquareThumbnail(data.Custom.URL, inlineEmojiSize, gtkutil.ScaleFactor())
-			imgutil.AsyncGET(i.ctx, url, imgutil.ImageSetter{
-				SetFromPaintable: image.SetFromPaintable,
-				SetFromPixbuf:    image.SetFromPixbuf,
-			})
-
-			// Register the anchor.
-			i.anchors.PushBack(anchorPiece{
-				anchor: anchor,
-				html:   customEmojiHTML(data),
-				text:   data.Name,
-			})
+			i.insertEmoji(row.Bounds[1], data.Name, data.Custom.URL, customEmojiHTML(data))
 		}
 	default:
 		log.Printf("unknown data type %T", data)
@@ -195,6 +171,61 @@ func (i *Input) onAutocompleted(row autocomplete.SelectedData) bool {
 	return true
 }
 
+// InsertMention inserts a mention chip of the given user at the cursor and
+// focuses the input.
+func (i *Input) InsertMention(userID matrix.UserID) {
+	i.buffer.BeginUserAction()
+	defer i.buffer.EndUserAction()
+
+	iter := i.buffer.IterAtMark(i.buffer.GetInsert())
+	i.insertMention(iter, userID)
+	i.buffer.Insert(iter, " ")
+
+	i.GrabFocus()
+}
+
+// insertMention inserts a mention chip of the given user at iter.
+func (i *Input) insertMention(iter *gtk.TextIter, userID matrix.UserID) {
+	chip := mauthor.NewChip(i.ctx, i.roomID, userID)
+	anchor := chip.InsertText(i.TextView, iter)
+
+	// Register the anchor.
+	i.anchors.PushBack(anchorPiece{
+		anchor: anchor,
+		html: fmt.Sprintf(
+			`<a href="https://matrix.to/#/%s">%s</a>`,
+			html.EscapeString(string(userID)), html.EscapeString(chip.Name()),
+		),
+		text:    string(userID),
+		mention: userID,
+	})
+}
+
+// insertEmoji inserts an inline custom emoji at iter.
+func (i *Input) insertEmoji(iter *gtk.TextIter, name string, emojiURL matrix.URL, emojiHTML string) {
+	anchor := i.buffer.CreateChildAnchor(iter)
+
+	image := md.InsertImageWidget(i.TextView, anchor)
+	image.AddCSSClass("compose-inline-emoji")
+	image.SetSizeRequest(inlineEmojiSize, inlineEmojiSize)
+	image.SetName(name)
+
+	client := gotktrix.FromContext(i.ctx).Offline()
+	url, _ := client.SquareThumbnail(emojiURL, inlineEmojiSize, gtkutil.ScaleFactor())
+	imgutil.AsyncGET(i.ctx, url, imgutil.ImageSetter{
+		SetFromPaintable: image.SetFromPaintable,
+		SetFromPixbuf:    image.SetFromPixbuf,
+	})
+
+	// Register the anchor.
+	i.anchors.PushBack(anchorPiece{
+		anchor: anchor,
+		html:   emojiHTML,
+		text:   name,
+		emoji:  emojiURL,
+	})
+}
+
 func (i *Input) onKey(val, _ uint, state gdk.ModifierType) bool {
 	switch val {
 	case gdk.KEY_Return:
@@ -319,33 +350,29 @@ func (i *Input) Send() bool {
 	}
 
 	ctx := i.ctx
+
+	if name, ok := dt.command(); ok {
+		go func() {
+			if err := runCommand(ctx, name, dt); err != nil {
+				app.Error(ctx, errors.Wrapf(err, "/%s", name))
+			}
+		}()
+
+		i.buffer.Delete(i.buffer.Bounds())
+		i.ctrl.ReplyTo("")
+		i.ctrl.Edit("")
+		return true
+	}
+
 	go func() {
 		client := gotktrix.FromContext(ctx)
 		roomEv := dt.put(client)
 
-		var eventID matrix.EventID
-		var err error
-
-		// Only push a new message if we're not editing.
-		if dt.editing == "" {
-			rowCh := make(chan interface{}, 1)
-			glib.IdleAdd(func() {
-				// Give the controller the RoomMessageEvent instead of our
-				// private type.
-				rowCh <- i.ctrl.AddSendingMessage(&roomEv.RoomMessageEvent)
-			})
-
-			defer func() {
-				row := <-rowCh
-				glib.IdleAdd(func() {
-					i.ctrl.BindSendingMessage(row, eventID)
-				})
-			}()
-		}
-
-		eventID, err = client.RoomEventSend(roomEv.RoomID, roomEv.Type, roomEv)
+		// The message view picks up the message from the outbox, so there's
+		// no need to add a sending message here.
+		_, err := client.Outbox.Enqueue(roomEv.RoomID, roomEv.Type, roomEv)
 		if err != nil {
-			app.Error(i.ctx, errors.Wrap(err, "failed to send message"))
+			app.Error(ctx, errors.Wrap(err, "failed to queue message"))
 		}
 	}()
 
@@ -488,24 +515,33 @@ func (data inputData) relatesTo() json.RawMessage {
 		return nil
 	}
 
-	type inReplyTo struct {
-		EventID matrix.EventID `json:"event_id"`
+	var relatesTo struct {
+		EventID       matrix.EventID `json:"event_id,omitempty"`
+		RelType       string         `json:"rel_type,omitempty"`
+		IsFallingBack bool           `json:"is_falling_back,omitempty"`
+		InReplyTo     *m.InReplyTo   `json:"m.in_reply_to,omitempty"`
 	}
 
-	var relatesTo struct {
-		EventID   matrix.EventID `json:"event_id,omitempty"`
-		RelType   string         `json:"rel_type,omitempty"`
-		InReplyTo *inReplyTo     `json:"m.in_reply_to,omitempty"`
+	if data.replyingTo != "" {
+		relatesTo.InReplyTo = &m.InReplyTo{
+			EventID: data.replyingTo,
+		}
 	}
 
-	if data.editing != "" {
+	switch {
+	case data.editing != "":
 		relatesTo.EventID = data.editing
 		relatesTo.RelType = "m.replace"
-	}
-
-	if data.replyingTo != "" {
-		relatesTo.InReplyTo = &inReplyTo{
-			EventID: data.replyingTo,
+	case data.thread != "":
+		relatesTo.EventID = data.thread
+		relatesTo.RelType = m.ThreadRelType
+		// Clients that don't know about threads will see the message as a
+		// reply to the thread root.
+		if relatesTo.InReplyTo == nil {
+			relatesTo.IsFallingBack = true
+			relatesTo.InReplyTo = &m.InReplyTo{
+				EventID: data.thread,
+			}
 		}
 	}
 
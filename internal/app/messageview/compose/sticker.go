@@ -0,0 +1,196 @@
+package compose
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+
+	"github.com/diamondburned/gotk4/pkg/gtk/v4"
+	"github.com/diamondburned/gotkit/app"
+	"github.com/diamondburned/gotkit/app/locale"
+	"github.com/diamondburned/gotkit/gtkutil"
+	"github.com/diamondburned/gotkit/gtkutil/cssutil"
+	"github.com/diamondburned/gotkit/gtkutil/imgutil"
+	"github.com/diamondburned/gotktrix/internal/gotktrix"
+	"github.com/diamondburned/gotktrix/internal/gotktrix/events/emojis"
+	"github.com/diamondburned/gotktrix/internal/gotktrix/events/m"
+	"github.com/diamondburned/gotktrix/internal/sortutil"
+	"github.com/diamondburned/gotrix/matrix"
+	"github.com/pkg/errors"
+)
+
+// stickerPickerSize is the size of each sticker in the picker.
+const stickerPickerSize = 72
+
+// stickerPicker is a popover that lists the sticker packs of the user and the
+// room.
+type stickerPicker struct {
+	*gtk.Popover
+	stack    *gtk.Stack
+	switcher *gtk.StackSwitcher
+
+	ctx      context.Context
+	composer *Composer
+}
+
+var stickerPickerCSS = cssutil.Applier("composer-stickers", `
+	.composer-stickers stackswitcher {
+		margin-bottom: 4px;
+	}
+	.composer-stickers flowboxchild {
+		padding: 4px;
+		border-radius: 4px;
+	}
+`)
+
+func newStickerPicker(ctx context.Context, c *Composer) *stickerPicker {
+	p := stickerPicker{
+		ctx:      ctx,
+		composer: c,
+	}
+
+	p.stack = gtk.NewStack()
+	p.stack.SetTransitionType(gtk.StackTransitionTypeCrossfade)
+
+	p.switcher = gtk.NewStackSwitcher()
+	p.switcher.SetStack(p.stack)
+	p.switcher.SetHAlign(gtk.AlignCenter)
+
+	scroll := gtk.NewScrolledWindow()
+	scroll.SetPolicy(gtk.PolicyNever, gtk.PolicyAutomatic)
+	scroll.SetSizeRequest(4*(stickerPickerSize+12), 3*(stickerPickerSize+12))
+	scroll.SetChild(p.stack)
+
+	box := gtk.NewBox(gtk.OrientationVertical, 0)
+	box.Append(p.switcher)
+	box.Append(scroll)
+
+	p.Popover = gtk.NewPopover()
+	p.Popover.SetChild(box)
+	p.Popover.ConnectShow(p.invalidate)
+	stickerPickerCSS(p.Popover)
+
+	return &p
+}
+
+// invalidate reloads all sticker packs.
+func (p *stickerPicker) invalidate() {
+	for child := p.stack.FirstChild(); child != nil; child = p.stack.FirstChild() {
+		p.stack.Remove(child)
+	}
+
+	client := gotktrix.FromContext(p.ctx).Offline()
+	packs := emojis.StickerPacks(client, p.composer.roomID)
+
+	if len(packs) == 0 {
+		empty := gtk.NewLabel(locale.S(p.ctx,
+			"No stickers yet. Stickers can be added in the emoji settings."))
+		empty.SetWrap(true)
+		empty.SetMaxWidthChars(30)
+		p.stack.AddChild(empty)
+		p.switcher.Hide()
+		return
+	}
+
+	p.switcher.SetVisible(len(packs) > 1)
+
+	for _, pack := range packs {
+		name := pack.Name
+		if name == "" {
+			name = locale.S(p.ctx, "Your Stickers")
+		}
+
+		p.stack.AddTitled(p.newPack(pack), "pack-"+string(pack.RoomID), name)
+	}
+}
+
+func (p *stickerPicker) newPack(pack emojis.StickerPack) gtk.Widgetter {
+	names := make([]emojis.EmojiName, 0, len(pack.Stickers))
+	for name := range pack.Stickers {
+		names = append(names, name)
+	}
+
+	sort.Slice(names, func(i, j int) bool {
+		return sortutil.LessFold(string(names[i]), string(names[j]))
+	})
+
+	flow := gtk.NewFlowBox()
+	flow.SetSelectionMode(gtk.SelectionNone)
+	flow.SetActivateOnSingleClick(true)
+	flow.SetHomogeneous(true)
+	flow.SetMinChildrenPerLine(4)
+	flow.SetMaxChildrenPerLine(4)
+
+	client := gotktrix.FromContext(p.ctx)
+
+	for _, name := range names {
+		sticker := pack.Stickers[name]
+
+		img := gtk.NewImage()
+		img.SetSizeRequest(stickerPickerSize, stickerPickerSize)
+		img.SetTooltipText(name.Name())
+
+		url, _ := client.SquareThumbnail(sticker.URL, stickerPickerSize, gtkutil.ScaleFactor())
+		imgutil.AsyncGET(p.ctx, url, imgutil.ImageSetterFromImage(img))
+
+		child := gtk.NewFlowBoxChild()
+		child.SetChild(img)
+		child.SetName(string(name))
+		flow.Insert(child, -1)
+	}
+
+	flow.ConnectChildActivated(func(child *gtk.FlowBoxChild) {
+		name := emojis.EmojiName(child.Name())
+		p.Popdown()
+		p.composer.sendSticker(name, pack.Stickers[name])
+	})
+
+	return flow
+}
+
+// stickerContent is the content of a sending m.sticker event.
+type stickerContent struct {
+	Body      string          `json:"body"`
+	URL       matrix.URL      `json:"url"`
+	Info      json.RawMessage `json:"info"`
+	RelatesTo json.RawMessage `json:"m.relates_to,omitempty"`
+}
+
+// sendSticker sends the given sticker into the room.
+func (c *Composer) sendSticker(name emojis.EmojiName, sticker emojis.Emoji) {
+	data := inputData{
+		roomID: c.roomID,
+		inputState: inputState{
+			replyingTo: c.input.replyingTo,
+			thread:     c.input.thread,
+		},
+	}
+
+	content := stickerContent{
+		Body:      sticker.Body,
+		URL:       sticker.URL,
+		Info:      sticker.Info,
+		RelatesTo: data.relatesTo(),
+	}
+
+	if content.Body == "" {
+		content.Body = name.Name()
+	}
+	// The info field is required, even if it's empty.
+	if len(content.Info) == 0 {
+		content.Info = json.RawMessage("{}")
+	}
+
+	ctx := c.ctx
+
+	go func() {
+		client := gotktrix.FromContext(ctx)
+
+		_, err := client.Outbox.Enqueue(c.roomID, m.StickerEventType, content)
+		if err != nil {
+			app.Error(ctx, errors.Wrap(err, "failed to queue sticker"))
+		}
+	}()
+
+	c.ctrl.ReplyTo("")
+}
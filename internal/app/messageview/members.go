@@ -0,0 +1,758 @@
+package messageview
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"github.com/diamondburned/gotk4/pkg/core/glib"
+	"github.com/diamondburned/gotk4/pkg/gtk/v4"
+	"github.com/diamondburned/gotk4/pkg/pango"
+	"github.com/diamondburned/gotkit/app"
+	"github.com/diamondburned/gotkit/app/locale"
+	"github.com/diamondburned/gotkit/components/dialogs"
+	"github.com/diamondburned/gotkit/components/onlineimage"
+	"github.com/diamondburned/gotkit/gtkutil"
+	"github.com/diamondburned/gotkit/gtkutil/cssutil"
+	"github.com/diamondburned/gotktrix/internal/app/userview"
+	"github.com/diamondburned/gotktrix/internal/gotktrix"
+	"github.com/diamondburned/gotktrix/internal/sortutil"
+	"github.com/diamondburned/gotrix/event"
+	"github.com/diamondburned/gotrix/matrix"
+	"github.com/pkg/errors"
+)
+
+const (
+	// memberPanelWidth is the width of the member list side panel.
+	memberPanelWidth = 250
+	// memberAvatarSize is the size of each member's avatar.
+	memberAvatarSize = 28
+	// memberSearchLimit is the maximum number of members searched in the index.
+	memberSearchLimit = 50
+)
+
+// memberGroup is the group that a member is listed under. Groups are listed in
+// their order.
+type memberGroup uint8
+
+const (
+	adminGroup memberGroup = iota
+	moderatorGroup
+	defaultGroup
+	invitedGroup
+	bannedGroup
+)
+
+func (g memberGroup) name(ctx context.Context) string {
+	switch g {
+	case adminGroup:
+		return locale.S(ctx, "Administrators")
+	case moderatorGroup:
+		return locale.S(ctx, "Moderators")
+	case invitedGroup:
+		return locale.S(ctx, "Invited")
+	case bannedGroup:
+		return locale.S(ctx, "Banned")
+	default:
+		return locale.S(ctx, "Members")
+	}
+}
+
+func groupMember(membership event.MemberType, level int) memberGroup {
+	switch {
+	case membership == event.MemberInvited:
+		return invitedGroup
+	case membership == event.MemberBanned:
+		return bannedGroup
+	case level >= 100:
+		return adminGroup
+	case level >= 50:
+		return moderatorGroup
+	default:
+		return defaultGroup
+	}
+}
+
+// memberPanel is the side panel that lists the members of the room.
+type memberPanel struct {
+	*gtk.Box
+	title   *gtk.Label
+	invite  *gtk.Button
+	search  *gtk.SearchEntry
+	list    *gtk.ListBox
+	loading *gtk.Spinner
+
+	page   *Page
+	roomID matrix.RoomID
+
+	rows   map[matrix.UserID]*memberRow
+	levels *gotktrix.PowerLevels
+	// matches is the set of members that match the search query. It is nil
+	// if the user isn't searching.
+	matches map[matrix.UserID]bool
+	// searchCancel cancels the ongoing search.
+	searchCancel context.CancelFunc
+}
+
+var memberPanelCSS = cssutil.Applier("messageview-members", `
+	.messageview-members {
+		border-left: 1px solid @borders;
+	}
+	.messageview-members-header {
+		padding: 4px 4px 4px 10px;
+		border-bottom: 1px solid @borders;
+	}
+	.messageview-members-header > label {
+		font-weight: bold;
+	}
+	.messageview-members-search {
+		margin: 6px;
+	}
+	.messageview-members-list {
+		background: none;
+	}
+	.messageview-members-group {
+		margin: 8px 10px 2px 10px;
+		font-size: 0.85em;
+		font-weight: bold;
+		color: alpha(@theme_fg_color, 0.75);
+	}
+`)
+
+func newMemberPanel(page *Page) *memberPanel {
+	ctx := page.ctx.Take()
+
+	m := memberPanel{
+		page:   page,
+		roomID: page.roomID,
+		rows:   make(map[matrix.UserID]*memberRow),
+	}
+
+	m.title = gtk.NewLabel(locale.S(ctx, "Members"))
+	m.title.SetXAlign(0)
+	m.title.SetHExpand(true)
+	m.title.SetEllipsize(pango.EllipsizeEnd)
+
+	m.invite = gtk.NewButtonFromIconName("contact-new-symbolic")
+	m.invite.SetHasFrame(false)
+	m.invite.SetTooltipText(locale.S(ctx, "Invite User"))
+	m.invite.ConnectClicked(func() { m.promptInvite() })
+
+	closeButton := gtk.NewButtonFromIconName("window-close-symbolic")
+	closeButton.SetHasFrame(false)
+	closeButton.SetTooltipText(locale.S(ctx, "Close Members"))
+	closeButton.ConnectClicked(func() { page.ToggleMembers() })
+
+	header := gtk.NewBox(gtk.OrientationHorizontal, 0)
+	header.AddCSSClass("messageview-members-header")
+	header.Append(m.title)
+	header.Append(m.invite)
+	header.Append(closeButton)
+
+	m.search = gtk.NewSearchEntry()
+	m.search.AddCSSClass("messageview-members-search")
+	m.search.SetObjectProperty("placeholder-text", locale.S(ctx, "Search Members"))
+	m.search.ConnectSearchChanged(func() { m.searchMembers(m.search.Text()) })
+
+	m.list = gtk.NewListBox()
+	m.list.AddCSSClass("messageview-members-list")
+	m.list.SetSelectionMode(gtk.SelectionNone)
+	m.list.SetSortFunc(m.sort)
+	m.list.SetFilterFunc(func(row *gtk.ListBoxRow) bool {
+		return m.matches == nil || m.matches[matrix.UserID(row.Name())]
+	})
+	m.list.SetHeaderFunc(func(row, before *gtk.ListBoxRow) {
+		r := m.rows[matrix.UserID(row.Name())]
+		if r == nil {
+			return
+		}
+
+		if before != nil {
+			if b := m.rows[matrix.UserID(before.Name())]; b != nil && b.group == r.group {
+				row.SetHeader(nil)
+				return
+			}
+		}
+
+		label := gtk.NewLabel(r.group.name(ctx))
+		label.AddCSSClass("messageview-members-group")
+		label.SetXAlign(0)
+		row.SetHeader(label)
+	})
+
+	m.loading = gtk.NewSpinner()
+	m.loading.SetSizeRequest(24, 24)
+	m.loading.SetMarginTop(8)
+	m.loading.SetMarginBottom(8)
+
+	listBox := gtk.NewBox(gtk.OrientationVertical, 0)
+	listBox.Append(m.loading)
+	listBox.Append(m.list)
+
+	scroll := gtk.NewScrolledWindow()
+	scroll.SetVExpand(true)
+	scroll.SetPolicy(gtk.PolicyNever, gtk.PolicyAutomatic)
+	scroll.SetChild(listBox)
+
+	m.Box = gtk.NewBox(gtk.OrientationVertical, 0)
+	m.Box.SetSizeRequest(memberPanelWidth, -1)
+	m.Box.Append(header)
+	m.Box.Append(m.search)
+	m.Box.Append(scroll)
+	memberPanelCSS(m.Box)
+
+	client := gotktrix.FromContext(ctx)
+
+	// Reload all members every time the panel is shown, since changes aren't
+	// tracked while it's hidden.
+	gtkutil.BindSubscribe(m, func() func() {
+		m.load()
+
+		onEvent := func(ev event.Event) {
+			switch ev := ev.(type) {
+			case *event.RoomMemberEvent:
+				glib.IdleAdd(func() { m.setMember(*ev) })
+			case *event.RoomPowerLevelsEvent:
+				glib.IdleAdd(func() { m.invalidateLevels() })
+			}
+		}
+
+		return gtkutil.FuncBatcher(
+			client.SubscribeRoomEvents(m.roomID, []event.Type{
+				event.TypeRoomMember,
+				event.TypeRoomPowerLevels,
+			}, onEvent),
+			client.SubscribeTimeline(m.roomID, func(ev event.RoomEvent) {
+				onEvent(ev)
+			}),
+			client.SubscribeUser(event.TypePresence, func(ev *event.PresenceEvent) {
+				glib.IdleAdd(func() {
+					if row, ok := m.rows[ev.User]; ok {
+						row.setPresence(ev.Presence)
+					}
+				})
+			}),
+		)
+	})
+
+	return &m
+}
+
+// load loads all members of the room in the background.
+func (m *memberPanel) load() {
+	ctx := m.page.ctx.Take()
+	client := gotktrix.FromContext(ctx)
+
+	m.loading.Start()
+	m.loading.Show()
+
+	gtkutil.Async(ctx, func() func() {
+		if err := client.RoomEnsureMembers(m.roomID); err != nil {
+			log.Println("failed to fetch all members:", err)
+		}
+
+		members, err := client.RoomMembers(m.roomID)
+		if err != nil {
+			return func() {
+				m.loading.Stop()
+				m.loading.Hide()
+				app.Error(ctx, errors.Wrap(err, "failed to get members"))
+			}
+		}
+
+		levels, _ := client.Offline().RoomPowerLevels(m.roomID)
+
+		return func() {
+			m.loading.Stop()
+			m.loading.Hide()
+			m.levels = levels
+			m.setMembers(members)
+		}
+	})
+}
+
+// setMembers replaces all members in the list.
+func (m *memberPanel) setMembers(members []event.RoomMemberEvent) {
+	for userID, row := range m.rows {
+		m.list.Remove(row)
+		delete(m.rows, userID)
+	}
+
+	for _, member := range members {
+		m.setMember(member)
+	}
+
+	m.invalidate()
+}
+
+// setMember adds or updates the given member. Members that aren't in the room
+// are removed.
+func (m *memberPanel) setMember(member event.RoomMemberEvent) {
+	if member.RoomID != "" && member.RoomID != m.roomID {
+		return
+	}
+
+	row, ok := m.rows[member.UserID]
+
+	switch member.NewState {
+	case event.MemberJoined, event.MemberInvited, event.MemberBanned:
+		// Keep.
+	default:
+		if ok {
+			m.list.Remove(row)
+			delete(m.rows, member.UserID)
+			m.invalidate()
+		}
+		return
+	}
+
+	if !ok {
+		row = newMemberRow(m, member.UserID)
+		m.rows[member.UserID] = row
+		m.list.Append(row)
+	}
+
+	row.update(member, m.level(member.UserID))
+	row.Changed()
+	m.invalidate()
+}
+
+func (m *memberPanel) level(userID matrix.UserID) int {
+	if m.levels == nil {
+		return 0
+	}
+	return m.levels.UserLevel(userID)
+}
+
+// invalidateLevels reloads the power levels of the room and regroups the
+// members.
+func (m *memberPanel) invalidateLevels() {
+	client := gotktrix.FromContext(m.page.ctx.Take()).Offline()
+	m.levels, _ = client.RoomPowerLevels(m.roomID)
+
+	for userID, row := range m.rows {
+		row.setLevel(m.level(userID))
+	}
+
+	m.list.InvalidateSort()
+	m.list.InvalidateHeaders()
+	m.invalidate()
+}
+
+// invalidate updates the header and the invite button.
+func (m *memberPanel) invalidate() {
+	ctx := m.page.ctx.Take()
+	client := gotktrix.FromContext(ctx).Offline()
+
+	var joined int
+	for _, row := range m.rows {
+		if row.membership == event.MemberJoined {
+			joined++
+		}
+	}
+
+	m.title.SetLabel(locale.Sprintf(ctx, "Members (%d)", joined))
+	m.invite.SetSensitive(client.HasPower(m.roomID, gotktrix.InviteAction))
+}
+
+func (m *memberPanel) sort(r1, r2 *gtk.ListBoxRow) int {
+	m1 := m.rows[matrix.UserID(r1.Name())]
+	m2 := m.rows[matrix.UserID(r2.Name())]
+	if m1 == nil || m2 == nil {
+		return 0
+	}
+
+	if m1.group != m2.group {
+		if m1.group < m2.group {
+			return -1
+		}
+		return 1
+	}
+
+	if m1.level != m2.level {
+		if m1.level > m2.level {
+			return -1
+		}
+		return 1
+	}
+
+	return sortutil.CmpFold(m1.name, m2.name)
+}
+
+// searchMembers filters the list to members whose names match the given query.
+// The member index is used for fuzzy matching.
+func (m *memberPanel) searchMembers(query string) {
+	if m.searchCancel != nil {
+		m.searchCancel()
+		m.searchCancel = nil
+	}
+
+	query = strings.TrimSpace(query)
+	if query == "" {
+		m.matches = nil
+		m.list.InvalidateFilter()
+		m.list.InvalidateHeaders()
+		return
+	}
+
+	ctx, cancel := context.WithCancel(m.page.ctx.Take())
+	m.searchCancel = cancel
+
+	// Match names and user IDs as-is first, since the index doesn't have
+	// user IDs.
+	matches := make(map[matrix.UserID]bool)
+	for userID, row := range m.rows {
+		if sortutil.ContainsFold(row.name, query) || sortutil.ContainsFold(string(userID), query) {
+			matches[userID] = true
+		}
+	}
+
+	client := gotktrix.FromContext(ctx)
+
+	gtkutil.Async(ctx, func() func() {
+		searcher := client.Index.SearchRoomMember(m.roomID, memberSearchLimit)
+		results := searcher.Search(ctx, query)
+
+		return func() {
+			for _, result := range results {
+				matches[result.ID] = true
+			}
+
+			m.matches = matches
+			m.list.InvalidateFilter()
+			m.list.InvalidateHeaders()
+		}
+	})
+}
+
+func (m *memberPanel) promptInvite() {
+	ctx := m.page.ctx.Take()
+	_, server, _ := gotktrix.FromContext(ctx).UserID.Parse()
+
+	entry := gtk.NewEntry()
+	entry.SetPlaceholderText("@user:" + server)
+
+	m.prompt(locale.S(ctx, "Invite User"), locale.S(ctx, "Invite"), entry, func() error {
+		userID := matrix.UserID(strings.TrimSpace(entry.Text()))
+		if _, _, err := userID.Parse(); err != nil {
+			return errors.Errorf("invalid user ID %q", userID)
+		}
+
+		m.do(func(client *gotktrix.Client) error {
+			return errors.Wrap(client.Invite(m.roomID, userID, ""), "failed to invite")
+		})
+		return nil
+	})
+}
+
+// prompt shows a small dialog with the given input widget. f is called when
+// the user presses the OK button, and the dialog is closed unless f returns an
+// error.
+func (m *memberPanel) prompt(title, ok string, input gtk.Widgetter, f func() error) {
+	ctx := m.page.ctx.Take()
+
+	d := dialogs.New(ctx, locale.S(ctx, "Cancel"), ok)
+	d.SetTitle(title)
+	d.SetDefaultSize(300, -1)
+	d.SetChild(input)
+	d.BindEnterOK()
+	d.BindCancelClose()
+	d.OK.ConnectClicked(func() {
+		if err := f(); err != nil {
+			app.Error(ctx, err)
+			return
+		}
+		d.Close()
+	})
+	d.Show()
+
+	if entry, ok := input.(*gtk.Entry); ok {
+		entry.ConnectActivate(func() { d.OK.Activate() })
+	}
+}
+
+// do runs the given action in the background. Errors are shown to the user.
+func (m *memberPanel) do(f func(*gotktrix.Client) error) {
+	ctx := m.page.ctx.Take()
+	client := gotktrix.FromContext(ctx)
+
+	go func() {
+		if err := f(client); err != nil {
+			app.Error(ctx, err)
+		}
+	}()
+}
+
+// memberRow is a single member in the member list.
+type memberRow struct {
+	*gtk.ListBoxRow
+	avatar   *onlineimage.Avatar
+	name     string
+	label    *gtk.Label
+	presence *gtk.Box
+
+	panel      *memberPanel
+	userID     matrix.UserID
+	membership event.MemberType
+	group      memberGroup
+	level      int
+}
+
+var memberRowCSS = cssutil.Applier("messageview-member", `
+	.messageview-member {
+		padding: 2px 10px;
+	}
+	.messageview-member > label {
+		margin-left: 8px;
+	}
+	.messageview-member-presence {
+		min-width:  8px;
+		min-height: 8px;
+		border-radius: 99px;
+		border: 2px solid @theme_bg_color;
+		background-color: @theme_unfocused_fg_color;
+	}
+	.messageview-member-presence.online {
+		background-color: @success_color;
+	}
+	.messageview-member-presence.unavailable {
+		background-color: @warning_color;
+	}
+	.messageview-member-presence.offline {
+		background-color: alpha(@theme_unfocused_fg_color, 0.5);
+	}
+	.messageview-member-invited,
+	.messageview-member-banned {
+		opacity: 0.6;
+	}
+`)
+
+func newMemberRow(m *memberPanel, userID matrix.UserID) *memberRow {
+	ctx := m.page.ctx.Take()
+
+	r := memberRow{
+		panel:  m,
+		userID: userID,
+	}
+
+	r.avatar = onlineimage.NewAvatar(ctx, gotktrix.AvatarProvider, memberAvatarSize)
+
+	r.presence = gtk.NewBox(gtk.OrientationHorizontal, 0)
+	r.presence.AddCSSClass("messageview-member-presence")
+	r.presence.SetHAlign(gtk.AlignEnd)
+	r.presence.SetVAlign(gtk.AlignEnd)
+
+	avatarOverlay := gtk.NewOverlay()
+	avatarOverlay.SetChild(r.avatar)
+	avatarOverlay.AddOverlay(r.presence)
+
+	r.label = gtk.NewLabel("")
+	r.label.SetXAlign(0)
+	r.label.SetHExpand(true)
+	r.label.SetEllipsize(pango.EllipsizeEnd)
+
+	box := gtk.NewBox(gtk.OrientationHorizontal, 0)
+	box.Append(avatarOverlay)
+	box.Append(r.label)
+	memberRowCSS(box)
+
+	r.ListBoxRow = gtk.NewListBoxRow()
+	r.ListBoxRow.SetName(string(userID))
+	r.ListBoxRow.SetActivatable(false)
+	r.ListBoxRow.SetChild(box)
+
+	client := gotktrix.FromContext(ctx).Offline()
+	r.setPresence(client.UserPresence(userID))
+
+	userview.Bind(ctx, r, m.roomID, userID)
+
+	gtkutil.BindActionMap(r, map[string]func(){
+		"member.dm":      func() { r.startDirect() },
+		"member.kick":    func() { r.promptReason(locale.S(ctx, "Kick"), r.kick) },
+		"member.ban":     func() { r.promptReason(locale.S(ctx, "Ban"), r.ban) },
+		"member.unban":   func() { r.unban() },
+		"member.power":   func() { r.promptPower() },
+		"member.copy-id": func() { r.copyID() },
+	})
+
+	gtkutil.BindRightClick(r, func() {
+		s := locale.SFunc(ctx)
+
+		self := r.userID == client.UserID
+		canKick := r.canModerate(gotktrix.KickAction)
+		canBan := r.canModerate(gotktrix.BanAction)
+
+		p := gtkutil.NewPopoverMenuCustom(r, gtk.PosBottom, []gtkutil.PopoverMenuItem{
+			gtkutil.MenuItem(s("Copy User ID"), "member.copy-id"),
+			gtkutil.MenuItem(s("Send Direct Message"), "member.dm", !self),
+			gtkutil.MenuSeparator(s("Moderation")),
+			gtkutil.MenuItem(s("Change Power Level..."), "member.power", r.canChangeLevel()),
+			gtkutil.MenuItem(s("Kick..."), "member.kick", canKick, r.membership == event.MemberJoined),
+			gtkutil.MenuItem(s("Cancel Invite..."), "member.kick", canKick, r.membership == event.MemberInvited),
+			gtkutil.MenuItem(s("Ban..."), "member.ban", canBan, r.membership != event.MemberBanned),
+			gtkutil.MenuItem(s("Unban"), "member.unban", canBan, r.membership == event.MemberBanned),
+		})
+		p.SetAutohide(true)
+		p.SetCascadePopdown(true)
+		gtkutil.PopupFinally(p)
+	})
+
+	return &r
+}
+
+func (r *memberRow) update(member event.RoomMemberEvent, level int) {
+	r.name = string(member.UserID)
+	if member.DisplayName != nil && *member.DisplayName != "" {
+		r.name = *member.DisplayName
+	}
+
+	r.label.SetLabel(r.name)
+	r.avatar.SetInitials(r.name)
+	r.avatar.SetFromURL(string(member.AvatarURL))
+
+	r.RemoveCSSClass("messageview-member-" + string(r.membership))
+	r.membership = member.NewState
+	r.AddCSSClass("messageview-member-" + string(r.membership))
+
+	r.setLevel(level)
+}
+
+func (r *memberRow) setLevel(level int) {
+	r.level = level
+	r.group = groupMember(r.membership, level)
+
+	tooltip := string(r.userID)
+	if level != 0 {
+		tooltip += "\n" + locale.Sprintf(r.panel.page.ctx.Take(), "Power level %d", level)
+	}
+	r.label.SetTooltipText(tooltip)
+}
+
+func (r *memberRow) setPresence(presence matrix.Presence) {
+	for _, p := range []matrix.Presence{
+		matrix.PresenceOnline,
+		matrix.PresenceIdle,
+		matrix.PresenceOffline,
+	} {
+		r.presence.RemoveCSSClass(string(p))
+	}
+	r.presence.AddCSSClass(string(presence))
+}
+
+// canModerate returns true if the user can do the given action on this member.
+// Users can only act on members with a lower power level than theirs.
+func (r *memberRow) canModerate(action gotktrix.PowerAction) bool {
+	client := gotktrix.FromContext(r.panel.page.ctx.Take()).Offline()
+	if r.userID == client.UserID || !client.HasPower(r.panel.roomID, action) {
+		return false
+	}
+
+	return r.panel.level(client.UserID) > r.level || client.IsRoomCreator(r.panel.roomID)
+}
+
+// canChangeLevel returns true if the user can change this member's power level.
+// Users can always lower their own power level.
+func (r *memberRow) canChangeLevel() bool {
+	if r.membership != event.MemberJoined && r.membership != event.MemberInvited {
+		return false
+	}
+
+	client := gotktrix.FromContext(r.panel.page.ctx.Take()).Offline()
+	if r.userID == client.UserID {
+		return client.HasPower(r.panel.roomID, gotktrix.StateAction(event.TypeRoomPowerLevels))
+	}
+
+	return r.canModerate(gotktrix.StateAction(event.TypeRoomPowerLevels))
+}
+
+func (r *memberRow) copyID() {
+	clipboard := r.Display().Clipboard()
+	clipboard.SetText(string(r.userID))
+}
+
+func (r *memberRow) startDirect() {
+	ctx := r.panel.page.ctx.Take()
+	client := gotktrix.FromContext(ctx)
+	userID := r.userID
+
+	gtkutil.Async(ctx, func() func() {
+		roomID, err := client.StartDirect(userID)
+		if err != nil {
+			return func() { app.Error(ctx, err) }
+		}
+
+		return func() { r.panel.page.parent.ctrl.OpenJoinedRoom(roomID) }
+	})
+}
+
+// promptReason asks the user for an optional reason before calling f with it.
+func (r *memberRow) promptReason(action string, f func(reason string)) {
+	ctx := r.panel.page.ctx.Take()
+
+	entry := gtk.NewEntry()
+	entry.SetPlaceholderText(locale.S(ctx, "Reason (optional)"))
+
+	title := locale.Sprintf(ctx, "%s %s", action, r.name)
+	r.panel.prompt(title, action, entry, func() error {
+		f(strings.TrimSpace(entry.Text()))
+		return nil
+	})
+}
+
+func (r *memberRow) kick(reason string) {
+	userID := r.userID
+	r.panel.do(func(client *gotktrix.Client) error {
+		return errors.Wrap(client.Kick(r.panel.roomID, userID, reason), "failed to kick")
+	})
+}
+
+func (r *memberRow) ban(reason string) {
+	userID := r.userID
+	r.panel.do(func(client *gotktrix.Client) error {
+		return errors.Wrap(client.Ban(r.panel.roomID, userID, reason), "failed to ban")
+	})
+}
+
+func (r *memberRow) unban() {
+	userID := r.userID
+	r.panel.do(func(client *gotktrix.Client) error {
+		return errors.Wrap(client.Unban(r.panel.roomID, userID, ""), "failed to unban")
+	})
+}
+
+func (r *memberRow) promptPower() {
+	ctx := r.panel.page.ctx.Take()
+	client := gotktrix.FromContext(ctx).Offline()
+
+	// Users can't give a higher power level than theirs.
+	max := r.panel.level(client.UserID)
+	if client.IsRoomCreator(r.panel.roomID) && max < 100 {
+		max = 100
+	}
+
+	spin := gtk.NewSpinButtonWithRange(0, float64(max), 1)
+	spin.SetDigits(0)
+	spin.SetNumeric(true)
+	spin.SetValue(float64(r.level))
+
+	title := locale.Sprintf(ctx, "Change Power Level of %s", r.name)
+	r.panel.prompt(title, locale.S(ctx, "Change"), spin, func() error {
+		level := spin.ValueAsInt()
+		userID := r.userID
+
+		r.panel.do(func(client *gotktrix.Client) error {
+			return client.SetUserPowerLevel(r.panel.roomID, userID, level)
+		})
+		return nil
+	})
+}
+
+// ToggleMembers shows or hides the member list panel.
+func (p *Page) ToggleMembers() {
+	if p.members == nil {
+		p.members = newMemberPanel(p)
+		p.membersRev.SetChild(p.members)
+	}
+
+	p.membersRev.SetRevealChild(!p.membersRev.RevealChild())
+}
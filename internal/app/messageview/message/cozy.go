@@ -7,6 +7,7 @@ import (
 	"github.com/diamondburned/gotkit/components/onlineimage"
 	"github.com/diamondburned/gotkit/gtkutil/cssutil"
 	"github.com/diamondburned/gotktrix/internal/app/messageview/message/mauthor"
+	"github.com/diamondburned/gotktrix/internal/app/userview"
 	"github.com/diamondburned/gotktrix/internal/gotktrix"
 	"github.com/diamondburned/gotrix/event"
 )
@@ -63,6 +64,9 @@ func (v messageViewer) cozyMessage(ev *event.RoomMessageEvent) *cozyMessage {
 		msg.avatar.SetFromURL(string(*mxc))
 	}
 
+	userview.Bind(v, msg.sender, ev.RoomID, ev.Sender)
+	userview.Bind(v, msg.avatar, ev.RoomID, ev.Sender)
+
 	authorTsBox := gtk.NewBox(gtk.OrientationHorizontal, 0)
 	authorTsBox.Append(msg.sender)
 	authorTsBox.Append(msg.timestamp)
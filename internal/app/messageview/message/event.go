@@ -13,6 +13,7 @@ import (
 	"github.com/diamondburned/gotkit/gtkutil/cssutil"
 	"github.com/diamondburned/gotktrix/internal/app/messageview/message/mauthor"
 	"github.com/diamondburned/gotktrix/internal/gotktrix"
+	"github.com/diamondburned/gotktrix/internal/gotktrix/events/m"
 	"github.com/diamondburned/gotktrix/internal/gotktrix/events/sys"
 	"github.com/diamondburned/gotrix/event"
 	"github.com/diamondburned/gotrix/matrix"
@@ -161,6 +162,15 @@ func RenderEvent(ctx context.Context, ev event.RoomEvent) string {
 		return p.Sprintf("%s changed the room's name to <i>%s</i>.", r.sender(), html.EscapeString(ev.Name))
 	case *event.RoomTopicEvent:
 		return p.Sprintf("%s changed the room's topic to <i>%s</i>.", r.sender(), html.EscapeString(ev.Topic))
+	case *m.StickerEvent:
+		return p.Sprintf(`%s sent a sticker: <span alpha="80%%">%s</span>`, r.sender(), html.EscapeString(ev.Body))
+	case *m.EncryptionEvent:
+		return p.Sprintf("%s enabled end-to-end encryption.", r.sender())
+	case *m.EncryptedEvent:
+		return p.Sprintf(
+			`%s: <span alpha="80%%"><i>%s</i></span>`,
+			r.sender(), locale.S(r.ctx, "unable to decrypt message."),
+		)
 	case *sys.ErroneousEvent:
 		return p.Sprintf(
 			`%s sent an unusual event: <span color="red">%v</span>.`,
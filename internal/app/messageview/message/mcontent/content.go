@@ -71,6 +71,8 @@ func New(ctx context.Context, ev *event.RoomMessageEvent) *Content {
 		part = newFileContent(ctx, ev)
 	case event.RoomMessageLocation:
 		part = newLocationContent(ctx, ev)
+	case m.StickerMessageType:
+		part = newStickerContent(ctx, ev)
 	}
 
 	if part == nil {
@@ -0,0 +1,75 @@
+package mcontent
+
+import (
+	"context"
+
+	"github.com/diamondburned/chatkit/components/embed"
+	"github.com/diamondburned/gotkit/gtkutil"
+	"github.com/diamondburned/gotkit/gtkutil/cssutil"
+	"github.com/diamondburned/gotkit/gtkutil/imgutil"
+	"github.com/diamondburned/gotktrix/internal/gotktrix"
+	"github.com/diamondburned/gotrix/event"
+)
+
+// stickerSize is the maximum width and height of a sticker.
+const stickerSize = 160
+
+type stickerContent struct {
+	*embed.Embed
+	ctx context.Context
+	msg *event.RoomMessageEvent
+	gif bool
+}
+
+var stickerCSS = cssutil.Applier("mcontent-sticker", `
+	.mcontent-sticker {
+		margin-top: 6px;
+		background: none;
+		border: none;
+	}
+`)
+
+// newStickerContent creates a new content part for a sticker converted using
+// m.StickerEvent.AsMessage. Unlike images, stickers are small and can't be
+// opened.
+func newStickerContent(ctx context.Context, msg *event.RoomMessageEvent) *stickerContent {
+	c := stickerContent{
+		ctx: ctx,
+		msg: msg,
+	}
+
+	i, _ := msg.ImageInfo()
+	// Thumbnails of animated stickers aren't animated, so those are fetched
+	// whole.
+	c.gif = i.MimeType == "image/gif"
+
+	typ := embed.EmbedTypeImage
+	if c.gif {
+		typ = embed.EmbedTypeGIF
+	}
+
+	c.Embed = embed.New(ctx, stickerSize, stickerSize, embed.Opts{
+		Type:     typ,
+		Provider: imgutil.HTTPProvider,
+	})
+	c.Embed.SetTooltipText(msg.Body)
+	c.Embed.SetSizeRequest(gotktrix.MaxSize(i.Width, i.Height, stickerSize, stickerSize))
+	stickerCSS(c.Embed)
+
+	return &c
+}
+
+func (c *stickerContent) LoadMore() {
+	client := gotktrix.FromContext(c.ctx)
+
+	var url string
+	if c.gif {
+		url, _ = client.MediaDownloadURL(c.msg.URL, true, "")
+	} else {
+		url, _ = client.ScaledThumbnail(c.msg.URL, stickerSize, stickerSize, gtkutil.ScaleFactor())
+	}
+
+	c.Embed.SetFromURL(url)
+}
+
+func (c *stickerContent) content() {}
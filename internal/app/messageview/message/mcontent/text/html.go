@@ -10,10 +10,12 @@ import (
 
 	"github.com/diamondburned/gotk4/pkg/gio/v2"
 	"github.com/diamondburned/gotk4/pkg/gtk/v4"
+	"github.com/diamondburned/gotkit/app/locale"
 	"github.com/diamondburned/gotkit/gtkutil"
 	"github.com/diamondburned/gotkit/gtkutil/imgutil"
 	"github.com/diamondburned/gotkit/gtkutil/textutil"
 	"github.com/diamondburned/gotktrix/internal/app/messageview/message/mauthor"
+	"github.com/diamondburned/gotktrix/internal/app/userview"
 	"github.com/diamondburned/gotktrix/internal/gotktrix"
 	"github.com/diamondburned/gotktrix/internal/md"
 	"github.com/diamondburned/gotrix/matrix"
@@ -82,6 +84,14 @@ func (b htmlBox) SetExtraMenu(model gio.MenuModeller) {
 			box.SetExtraMenu(model)
 		case *codeBlock:
 			block.text.SetExtraMenu(model)
+		case *detailsBlock:
+			box := htmlBox{block.state.parent, block.state.list}
+			box.SetExtraMenu(model)
+		case *tableBlock:
+			for _, cell := range block.cells {
+				box := htmlBox{cell.parent, cell.list}
+				box.SetExtraMenu(model)
+			}
 		}
 	}
 }
@@ -151,6 +161,14 @@ func autolinkBlock(l *list.List) []string {
 			case *quoteBlock:
 				each(block.state.list)
 				continue
+			case *detailsBlock:
+				each(block.state.list)
+				continue
+			case *tableBlock:
+				for _, cell := range block.cells {
+					each(cell.list)
+				}
+				continue
 			default:
 				continue
 			}
@@ -259,12 +277,26 @@ func (s *renderState) renderNode(n *html.Node) traverseStatus {
 			return traverseOK
 
 		// Inline.
-		case "font", "span": // data-mx-bg-color, data-mx-color
-			tag := textutil.HashTag(s.block.table, textutil.TextTag{
-				"foreground": nodeAttr(n, "data-mx-color", "color"),
-				"background": nodeAttr(n, "data-mx-bg-color"),
-			})
-			s.renderChildrenTagged(n, tag)
+		case "font", "span": // data-mx-bg-color, data-mx-color, data-mx-spoiler
+			if nodeHasAttr(n, "data-mx-maths") {
+				s.renderMath(n, false)
+				return traverseSkipChildren
+			}
+
+			render := func() {
+				tag := textutil.HashTag(s.block.table, textutil.TextTag{
+					"foreground": nodeAttr(n, "data-mx-color", "color"),
+					"background": nodeAttr(n, "data-mx-bg-color"),
+				})
+				s.renderChildrenTagged(n, tag)
+			}
+
+			if nodeHasAttr(n, "data-mx-spoiler") {
+				s.renderSpoiler(n, render)
+			} else {
+				render()
+			}
+
 			return traverseSkipChildren
 
 		// Inline.
@@ -305,6 +337,11 @@ func (s *renderState) renderNode(n *html.Node) traverseStatus {
 			return traverseSkipChildren
 
 		case "p", "div":
+			if nodeHasAttr(n, "data-mx-maths") {
+				s.renderMath(n, true)
+				return traverseSkipChildren
+			}
+
 			// Only start and stop a new block if we're not already in a
 			// blockquote, since we're not nesting anything, so doing this will
 			// mess up the blockquote.
@@ -323,6 +360,37 @@ func (s *renderState) renderNode(n *html.Node) traverseStatus {
 			s.traverseChildren(n)
 			return traverseSkipChildren
 
+		// Block Elements.
+		case "details": // open
+			details := s.block.details()
+			details.SetExpanded(nodeHasAttr(n, "open"))
+
+			summary := locale.S(s.ctx, "Details")
+			for child := n.FirstChild; child != nil; child = child.NextSibling {
+				if nodeIsData(child, nodeFlagTag, "summary") {
+					if text := nodeSpacedText(child); text != "" {
+						summary = text
+					}
+					break
+				}
+			}
+			details.summary.SetText(summary)
+
+			state := s.withBlock(details.state)
+			state.traverseChildren(n)
+
+			s.block.finalizeBlock()
+			return traverseSkipChildren
+
+		case "summary":
+			// Already used as the label of the details block.
+			return traverseSkipChildren
+
+		// Block Elements.
+		case "table":
+			s.renderTable(n)
+			return traverseSkipChildren
+
 		// Inline.
 		case "a":
 			text := s.block.richText()
@@ -349,6 +417,7 @@ func (s *renderState) renderNode(n *html.Node) traverseStatus {
 					mauthor.WithName(nodeInnerText(n)),
 				)
 				chip.InsertText(text.TextView, text.iter)
+				userview.Bind(s.ctx, chip, s.room, uID)
 
 				md.InsertInvisible(text.iter, string(uID))
 				return traverseSkipChildren
@@ -503,6 +572,93 @@ func (s *renderState) renderNode(n *html.Node) traverseStatus {
 	return traverseOK
 }
 
+// renderSpoiler calls f, which renders n's children, and hides everything that
+// it rendered until the user clicks on it. The spoiler's reason is shown
+// before it, if any.
+func (s *renderState) renderSpoiler(n *html.Node, f func()) {
+	text := s.block.text()
+
+	if reason := nodeAttr(n, "data-mx-spoiler"); reason != "" {
+		text.tagNameBounded("caption", func() {
+			text.buf.Insert(text.iter, "("+reason+") ")
+		})
+	}
+
+	text.spoilerBounded(f)
+}
+
+// renderMath renders the LaTeX source of the given data-mx-maths element. The
+// children, which are only the fallback, are ignored.
+func (s *renderState) renderMath(n *html.Node, block bool) {
+	tex := nodeAttr(n, "data-mx-maths")
+
+	if block {
+		s.block.code().withHighlight("tex", func(text *textBlock) {
+			text.buf.Insert(text.iter, tex)
+		})
+		s.block.finalizeBlock()
+		return
+	}
+
+	text := s.block.text()
+	text.tagNameBounded("_math", func() {
+		text.buf.Insert(text.iter, tex)
+	})
+}
+
+// renderTable renders the given table element into a grid. Each cell is its
+// own block state, so it may contain any other element.
+func (s *renderState) renderTable(n *html.Node) {
+	var caption *html.Node
+	var rows []*html.Node
+
+	var collect func(n *html.Node)
+	collect = func(n *html.Node) {
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type != html.ElementNode {
+				continue
+			}
+
+			switch child.Data {
+			case "caption":
+				caption = child
+			case "thead", "tbody", "tfoot":
+				collect(child)
+			case "tr":
+				rows = append(rows, child)
+			}
+		}
+	}
+	collect(n)
+
+	if caption != nil {
+		s.block.paragraph()
+		s.renderChildren(caption)
+		s.block.finalizeBlock()
+	}
+
+	table := s.block.tableGrid()
+
+	for y, row := range rows {
+		var x int
+
+		for cell := row.FirstChild; cell != nil; cell = cell.NextSibling {
+			if !nodeIsData(cell, nodeFlagTag, "td") && !nodeIsData(cell, nodeFlagTag, "th") {
+				continue
+			}
+
+			width := parseIntOr(nodeAttr(cell, "colspan"), 1)
+
+			state := s.withBlock(table.cell(s.block, x, y, width, cell.Data == "th"))
+			state.traverseChildren(cell)
+
+			x += width
+		}
+	}
+
+	s.block.finalizeBlock()
+}
+
 func parseIntOr(intv string, or int) int {
 	v, _ := strconv.Atoi(intv)
 	if v <= 0 {
@@ -754,6 +910,23 @@ func nodeInnerText(n *html.Node) string {
 	return s.String()
 }
 
+// nodeSpacedText is like nodeInnerText, except the whitespaces between words
+// are kept as single spaces.
+func nodeSpacedText(n *html.Node) string {
+	var s strings.Builder
+	nodeRawTextRec(n.FirstChild, &s)
+	return strings.Join(strings.Fields(s.String()), " ")
+}
+
+func nodeRawTextRec(n *html.Node, s *strings.Builder) {
+	for ; n != nil; n = n.NextSibling {
+		if n.Type == html.TextNode {
+			s.WriteString(n.Data)
+		}
+		nodeRawTextRec(n.FirstChild, s)
+	}
+}
+
 func nodeInnerTextRec(n *html.Node, s *strings.Builder) {
 	for n != nil {
 		if t := nodeText(n); t != "" {
@@ -145,6 +145,24 @@ func (s *currentBlockState) quote() *quoteBlock {
 	return block
 }
 
+func (s *currentBlockState) details() *detailsBlock {
+	block := newDetailsBlock(s)
+
+	s.element = s.list.PushBack(block)
+	s.parent.Append(block)
+
+	return block
+}
+
+func (s *currentBlockState) tableGrid() *tableBlock {
+	block := newTableBlock(s)
+
+	s.element = s.list.PushBack(block)
+	s.parent.Append(block)
+
+	return block
+}
+
 func (s *currentBlockState) separator() *separatorBlock {
 	if block, ok := s.current().(*separatorBlock); ok {
 		return block
@@ -172,6 +190,7 @@ type textBlock struct {
 
 	state struct {
 		hyperlink bool
+		spoiler   bool
 	}
 }
 
@@ -220,6 +239,48 @@ func (b *textBlock) hasLink() {
 	}
 }
 
+// hasSpoiler connects the needed handlers into the textBlock to reveal spoilers
+// when they're clicked.
+func (b *textBlock) hasSpoiler() {
+	if !b.flip(&b.state.spoiler) {
+		return
+	}
+
+	spoiler := b.tag("_spoiler")
+
+	click := gtk.NewGestureClick()
+	click.SetButton(gdk.BUTTON_PRIMARY)
+	click.ConnectPressed(func(n int, x, y float64) {
+		bx, by := b.WindowToBufferCoords(gtk.TextWindowWidget, int(x), int(y))
+		start, ok := b.IterAtLocation(bx, by)
+		if !ok || !start.HasTag(spoiler) {
+			return
+		}
+
+		end := start.Copy()
+		end.ForwardToTagToggle(spoiler)
+		if !start.StartsTag(spoiler) {
+			start.BackwardToTagToggle(spoiler)
+		}
+
+		b.buf.RemoveTag(spoiler, start, end)
+		click.SetState(gtk.EventSequenceClaimed)
+	})
+
+	b.AddController(click)
+}
+
+// spoilerBounded calls f and hides everything that it wrote until the spoiler
+// is clicked.
+func (b *textBlock) spoilerBounded(f func()) {
+	b.hasSpoiler()
+
+	spoiler := b.tag("_spoiler")
+	b.tagBounded(spoiler, f)
+	// Keep the spoiler above everything else, including links and colors.
+	spoiler.SetPriority(b.table.Size() - 1)
+}
+
 // flip flips the bool to true and returns true; false is returned otherwise.
 func (b *textBlock) flip(value *bool) bool {
 	if *value {
@@ -355,6 +416,101 @@ func newQuoteBlock(s *currentBlockState) *quoteBlock {
 	return &quote
 }
 
+type detailsBlock struct {
+	*gtk.Expander
+	summary *gtk.Label
+	state   *currentBlockState
+}
+
+var detailsBlockCSS = cssutil.Applier("mcontent-details-block", `
+	.mcontent-details-block:not(:last-child) {
+		margin-bottom: 3px;
+	}
+	.mcontent-details-summary {
+		font-weight: bold;
+	}
+`)
+
+func newDetailsBlock(s *currentBlockState) *detailsBlock {
+	box := gtk.NewBox(gtk.OrientationVertical, 0)
+	box.SetMarginStart(18)
+
+	summary := gtk.NewLabel("")
+	summary.AddCSSClass("mcontent-details-summary")
+	summary.SetXAlign(0)
+	summary.SetWrap(true)
+	summary.SetWrapMode(pango.WrapWordChar)
+
+	expander := gtk.NewExpander("")
+	expander.SetLabelWidget(summary)
+	expander.SetChild(box)
+
+	details := detailsBlock{
+		Expander: expander,
+		summary:  summary,
+		state:    s.clone(box),
+	}
+	detailsBlockCSS(details)
+	return &details
+}
+
+type tableBlock struct {
+	*gtk.ScrolledWindow
+	grid  *gtk.Grid
+	cells []*currentBlockState
+}
+
+var tableBlockCSS = cssutil.Applier("mcontent-table-block", `
+	.mcontent-table-block grid {
+		border: 1px solid alpha(@theme_fg_color, 0.25);
+	}
+	.mcontent-table-block grid > box {
+		padding: 2px 6px;
+		border: 1px solid alpha(@theme_fg_color, 0.15);
+	}
+	.mcontent-table-header {
+		background-color: alpha(@theme_fg_color, 0.05);
+	}
+	.mcontent-table-header textview {
+		font-weight: bold;
+	}
+	.mcontent-table-block:not(:last-child) {
+		margin-bottom: 3px;
+	}
+`)
+
+func newTableBlock(s *currentBlockState) *tableBlock {
+	grid := gtk.NewGrid()
+	grid.SetHAlign(gtk.AlignStart)
+
+	scroll := gtk.NewScrolledWindow()
+	scroll.SetPolicy(gtk.PolicyAutomatic, gtk.PolicyNever)
+	scroll.SetPropagateNaturalHeight(true)
+	scroll.SetChild(grid)
+
+	table := tableBlock{
+		ScrolledWindow: scroll,
+		grid:           grid,
+	}
+	tableBlockCSS(table)
+	return &table
+}
+
+// cell adds a new cell at the given position and returns its block state.
+func (b *tableBlock) cell(s *currentBlockState, col, row, width int, header bool) *currentBlockState {
+	box := gtk.NewBox(gtk.OrientationVertical, 0)
+	box.SetHExpand(true)
+	if header {
+		box.AddCSSClass("mcontent-table-header")
+	}
+
+	b.grid.Attach(box, col, row, width, 1)
+
+	state := s.clone(box)
+	b.cells = append(b.cells, state)
+	return state
+}
+
 type codeBlock struct {
 	*gtk.Overlay
 	context context.Context
@@ -50,9 +50,19 @@ func bindParent(
 		actions["message.delete"] = func() { redactMessage(v) }
 	}
 
+	// Only allow starting threads off of messages that aren't in one.
+	tv, canThread := v.MessageViewer.(ThreadViewer)
+	if msg, ok := v.event.(*event.RoomMessageEvent); ok && threadRoot(msg.RelatesTo) != "" {
+		canThread = false
+	}
+	if canThread {
+		actions["message.thread"] = func() { tv.OpenThread(roomEv.ID) }
+	}
+
 	menuItems := []gtkutil.PopoverMenuItem{
 		gtkutil.MenuItem(locale.S(v, "_Edit"), "message.edit", isSelf),
 		gtkutil.MenuItem(locale.S(v, "_Reply"), "message.reply"),
+		gtkutil.MenuItem(locale.S(v, "Reply in _Thread"), "message.thread", canThread),
 		gtkutil.MenuItem(locale.S(v, "Add Rea_ction"), "message.react"),
 		gtkutil.MenuItem(locale.S(v, "Add Reaction with _Text"), "message.react-text"),
 		gtkutil.MenuItem(locale.S(v, "_Delete"), "message.delete", canRedact),
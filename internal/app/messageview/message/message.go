@@ -10,6 +10,7 @@ import (
 	"github.com/diamondburned/gotkit/gtkutil/cssutil"
 	"github.com/diamondburned/gotktrix/internal/app/messageview/message/mcontent"
 	"github.com/diamondburned/gotktrix/internal/gotktrix"
+	"github.com/diamondburned/gotktrix/internal/gotktrix/events/m"
 	"github.com/diamondburned/gotrix/event"
 	"github.com/diamondburned/gotrix/matrix"
 )
@@ -83,6 +84,11 @@ func (v messageViewer) client() *gotktrix.Client {
 
 // NewCozyMessage creates a new cozy or collapsed message.
 func NewCozyMessage(ctx context.Context, view MessageViewer, ev event.RoomEvent, before Message) Message {
+	// Stickers are shown like any other message.
+	if sticker, ok := ev.(*m.StickerEvent); ok {
+		ev = sticker.AsMessage()
+	}
+
 	viewer := messageViewer{
 		Context:       ctx,
 		MessageViewer: view,
@@ -130,6 +136,7 @@ type message struct {
 	parent    messageViewer
 	timestamp *timestamp
 	content   *mcontent.Content
+	thread    *threadSummary
 }
 
 func (v messageViewer) newMessage(ev *event.RoomMessageEvent, longTimestamp bool) *message {
@@ -145,11 +152,25 @@ func (v messageViewer) newMessage(ev *event.RoomMessageEvent, longTimestamp bool
 		content.Prepend(reply)
 	}
 
-	return &message{
+	msg := message{
 		parent:    v,
 		timestamp: timestamp,
 		content:   content,
 	}
+
+	if tv, ok := v.MessageViewer.(ThreadViewer); ok {
+		if rootID := m.ThreadRoot(ev.RelatesTo); rootID != "" {
+			content.Prepend(newThreadIndicator(v.Context, tv, rootID))
+		}
+
+		if summary, ok := m.ParseThreadSummary(ev.Raw); ok {
+			msg.thread = newThreadSummary(v.Context, tv, ev.ID)
+			msg.thread.set(summary.Count, latestThreadTime(summary))
+			content.Append(msg.thread)
+		}
+	}
+
+	return &msg
 }
 
 func (m *message) Event() event.RoomEvent {
@@ -157,6 +178,10 @@ func (m *message) Event() event.RoomEvent {
 }
 
 func (m *message) OnRelatedEvent(ev event.RoomEvent) bool {
+	if m.onThreadReply(ev) {
+		return true
+	}
+
 	ok := m.content.OnRelatedEvent(ev)
 
 	t, edited := m.content.EditedTimestamp()
@@ -188,8 +213,14 @@ func messageRepliesTo(ev *event.RoomMessageEvent) matrix.EventID {
 		InReplyTo struct {
 			EventID matrix.EventID `json:"event_id"`
 		} `json:"m.in_reply_to"`
+		// IsFallingBack is true for thread messages that don't actually reply
+		// to anything.
+		IsFallingBack bool `json:"is_falling_back"`
 	}
 
 	json.Unmarshal(ev.RelatesTo, &relatesTo)
+	if relatesTo.IsFallingBack {
+		return ""
+	}
 	return relatesTo.InReplyTo.EventID
 }
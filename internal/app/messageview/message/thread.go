@@ -0,0 +1,152 @@
+package message
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/diamondburned/gotk4/pkg/gtk/v4"
+	"github.com/diamondburned/gotkit/app/locale"
+	"github.com/diamondburned/gotkit/gtkutil/cssutil"
+	"github.com/diamondburned/gotktrix/internal/gotktrix/events/m"
+	"github.com/diamondburned/gotrix/event"
+	"github.com/diamondburned/gotrix/matrix"
+)
+
+// ThreadViewer is a MessageViewer that can also show threads. Messages only
+// show their thread summaries and the thread menu items if their viewer
+// implements this.
+type ThreadViewer interface {
+	MessageViewer
+	// OpenThread shows the thread with the given root event.
+	OpenThread(root matrix.EventID)
+}
+
+var threadSummaryCSS = cssutil.Applier("message-thread", `
+	.message-thread {
+		margin: 2px 0;
+		padding: 2px 6px;
+		min-height: 0;
+		font-size: 0.9em;
+	}
+	.message-thread-indicator {
+		color: alpha(@theme_fg_color, 0.65);
+	}
+`)
+
+// threadSummary is the button below a thread root that shows the number of
+// replies in the thread.
+type threadSummary struct {
+	*gtk.Button
+	ctx    context.Context
+	label  *gtk.Label
+	count  int
+	latest matrix.Timestamp
+}
+
+func newThreadSummary(ctx context.Context, v ThreadViewer, rootID matrix.EventID) *threadSummary {
+	s := threadSummary{ctx: ctx}
+
+	s.label = gtk.NewLabel("")
+	s.label.SetXAlign(0)
+
+	s.Button = gtk.NewButton()
+	s.Button.SetHAlign(gtk.AlignStart)
+	s.Button.SetChild(s.label)
+	s.Button.ConnectClicked(func() { v.OpenThread(rootID) })
+	threadSummaryCSS(s)
+
+	return &s
+}
+
+func (s *threadSummary) set(count int, latest matrix.Timestamp) {
+	s.count = count
+	s.latest = latest
+
+	var text string
+	if count == 1 {
+		text = locale.S(s.ctx, "1 reply")
+	} else {
+		text = locale.Sprintf(s.ctx, "%d replies", count)
+	}
+
+	if latest > 0 {
+		text += " · " + locale.TimeAgo(s.ctx, latest.Time())
+	}
+
+	s.label.SetText(text)
+}
+
+// addReply counts the given reply into the summary. Replies that aren't newer
+// than the latest reply are assumed to be already counted.
+func (s *threadSummary) addReply(ev event.RoomEvent) {
+	ts := ev.RoomInfo().OriginServerTime
+	if ts <= s.latest {
+		return
+	}
+	s.set(s.count+1, ts)
+}
+
+// newThreadIndicator creates a button that is shown above thread replies in
+// the main timeline.
+func newThreadIndicator(ctx context.Context, v ThreadViewer, rootID matrix.EventID) *gtk.Button {
+	label := gtk.NewLabel(locale.S(ctx, "In a thread"))
+	label.AddCSSClass("message-thread-indicator")
+
+	button := gtk.NewButton()
+	button.SetHAlign(gtk.AlignStart)
+	button.SetHasFrame(false)
+	button.SetChild(label)
+	button.ConnectClicked(func() { v.OpenThread(rootID) })
+	threadSummaryCSS(button)
+
+	return button
+}
+
+// latestThreadTime returns the timestamp of the latest event in the summary.
+func latestThreadTime(summary m.ThreadSummary) matrix.Timestamp {
+	if summary.Latest == nil {
+		return 0
+	}
+
+	var latest struct {
+		OriginServerTime matrix.Timestamp `json:"origin_server_ts"`
+	}
+	if err := json.Unmarshal(summary.Latest, &latest); err != nil {
+		return 0
+	}
+
+	return latest.OriginServerTime
+}
+
+// threadRoot is m.ThreadRoot. It's aliased since the message receivers shadow
+// package m.
+func threadRoot(relatesTo json.RawMessage) matrix.EventID {
+	return m.ThreadRoot(relatesTo)
+}
+
+// onThreadReply updates the thread summary if the given event is a reply in
+// the thread of this message.
+func (m *message) onThreadReply(ev event.RoomEvent) bool {
+	reply, ok := ev.(*event.RoomMessageEvent)
+	if !ok {
+		return false
+	}
+
+	rootID := threadRoot(reply.RelatesTo)
+	if rootID == "" || rootID != m.parent.event.RoomInfo().ID {
+		return false
+	}
+
+	tv, ok := m.parent.MessageViewer.(ThreadViewer)
+	if !ok {
+		return false
+	}
+
+	if m.thread == nil {
+		m.thread = newThreadSummary(m.parent.Context, tv, rootID)
+		m.content.Append(m.thread)
+	}
+
+	m.thread.addReply(reply)
+	return true
+}
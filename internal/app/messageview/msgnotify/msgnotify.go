@@ -5,10 +5,12 @@ import (
 	"strings"
 
 	"github.com/diamondburned/gotkit/app"
+	"github.com/diamondburned/gotkit/app/locale"
 	"github.com/diamondburned/gotkit/app/notify"
 	"github.com/diamondburned/gotkit/gtkutil"
 	"github.com/diamondburned/gotktrix/internal/app/messageview/message/mauthor"
 	"github.com/diamondburned/gotktrix/internal/gotktrix"
+	"github.com/diamondburned/gotrix/api"
 	"github.com/diamondburned/gotrix/event"
 	"github.com/diamondburned/gotrix/matrix"
 )
@@ -20,51 +22,104 @@ type OpenRoomCommand struct {
 }
 
 // StartNotify starts notifying the user for any new messages that mentions the
-// user. A stop callback is returned. actionID must be application-scoped and
-// therefore have the "app." prefix.
+// user and for any new invites. A stop callback is returned. actionID must be
+// application-scoped and therefore have the "app." prefix.
 func StartNotify(ctx context.Context, actionID string) (stop func()) {
 	if !strings.HasPrefix(actionID, "app.") {
 		panic("actionID does not have the app prefix")
 	}
 
 	client := gotktrix.FromContext(ctx)
-	return client.SubscribeAllTimeline(func(ev event.RoomEvent) {
-		message, ok := ev.(*event.RoomMessageEvent)
-		if !ok {
-			return
-		}
-
-		// TODO: NotifySoundMessage?
-		action := client.NotifyMessage(message, gotktrix.NotifyMessage)
-		if action == 0 {
-			return
-		}
-
-		unreadIcon := notify.IconName("unread-mail")
-		icon := notify.Icon(unreadIcon)
-
-		avatar, _ := client.MemberAvatar(message.RoomID, message.Sender)
-		if avatar != nil {
-			avatarURL, _ := client.SquareThumbnail(*avatar, notify.MaxIconSize, 1)
-			icon = notify.IconURL(ctx, avatarURL, unreadIcon)
-		}
-
-		notification := notify.Notification{
-			ID:    notify.HashID("new_message", client.UserID, message.RoomID),
-			Title: mauthor.Name(client, message.RoomID, message.Sender),
-			Body:  message.Body,
-			Icon:  icon,
-			Sound: notify.MessageSound,
-			Action: notify.Action{
-				ActionID: actionID,
-				Argument: gtkutil.NewJSONVariant(OpenRoomCommand{
-					UserID: client.UserID,
-					RoomID: message.RoomID,
-				}),
-			},
-		}
-
-		a := app.FromContext(ctx)
-		notification.Send(a)
-	})
+	return gtkutil.FuncBatcher(
+		client.SubscribeAllTimeline(func(ev event.RoomEvent) {
+			message, ok := ev.(*event.RoomMessageEvent)
+			if ok {
+				notifyMessage(ctx, actionID, message)
+			}
+		}),
+		client.OnSync(func(sync *api.SyncResponse) {
+			// Every pending invite is sent again in an initial sync and when
+			// its state changes, so only notify the new ones.
+			for roomID := range sync.Rooms.Invited {
+				if client.MarkInviteNotified(roomID) {
+					notifyInvite(ctx, roomID)
+				}
+			}
+		}),
+	)
+}
+
+func notifyMessage(ctx context.Context, actionID string, message *event.RoomMessageEvent) {
+	client := gotktrix.FromContext(ctx)
+	if client.IsIgnored(message.Sender) {
+		return
+	}
+
+	// TODO: NotifySoundMessage?
+	action := client.NotifyMessage(message, gotktrix.NotifyMessage)
+	if action == 0 {
+		return
+	}
+
+	unreadIcon := notify.IconName("unread-mail")
+	icon := notify.Icon(unreadIcon)
+
+	avatar, _ := client.MemberAvatar(message.RoomID, message.Sender)
+	if avatar != nil {
+		avatarURL, _ := client.SquareThumbnail(*avatar, notify.MaxIconSize, 1)
+		icon = notify.IconURL(ctx, avatarURL, unreadIcon)
+	}
+
+	notification := notify.Notification{
+		ID:    notify.HashID("new_message", client.UserID, message.RoomID),
+		Title: mauthor.Name(client, message.RoomID, message.Sender),
+		Body:  message.Body,
+		Icon:  icon,
+		Sound: notify.MessageSound,
+		Action: notify.Action{
+			ActionID: actionID,
+			Argument: gtkutil.NewJSONVariant(OpenRoomCommand{
+				UserID: client.UserID,
+				RoomID: message.RoomID,
+			}),
+		},
+	}
+
+	a := app.FromContext(ctx)
+	notification.Send(a)
+}
+
+func notifyInvite(ctx context.Context, roomID matrix.RoomID) {
+	client := gotktrix.FromContext(ctx).Offline()
+
+	invite, err := client.RoomInvite(roomID)
+	if err != nil || client.IsIgnored(invite.Inviter) {
+		return
+	}
+
+	inviteIcon := notify.IconName("mail-unread")
+	icon := notify.Icon(inviteIcon)
+
+	if invite.Avatar != "" {
+		avatarURL, _ := client.SquareThumbnail(invite.Avatar, notify.MaxIconSize, 1)
+		icon = notify.IconURL(ctx, avatarURL, inviteIcon)
+	}
+
+	body := locale.Sprintf(ctx, "%s invited you.", invite.InviterName)
+	if invite.Reason != "" {
+		body += "\n" + invite.Reason
+	}
+
+	// There's no action, since the room can't be opened until the user
+	// accepts the invite from the room list.
+	notification := notify.Notification{
+		ID:    notify.HashID("new_invite", client.UserID, roomID),
+		Title: invite.Name,
+		Body:  body,
+		Icon:  icon,
+		Sound: notify.MessageSound,
+	}
+
+	a := app.FromContext(ctx)
+	notification.Send(a)
 }
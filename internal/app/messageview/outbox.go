@@ -0,0 +1,305 @@
+package messageview
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+
+	"github.com/diamondburned/gotk4/pkg/gtk/v4"
+	"github.com/diamondburned/gotk4/pkg/pango"
+	"github.com/diamondburned/gotkit/app/locale"
+	"github.com/diamondburned/gotkit/gtkutil/cssutil"
+	"github.com/diamondburned/gotktrix/internal/gotktrix"
+	"github.com/diamondburned/gotktrix/internal/gotktrix/events/sys"
+	"github.com/diamondburned/gotrix/event"
+	"github.com/diamondburned/gotrix/matrix"
+)
+
+// sendingBox wraps the body of a message that is still in the outbox. It shows
+// the sending error along with the Retry and Cancel buttons.
+type sendingBox struct {
+	*gtk.Box
+	body gtk.Widgetter
+
+	rev   *gtk.Revealer
+	label *gtk.Label
+}
+
+var sendingBoxCSS = cssutil.Applier("messageview-sending", `
+	.messageview-sending-error {
+		margin: 0 10px 4px 10px;
+	}
+	.messageview-sending-error label {
+		font-size: 0.9em;
+		color: @error_color;
+	}
+	.messageview-sending-error button {
+		padding: 0 6px;
+		min-height: 0;
+	}
+`)
+
+func newSendingBox(ctx context.Context, txnID string) *sendingBox {
+	b := sendingBox{}
+
+	b.label = gtk.NewLabel("")
+	b.label.SetXAlign(0)
+	b.label.SetHExpand(true)
+	b.label.SetWrap(true)
+	b.label.SetWrapMode(pango.WrapWordChar)
+
+	retry := gtk.NewButtonWithLabel(locale.S(ctx, "Retry"))
+	retry.ConnectClicked(func() {
+		gotktrix.FromContext(ctx).Outbox.Retry(txnID)
+	})
+
+	cancel := gtk.NewButtonWithLabel(locale.S(ctx, "Cancel"))
+	cancel.ConnectClicked(func() {
+		gotktrix.FromContext(ctx).Outbox.Cancel(txnID)
+	})
+
+	errBox := gtk.NewBox(gtk.OrientationHorizontal, 4)
+	errBox.AddCSSClass("messageview-sending-error")
+	errBox.Append(b.label)
+	errBox.Append(retry)
+	errBox.Append(cancel)
+
+	b.rev = gtk.NewRevealer()
+	b.rev.SetTransitionType(gtk.RevealerTransitionTypeSlideDown)
+	b.rev.SetRevealChild(false)
+	b.rev.SetChild(errBox)
+
+	b.Box = gtk.NewBox(gtk.OrientationVertical, 0)
+	b.Box.Append(b.rev)
+	sendingBoxCSS(b)
+
+	return &b
+}
+
+// setBody replaces the message body.
+func (b *sendingBox) setBody(body gtk.Widgetter) {
+	if b.body != nil {
+		b.Box.Remove(b.body)
+	}
+	b.body = body
+	b.Box.Prepend(body)
+}
+
+// takeBody removes the message body from the box and returns it.
+func (b *sendingBox) takeBody() gtk.Widgetter {
+	body := b.body
+	if body != nil {
+		b.Box.Remove(body)
+		b.body = nil
+	}
+	return body
+}
+
+func (b *sendingBox) update(ctx context.Context, u gotktrix.OutboxUpdate) {
+	if u.Status != gotktrix.OutboxFailed {
+		b.rev.SetRevealChild(false)
+		return
+	}
+
+	var msg string
+	if u.NextRetry.IsZero() {
+		msg = locale.Sprintf(ctx, "Failed to send: %s.", u.Err)
+	} else {
+		msg = locale.Sprintf(ctx, "Failed to send, retrying at %s.", locale.Time(u.NextRetry, false))
+	}
+
+	b.label.SetText(msg)
+	b.label.SetTooltipText(u.Err.Error())
+	b.rev.SetRevealChild(true)
+}
+
+// outboxRoomEvent creates a room event out of the outbox event so that it can
+// be displayed.
+func outboxRoomEvent(userID matrix.UserID, ev gotktrix.OutboxEvent) event.RoomEvent {
+	raw, err := json.Marshal(struct {
+		Type     event.Type       `json:"type"`
+		Content  json.RawMessage  `json:"content"`
+		Sender   matrix.UserID    `json:"sender"`
+		RoomID   matrix.RoomID    `json:"room_id"`
+		ServerTS matrix.Timestamp `json:"origin_server_ts"`
+		Unsigned struct {
+			TransactionID string `json:"transaction_id"`
+		} `json:"unsigned"`
+	}{
+		Type:     ev.Type,
+		Content:  ev.Content,
+		Sender:   userID,
+		RoomID:   ev.RoomID,
+		ServerTS: ev.CreatedAt,
+		Unsigned: struct {
+			TransactionID string `json:"transaction_id"`
+		}{ev.TxnID},
+	})
+	if err != nil {
+		log.Panicln("cannot marshal outbox event:", err) // bug
+	}
+
+	return sys.ParseTimeline(raw, ev.RoomID)
+}
+
+// failedOutboxBar is the bar that shows the events that failed to send but have
+// no row of their own to show the error in, such as edits and hidden thread
+// replies. They're kept in the outbox until they're retried or cancelled.
+type failedOutboxBar struct {
+	*gtk.InfoBar
+	label *gtk.Label
+
+	ctx    context.Context
+	failed map[string]gotktrix.OutboxUpdate
+}
+
+var failedOutboxBarCSS = cssutil.Applier("messageview-failedbar", `
+	.messageview-failedbar > revealer > box {
+		padding-top:    2px;
+		padding-bottom: 2px;
+		padding-left:  12px;
+	}
+	.messageview-failedbar label {
+		color: @error_color;
+	}
+	.messageview-failedbar button {
+		padding-top:    0px;
+		padding-bottom: 0px;
+	}
+`)
+
+func newFailedOutboxBar(ctx context.Context) *failedOutboxBar {
+	b := failedOutboxBar{
+		ctx:    ctx,
+		failed: make(map[string]gotktrix.OutboxUpdate),
+	}
+
+	b.label = gtk.NewLabel("")
+	b.label.SetXAlign(0)
+	b.label.SetHExpand(true)
+	b.label.SetWrap(true)
+	b.label.SetWrapMode(pango.WrapWordChar)
+
+	b.InfoBar = gtk.NewInfoBar()
+	b.InfoBar.AddChild(b.label)
+	b.InfoBar.SetRevealed(false)
+
+	retry := b.InfoBar.AddButton(locale.S(ctx, "Retry"), 0)
+	retry.ConnectClicked(func() { b.each((*gotktrix.Outbox).Retry) })
+
+	cancel := b.InfoBar.AddButton(locale.S(ctx, "Cancel"), 0)
+	cancel.ConnectClicked(func() { b.each((*gotktrix.Outbox).Cancel) })
+
+	failedOutboxBarCSS(b)
+	return &b
+}
+
+// each calls f on the outbox with every failed event.
+func (b *failedOutboxBar) each(f func(o *gotktrix.Outbox, txnID string)) {
+	client := gotktrix.FromContext(b.ctx)
+	for txnID := range b.failed {
+		f(client.Outbox, txnID)
+	}
+}
+
+// update updates the bar with the given update of an event without a row.
+func (b *failedOutboxBar) update(u gotktrix.OutboxUpdate) {
+	if u.Status == gotktrix.OutboxFailed && u.NextRetry.IsZero() {
+		b.failed[u.Event.TxnID] = u
+	} else {
+		delete(b.failed, u.Event.TxnID)
+	}
+
+	switch len(b.failed) {
+	case 0:
+		b.SetRevealed(false)
+		return
+	case 1:
+		for _, failed := range b.failed {
+			b.label.SetText(locale.Sprintf(b.ctx, "Failed to send: %s.", failed.Err))
+			b.label.SetTooltipText(failed.Err.Error())
+		}
+	default:
+		b.label.SetText(locale.Plural(b.ctx,
+			"%d event failed to send.", "%d events failed to send.", len(b.failed)))
+		b.label.SetTooltipText("")
+	}
+
+	b.SetRevealed(true)
+}
+
+// onOutboxUpdate updates the sending message rows. Updates to events that
+// relate to another event, such as edits, and hidden thread replies don't have
+// their own row, so they're shown in the failed bar if they fail.
+func (p *Page) onOutboxUpdate(u gotktrix.OutboxUpdate) {
+	ctx := p.ctx.Take()
+	client := gotktrix.FromContext(ctx)
+
+	ev := outboxRoomEvent(client.UserID, u.Event)
+
+	hidden := relatesTo(ev) != ""
+	if threadRoot(ev) != "" && hideThreadReplies.Value() {
+		hidden = true
+	}
+
+	if hidden {
+		p.failedBar.update(u)
+		return
+	}
+
+	key, ok := p.outbox[u.Event.TxnID]
+
+	switch u.Status {
+	case gotktrix.OutboxPending, gotktrix.OutboxFailed:
+		if !ok {
+			key = p.addOutboxMessage(ctx, ev, u.Event.TxnID)
+			p.outbox[u.Event.TxnID] = key
+		}
+
+		if msg, ok := p.messages[key]; ok && msg.sending != nil {
+			msg.sending.update(ctx, u)
+		}
+
+	case gotktrix.OutboxSent:
+		if ok {
+			p.BindSendingMessage(key, u.EventID)
+			delete(p.outbox, u.Event.TxnID)
+		}
+
+	case gotktrix.OutboxCancelled:
+		if ok {
+			p.StopSendingMessage(key)
+			delete(p.outbox, u.Event.TxnID)
+		}
+	}
+}
+
+// addOutboxMessage adds the given outbox event as a sending message.
+func (p *Page) addOutboxMessage(ctx context.Context, ev event.RoomEvent, txnID string) messageKey {
+	key := messageKeyLocal()
+
+	row := gtk.NewListBoxRow()
+	row.SetName(string(key))
+	row.AddCSSClass("messageview-messagerow")
+	row.AddCSSClass("messageview-usermessage")
+
+	sending := newSendingBox(ctx, txnID)
+	row.SetChild(sending)
+
+	p.setMessage(key, messageRow{
+		row:     row,
+		ev:      ev,
+		sending: sending,
+	})
+
+	return key
+}
+
+// loadOutbox adds all unsent messages of this room.
+func (p *Page) loadOutbox() {
+	client := gotktrix.FromContext(p.ctx.Take())
+
+	for _, u := range client.Outbox.Events(p.roomID) {
+		p.onOutboxUpdate(u)
+	}
+}
@@ -99,8 +99,17 @@ type Page struct {
 	gtk.Widgetter
 	Composer *compose.Composer
 
-	main *adaptive.LoadablePage
-	box  *gtk.Box
+	main  *adaptive.LoadablePage
+	box   *gtk.Box
+	split *gtk.Box
+
+	// thread is the currently opened thread panel, if any.
+	thread    *threadPanel
+	threadRev *gtk.Revealer
+
+	// members is the member list panel. It is created when it's first shown.
+	members    *memberPanel
+	membersRev *gtk.Revealer
 
 	// moreMsgBar is the bar on top that pops up when there are new unread
 	// messages in the current room.
@@ -115,9 +124,19 @@ type Page struct {
 	// pieces of events in separate places.
 	messages map[messageKey]messageRow
 	mrelated map[matrix.EventID]matrix.EventID // keep track of reactions
+	// outbox maps transaction IDs of unsent messages to their rows.
+	outbox map[string]messageKey
+	// failedBar shows the unsent events that have no rows.
+	failedBar *failedOutboxBar
+	// threaded maps the IDs of hidden thread events to their thread roots.
+	threaded map[matrix.EventID]matrix.EventID
+	// ignored is the set of ignored users whose messages aren't shown.
+	ignored map[matrix.UserID]bool
 
 	// extra is the bottom popup for typing indicators and etc.
 	extra *extraRevealer
+	// search is the message search bar on top.
+	search *searchPanel
 
 	name    string
 	onTitle func(title string)
@@ -131,6 +150,10 @@ type Page struct {
 	replyingTo matrix.EventID
 
 	loaded bool
+	// ready is true once the initial messages are all added. onReady holds
+	// the callbacks to be called when that happens.
+	ready   bool
+	onReady []func()
 }
 
 type messageRow struct {
@@ -140,12 +163,16 @@ type messageRow struct {
 	custom bool
 	// these fields are changed depending on the above fields.
 	body message.Message
+	// sending wraps body if the message is still in the outbox.
+	sending *sendingBox
+	// receipts wraps body once members have read up to the message.
+	receipts *receiptsBox
 	// before tracks the event before so we can invalidate it if we insert a new
 	// one before.
 	before matrix.EventID
 }
 
-var _ message.MessageViewer = (*Page)(nil)
+var _ message.ThreadViewer = (*Page)(nil)
 
 var msgListCSS = cssutil.Applier("messageview-msglist", `
 	.messageview-msglist {
@@ -201,6 +228,7 @@ const maxFetch = 40
 
 var messageviewEvents = []event.Type{
 	event.TypeTyping,
+	event.TypeReceipt,
 	m.FullyReadEventType,
 }
 
@@ -211,6 +239,8 @@ func NewPage(ctx context.Context, parent *View, roomID matrix.RoomID) *Page {
 	p := Page{
 		messages: make(map[messageKey]messageRow),
 		mrelated: make(map[matrix.EventID]matrix.EventID),
+		outbox:   make(map[string]messageKey),
+		threaded: make(map[matrix.EventID]matrix.EventID),
 
 		onTitle: func(string) {},
 		name:    name,
@@ -296,14 +326,34 @@ func NewPage(ctx context.Context, parent *View, roomID matrix.RoomID) *Page {
 	overlay.AddOverlay(p.extra)
 	overlay.AddOverlay(p.moreMsgBar)
 
+	p.search = newSearchPanel(&p)
+
+	p.failedBar = newFailedOutboxBar(ctx)
+
 	p.box = gtk.NewBox(gtk.OrientationVertical, 0)
+	p.box.Append(p.search)
 	p.box.Append(overlay)
+	p.box.Append(p.failedBar)
 	p.box.Append(p.Composer)
 	p.box.SetFocusChild(p.Composer)
 	p.box.AddCSSClass("messageview-box")
+	p.box.SetHExpand(true)
+
+	p.threadRev = gtk.NewRevealer()
+	p.threadRev.SetTransitionType(gtk.RevealerTransitionTypeSlideLeft)
+	p.threadRev.SetRevealChild(false)
+
+	p.split = gtk.NewBox(gtk.OrientationHorizontal, 0)
+	p.split.Append(p.box)
+	p.split.Append(p.threadRev)
+
+	p.membersRev = gtk.NewRevealer()
+	p.membersRev.SetTransitionType(gtk.RevealerTransitionTypeSlideLeft)
+	p.membersRev.SetRevealChild(false)
+	p.split.Append(p.membersRev)
 
 	p.main = adaptive.NewLoadablePage()
-	p.main.SetChild(p.box)
+	p.main.SetChild(p.split)
 	rhsCSS(p.main)
 
 	// main widget
@@ -315,6 +365,27 @@ func NewPage(ctx context.Context, parent *View, roomID matrix.RoomID) *Page {
 		})
 	})
 
+	// Sync the whole state and more of the timeline of the room while it's
+	// shown, which only matters using sliding sync.
+	p.ctx.OnRenew(func(context.Context) func() {
+		return parent.client.WatchRoom(roomID)
+	})
+
+	// Catch up with changes to the ignored users made while the page was
+	// hidden, then keep up with the ones made afterwards.
+	p.ctx.OnRenew(func(context.Context) func() {
+		glib.IdleAdd(p.onIgnoredUsers)
+		return parent.client.SubscribeUser(m.IgnoredUserListEventType, func() {
+			glib.IdleAdd(p.onIgnoredUsers)
+		})
+	})
+
+	p.ctx.OnRenew(func(context.Context) func() {
+		return parent.client.Outbox.Subscribe(roomID, func(u gotktrix.OutboxUpdate) {
+			glib.IdleAdd(func() { p.onOutboxUpdate(u) })
+		})
+	})
+
 	p.ctx.OnRenew(func(context.Context) func() {
 		client := gotktrix.FromContext(ctx)
 		return client.SubscribeRoomEvents(roomID, messageviewEvents, func(e event.Event) {
@@ -322,6 +393,8 @@ func NewPage(ctx context.Context, parent *View, roomID matrix.RoomID) *Page {
 				switch e := e.(type) {
 				case *event.TypingEvent:
 					p.onTypingEvent(e)
+				case *event.ReceiptEvent:
+					p.invalidateReceipts()
 				case *m.FullyReadEvent:
 					p.moreMsgBar.Invalidate()
 				}
@@ -492,6 +565,7 @@ func (p *Page) MarkAsRead() {
 
 	client := gotktrix.FromContext(p.ctx.Take())
 	roomID := p.roomID
+	receipt := receiptType()
 
 	p.markReadBtn.SetSensitive(false)
 	done := func(hide bool) {
@@ -509,7 +583,7 @@ func (p *Page) MarkAsRead() {
 			return func() { done(true) }
 		}
 
-		if err := client.MarkRoomAsRead(roomID, latest.RoomInfo().ID); err != nil {
+		if err := client.MarkRoomAsRead(roomID, latest.RoomInfo().ID, receipt); err != nil {
 			// No need to interrupt the user for this.
 			log.Println("failed to mark room as read:", err)
 			return func() { done(false) }
@@ -651,6 +725,12 @@ func (p *Page) BindSendingMessage(mark interface{}, evID matrix.EventID) (replac
 		msg.body.SetBlur(false)
 	}
 
+	if msg.sending != nil {
+		msg.sending.takeBody()
+		msg.sending = nil
+		msg.row.SetChild(msg.body)
+	}
+
 	msg.row.SetName(string(eventKey))
 	p.messages[eventKey] = msg
 
@@ -688,6 +768,14 @@ func (p *Page) OnRoomEvent(ev event.RoomEvent) {
 func (p *Page) onRoomEvent(ev event.RoomEvent) (key messageKey) {
 	key = messageKeyEvent(ev)
 
+	if p.parent.client.IsIgnored(ev.RoomInfo().Sender) {
+		return
+	}
+
+	if p.onThreadEvent(ev) {
+		return
+	}
+
 	if relatesToID := relatesTo(ev); relatesToID != "" {
 		r, ok := p.relatedEvent(relatesToID)
 		if ok && r.body.OnRelatedEvent(ev) {
@@ -792,7 +880,15 @@ func (p *Page) resetMessage(key messageKey, before messageRow) bool {
 		}
 
 		p.messages[key] = msg
-		msg.row.SetChild(msg.body)
+
+		if msg.sending != nil {
+			msg.sending.setBody(msg.body)
+			msg.body.SetBlur(true)
+		} else if msg.receipts != nil {
+			msg.receipts.setBody(msg.body)
+		} else {
+			msg.row.SetChild(msg.body)
+		}
 	}
 
 	return true
@@ -821,8 +917,13 @@ func relatesTo(ev event.RoomEvent) matrix.EventID {
 	case *event.RoomMessageEvent:
 		var relatesTo struct {
 			EventID matrix.EventID `json:"event_id"`
+			RelType string         `json:"rel_type"`
 		}
 		json.Unmarshal(ev.RelatesTo, &relatesTo)
+		// Thread replies are handled separately; see onThreadEvent.
+		if relatesTo.RelType == m.ThreadRelType {
+			return ""
+		}
 		return relatesTo.EventID
 	default:
 		return ""
@@ -847,6 +948,7 @@ func (p *Page) Load() {
 		return
 	}
 	p.loaded = true
+	p.ignored = p.ignoredUsers()
 
 	p.ctx.Renew()
 	ctx := p.ctx.Take()
@@ -855,7 +957,7 @@ func (p *Page) Load() {
 	fetchName := p.name == ""
 
 	load := func(events []event.RoomEvent) {
-		p.main.SetChild(p.box)
+		p.main.SetChild(p.split)
 		p.list.GrabFocus()
 		p.scroll.ScrollToBottom()
 
@@ -885,6 +987,17 @@ func (p *Page) Load() {
 				glib.TimeoutAddPriority(time, glib.PriorityHighIdle, load)
 			}
 		}
+
+		// Queue this after the last batch of messages.
+		last := uint(len(events)/thres) * delay
+		glib.TimeoutAddPriority(last, glib.PriorityHighIdle, func() {
+			p.loadOutbox()
+			p.setReady()
+			p.invalidateReceipts()
+			// Restore the draft only now, since it may be replying to or
+			// editing one of the loaded messages.
+			p.Composer.RestoreDraft()
+		})
 	}
 
 	// We can rely on this comparison to directly call Paginate on the main
@@ -961,12 +1074,148 @@ func (p *Page) loadMore(done paginateDoneFunc) {
 				}
 			}
 
+			// Older messages might have receipts.
+			p.invalidateReceipts()
+
 			// TODO: check for hasMore.
 			done(true, nil)
 		}
 	})
 }
 
+// ignoredUsers returns the set of users that are currently ignored.
+func (p *Page) ignoredUsers() map[matrix.UserID]bool {
+	userIDs := p.parent.client.Offline().IgnoredUsers()
+
+	ignored := make(map[matrix.UserID]bool, len(userIDs))
+	for _, userID := range userIDs {
+		ignored[userID] = true
+	}
+
+	return ignored
+}
+
+// onIgnoredUsers updates the messages after the list of ignored users changes.
+// Messages of newly ignored users are removed, while the page is reloaded if
+// anyone is unignored, since their messages were never added.
+func (p *Page) onIgnoredUsers() {
+	if !p.loaded {
+		return
+	}
+
+	ignored := p.ignoredUsers()
+
+	var unignored bool
+	for userID := range p.ignored {
+		if !ignored[userID] {
+			unignored = true
+			break
+		}
+	}
+
+	p.ignored = ignored
+
+	if unignored {
+		p.reload()
+		return
+	}
+
+	for i := 0; ; {
+		row := p.list.RowAtIndex(i)
+		if row == nil {
+			break
+		}
+
+		key := messageKeyRow(row)
+
+		msg, ok := p.messages[key]
+		if !ok || msg.ev == nil || !ignored[msg.ev.RoomInfo().Sender] {
+			i++
+			continue
+		}
+
+		delete(p.messages, key)
+		p.list.Remove(row)
+		// The message after may have been collapsed into the removed one.
+		p.resetMessageIx(i)
+	}
+}
+
+// reload removes all messages and loads the page again from the latest ones.
+func (p *Page) reload() {
+	for {
+		row := p.list.RowAtIndex(0)
+		if row == nil {
+			break
+		}
+		p.list.Remove(row)
+	}
+
+	p.messages = make(map[messageKey]messageRow)
+	p.mrelated = make(map[matrix.EventID]matrix.EventID)
+	p.outbox = make(map[string]messageKey)
+	p.threaded = make(map[matrix.EventID]matrix.EventID)
+
+	p.pager = p.parent.client.RoomPaginator(p.roomID, maxFetch)
+	p.loaded = false
+	p.ready = false
+
+	p.Load()
+}
+
+func (p *Page) setReady() {
+	p.ready = true
+
+	for _, f := range p.onReady {
+		f()
+	}
+	p.onReady = nil
+}
+
+// maxJumpPages is the maximum number of pages to paginate when jumping to an
+// event that isn't loaded yet.
+const maxJumpPages = 10
+
+// ShowSearch reveals the message search bar.
+func (p *Page) ShowSearch() {
+	p.search.Show()
+}
+
+// JumpTo scrolls to the given event. If the event isn't loaded yet, then older
+// messages are fetched until it's found.
+func (p *Page) JumpTo(eventID matrix.EventID) {
+	if !p.ready {
+		p.onReady = append(p.onReady, func() { p.jumpTo(eventID, maxJumpPages) })
+		return
+	}
+
+	p.jumpTo(eventID, maxJumpPages)
+}
+
+func (p *Page) jumpTo(eventID matrix.EventID, pages int) {
+	if p.ScrollTo(eventID) {
+		return
+	}
+
+	if pages == 0 {
+		log.Printf("cannot find event %s within %d pages", eventID, maxJumpPages)
+		return
+	}
+
+	p.loadMore(func(hasMore bool, err error) {
+		if err != nil {
+			app.Error(p.ctx.Take(), err)
+			return
+		}
+		if !hasMore {
+			return
+		}
+		// loadMore focuses its messages in an idle callback, so queue after
+		// that.
+		glib.IdleAdd(func() { p.jumpTo(eventID, pages-1) })
+	})
+}
+
 // ScrollTo implements message.MessageViewer.
 func (p *Page) ScrollTo(eventID matrix.EventID) bool {
 	m, ok := p.relatedEvent(eventID)
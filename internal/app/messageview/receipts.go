@@ -0,0 +1,234 @@
+package messageview
+
+import (
+	"context"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/diamondburned/gotk4/pkg/gtk/v4"
+	"github.com/diamondburned/gotkit/app/locale"
+	"github.com/diamondburned/gotkit/app/prefs"
+	"github.com/diamondburned/gotkit/components/onlineimage"
+	"github.com/diamondburned/gotkit/gtkutil"
+	"github.com/diamondburned/gotkit/gtkutil/cssutil"
+	"github.com/diamondburned/gotktrix/internal/gotktrix"
+	"github.com/diamondburned/gotktrix/internal/gotktrix/events/m"
+	"github.com/diamondburned/gotrix/matrix"
+)
+
+var privateReceipts = prefs.NewBool(false, prefs.PropMeta{
+	Name:    "Private Read Receipts",
+	Section: "Privacy",
+	Description: "Only let your own devices know which messages you have read. " +
+		"Other members will not see your avatar under the messages.",
+})
+
+// receiptType returns the type of receipts to send depending on the user's
+// preference.
+func receiptType() m.ReceiptType {
+	if privateReceipts.Value() {
+		return m.PrivateReadReceipt
+	}
+	return m.ReadReceipt
+}
+
+const (
+	// maxReceiptAvatars is the maximum number of avatars shown under a
+	// message. The rest is shown as a number.
+	maxReceiptAvatars = 5
+	// receiptAvatarSize is the size of each avatar.
+	receiptAvatarSize = 16
+)
+
+// receiptUser is a member that has read up to a message.
+type receiptUser struct {
+	id     matrix.UserID
+	name   string
+	avatar *matrix.URL
+}
+
+// receiptsBox wraps the body of a message that members have read up to. It
+// shows the avatars of those members under the body.
+type receiptsBox struct {
+	*gtk.Box
+	body gtk.Widgetter
+
+	ctx     context.Context
+	bar     *gtk.Box
+	avatars *gtk.Box
+	shown   []*onlineimage.Avatar
+	more    *gtk.Label
+}
+
+var receiptsBoxCSS = cssutil.Applier("messageview-receipts", `
+	.messageview-receipts-bar {
+		margin: 0 10px 2px 10px;
+	}
+	.messageview-receipts-avatars .onlineimage {
+		border: 1px solid @theme_base_color;
+		border-radius: 99px;
+	}
+	.messageview-receipts-avatars .onlineimage:not(:first-child) {
+		margin-left: -4px;
+	}
+	.messageview-receipts-more {
+		margin-left: 2px;
+		font-size: 0.75em;
+		color: alpha(@theme_fg_color, 0.65);
+	}
+`)
+
+func newReceiptsBox(ctx context.Context) *receiptsBox {
+	b := receiptsBox{ctx: ctx}
+
+	b.more = gtk.NewLabel("")
+	b.more.AddCSSClass("messageview-receipts-more")
+	b.more.Hide()
+
+	b.avatars = gtk.NewBox(gtk.OrientationHorizontal, 0)
+	b.avatars.AddCSSClass("messageview-receipts-avatars")
+
+	b.bar = gtk.NewBox(gtk.OrientationHorizontal, 0)
+	b.bar.AddCSSClass("messageview-receipts-bar")
+	b.bar.SetHAlign(gtk.AlignEnd)
+	b.bar.Append(b.avatars)
+	b.bar.Append(b.more)
+	b.bar.Hide()
+
+	b.Box = gtk.NewBox(gtk.OrientationVertical, 0)
+	b.Box.Append(b.bar)
+	receiptsBoxCSS(b)
+
+	return &b
+}
+
+// setBody replaces the message body.
+func (b *receiptsBox) setBody(body gtk.Widgetter) {
+	if b.body != nil {
+		b.Box.Remove(b.body)
+	}
+	b.body = body
+	b.Box.Prepend(body)
+}
+
+// setUsers shows the given users under the message.
+func (b *receiptsBox) setUsers(users []receiptUser) {
+	for _, avatar := range b.shown {
+		b.avatars.Remove(avatar)
+	}
+	b.shown = b.shown[:0]
+
+	if len(users) == 0 {
+		b.bar.Hide()
+		return
+	}
+
+	names := make([]string, len(users))
+	for i, user := range users {
+		names[i] = user.name
+	}
+
+	b.bar.SetTooltipText(locale.Sprintf(b.ctx, "Seen by %s", strings.Join(names, ", ")))
+	b.bar.Show()
+
+	shown := users
+	if len(shown) > maxReceiptAvatars {
+		shown = shown[:maxReceiptAvatars]
+	}
+
+	for _, user := range shown {
+		avatar := onlineimage.NewAvatar(b.ctx, gotktrix.AvatarProvider, receiptAvatarSize)
+		avatar.SetInitials(user.name)
+		if user.avatar != nil {
+			avatar.SetFromURL(string(*user.avatar))
+		}
+		b.avatars.Append(avatar)
+		b.shown = append(b.shown, avatar)
+	}
+
+	if more := len(users) - len(shown); more > 0 {
+		b.more.SetText(locale.Sprintf(b.ctx, "+%d", more))
+		b.more.Show()
+	} else {
+		b.more.Hide()
+	}
+}
+
+// invalidateReceipts asynchronously reloads the read receipts of the room and
+// shows them under the last message that each member has read.
+func (p *Page) invalidateReceipts() {
+	ctx := p.ctx.Take()
+	client := gotktrix.FromContext(ctx).Offline()
+	roomID := p.roomID
+
+	gtkutil.Async(ctx, func() func() {
+		receipts, err := client.State.RoomReceipts(roomID)
+		if err != nil {
+			log.Println("cannot get read receipts:", err)
+			return nil
+		}
+
+		readers := make(map[matrix.EventID][]receiptUser, len(receipts))
+
+		for eventID, userIDs := range receipts {
+			for _, userID := range userIDs {
+				// The user knows what they've read.
+				if userID == client.UserID {
+					continue
+				}
+
+				user := receiptUser{id: userID, name: string(userID)}
+				if name, err := client.MemberName(roomID, userID, false); err == nil {
+					user.name = name.Name
+				}
+				user.avatar, _ = client.MemberAvatar(roomID, userID)
+
+				readers[eventID] = append(readers[eventID], user)
+			}
+		}
+
+		return func() { p.setReceipts(readers) }
+	})
+}
+
+// setReceipts shows the given readers under their messages and removes the
+// avatars of everyone else.
+func (p *Page) setReceipts(readers map[matrix.EventID][]receiptUser) {
+	rows := make(map[messageKey][]receiptUser, len(readers))
+
+	for eventID, users := range readers {
+		// Receipts on reactions and edits are shown under the message that
+		// they belong to. Messages that aren't loaded are skipped.
+		r, ok := p.relatedEvent(eventID)
+		if !ok || r.sending != nil || r.body == nil {
+			continue
+		}
+
+		key := messageKeyRow(r.row)
+		rows[key] = append(rows[key], users...)
+	}
+
+	for key, msg := range p.messages {
+		users := rows[key]
+		if msg.receipts == nil {
+			if len(users) == 0 {
+				continue
+			}
+
+			msg.receipts = newReceiptsBox(p.ctx.Take())
+			msg.row.SetChild(nil)
+			msg.receipts.setBody(msg.body)
+			msg.row.SetChild(msg.receipts)
+			p.messages[key] = msg
+		}
+
+		sort.Slice(users, func(i, j int) bool {
+			if users[i].name != users[j].name {
+				return users[i].name < users[j].name
+			}
+			return users[i].id < users[j].id
+		})
+		msg.receipts.setUsers(users)
+	}
+}
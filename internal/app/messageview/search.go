@@ -0,0 +1,276 @@
+package messageview
+
+import (
+	"context"
+	"html"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/diamondburned/gotk4/pkg/gtk/v4"
+	"github.com/diamondburned/gotk4/pkg/pango"
+	"github.com/diamondburned/gotkit/app/locale"
+	"github.com/diamondburned/gotkit/gtkutil"
+	"github.com/diamondburned/gotkit/gtkutil/cssutil"
+	"github.com/diamondburned/gotktrix/internal/app/messageview/message/mauthor"
+	"github.com/diamondburned/gotktrix/internal/gotktrix"
+	"github.com/diamondburned/gotktrix/internal/gotktrix/indexer"
+	"github.com/diamondburned/gotrix/matrix"
+)
+
+// searchResultLimit is the maximum number of search results shown.
+const searchResultLimit = 50
+
+// searchPanel is the message search bar on top of a page. Activating a result
+// jumps to the message, opening its room if needed.
+type searchPanel struct {
+	*gtk.Box
+	bar   *gtk.SearchBar
+	entry *gtk.SearchEntry
+	here  *gtk.CheckButton
+
+	results *gtk.ListBox
+	resRev  *gtk.Revealer
+	hits    []indexer.IndexedRoomMessage
+
+	page   *Page
+	cancel context.CancelFunc
+}
+
+var searchPanelCSS = cssutil.Applier("messageview-search", `
+	.messageview-search-results {
+		background: none;
+		border-bottom: 1px solid @borders;
+	}
+	.messageview-search-results > row {
+		padding: 4px 10px;
+	}
+	.messageview-search-author {
+		font-size: 0.9em;
+	}
+	.messageview-search-time {
+		font-size: 0.8em;
+		color: alpha(@theme_fg_color, 0.55);
+	}
+`)
+
+func newSearchPanel(page *Page) *searchPanel {
+	s := searchPanel{page: page}
+
+	s.entry = gtk.NewSearchEntry()
+	s.entry.SetHExpand(true)
+	s.entry.SetObjectProperty("placeholder-text", locale.S(page.ctx.Take(),
+		"Search messages (from:@user:server, before:2006-01-02, after:2006-01-02)"))
+	s.entry.ConnectSearchChanged(func() { s.search(s.entry.Text()) })
+	s.entry.ConnectStopSearch(func() { s.bar.SetSearchMode(false) })
+
+	s.here = gtk.NewCheckButtonWithLabel(locale.S(page.ctx.Take(), "This room only"))
+	s.here.SetActive(true)
+	s.here.ConnectToggled(func() { s.search(s.entry.Text()) })
+
+	barBox := gtk.NewBox(gtk.OrientationHorizontal, 6)
+	barBox.Append(s.entry)
+	barBox.Append(s.here)
+
+	s.bar = gtk.NewSearchBar()
+	s.bar.ConnectEntry(&s.entry.Editable)
+	s.bar.SetSearchMode(false)
+	s.bar.SetShowCloseButton(true)
+	s.bar.SetChild(barBox)
+	s.bar.NotifyProperty("search-mode-enabled", func() {
+		if !s.bar.SearchMode() {
+			s.entry.SetText("")
+			s.clear()
+		}
+	})
+
+	s.results = gtk.NewListBox()
+	s.results.AddCSSClass("messageview-search-results")
+	s.results.SetSelectionMode(gtk.SelectionNone)
+	s.results.SetActivateOnSingleClick(true)
+	s.results.ConnectRowActivated(func(row *gtk.ListBoxRow) {
+		ix := row.Index()
+		if ix >= 0 && ix < len(s.hits) {
+			s.jumpTo(s.hits[ix])
+		}
+	})
+
+	scroll := gtk.NewScrolledWindow()
+	scroll.SetPolicy(gtk.PolicyNever, gtk.PolicyAutomatic)
+	scroll.SetPropagateNaturalHeight(true)
+	scroll.SetMaxContentHeight(300)
+	scroll.SetChild(s.results)
+
+	s.resRev = gtk.NewRevealer()
+	s.resRev.SetTransitionType(gtk.RevealerTransitionTypeSlideDown)
+	s.resRev.SetRevealChild(false)
+	s.resRev.SetChild(scroll)
+
+	s.Box = gtk.NewBox(gtk.OrientationVertical, 0)
+	s.Box.Append(s.bar)
+	s.Box.Append(s.resRev)
+	searchPanelCSS(s)
+
+	return &s
+}
+
+// Show reveals the search bar and focuses its entry.
+func (s *searchPanel) Show() {
+	s.bar.SetSearchMode(true)
+	s.entry.GrabFocus()
+}
+
+func (s *searchPanel) clear() {
+	if s.cancel != nil {
+		s.cancel()
+		s.cancel = nil
+	}
+
+	s.hits = nil
+	s.resRev.SetRevealChild(false)
+
+	for row := s.results.FirstChild(); row != nil; row = s.results.FirstChild() {
+		s.results.Remove(row)
+	}
+}
+
+func (s *searchPanel) search(str string) {
+	s.clear()
+
+	filter, text := parseSearchQuery(str)
+	if text == "" {
+		return
+	}
+
+	if s.here.Active() {
+		filter.Room = s.page.roomID
+	}
+
+	ctx, cancel := context.WithCancel(s.page.ctx.Take())
+	s.cancel = cancel
+
+	client := gotktrix.FromContext(ctx)
+
+	gtkutil.Async(ctx, func() func() {
+		searcher := client.Index.SearchMessages(filter, searchResultLimit)
+		hits := searcher.Search(ctx, text)
+
+		return func() {
+			if ctx.Err() != nil {
+				return
+			}
+			s.setResults(hits)
+		}
+	})
+}
+
+func (s *searchPanel) setResults(hits []indexer.IndexedRoomMessage) {
+	ctx := s.page.ctx.Take()
+	client := gotktrix.FromContext(ctx).Offline()
+
+	s.hits = hits
+
+	if len(hits) == 0 {
+		empty := gtk.NewLabel(locale.S(ctx, "No messages found."))
+		empty.AddCSSClass("messageview-search-time")
+
+		row := gtk.NewListBoxRow()
+		row.SetActivatable(false)
+		row.SetChild(empty)
+
+		s.results.Append(row)
+		s.resRev.SetRevealChild(true)
+		return
+	}
+
+	for _, hit := range hits {
+		markup := mauthor.Markup(client, hit.Room, hit.Sender, mauthor.WithMinimal())
+		if hit.Room != s.page.roomID {
+			roomName, _ := client.RoomName(hit.Room)
+			markup += " · " + html.EscapeString(roomName)
+		}
+
+		author := gtk.NewLabel("")
+		author.AddCSSClass("messageview-search-author")
+		author.SetXAlign(0)
+		author.SetHExpand(true)
+		author.SetEllipsize(pango.EllipsizeEnd)
+		author.SetMarkup(markup)
+
+		ts := time.UnixMilli(hit.Time)
+
+		timestamp := gtk.NewLabel(locale.Time(ts, false))
+		timestamp.AddCSSClass("messageview-search-time")
+		timestamp.SetTooltipText(locale.Time(ts, true))
+
+		top := gtk.NewBox(gtk.OrientationHorizontal, 6)
+		top.Append(author)
+		top.Append(timestamp)
+
+		body := gtk.NewLabel(hit.Body)
+		body.SetXAlign(0)
+		body.SetWrap(true)
+		body.SetWrapMode(pango.WrapWordChar)
+		body.SetLines(3)
+		body.SetEllipsize(pango.EllipsizeEnd)
+
+		box := gtk.NewBox(gtk.OrientationVertical, 2)
+		box.Append(top)
+		box.Append(body)
+
+		row := gtk.NewListBoxRow()
+		row.SetChild(box)
+
+		s.results.Append(row)
+	}
+
+	s.resRev.SetRevealChild(true)
+}
+
+func (s *searchPanel) jumpTo(hit indexer.IndexedRoomMessage) {
+	if hit.Room == s.page.roomID {
+		s.page.JumpTo(hit.ID)
+		return
+	}
+
+	// The room has to be opened first. This replaces the current page, so
+	// the search panel will be gone by the time the message is found.
+	view := s.page.parent
+	view.ctrl.OpenRoom(hit.Room)
+
+	if page := view.Current(); page != nil && page.roomID == hit.Room {
+		page.JumpTo(hit.ID)
+	}
+}
+
+// parseSearchQuery parses the filter keywords out of the given search query.
+// The rest of the query is returned as the text to search for.
+func parseSearchQuery(str string) (indexer.MessageFilter, string) {
+	var filter indexer.MessageFilter
+	var words []string
+
+	for _, word := range strings.Fields(str) {
+		switch {
+		case strings.HasPrefix(word, "from:"):
+			filter.Sender = matrix.UserID(strings.TrimPrefix(word, "from:"))
+		case strings.HasPrefix(word, "before:"):
+			t, err := time.ParseInLocation("2006-01-02", strings.TrimPrefix(word, "before:"), time.Local)
+			if err != nil {
+				log.Println("search: invalid before date:", err)
+				continue
+			}
+			filter.Before = t
+		case strings.HasPrefix(word, "after:"):
+			t, err := time.ParseInLocation("2006-01-02", strings.TrimPrefix(word, "after:"), time.Local)
+			if err != nil {
+				log.Println("search: invalid after date:", err)
+				continue
+			}
+			filter.After = t
+		default:
+			words = append(words, word)
+		}
+	}
+
+	return filter, strings.Join(words, " ")
+}
@@ -0,0 +1,412 @@
+package messageview
+
+import (
+	"github.com/diamondburned/gotk4/pkg/gtk/v4"
+	"github.com/diamondburned/gotk4/pkg/pango"
+	"github.com/diamondburned/gotkit/app"
+	"github.com/diamondburned/gotkit/app/locale"
+	"github.com/diamondburned/gotkit/app/prefs"
+	"github.com/diamondburned/gotkit/components/autoscroll"
+	"github.com/diamondburned/gotkit/gtkutil"
+	"github.com/diamondburned/gotkit/gtkutil/cssutil"
+	"github.com/diamondburned/gotktrix/internal/app/messageview/compose"
+	"github.com/diamondburned/gotktrix/internal/app/messageview/message"
+	"github.com/diamondburned/gotktrix/internal/app/userview"
+	"github.com/diamondburned/gotktrix/internal/gotktrix"
+	"github.com/diamondburned/gotktrix/internal/gotktrix/events/m"
+	"github.com/diamondburned/gotrix/event"
+	"github.com/diamondburned/gotrix/matrix"
+	"github.com/pkg/errors"
+)
+
+var hideThreadReplies = prefs.NewBool(true, prefs.PropMeta{
+	Name:    "Hide Thread Replies",
+	Section: "Text",
+	Description: "Only show replies in threads inside the thread panel " +
+		"instead of also showing them in the main timeline.",
+})
+
+const (
+	// threadPageSize is the number of thread events fetched at once.
+	threadPageSize = 50
+	// maxThreadPages is the maximum number of pages fetched when a thread is
+	// opened.
+	maxThreadPages = 10
+)
+
+// threadRoot returns the root of the thread that the given event is in, or an
+// empty string if it's not in one.
+func threadRoot(ev event.RoomEvent) matrix.EventID {
+	msg, ok := ev.(*event.RoomMessageEvent)
+	if !ok {
+		return ""
+	}
+	return m.ThreadRoot(msg.RelatesTo)
+}
+
+// threadPanel is the side panel that shows the events of a single thread.
+type threadPanel struct {
+	*gtk.Box
+	list     *gtk.ListBox
+	scroll   *autoscroll.Window
+	loading  *gtk.Spinner
+	composer *compose.Composer
+
+	ctx    gtkutil.Canceller
+	page   *Page
+	pager  *gotktrix.ThreadPaginator
+	rootID matrix.EventID
+
+	rows    map[matrix.EventID]threadRow
+	related map[matrix.EventID]matrix.EventID
+	// order is the list of event IDs with rows, latest last.
+	order  []matrix.EventID
+	loaded bool
+}
+
+type threadRow struct {
+	row  *gtk.ListBoxRow
+	body message.Message
+}
+
+var (
+	_ message.MessageViewer = (*threadPanel)(nil)
+	_ compose.Controller    = (*threadPanel)(nil)
+)
+
+var threadPanelCSS = cssutil.Applier("messageview-thread", `
+	.messageview-thread {
+		border-left: 1px solid @borders;
+	}
+	.messageview-thread-header {
+		padding: 4px 4px 4px 10px;
+		border-bottom: 1px solid @borders;
+	}
+	.messageview-thread-header label {
+		font-weight: bold;
+	}
+	.messageview-thread-list {
+		background: none;
+	}
+	.messageview-thread-list > row {
+		padding: 0;
+		background: none;
+	}
+	.messageview-thread-root {
+		border-bottom: 1px solid @borders;
+		padding-bottom: 4px;
+	}
+`)
+
+// threadPanelWidth is the width of the thread side panel.
+const threadPanelWidth = 400
+
+func newThreadPanel(page *Page, rootID matrix.EventID) *threadPanel {
+	ctx := page.ctx.Take()
+
+	t := threadPanel{
+		page:    page,
+		rootID:  rootID,
+		pager:   page.parent.client.ThreadPaginator(page.roomID, rootID, threadPageSize),
+		rows:    make(map[matrix.EventID]threadRow),
+		related: make(map[matrix.EventID]matrix.EventID),
+	}
+
+	title := gtk.NewLabel(locale.S(ctx, "Thread"))
+	title.SetXAlign(0)
+	title.SetHExpand(true)
+	title.SetEllipsize(pango.EllipsizeEnd)
+
+	closeButton := gtk.NewButtonFromIconName("window-close-symbolic")
+	closeButton.SetHasFrame(false)
+	closeButton.SetTooltipText(locale.S(ctx, "Close Thread"))
+	closeButton.ConnectClicked(func() { page.CloseThread() })
+
+	header := gtk.NewBox(gtk.OrientationHorizontal, 0)
+	header.AddCSSClass("messageview-thread-header")
+	header.Append(title)
+	header.Append(closeButton)
+
+	t.list = gtk.NewListBox()
+	t.list.AddCSSClass("messageview-thread-list")
+	t.list.SetSelectionMode(gtk.SelectionNone)
+
+	t.loading = gtk.NewSpinner()
+	t.loading.SetSizeRequest(24, 24)
+	t.loading.SetMarginTop(8)
+	t.loading.SetMarginBottom(8)
+	t.loading.Start()
+
+	listBox := gtk.NewBox(gtk.OrientationVertical, 0)
+	listBox.Append(t.loading)
+	listBox.Append(t.list)
+
+	t.scroll = autoscroll.NewWindow()
+	t.scroll.SetVExpand(true)
+	t.scroll.SetPolicy(gtk.PolicyNever, gtk.PolicyAutomatic)
+	t.scroll.SetChild(listBox)
+	t.list.SetAdjustment(t.scroll.VAdjustment())
+
+	t.composer = compose.New(ctx, &t, page.roomID)
+	t.composer.SetThread(rootID)
+
+	t.Box = gtk.NewBox(gtk.OrientationVertical, 0)
+	t.Box.SetSizeRequest(threadPanelWidth, -1)
+	t.Box.Append(header)
+	t.Box.Append(t.scroll)
+	t.Box.Append(t.composer)
+	threadPanelCSS(t)
+
+	// Mentions from the messages of the thread go into the thread's
+	// composer.
+	ctx = userview.WithController(ctx, userController{
+		Controller: page.parent.ctrl,
+		composer:   func() *compose.Composer { return t.composer },
+	})
+
+	t.ctx = gtkutil.WithVisibility(ctx, t)
+
+	return &t
+}
+
+// load fetches the root and the events of the thread.
+func (t *threadPanel) load() {
+	ctx := t.ctx.Take()
+	client := t.page.parent.client.WithContext(ctx)
+
+	gtkutil.Async(ctx, func() func() {
+		root, err := client.RoomTimelineEvent(t.page.roomID, t.rootID)
+		if err != nil {
+			app.Error(ctx, errors.Wrap(err, "cannot fetch thread root"))
+		}
+
+		var events []event.RoomEvent
+
+		for i := 0; i < maxThreadPages; i++ {
+			page, err := t.pager.Paginate(ctx)
+			if err != nil {
+				app.Error(ctx, errors.Wrap(err, "cannot fetch thread"))
+				break
+			}
+			if page == nil {
+				break
+			}
+			events = append(page, events...)
+		}
+
+		return func() {
+			t.loaded = true
+			t.loading.Stop()
+			t.loading.Hide()
+
+			if root != nil {
+				t.addEvent(root)
+				if r, ok := t.rows[t.rootID]; ok {
+					r.row.AddCSSClass("messageview-thread-root")
+				}
+			}
+
+			for _, ev := range events {
+				t.addEvent(ev)
+			}
+
+			for _, r := range t.rows {
+				r.body.LoadMore()
+			}
+
+			t.scroll.ScrollToBottom()
+		}
+	})
+}
+
+// OnRoomEvent is called by the page on every event that belongs to this
+// thread, as well as on every event that relates to one.
+func (t *threadPanel) OnRoomEvent(ev event.RoomEvent) {
+	// Events that arrive while loading are already fetched.
+	if !t.loaded {
+		return
+	}
+
+	if r, ok := t.addEvent(ev); ok {
+		r.body.LoadMore()
+	}
+}
+
+// addEvent adds the given event into the thread. The returned row is only
+// valid if a new row is created.
+func (t *threadPanel) addEvent(ev event.RoomEvent) (threadRow, bool) {
+	id := ev.RoomInfo().ID
+
+	if _, ok := t.rows[id]; ok || t.page.parent.client.IsIgnored(ev.RoomInfo().Sender) {
+		return threadRow{}, false
+	}
+
+	if relatedID := relatesTo(ev); relatedID != "" {
+		if r, ok := t.relatedRow(relatedID); ok && r.body.OnRelatedEvent(ev) {
+			t.related[id] = relatedID
+			return threadRow{}, false
+		}
+	}
+
+	var before message.Message
+	if len(t.order) > 0 {
+		before = t.rows[t.order[len(t.order)-1]].body
+	}
+
+	body := message.NewCozyMessage(t.ctx.Take(), t, ev, before)
+
+	row := gtk.NewListBoxRow()
+	row.SetChild(body)
+
+	r := threadRow{row: row, body: body}
+	t.rows[id] = r
+	t.order = append(t.order, id)
+	t.list.Append(row)
+
+	return r, true
+}
+
+func (t *threadPanel) relatedRow(id matrix.EventID) (threadRow, bool) {
+	for id != "" {
+		if r, ok := t.rows[id]; ok {
+			return r, true
+		}
+		id = t.related[id]
+	}
+	return threadRow{}, false
+}
+
+// hasEvent returns true if the given event ID is in the thread.
+func (t *threadPanel) hasEvent(id matrix.EventID) bool {
+	_, ok := t.relatedRow(id)
+	return ok
+}
+
+// ReplyTo implements message.MessageViewer.
+func (t *threadPanel) ReplyTo(id matrix.EventID) {
+	t.composer.ReplyTo(id)
+}
+
+// Edit implements message.MessageViewer.
+func (t *threadPanel) Edit(id matrix.EventID) {
+	t.composer.Edit(id)
+}
+
+// ScrollTo implements message.MessageViewer.
+func (t *threadPanel) ScrollTo(id matrix.EventID) bool {
+	if r, ok := t.relatedRow(id); ok {
+		return r.row.GrabFocus()
+	}
+	return t.page.ScrollTo(id)
+}
+
+// FocusLatestUserEventID implements compose.Controller.
+func (t *threadPanel) FocusLatestUserEventID() matrix.EventID {
+	userID := t.page.parent.client.UserID
+
+	for i := len(t.order) - 1; i >= 0; i-- {
+		r := t.rows[t.order[i]]
+		if r.body.Event().RoomInfo().Sender == userID {
+			r.row.GrabFocus()
+			return t.order[i]
+		}
+	}
+
+	return ""
+}
+
+// Uploads and other sending messages are shown in the main timeline.
+
+// AddSendingMessage implements compose.Controller.
+func (t *threadPanel) AddSendingMessage(ev event.RoomEvent) interface{} {
+	return t.page.AddSendingMessage(ev)
+}
+
+// AddSendingMessageCustom implements compose.Controller.
+func (t *threadPanel) AddSendingMessageCustom(ev event.RoomEvent, w gtk.Widgetter) interface{} {
+	return t.page.AddSendingMessageCustom(ev, w)
+}
+
+// StopSendingMessage implements compose.Controller.
+func (t *threadPanel) StopSendingMessage(mark interface{}) bool {
+	return t.page.StopSendingMessage(mark)
+}
+
+// BindSendingMessage implements compose.Controller.
+func (t *threadPanel) BindSendingMessage(mark interface{}, evID matrix.EventID) bool {
+	return t.page.BindSendingMessage(mark, evID)
+}
+
+// OpenThread shows the thread with the given root event on the side of the
+// page. The previous thread is closed.
+func (p *Page) OpenThread(rootID matrix.EventID) {
+	if p.thread != nil {
+		if p.thread.rootID == rootID {
+			p.threadRev.SetRevealChild(true)
+			return
+		}
+		p.CloseThread()
+	}
+
+	p.thread = newThreadPanel(p, rootID)
+	p.thread.load()
+
+	p.threadRev.SetChild(p.thread)
+	p.threadRev.SetRevealChild(true)
+}
+
+// CloseThread closes the thread panel.
+func (p *Page) CloseThread() {
+	if p.thread == nil {
+		return
+	}
+
+	p.thread = nil
+	p.threadRev.SetRevealChild(false)
+	p.threadRev.SetChild(nil)
+}
+
+// onThreadEvent handles the given event if it belongs to a thread, either
+// because it's a reply in one or because it relates to a reply in one. True is
+// returned if the event shouldn't be shown in the main timeline.
+func (p *Page) onThreadEvent(ev event.RoomEvent) bool {
+	id := ev.RoomInfo().ID
+
+	if _, ok := p.threaded[id]; ok {
+		return true
+	}
+
+	rootID := threadRoot(ev)
+	if rootID == "" {
+		// Check if the event relates to an event in a thread, such as an
+		// edit of a thread reply.
+		relatedID := relatesTo(ev)
+		if relatedID == "" {
+			return false
+		}
+
+		rootID = p.threaded[relatedID]
+		if rootID == "" && p.thread != nil && p.thread.hasEvent(relatedID) {
+			rootID = p.thread.rootID
+		}
+		if rootID == "" {
+			return false
+		}
+	} else {
+		// Count the reply into the root's thread summary.
+		if r, ok := p.messages[messageKeyEventID(rootID)]; ok {
+			r.body.OnRelatedEvent(ev)
+		}
+	}
+
+	if p.thread != nil && p.thread.rootID == rootID {
+		p.thread.OnRoomEvent(ev)
+	}
+
+	if !hideThreadReplies.Value() {
+		return false
+	}
+
+	p.threaded[id] = rootID
+	return true
+}
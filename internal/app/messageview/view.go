@@ -4,6 +4,8 @@ import (
 	"context"
 
 	"github.com/diamondburned/gotk4/pkg/gtk/v4"
+	"github.com/diamondburned/gotktrix/internal/app/messageview/compose"
+	"github.com/diamondburned/gotktrix/internal/app/userview"
 	"github.com/diamondburned/gotktrix/internal/gotktrix"
 	"github.com/diamondburned/gotrix/matrix"
 )
@@ -23,6 +25,9 @@ type View struct {
 // This is used for tabs, but we're not implementing tabs for now.
 
 type Controller interface {
+	OpenRoom(id matrix.RoomID)
+	// OpenJoinedRoom opens a room that the user has just joined.
+	OpenJoinedRoom(id matrix.RoomID)
 	SetSelectedRoom(id matrix.RoomID)
 }
 
@@ -37,12 +42,36 @@ func New(ctx context.Context, ctrl Controller) *View {
 	stack := gtk.NewStack()
 	stack.SetTransitionType(gtk.StackTransitionTypeCrossfade)
 
-	return &View{
+	v := View{
 		Stack:  stack,
-		ctx:    ctx,
 		ctrl:   ctrl,
 		client: gotktrix.FromContext(ctx),
 	}
+
+	v.ctx = userview.WithController(ctx, userController{
+		Controller: ctrl,
+		composer: func() *compose.Composer {
+			if v.current == nil {
+				return nil
+			}
+			return v.current.Composer
+		},
+	})
+
+	return &v
+}
+
+// userController implements userview.Controller. Mentions are inserted into
+// the composer returned by the composer function.
+type userController struct {
+	Controller
+	composer func() *compose.Composer
+}
+
+func (c userController) MentionUser(userID matrix.UserID) {
+	if composer := c.composer(); composer != nil {
+		composer.Input().InsertMention(userID)
+	}
 }
 
 // SetPlaceholder sets the placeholder widget.
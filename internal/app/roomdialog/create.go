@@ -0,0 +1,131 @@
+package roomdialog
+
+import (
+	"context"
+	"strings"
+
+	"github.com/diamondburned/gotk4/pkg/core/glib"
+	"github.com/diamondburned/gotkit/app/locale"
+	"github.com/diamondburned/gotktrix/internal/components/assistant"
+	"github.com/diamondburned/gotktrix/internal/gotktrix"
+	"github.com/diamondburned/gotrix/matrix"
+	"github.com/pkg/errors"
+)
+
+// ShowCreate shows the dialog for creating a new room. The new room is opened
+// using the given function once it's created.
+func ShowCreate(ctx context.Context, open OpenFunc) {
+	step := assistant.NewStep(locale.S(ctx, "New Room"), locale.S(ctx, "Create"))
+	a := newAssistant(ctx, locale.S(ctx, "New Room"), step)
+
+	_, server, _ := gotktrix.FromContext(ctx).UserID.Parse()
+
+	inputs := newInputBox()
+
+	name := inputs.addEntry(a, locale.S(ctx, "Name"))
+	topic := inputs.addEntry(a, locale.S(ctx, "Topic (optional)"))
+
+	alias := inputs.addEntry(a, locale.S(ctx, "Address (optional)"))
+	alias.SetPlaceholderText("#name:" + server)
+
+	invites := inputs.addEntry(a, locale.S(ctx, "Invite (optional)"))
+	invites.SetPlaceholderText("@user:" + server)
+	invites.SetTooltipText(locale.S(ctx, "User IDs separated by spaces or commas."))
+
+	public := inputs.addSwitch(locale.S(ctx, "Public"), false)
+	public.SetTooltipText(locale.S(ctx,
+		"Public rooms are listed in the room directory and anyone can join them."))
+
+	encrypted := inputs.addSwitch(locale.S(ctx, "End-to-End Encryption"), true)
+	encrypted.SetTooltipText(locale.S(ctx, "Encryption cannot be disabled later."))
+
+	// Encrypting public rooms is usually pointless, so suggest against it.
+	public.NotifyProperty("active", func() {
+		encrypted.SetActive(!public.Active())
+	})
+
+	errLabel := newErrorLabel()
+
+	content := step.ContentArea()
+	content.Append(inputs)
+	content.Append(errLabel)
+
+	step.Done = func(step *assistant.Step) {
+		arg := gotktrix.CreateRoomArg{
+			Name:      strings.TrimSpace(name.Text()),
+			Topic:     strings.TrimSpace(topic.Text()),
+			Public:    public.Active(),
+			Encrypted: encrypted.Active(),
+		}
+
+		if arg.Name == "" {
+			showError(errLabel, errors.New(locale.S(ctx, "The room needs a name.")))
+			return
+		}
+
+		aliasName, err := parseAliasName(alias.Text(), server)
+		if err != nil {
+			showError(errLabel, err)
+			return
+		}
+		arg.AliasName = aliasName
+
+		arg.Invite, err = parseUserIDs(invites.Text())
+		if err != nil {
+			showError(errLabel, err)
+			return
+		}
+
+		client := gotktrix.FromContext(ctx)
+		a.Busy()
+
+		go func() {
+			roomID, err := client.CreateRoom(arg)
+			glib.IdleAdd(func() {
+				if err != nil {
+					showError(errLabel, err)
+					a.Continue()
+					return
+				}
+
+				a.Close()
+				open(roomID)
+			})
+		}()
+	}
+
+	a.Show()
+}
+
+// parseAliasName parses the local part of the alias that the user typed in. The
+// user may type in the whole alias as long as it's on the user's server.
+func parseAliasName(alias, server string) (string, error) {
+	alias = strings.TrimSpace(alias)
+	alias = strings.TrimPrefix(alias, "#")
+	alias = strings.TrimSuffix(alias, ":"+server)
+
+	if strings.ContainsAny(alias, ":# ") {
+		return "", errors.Errorf("invalid room address %q", alias)
+	}
+
+	return alias, nil
+}
+
+// parseUserIDs parses the list of user IDs separated by spaces or commas.
+func parseUserIDs(str string) ([]matrix.UserID, error) {
+	fields := strings.FieldsFunc(str, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\n' || r == '\t'
+	})
+
+	userIDs := make([]matrix.UserID, 0, len(fields))
+
+	for _, field := range fields {
+		userID := matrix.UserID(field)
+		if _, _, err := userID.Parse(); err != nil {
+			return nil, errors.Errorf("invalid user ID %q", field)
+		}
+		userIDs = append(userIDs, userID)
+	}
+
+	return userIDs, nil
+}
@@ -0,0 +1,330 @@
+package roomdialog
+
+import (
+	"context"
+	"strings"
+
+	"github.com/diamondburned/gotk4/pkg/core/glib"
+	"github.com/diamondburned/gotk4/pkg/gtk/v4"
+	"github.com/diamondburned/gotk4/pkg/pango"
+	"github.com/diamondburned/gotkit/app/locale"
+	"github.com/diamondburned/gotkit/components/onlineimage"
+	"github.com/diamondburned/gotkit/gtkutil/cssutil"
+	"github.com/diamondburned/gotkit/gtkutil/textutil"
+	"github.com/diamondburned/gotktrix/internal/components/assistant"
+	"github.com/diamondburned/gotktrix/internal/gotktrix"
+	"github.com/diamondburned/gotrix/api"
+	"github.com/diamondburned/gotrix/event"
+	"github.com/diamondburned/gotrix/matrix"
+)
+
+const (
+	// directoryPageSize is the number of rooms fetched at once.
+	directoryPageSize = 30
+	// directoryAvatarSize is the size of the room avatars.
+	directoryAvatarSize = 32
+)
+
+// ShowDirectory shows the public room directory browser. The user first
+// chooses the server whose directory to browse, then searches and joins the
+// rooms in it. Joined rooms are opened using the given function.
+func ShowDirectory(ctx context.Context, open OpenFunc) {
+	step := assistant.NewStep(locale.S(ctx, "Server"), locale.S(ctx, "Browse"))
+	a := newAssistant(ctx, locale.S(ctx, "Room Directory"), step)
+
+	_, server, _ := gotktrix.FromContext(ctx).UserID.Parse()
+
+	inputs := newInputBox()
+
+	entry := inputs.addEntry(a, locale.S(ctx, "Server"))
+	entry.SetText(server)
+
+	content := step.ContentArea()
+	content.Append(inputs)
+
+	step.Done = func(*assistant.Step) {
+		server := strings.TrimSpace(entry.Text())
+
+		rooms := newDirectoryStep(ctx, open, server)
+		a.AddStep(rooms.Step)
+		a.SetStep(rooms.Step)
+		rooms.search("")
+	}
+
+	a.Show()
+}
+
+// directoryStep is the assistant step that lists the rooms in a directory.
+type directoryStep struct {
+	*assistant.Step
+	ctx    context.Context
+	open   OpenFunc
+	server string
+
+	list     *gtk.ListBox
+	more     *gtk.Button
+	spinner  *gtk.Spinner
+	errLabel *gtk.Label
+
+	term string
+	next string
+	// gen is incremented on every new search, so that the results of older
+	// searches are thrown away.
+	gen uint
+}
+
+var directoryStepCSS = cssutil.Applier("roomdialog-directory", `
+	.roomdialog-directory {
+		padding: 0;
+		margin:  0;
+	}
+	.roomdialog-directory > entry {
+		margin: 6px;
+	}
+	.roomdialog-directory list {
+		background: none;
+	}
+	.roomdialog-directory-room {
+		padding: 6px;
+	}
+	.roomdialog-directory-room .onlineimage {
+		margin-right: 6px;
+	}
+	.roomdialog-directory-more {
+		margin: 6px;
+	}
+`)
+
+var (
+	directoryNameAttrs = textutil.Attrs(
+		pango.NewAttrWeight(pango.WeightBold),
+	)
+	directorySubtitleAttrs = textutil.Attrs(
+		pango.NewAttrScale(0.85),
+		pango.NewAttrForegroundAlpha(65535*75/100), // 75%
+	)
+)
+
+func newDirectoryStep(ctx context.Context, open OpenFunc, server string) *directoryStep {
+	d := directoryStep{
+		Step:   assistant.NewStep(locale.S(ctx, "Rooms"), ""),
+		ctx:    ctx,
+		open:   open,
+		server: server,
+	}
+	d.CanBack = true
+
+	searchEntry := gtk.NewSearchEntry()
+	searchEntry.SetObjectProperty("placeholder-text", locale.S(ctx, "Search Rooms..."))
+	searchEntry.ConnectSearchChanged(func() { d.search(searchEntry.Text()) })
+
+	d.list = gtk.NewListBox()
+	d.list.SetSelectionMode(gtk.SelectionNone)
+
+	d.spinner = gtk.NewSpinner()
+	d.spinner.SetSizeRequest(24, 24)
+	d.spinner.SetMarginTop(6)
+	d.spinner.SetMarginBottom(6)
+
+	d.more = gtk.NewButtonWithLabel(locale.S(ctx, "Load More"))
+	d.more.AddCSSClass("roomdialog-directory-more")
+	d.more.SetHAlign(gtk.AlignCenter)
+	d.more.ConnectClicked(func() { d.fetch() })
+	d.more.Hide()
+
+	d.errLabel = newErrorLabel()
+
+	listBox := gtk.NewBox(gtk.OrientationVertical, 0)
+	listBox.Append(d.list)
+	listBox.Append(d.spinner)
+	listBox.Append(d.more)
+	listBox.Append(d.errLabel)
+
+	scroll := gtk.NewScrolledWindow()
+	scroll.SetVExpand(true)
+	scroll.SetPolicy(gtk.PolicyNever, gtk.PolicyAutomatic)
+	scroll.SetChild(listBox)
+
+	content := d.ContentArea()
+	content.SetHAlign(gtk.AlignFill)
+	content.SetVAlign(gtk.AlignFill)
+	content.Append(searchEntry)
+	content.Append(scroll)
+	directoryStepCSS(content)
+
+	return &d
+}
+
+// search clears the list and searches for the given term.
+func (d *directoryStep) search(term string) {
+	d.term = term
+	d.next = ""
+	d.gen++
+
+	for {
+		row := d.list.RowAtIndex(0)
+		if row == nil {
+			break
+		}
+		d.list.Remove(row)
+	}
+
+	d.fetch()
+}
+
+// fetch fetches the next page of rooms.
+func (d *directoryStep) fetch() {
+	gen := d.gen
+	term := d.term
+	next := d.next
+
+	d.more.Hide()
+	d.errLabel.Hide()
+	d.spinner.Show()
+	d.spinner.Start()
+
+	client := gotktrix.FromContext(d.ctx)
+
+	go func() {
+		rooms, err := client.SearchDirectory(d.server, term, next, directoryPageSize)
+		glib.IdleAdd(func() {
+			if gen != d.gen {
+				return
+			}
+
+			d.spinner.Stop()
+			d.spinner.Hide()
+
+			if err != nil {
+				showError(d.errLabel, err)
+				return
+			}
+
+			for _, room := range rooms.Rooms {
+				d.list.Append(d.newRoomRow(room))
+			}
+
+			d.next = rooms.Next
+			d.more.SetVisible(rooms.Next != "")
+		})
+	}()
+}
+
+func (d *directoryStep) newRoomRow(room api.PublicRoom) *gtk.ListBoxRow {
+	roomID := matrix.RoomID(room.RoomID)
+
+	title := room.RoomID
+	if room.Name != nil && *room.Name != "" {
+		title = *room.Name
+	} else if room.CanonicalAlias != nil {
+		title = *room.CanonicalAlias
+	}
+
+	avatar := onlineimage.NewAvatar(d.ctx, gotktrix.AvatarProvider, directoryAvatarSize)
+	avatar.SetVAlign(gtk.AlignStart)
+	avatar.SetInitials(title)
+	if room.AvatarURL != nil {
+		avatar.SetFromURL(string(*room.AvatarURL))
+	}
+
+	name := gtk.NewLabel(title)
+	name.SetXAlign(0)
+	name.SetEllipsize(pango.EllipsizeEnd)
+	name.SetAttributes(directoryNameAttrs)
+
+	subtitle := locale.Plural(d.ctx, "%d member", "%d members", room.JoinedMemberCount)
+	if room.CanonicalAlias != nil && *room.CanonicalAlias != title {
+		subtitle = *room.CanonicalAlias + " · " + subtitle
+	}
+
+	info := gtk.NewLabel(subtitle)
+	info.SetXAlign(0)
+	info.SetEllipsize(pango.EllipsizeEnd)
+	info.SetAttributes(directorySubtitleAttrs)
+
+	textBox := gtk.NewBox(gtk.OrientationVertical, 0)
+	textBox.SetHExpand(true)
+	textBox.Append(name)
+	textBox.Append(info)
+
+	if room.Topic != nil && *room.Topic != "" {
+		topic := gtk.NewLabel(*room.Topic)
+		topic.SetXAlign(0)
+		topic.SetWrap(true)
+		topic.SetWrapMode(pango.WrapWordChar)
+		topic.SetLines(2)
+		topic.SetEllipsize(pango.EllipsizeEnd)
+		topic.SetTooltipText(*room.Topic)
+		textBox.Append(topic)
+	}
+
+	join := gtk.NewButton()
+	join.SetVAlign(gtk.AlignCenter)
+
+	if d.isJoined(roomID) {
+		join.SetLabel(locale.S(d.ctx, "Open"))
+		join.ConnectClicked(func() { d.finish(roomID) })
+	} else {
+		join.SetLabel(locale.S(d.ctx, "Join"))
+		join.AddCSSClass("suggested-action")
+		join.ConnectClicked(func() { d.join(join, roomID) })
+	}
+
+	box := gtk.NewBox(gtk.OrientationHorizontal, 0)
+	box.AddCSSClass("roomdialog-directory-room")
+	box.Append(avatar)
+	box.Append(textBox)
+	box.Append(join)
+
+	row := gtk.NewListBoxRow()
+	row.SetActivatable(false)
+	row.SetChild(box)
+
+	return row
+}
+
+// isJoined returns true if the user is already in the room.
+func (d *directoryStep) isJoined(roomID matrix.RoomID) bool {
+	client := gotktrix.FromContext(d.ctx).Offline()
+
+	e, err := client.RoomState(roomID, event.TypeRoomMember, string(client.UserID))
+	if err != nil {
+		return false
+	}
+
+	member, ok := e.(*event.RoomMemberEvent)
+	return ok && member.NewState == event.MemberJoined
+}
+
+func (d *directoryStep) join(button *gtk.Button, roomID matrix.RoomID) {
+	button.SetSensitive(false)
+	d.errLabel.Hide()
+
+	var servers []string
+	if d.server != "" {
+		servers = []string{d.server}
+	}
+
+	client := gotktrix.FromContext(d.ctx)
+
+	go func() {
+		_, err := client.JoinRoom(string(roomID), servers)
+		glib.IdleAdd(func() {
+			if err != nil {
+				button.SetSensitive(true)
+				showError(d.errLabel, err)
+				return
+			}
+
+			d.finish(roomID)
+		})
+	}()
+}
+
+// finish closes the dialog and opens the given room.
+func (d *directoryStep) finish(roomID matrix.RoomID) {
+	if a := d.Assistant(); a != nil {
+		a.Close()
+	}
+	d.open(roomID)
+}
@@ -0,0 +1,56 @@
+package roomdialog
+
+import (
+	"context"
+
+	"github.com/diamondburned/gotk4/pkg/core/glib"
+	"github.com/diamondburned/gotkit/app/locale"
+	"github.com/diamondburned/gotktrix/internal/components/assistant"
+	"github.com/diamondburned/gotktrix/internal/gotktrix"
+)
+
+// ShowJoin shows the dialog for joining a room using its ID, its alias or a
+// matrix.to link. The room is opened using the given function once it's
+// joined.
+func ShowJoin(ctx context.Context, open OpenFunc) {
+	step := assistant.NewStep(locale.S(ctx, "Join Room"), locale.S(ctx, "Join"))
+	a := newAssistant(ctx, locale.S(ctx, "Join Room"), step)
+
+	inputs := newInputBox()
+
+	entry := inputs.addEntry(a, locale.S(ctx, "Room ID, Address or Link"))
+	entry.SetPlaceholderText("#room:matrix.org")
+
+	errLabel := newErrorLabel()
+
+	content := step.ContentArea()
+	content.Append(inputs)
+	content.Append(errLabel)
+
+	step.Done = func(step *assistant.Step) {
+		link, err := gotktrix.ParseRoomLink(entry.Text())
+		if err != nil {
+			showError(errLabel, err)
+			return
+		}
+
+		client := gotktrix.FromContext(ctx)
+		a.Busy()
+
+		go func() {
+			roomID, err := client.JoinRoom(link.IDOrAlias, link.Via)
+			glib.IdleAdd(func() {
+				if err != nil {
+					showError(errLabel, err)
+					a.Continue()
+					return
+				}
+
+				a.Close()
+				open(roomID)
+			})
+		}()
+	}
+
+	a.Show()
+}
@@ -0,0 +1,115 @@
+// Package roomdialog provides the dialogs for creating rooms, joining rooms and
+// browsing the public room directory.
+package roomdialog
+
+import (
+	"context"
+
+	"github.com/diamondburned/gotk4/pkg/gtk/v4"
+	"github.com/diamondburned/gotk4/pkg/pango"
+	"github.com/diamondburned/gotkit/app"
+	"github.com/diamondburned/gotkit/gtkutil/cssutil"
+	"github.com/diamondburned/gotkit/gtkutil/textutil"
+	"github.com/diamondburned/gotktrix/internal/components/assistant"
+	"github.com/diamondburned/gotrix/matrix"
+)
+
+// OpenFunc is called with the ID of the room that the user has just created or
+// joined.
+type OpenFunc func(matrix.RoomID)
+
+// newAssistant creates a new assistant dialog on top of the window in the given
+// context.
+func newAssistant(ctx context.Context, title string, steps ...*assistant.Step) *assistant.Assistant {
+	a := assistant.New(app.GTKWindowFromContext(ctx), steps)
+	a.SetTitle(title)
+	return a
+}
+
+var inputBoxCSS = cssutil.Applier("roomdialog-input-box", `
+	.roomdialog-input-box {
+		margin-top: 4px;
+	}
+	.roomdialog-input-box > label {
+		margin-left: .5em;
+	}
+	.roomdialog-input-box > entry,
+	.roomdialog-input-box > box {
+		margin-bottom: 4px;
+	}
+`)
+
+var inputLabelAttrs = textutil.Attrs(
+	pango.NewAttrForegroundAlpha(65535 * 90 / 100), // 90%
+)
+
+// inputBox is a vertical box of labeled input widgets.
+type inputBox struct {
+	*gtk.Box
+}
+
+func newInputBox() inputBox {
+	box := gtk.NewBox(gtk.OrientationVertical, 0)
+	box.SetSizeRequest(250, -1)
+	inputBoxCSS(box)
+
+	return inputBox{box}
+}
+
+// add adds the given widget with a label on top of it.
+func (b inputBox) add(name string, w gtk.Widgetter) {
+	label := gtk.NewLabel(name)
+	label.SetXAlign(0)
+	label.SetAttributes(inputLabelAttrs)
+
+	b.Append(label)
+	b.Append(w)
+}
+
+// addEntry adds a new entry with the given label. Pressing Enter in the entry
+// activates the OK button of the given assistant.
+func (b inputBox) addEntry(a *assistant.Assistant, name string) *gtk.Entry {
+	entry := gtk.NewEntry()
+	entry.SetEnableUndo(true)
+	entry.ConnectActivate(func() { a.OKButton().Activate() })
+
+	b.add(name, entry)
+	return entry
+}
+
+// addSwitch adds a new switch with the given label on its left.
+func (b inputBox) addSwitch(name string, active bool) *gtk.Switch {
+	label := gtk.NewLabel(name)
+	label.SetXAlign(0)
+	label.SetHExpand(true)
+
+	sw := gtk.NewSwitch()
+	sw.SetActive(active)
+
+	box := gtk.NewBox(gtk.OrientationHorizontal, 4)
+	box.Append(label)
+	box.Append(sw)
+
+	b.Append(box)
+	return sw
+}
+
+var errorLabelCSS = cssutil.Applier("roomdialog-error-label", `
+	.roomdialog-error-label {
+		padding-top: 4px;
+	}
+`)
+
+func newErrorLabel() *gtk.Label {
+	errLabel := textutil.ErrorLabel("")
+	errLabel.SetWrap(true)
+	errLabel.SetWrapMode(pango.WrapWordChar)
+	errLabel.Hide()
+	errorLabelCSS(errLabel)
+	return errLabel
+}
+
+func showError(errLabel *gtk.Label, err error) {
+	errLabel.SetMarkup(textutil.ErrorMarkup(err.Error()))
+	errLabel.Show()
+}
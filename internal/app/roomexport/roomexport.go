@@ -0,0 +1,433 @@
+// Package roomexport provides the dialog for exporting the history of a room
+// into an archive.
+package roomexport
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/diamondburned/gotk4/pkg/core/glib"
+	"github.com/diamondburned/gotk4/pkg/gtk/v4"
+	"github.com/diamondburned/gotkit/app"
+	"github.com/diamondburned/gotkit/app/locale"
+	"github.com/diamondburned/gotkit/gtkutil/cssutil"
+	"github.com/diamondburned/gotktrix/internal/archive"
+	"github.com/diamondburned/gotktrix/internal/components/filepick"
+	"github.com/diamondburned/gotktrix/internal/components/progress"
+	"github.com/diamondburned/gotktrix/internal/gotktrix"
+	"github.com/diamondburned/gotrix/event"
+	"github.com/diamondburned/gotrix/matrix"
+	"github.com/pkg/errors"
+)
+
+// paginateLimit is the number of events to paginate at once.
+const paginateLimit = 100
+
+const (
+	// maxRetries is the number of times that fetching the history is retried
+	// while the homeserver isn't reachable before the export fails.
+	maxRetries = 5
+	// minRetryDelay is the delay before the first retry, which is doubled for
+	// every retry after.
+	minRetryDelay = 2 * time.Second
+)
+
+var exportCSS = cssutil.Applier("roomexport", `
+	.roomexport {
+		margin: 12px;
+	}
+	.roomexport > * {
+		margin-bottom: 6px;
+	}
+	.roomexport-status {
+		font-size: 0.85em;
+	}
+`)
+
+// Show shows the dialog for exporting the history of the given room.
+func Show(ctx context.Context, roomID matrix.RoomID) {
+	client := gotktrix.FromContext(ctx).Offline()
+	name, _ := client.RoomName(roomID)
+
+	formatNames := make([]string, len(archive.Formats))
+	for i, format := range archive.Formats {
+		formatNames[i] = locale.S(ctx, format.Name())
+	}
+
+	format := gtk.NewDropDownFromStrings(formatNames)
+	format.SetHExpand(true)
+
+	formatLabel := gtk.NewLabel(locale.S(ctx, "Format"))
+	formatLabel.SetXAlign(0)
+
+	formatBox := gtk.NewBox(gtk.OrientationHorizontal, 6)
+	formatBox.Append(formatLabel)
+	formatBox.Append(format)
+
+	media := gtk.NewCheckButtonWithLabel(locale.S(ctx, "Download images and files"))
+	media.SetActive(true)
+
+	status := gtk.NewLabel(locale.S(ctx,
+		"The whole history of the room will be fetched. "+
+			"An interrupted export continues where it left off "+
+			"when exported to the same file again."))
+	status.AddCSSClass("roomexport-status")
+	status.SetWrap(true)
+	status.SetXAlign(0)
+
+	eventBar := progress.NewBar()
+	eventBar.SetShowText(true)
+	eventBar.Hide()
+
+	mediaBar := progress.NewBar()
+	mediaBar.SetShowText(true)
+	mediaBar.Hide()
+
+	box := gtk.NewBox(gtk.OrientationVertical, 0)
+	box.Append(formatBox)
+	box.Append(media)
+	box.Append(status)
+	box.Append(eventBar)
+	box.Append(mediaBar)
+	exportCSS(box)
+
+	dialog := gtk.NewDialogWithFlags(
+		app.FromContext(ctx).SuffixedTitle(locale.Sprintf(ctx, "Export %s", name)),
+		app.GTKWindowFromContext(ctx),
+		gtk.DialogDestroyWithParent|gtk.DialogUseHeaderBar,
+	)
+	dialog.SetDefaultSize(400, -1)
+	dialog.ContentArea().Append(box)
+
+	exportButton := dialog.AddButton(locale.S(ctx, "Export..."), int(gtk.ResponseAccept)).(*gtk.Button)
+	exportButton.AddCSSClass("suggested-action")
+	dialog.AddButton(locale.S(ctx, "Close"), int(gtk.ResponseCancel))
+
+	ctx, cancel := context.WithCancel(ctx)
+	dialog.ConnectDestroy(cancel)
+
+	start := func(path string) {
+		f := archive.Formats[format.Selected()]
+
+		formatBox.SetSensitive(false)
+		media.SetSensitive(false)
+		exportButton.SetSensitive(false)
+		eventBar.Show()
+
+		e := exporter{
+			client:   gotktrix.FromContext(ctx),
+			roomID:   roomID,
+			name:     name,
+			path:     path,
+			format:   f,
+			media:    media.Active(),
+			status:   status,
+			eventBar: eventBar,
+			mediaBar: mediaBar,
+		}
+
+		go func() {
+			err := e.export(ctx)
+
+			glib.IdleAdd(func() {
+				switch {
+				case err == nil:
+					status.SetText(locale.Sprintf(ctx, "Exported to %s.", path))
+				case errors.Is(err, context.Canceled):
+					return
+				default:
+					log.Println("failed to export room:", err)
+					eventBar.Error(err)
+					status.SetText(locale.S(ctx,
+						"The export failed. Export to the same file again to continue."))
+				}
+
+				formatBox.SetSensitive(true)
+				media.SetSensitive(true)
+				exportButton.SetSensitive(true)
+			})
+		}()
+	}
+
+	dialog.ConnectResponse(func(id int) {
+		if id != int(gtk.ResponseAccept) {
+			dialog.Destroy()
+			return
+		}
+
+		f := archive.Formats[format.Selected()]
+
+		chooser := filepick.New(
+			ctx, locale.S(ctx, "Export Room"),
+			gtk.FileChooserActionSave,
+			locale.S(ctx, "Export"),
+			locale.S(ctx, "Cancel"),
+		)
+		chooser.SetCurrentName(fileName(name, roomID) + f.Ext())
+		chooser.ConnectAccept(func() {
+			if path := chooser.File().Path(); path != "" {
+				start(path)
+			}
+		})
+		chooser.Show()
+	})
+
+	dialog.Show()
+}
+
+// fileName returns a safe file name for the given room.
+func fileName(name string, roomID matrix.RoomID) string {
+	if name == "" {
+		name = string(roomID)
+	}
+
+	b := []rune(name)
+	for i, r := range b {
+		switch r {
+		case '/', '\\', ':', '*', '?', '"', '<', '>', '|':
+			b[i] = '_'
+		}
+	}
+
+	return string(b)
+}
+
+type exporter struct {
+	client *gotktrix.Client
+	roomID matrix.RoomID
+	name   string
+	path   string
+	format archive.Format
+	media  bool
+
+	status   *gtk.Label
+	eventBar *progress.Bar
+	mediaBar *progress.Bar
+}
+
+func (e *exporter) setStatus(text string) {
+	glib.IdleAdd(func() { e.status.SetText(text) })
+}
+
+func (e *exporter) export(ctx context.Context) error {
+	checkpoint, err := archive.OpenCheckpoint(e.path, e.roomID)
+	if err != nil {
+		return err
+	}
+	defer checkpoint.Close()
+
+	if n := checkpoint.Len(); n > 0 {
+		e.setStatus(locale.Sprintf(ctx, "Resuming from %d saved events...", n))
+	} else {
+		e.setStatus(locale.S(ctx, "Fetching the room history..."))
+	}
+
+	if err := e.paginate(ctx, checkpoint); err != nil {
+		return err
+	}
+
+	events := checkpoint.Events()
+
+	resolver := resolver{
+		client: e.client.Offline(),
+		roomID: e.roomID,
+		names:  make(map[matrix.UserID]string),
+		media:  make(map[matrix.URL]string),
+	}
+
+	if e.media {
+		if err := e.downloadMedia(ctx, events, resolver.media); err != nil {
+			return err
+		}
+	}
+
+	e.setStatus(locale.S(ctx, "Writing the archive..."))
+
+	room := archive.Room{
+		ID:       e.roomID,
+		Name:     e.name,
+		Exported: time.Now(),
+	}
+
+	if err := writeArchive(e.path, e.format, room, events, resolver); err != nil {
+		return err
+	}
+
+	if err := checkpoint.Remove(); err != nil {
+		log.Println("cannot remove export checkpoint:", err)
+	}
+
+	return nil
+}
+
+// paginate collects the whole history of the room into the checkpoint. Events
+// collected by an interrupted export are skipped over. The paginator is strict,
+// so the history never has holes: messages that can't be fetched are retried
+// for a while before the export fails, and a failed export fetches them again
+// when it's resumed.
+func (e *exporter) paginate(ctx context.Context, checkpoint *archive.Checkpoint) error {
+	paginator := e.client.RoomPaginator(e.roomID, paginateLimit)
+	paginator.SetStrict(true)
+
+	var retries int
+
+	for {
+		events, err := paginator.Paginate(ctx)
+		if err != nil {
+			if !gotktrix.IsOffline(err) || retries == maxRetries {
+				return errors.Wrap(err, "failed to fetch the room history")
+			}
+
+			delay := minRetryDelay << retries
+			retries++
+
+			log.Println("cannot fetch room history, retrying:", err)
+			e.setStatus(locale.Sprintf(ctx,
+				"The homeserver isn't reachable, retrying in %d seconds...", int(delay.Seconds())))
+
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(delay):
+				continue
+			}
+		}
+
+		if retries > 0 {
+			retries = 0
+			e.setStatus(locale.S(ctx, "Fetching the room history..."))
+		}
+
+		if len(events) == 0 {
+			return nil
+		}
+
+		if _, err := checkpoint.Add(events); err != nil {
+			return err
+		}
+
+		n := checkpoint.Len()
+		glib.IdleAdd(func() {
+			e.eventBar.Set(int64(n))
+			e.eventBar.SetText(locale.Sprintf(ctx, "%d events", n))
+		})
+	}
+}
+
+// downloadMedia downloads the media of the given events into the media
+// directory of the archive, and fills the given map with the relative paths of
+// the downloaded files. Files that were already downloaded are skipped.
+func (e *exporter) downloadMedia(ctx context.Context, events []event.RoomEvent, paths map[matrix.URL]string) error {
+	var media []archive.Media
+	for _, ev := range events {
+		media = append(media, archive.EventMedia(ev)...)
+	}
+
+	if len(media) == 0 {
+		return nil
+	}
+
+	dir := archive.MediaDir(e.path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return errors.Wrap(err, "failed to make media directory")
+	}
+
+	glib.IdleAdd(func() {
+		e.mediaBar.SetMax(int64(len(media)))
+		e.mediaBar.Show()
+	})
+
+	// The bar for each file only shows the bytes downloaded.
+	fileBar := progress.NewBar()
+
+	for i, m := range media {
+		n := i + 1
+		glib.IdleAdd(func() {
+			e.mediaBar.Set(int64(n))
+			e.mediaBar.SetText(locale.Sprintf(ctx, "Downloading file %d of %d", n, len(media)))
+		})
+
+		name := archive.MediaFileName(m.URL, m.Name)
+		if name == "" {
+			continue
+		}
+
+		dst := filepath.Join(dir, name)
+		rel := filepath.ToSlash(filepath.Join(filepath.Base(dir), name))
+
+		if _, err := os.Stat(dst); err == nil {
+			paths[m.URL] = rel
+			continue
+		}
+
+		url, err := e.client.MediaDownloadURL(m.URL, true, "")
+		if err != nil {
+			log.Printf("cannot get URL of media %q: %v", m.URL, err)
+			continue
+		}
+
+		if err := progress.Download(ctx, url, dst, fileBar); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			log.Printf("cannot download media %q: %v", m.URL, err)
+			continue
+		}
+
+		paths[m.URL] = rel
+	}
+
+	return nil
+}
+
+// writeArchive writes the archive into a temporary file first, so that an
+// older archive isn't broken if writing fails.
+func writeArchive(path string, f archive.Format, room archive.Room, events []event.RoomEvent, r archive.Resolver) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp.*")
+	if err != nil {
+		return errors.Wrap(err, "cannot mktemp")
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if err := archive.Write(tmp, f, room, events, r); err != nil {
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return errors.Wrap(err, "cannot close archive")
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return errors.Wrap(err, "cannot mv archive")
+	}
+
+	return nil
+}
+
+// resolver implements archive.Resolver.
+type resolver struct {
+	client *gotktrix.Client
+	roomID matrix.RoomID
+	names  map[matrix.UserID]string
+	media  map[matrix.URL]string
+}
+
+func (r resolver) MemberName(userID matrix.UserID) string {
+	name, ok := r.names[userID]
+	if !ok {
+		n, err := r.client.MemberName(r.roomID, userID, false)
+		if err == nil {
+			name = n.Name
+		}
+		r.names[userID] = name
+	}
+	return name
+}
+
+func (r resolver) MediaPath(u matrix.URL) string {
+	return r.media[u]
+}
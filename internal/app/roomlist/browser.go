@@ -8,6 +8,7 @@ import (
 	"github.com/diamondburned/gotk4/pkg/gtk/v4"
 	"github.com/diamondburned/gotkit/gtkutil/cssutil"
 	"github.com/diamondburned/gotktrix/internal/app/roomlist/room"
+	"github.com/diamondburned/gotktrix/internal/app/roomlist/section"
 	"github.com/diamondburned/gotktrix/internal/app/roomlist/space"
 	"github.com/diamondburned/gotktrix/internal/gotktrix"
 	"github.com/diamondburned/gotrix/matrix"
@@ -16,8 +17,9 @@ import (
 // Browser describes a widget holding
 type Browser struct {
 	*gtk.Box
-	list   *space.List
-	spaces struct {
+	list    *space.List
+	invites *section.Invites
+	spaces  struct {
 		*gtk.Revealer
 		scroll *gtk.ScrolledWindow
 
@@ -48,6 +50,9 @@ func New(ctx context.Context, ctrl space.Controller) *Browser {
 	b.list = space.New(ctx, ctrl)
 	b.list.SetVExpand(true)
 
+	b.invites = section.NewInvites(ctx, &b)
+	b.list.SetInvites(b.invites)
+
 	allRooms := NewAllRoomsButton(ctx)
 	allRooms.SetActive(true)
 	allRooms.ConnectClicked(func() { b.chooseSpace(allRooms) })
@@ -89,6 +94,8 @@ func New(ctx context.Context, ctrl space.Controller) *Browser {
 func (b *Browser) InvalidateRooms() {
 	client := gotktrix.FromContext(b.ctx)
 
+	b.invites.Invalidate()
+
 	roomIDs, _ := client.State.Rooms()
 	if len(roomIDs) > 0 {
 		state := gotktrix.FromContext(b.ctx).Offline()
@@ -133,6 +140,27 @@ func (b *Browser) InvalidateRooms() {
 	}()
 }
 
+// AddRoom adds the room with the given ID into the browser if it's not already
+// in it. It's used for rooms that the user has just joined.
+func (b *Browser) AddRoom(roomID matrix.RoomID) {
+	client := gotktrix.FromContext(b.ctx).Offline()
+	b.addRoom(roomID, client.RoomType(roomID))
+	b.list.InvalidateSections()
+}
+
+// JoinedRoom adds the room that the user has just joined by accepting its invite
+// and opens it. If the room is a space, then the space is shown instead.
+func (b *Browser) JoinedRoom(roomID matrix.RoomID) {
+	b.AddRoom(roomID)
+
+	if space, ok := b.spaces.buttons[roomID]; ok {
+		b.chooseSpace(space)
+		return
+	}
+
+	b.list.OpenRoom(roomID)
+}
+
 func (b *Browser) addRoom(roomID matrix.RoomID, typ string) {
 	switch typ {
 	case "":
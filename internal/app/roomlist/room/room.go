@@ -3,6 +3,7 @@ package room
 import (
 	"context"
 	"fmt"
+	"html"
 	"strings"
 
 	"github.com/diamondburned/gotk4/pkg/gdk/v4"
@@ -18,6 +19,8 @@ import (
 	"github.com/diamondburned/gotkit/gtkutil/textutil"
 	"github.com/diamondburned/gotktrix/internal/app/emojiview"
 	"github.com/diamondburned/gotktrix/internal/app/messageview/message"
+	"github.com/diamondburned/gotktrix/internal/app/roomexport"
+	"github.com/diamondburned/gotktrix/internal/app/roomsettings"
 	"github.com/diamondburned/gotktrix/internal/gotktrix"
 	"github.com/diamondburned/gotrix/event"
 	"github.com/diamondburned/gotrix/matrix"
@@ -228,6 +231,8 @@ func AddTo(ctx context.Context, section Section, roomID matrix.RoomID) *Room {
 		"room.prompt-reorder":  func() { r.promptReorder() },
 		"room.move-to-section": nil,
 		"room.add-emojis":      func() { emojiview.ForRoom(r.ctx.Take(), r.ID) },
+		"room.settings":        func() { roomsettings.Show(r.ctx.Take(), r.ID) },
+		"room.export":          func() { roomexport.Show(r.ctx.Take(), r.ID) },
 	})
 
 	gtkutil.BindRightClick(r, func() {
@@ -243,6 +248,9 @@ func AddTo(ctx context.Context, section Section, roomID matrix.RoomID) *Room {
 			}),
 			gtkutil.MenuSeparator(s("Emojis")),
 			gtkutil.MenuItem(s("Add Emojis..."), "room.add-emojis"),
+			gtkutil.MenuSeparator(s("Room")),
+			gtkutil.MenuItem(s("Settings..."), "room.settings"),
+			gtkutil.MenuItem(s("Export History..."), "room.export"),
 		})
 		p.SetAutohide(true)
 		p.SetCascadePopdown(true)
@@ -255,15 +263,18 @@ func AddTo(ctx context.Context, section Section, roomID matrix.RoomID) *Room {
 	r.ctx.OnRenew(func(ctx context.Context) func() {
 		r.InvalidatePreview(ctx)
 
+		invalidate := func() {
+			fn := r.invalidatePreview(ctx)
+			gtkutil.IdleCtx(ctx, func() {
+				fn()
+				r.Changed()
+			})
+		}
+
 		return gtkutil.FuncBatcher(
 			r.State.Subscribe(),
-			client.SubscribeRoomSync(roomID, func() {
-				fn := r.invalidatePreview(ctx)
-				gtkutil.IdleCtx(ctx, func() {
-					fn()
-					r.Changed()
-				})
-			}),
+			client.SubscribeRoomSync(roomID, invalidate),
+			client.SubscribeRoomDraft(roomID, invalidate),
 		)
 	})
 
@@ -345,7 +356,13 @@ func (r *Room) invalidatePreview(ctx context.Context) func() {
 	if first == nil {
 		first, extra = client.State.LatestInTimeline(r.ID, "")
 	}
-	if first == nil {
+
+	// Check the draft first, since it's shown even in rooms without messages.
+	draft, hasDraft := client.RoomDraft(r.ID)
+	draftText := strings.Join(strings.Fields(draft.PlainText()), " ")
+	hasDraft = hasDraft && draftText != ""
+
+	if first == nil && !hasDraft {
 		return func() { r.erasePreview() }
 	}
 
@@ -387,7 +404,13 @@ func (r *Room) invalidatePreview(ctx context.Context) func() {
 			r.name.unread.SetText(fmt.Sprintf("(%d)", notifications.Notification))
 		}
 
-		preview := message.RenderEvent(ctx, first)
+		var preview string
+		if hasDraft {
+			preview = locale.Sprintf(ctx, "<b>Draft:</b> %s", html.EscapeString(draftText))
+		} else {
+			preview = message.RenderEvent(ctx, first)
+		}
+
 		r.preview.label.SetMarkup(preview)
 		r.preview.label.SetTooltipMarkup(preview)
 		r.preview.Show()
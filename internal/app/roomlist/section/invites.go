@@ -0,0 +1,285 @@
+package section
+
+import (
+	"context"
+	"log"
+
+	"github.com/diamondburned/gotk4/pkg/core/glib"
+	"github.com/diamondburned/gotk4/pkg/gtk/v4"
+	"github.com/diamondburned/gotk4/pkg/pango"
+	"github.com/diamondburned/gotkit/app"
+	"github.com/diamondburned/gotkit/app/locale"
+	"github.com/diamondburned/gotkit/components/onlineimage"
+	"github.com/diamondburned/gotkit/gtkutil"
+	"github.com/diamondburned/gotkit/gtkutil/cssutil"
+	"github.com/diamondburned/gotktrix/internal/app/roomlist/room"
+	"github.com/diamondburned/gotktrix/internal/gotktrix"
+	"github.com/diamondburned/gotrix/api"
+	"github.com/diamondburned/gotrix/matrix"
+)
+
+// InvitesController describes the parent widget that Invites controls.
+type InvitesController interface {
+	// JoinedRoom is called after the user accepts the invite into the room
+	// with the given ID.
+	JoinedRoom(matrix.RoomID)
+}
+
+// Invites is the section of rooms that the user is invited to. Unlike other
+// sections, the user isn't in these rooms yet, so each row can only be accepted
+// or declined.
+type Invites struct {
+	*gtk.Box
+	ctx  context.Context
+	ctrl InvitesController
+
+	button *iconButton
+	list   *gtk.ListBox
+	rows   map[matrix.RoomID]*inviteRow
+}
+
+// NewInvites creates a new invites section. The section is hidden until there
+// are invites.
+func NewInvites(ctx context.Context, ctrl InvitesController) *Invites {
+	list := gtk.NewListBox()
+	list.SetSelectionMode(gtk.SelectionNone)
+
+	rev := newSectionRevealer(ctx, InvitesSection)
+	rev.SetChild(list)
+
+	btn := newRevealButton(rev, TagName(ctx, InvitesSection))
+	btn.SetHasFrame(false)
+
+	box := gtk.NewBox(gtk.OrientationVertical, 0)
+	box.Append(btn)
+	box.Append(rev)
+	box.SetVisible(false)
+
+	s := Invites{
+		Box:    box,
+		ctx:    ctx,
+		ctrl:   ctrl,
+		button: btn,
+		list:   list,
+		rows:   make(map[matrix.RoomID]*inviteRow),
+	}
+
+	client := gotktrix.FromContext(ctx)
+
+	gtkutil.BindSubscribe(s, func() func() {
+		return client.OnSync(func(*api.SyncResponse) {
+			roomIDs, err := client.InvitedRooms()
+			if err != nil {
+				log.Println("failed to get invited rooms:", err)
+				return
+			}
+			glib.IdleAdd(func() { s.SetRooms(roomIDs) })
+		})
+	})
+
+	return &s
+}
+
+// Invalidate fetches the list of invites from the state.
+func (s *Invites) Invalidate() {
+	client := gotktrix.FromContext(s.ctx).Offline()
+
+	roomIDs, err := client.InvitedRooms()
+	if err != nil {
+		log.Println("failed to get invited rooms:", err)
+		return
+	}
+
+	s.SetRooms(roomIDs)
+}
+
+// SetRooms sets the rooms that the user is invited to. Rows of rooms that
+// aren't in the list anymore are removed.
+func (s *Invites) SetRooms(roomIDs []matrix.RoomID) {
+	invited := make(map[matrix.RoomID]bool, len(roomIDs))
+
+	for _, roomID := range roomIDs {
+		invited[roomID] = true
+
+		if _, ok := s.rows[roomID]; !ok {
+			s.add(roomID)
+		}
+	}
+
+	for roomID := range s.rows {
+		if !invited[roomID] {
+			s.remove(roomID)
+		}
+	}
+}
+
+func (s *Invites) add(roomID matrix.RoomID) {
+	client := gotktrix.FromContext(s.ctx).Offline()
+
+	invite, err := client.RoomInvite(roomID)
+	if err != nil {
+		log.Println("invalid invite:", err)
+		return
+	}
+
+	row := newInviteRow(s.ctx, s, invite)
+	s.list.Append(row)
+	s.rows[roomID] = row
+
+	s.invalidate()
+}
+
+func (s *Invites) remove(roomID matrix.RoomID) {
+	row, ok := s.rows[roomID]
+	if !ok {
+		return
+	}
+
+	s.list.Remove(row)
+	delete(s.rows, roomID)
+
+	s.invalidate()
+}
+
+func (s *Invites) invalidate() {
+	s.button.label.SetLabel(locale.Sprintf(s.ctx, "Invites (%d)", len(s.rows)))
+	s.SetVisible(len(s.rows) > 0)
+}
+
+// do runs the given action on the invite into the given room in the
+// background. The row is removed once the action succeeds.
+func (s *Invites) do(row *inviteRow, f func(*gotktrix.Client) error, done func()) {
+	row.SetSensitive(false)
+
+	ctx := s.ctx
+	client := gotktrix.FromContext(ctx)
+
+	go func() {
+		err := f(client)
+		glib.IdleAdd(func() {
+			if err != nil {
+				row.SetSensitive(true)
+				app.Error(ctx, err)
+				return
+			}
+
+			s.remove(row.invite.RoomID)
+			if done != nil {
+				done()
+			}
+		})
+	}()
+}
+
+// inviteRow is a row in the invites section.
+type inviteRow struct {
+	*gtk.ListBoxRow
+	invite gotktrix.RoomInvite
+}
+
+var inviteRowCSS = cssutil.Applier("room-invite", `
+	.room-invite {
+		padding: 2px 6px;
+		padding-right: 2px;
+	}
+	.room-invite-right {
+		margin-left: 6px;
+	}
+	.room-invite-inviter {
+		font-size: 0.8em;
+		color: alpha(@theme_fg_color, 0.75);
+	}
+	.room-invite-actions button {
+		min-width:  24px;
+		min-height: 24px;
+		padding: 2px;
+	}
+`)
+
+func newInviteRow(ctx context.Context, s *Invites, invite gotktrix.RoomInvite) *inviteRow {
+	row := inviteRow{invite: invite}
+
+	name := gtk.NewLabel(invite.Name)
+	name.SetSingleLineMode(true)
+	name.SetXAlign(0)
+	name.SetEllipsize(pango.EllipsizeEnd)
+	name.SetTooltipText(invite.Name)
+
+	inviter := gtk.NewLabel(locale.Sprintf(ctx, "Invited by %s", invite.InviterName))
+	inviter.AddCSSClass("room-invite-inviter")
+	inviter.SetSingleLineMode(true)
+	inviter.SetXAlign(0)
+	inviter.SetEllipsize(pango.EllipsizeEnd)
+	inviter.SetTooltipText(string(invite.Inviter))
+	if invite.Reason != "" {
+		inviter.SetTooltipText(string(invite.Inviter) + "\n" + invite.Reason)
+	}
+
+	right := gtk.NewBox(gtk.OrientationVertical, 0)
+	right.AddCSSClass("room-invite-right")
+	right.SetHExpand(true)
+	right.SetVAlign(gtk.AlignCenter)
+	right.Append(name)
+	right.Append(inviter)
+
+	avatar := onlineimage.NewAvatar(ctx, gotktrix.AvatarProvider, room.AvatarSize)
+	avatar.ConnectLabel(name)
+	avatar.SetFromURL(string(invite.Avatar))
+
+	accept := newInviteButton("object-select-symbolic", locale.S(ctx, "Accept"))
+	accept.AddCSSClass("suggested-action")
+	accept.ConnectClicked(func() {
+		roomID := invite.RoomID
+		s.do(&row, func(c *gotktrix.Client) error {
+			return c.AcceptInvite(roomID)
+		}, func() {
+			s.ctrl.JoinedRoom(roomID)
+		})
+	})
+
+	decline := newInviteButton("window-close-symbolic", locale.S(ctx, "Decline"))
+	decline.ConnectClicked(func() {
+		s.do(&row, func(c *gotktrix.Client) error {
+			return c.DeclineInvite(invite.RoomID)
+		}, nil)
+	})
+
+	ignore := newInviteButton("action-unavailable-symbolic",
+		locale.Sprintf(ctx, "Decline and Ignore %s", invite.InviterName))
+	ignore.AddCSSClass("destructive-action")
+	ignore.ConnectClicked(func() {
+		s.do(&row, func(c *gotktrix.Client) error {
+			if err := c.IgnoreUser(invite.Inviter); err != nil {
+				return err
+			}
+			return c.DeclineInvite(invite.RoomID)
+		}, nil)
+	})
+
+	actions := gtk.NewBox(gtk.OrientationHorizontal, 2)
+	actions.AddCSSClass("room-invite-actions")
+	actions.SetVAlign(gtk.AlignCenter)
+	actions.Append(accept)
+	actions.Append(decline)
+	actions.Append(ignore)
+
+	box := gtk.NewBox(gtk.OrientationHorizontal, 0)
+	box.Append(avatar)
+	box.Append(right)
+	box.Append(actions)
+	inviteRowCSS(box)
+
+	row.ListBoxRow = gtk.NewListBoxRow()
+	row.ListBoxRow.SetActivatable(false)
+	row.ListBoxRow.SetName(string(invite.RoomID))
+	row.ListBoxRow.SetChild(box)
+
+	return &row
+}
+
+func newInviteButton(icon, tooltip string) *gtk.Button {
+	button := gtk.NewButtonFromIconName(icon)
+	button.SetTooltipText(tooltip)
+	button.SetHasFrame(false)
+	return button
+}
@@ -126,6 +126,31 @@ func acquireConfig(ctx context.Context, uID matrix.UserID) *app.State {
 	return app.AcquireState(ctx, "sections", gotktrix.Base64UserID(uID), "state.json")
 }
 
+// newSectionRevealer creates a new revealer for the section with the given tag.
+// The revealer remembers whether or not it's revealed.
+func newSectionRevealer(ctx context.Context, tag matrix.TagName) *gtk.Revealer {
+	client := gotktrix.FromContext(ctx)
+	cfg := acquireConfig(ctx, client.UserID)
+
+	var reveal bool
+	if !cfg.Get(string(tag), &reveal) {
+		reveal = true
+	}
+
+	rev := gtk.NewRevealer()
+	rev.SetRevealChild(reveal)
+	rev.SetTransitionType(gtk.RevealerTransitionTypeSlideDown)
+	rev.NotifyProperty("reveal-child", func() {
+		if rev.RevealChild() {
+			cfg.Set(string(tag), nil)
+		} else {
+			cfg.Set(string(tag), false)
+		}
+	})
+
+	return rev
+}
+
 // New creates a new deactivated section.
 func New(ctx context.Context, ctrl Controller, tag matrix.TagName) *Section {
 	list := gtk.NewListBox()
@@ -144,24 +169,9 @@ func New(ctx context.Context, ctrl Controller, tag matrix.TagName) *Section {
 	inner.Append(minify)
 
 	client := gotktrix.FromContext(ctx)
-	cfg := acquireConfig(ctx, client.UserID)
 
-	var reveal bool
-	if !cfg.Get(string(tag), &reveal) {
-		reveal = true
-	}
-
-	rev := gtk.NewRevealer()
-	rev.SetRevealChild(reveal)
-	rev.SetTransitionType(gtk.RevealerTransitionTypeSlideDown)
+	rev := newSectionRevealer(ctx, tag)
 	rev.SetChild(inner)
-	rev.NotifyProperty("reveal-child", func() {
-		if rev.RevealChild() {
-			cfg.Set(string(tag), nil)
-		} else {
-			cfg.Set(string(tag), false)
-		}
-	})
 
 	name := TagName(ctx, tag)
 
@@ -25,8 +25,9 @@ var MatrixSectionOrder = map[matrix.TagName]int{
 const (
 	InternalTagNamespace = "xyz.diamondb.gotktrix"
 
-	DMSection    matrix.TagName = InternalTagNamespace + ".dm_section"
-	RoomsSection matrix.TagName = InternalTagNamespace + ".rooms_section"
+	DMSection      matrix.TagName = InternalTagNamespace + ".dm_section"
+	RoomsSection   matrix.TagName = InternalTagNamespace + ".rooms_section"
+	InvitesSection matrix.TagName = InternalTagNamespace + ".invites_section"
 )
 
 // TagIsIntern returns true if the given tag is a Matrix tag or a tag that
@@ -60,6 +61,8 @@ func TagName(ctx context.Context, name matrix.TagName) string {
 		return p.Sprint("People")
 	case RoomsSection:
 		return p.Sprint("Rooms")
+	case InvitesSection:
+		return p.Sprint("Invites")
 	}
 
 	return string(name)
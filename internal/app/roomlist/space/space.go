@@ -35,9 +35,12 @@ type List struct {
 	inner  *gtk.Box // contains sections
 
 	sections []*section.Section
+	invites  *section.Invites
 
 	space spaceState
 	rooms map[matrix.RoomID]*room.Room
+
+	visibleHandle glib.SourceHandle
 }
 
 // Controller describes the controller requirement.
@@ -117,9 +120,54 @@ func New(ctx context.Context, ctrl Controller) *List {
 
 	l.space = newSpaceState(l.InvalidateFilter)
 
+	// Keep the rooms that are scrolled into view synced.
+	vadj := l.scroll.VAdjustment()
+	vadj.ConnectValueChanged(l.queueVisibleRooms)
+	vadj.ConnectChanged(l.queueVisibleRooms)
+	l.scroll.ConnectMap(l.queueVisibleRooms)
+
 	return &l
 }
 
+// visibleRoomsDelay is the delay in milliseconds before the visible rooms are
+// updated, so that scrolling doesn't update them too often.
+const visibleRoomsDelay = 250
+
+// queueVisibleRooms queues updating the rooms that are visible to the client.
+func (l *List) queueVisibleRooms() {
+	if l.visibleHandle != 0 {
+		return
+	}
+
+	l.visibleHandle = glib.TimeoutAdd(visibleRoomsDelay, func() {
+		l.visibleHandle = 0
+		l.updateVisibleRooms()
+	})
+}
+
+// updateVisibleRooms tells the client which rooms are scrolled into view.
+func (l *List) updateVisibleRooms() {
+	height := float32(l.scroll.AllocatedHeight())
+	visible := make([]matrix.RoomID, 0, 32)
+
+	for id, room := range l.rooms {
+		if !room.Mapped() {
+			continue
+		}
+
+		bounds, ok := room.ComputeBounds(l.scroll)
+		if !ok {
+			continue
+		}
+
+		if bounds.Y()+bounds.Height() > 0 && bounds.Y() < height {
+			visible = append(visible, id)
+		}
+	}
+
+	gotktrix.FromContext(l.ctx).SetVisibleRooms(visible)
+}
+
 // SpaceID returns the ID of the currently displayed space. If it's empty, then
 // the list will show all rooms, i.e. no filtering is done.
 func (l *List) SpaceID() matrix.RoomID {
@@ -174,6 +222,8 @@ func (l *List) InvalidateFilter() {
 	for _, s := range l.sections {
 		s.InvalidateFilter()
 	}
+
+	l.queueVisibleRooms()
 }
 
 // Room gets the room with the given ID, or nil if the room is unknown.
@@ -216,16 +266,37 @@ func (l *List) InvalidateSections() {
 	for _, s := range l.sections {
 		s.Unparent()
 	}
+	if l.invites != nil {
+		l.invites.Unparent()
+	}
 
 	section.SortSections(l.sections)
 
 	l.inner = gtk.NewBox(gtk.OrientationVertical, 0)
 	l.outer.SetChild(l.inner)
 
+	// Invites always go first, since they need the user's attention.
+	if l.invites != nil {
+		l.inner.Append(l.invites)
+	}
+
 	// Insert the previous sections into the new box.
 	for _, s := range l.sections {
 		l.inner.Append(s)
 	}
+
+	l.queueVisibleRooms()
+}
+
+// SetInvites sets the invites section that's shown on top of the other
+// sections.
+func (l *List) SetInvites(invites *section.Invites) {
+	if l.invites != nil {
+		l.invites.Unparent()
+	}
+
+	l.invites = invites
+	l.InvalidateSections()
 }
 
 // SetSelectedRoom sets the given room ID as the selected room row. It does not
@@ -0,0 +1,113 @@
+package roomsettings
+
+import (
+	"context"
+
+	"github.com/diamondburned/gotkit/app/locale"
+	"github.com/diamondburned/gotktrix/internal/gotktrix"
+	"github.com/diamondburned/gotrix/event"
+	"github.com/diamondburned/gotrix/matrix"
+)
+
+func newAccessPage(ctx context.Context, roomID matrix.RoomID) *page {
+	p := newPage(ctx, roomID)
+	client := gotktrix.FromContext(ctx).Offline()
+
+	// The defaults here are the ones that the specification says to use if
+	// the room doesn't have the state event.
+	oldJoinRule := event.JoinInvite
+	oldHistory := event.VisibilityShared
+	oldGuests := event.GuestAccessForbidden
+
+	if e, _ := client.RoomState(roomID, event.TypeRoomJoinRules, ""); e != nil {
+		oldJoinRule = e.(*event.RoomJoinRulesEvent).JoinRule
+	}
+	if e, _ := client.RoomState(roomID, event.TypeRoomHistoryVisibility, ""); e != nil {
+		oldHistory = e.(*event.RoomHistoryVisibilityEvent).Visibility
+	}
+	if e, _ := client.RoomState(roomID, event.TypeRoomGuestAccess, ""); e != nil {
+		oldGuests = e.(*event.RoomGuestAccessEvent).GuestAccess
+	}
+
+	joinRules := []option{
+		{string(event.JoinPublic), locale.S(ctx, "Anyone can join")},
+		{string(event.JoinKnock), locale.S(ctx, "Anyone can ask to join")},
+		{string(event.JoinInvite), locale.S(ctx, "Only invited users can join")},
+	}
+
+	joinRule := newOptions(joinRules, string(oldJoinRule))
+	p.add(locale.S(ctx, "Who can join"), joinRule)
+	p.bindPower(joinRule, event.TypeRoomJoinRules)
+
+	histories := []option{
+		{string(event.VisibilityWorldReadable), locale.S(ctx, "Anyone")},
+		{string(event.VisibilityShared), locale.S(ctx, "Members only, including history before they joined")},
+		{string(event.VisibilityInvited), locale.S(ctx, "Members only, since they were invited")},
+		{string(event.VisibilityJoined), locale.S(ctx, "Members only, since they joined")},
+	}
+
+	history := newOptions(histories, string(oldHistory))
+	p.add(locale.S(ctx, "Who can read the history"), history)
+	p.addDescription(locale.S(ctx, "Changes only apply to future messages."))
+	p.bindPower(history, event.TypeRoomHistoryVisibility)
+
+	guestAccesses := []option{
+		{string(event.GuestAccessCanJoin), locale.S(ctx, "Guests can join")},
+		{string(event.GuestAccessForbidden), locale.S(ctx, "Guests cannot join")},
+	}
+
+	guests := newOptions(guestAccesses, string(oldGuests))
+	p.add(locale.S(ctx, "Guest access"), guests)
+	p.bindPower(guests, event.TypeRoomGuestAccess)
+
+	p.finish(func() (change, error) {
+		newJoinRule := event.JoinRule(selectedOption(joinRule, joinRules))
+		newHistory := event.HistoryVisibility(selectedOption(history, histories))
+		newGuests := event.GuestAccess(selectedOption(guests, guestAccesses))
+
+		joinRuleChanged := newJoinRule != oldJoinRule
+		historyChanged := newHistory != oldHistory
+		guestsChanged := newGuests != oldGuests
+
+		apply := func(client *gotktrix.Client) error {
+			if joinRuleChanged {
+				err := client.SetRoomState(roomID, event.TypeRoomJoinRules, "", map[string]string{
+					"join_rule": string(newJoinRule),
+				})
+				if err != nil {
+					return err
+				}
+			}
+
+			if historyChanged {
+				err := client.SetRoomState(roomID, event.TypeRoomHistoryVisibility, "", map[string]string{
+					"history_visibility": string(newHistory),
+				})
+				if err != nil {
+					return err
+				}
+			}
+
+			if guestsChanged {
+				err := client.SetRoomState(roomID, event.TypeRoomGuestAccess, "", map[string]string{
+					"guest_access": string(newGuests),
+				})
+				if err != nil {
+					return err
+				}
+			}
+
+			return nil
+		}
+
+		done := func() {
+			oldJoinRule = newJoinRule
+			oldHistory = newHistory
+			oldGuests = newGuests
+		}
+
+		return change{apply, done}, nil
+	})
+
+	return p
+}
@@ -0,0 +1,218 @@
+package roomsettings
+
+import (
+	"context"
+	"strings"
+
+	"github.com/diamondburned/gotk4/pkg/gtk/v4"
+	"github.com/diamondburned/gotkit/app"
+	"github.com/diamondburned/gotkit/app/locale"
+	"github.com/diamondburned/gotkit/gtkutil"
+	"github.com/diamondburned/gotkit/gtkutil/cssutil"
+	"github.com/diamondburned/gotktrix/internal/gotktrix"
+	"github.com/diamondburned/gotrix/event"
+	"github.com/diamondburned/gotrix/matrix"
+	"github.com/pkg/errors"
+)
+
+func newAddressesPage(ctx context.Context, roomID matrix.RoomID) *page {
+	p := newPage(ctx, roomID)
+	client := gotktrix.FromContext(ctx).Offline()
+
+	var old event.RoomCanonicalAliasEvent
+	if e, _ := client.RoomState(roomID, event.TypeRoomCanonicalAlias, ""); e != nil {
+		old = *e.(*event.RoomCanonicalAliasEvent)
+	}
+
+	_, server, _ := client.UserID.Parse()
+
+	mainAlias := gtk.NewEntry()
+	mainAlias.SetText(old.Alias)
+	mainAlias.SetPlaceholderText("#name:" + server)
+	p.add(locale.S(ctx, "Main Address"), mainAlias)
+	p.bindPower(mainAlias, event.TypeRoomCanonicalAlias)
+
+	altAliases := newTextView(strings.Join(old.AltAlias, "\n"))
+	p.add(locale.S(ctx, "Other Published Addresses"), wrapTextView(altAliases))
+	p.addDescription(locale.S(ctx, "One address per line."))
+	p.bindPower(altAliases, event.TypeRoomCanonicalAlias)
+
+	local := newLocalAliases(ctx, roomID)
+	p.add(locale.Sprintf(ctx, "Local Addresses on %s", server), local)
+	p.addDescription(locale.S(ctx,
+		"Addresses must be added here before they can be published. "+
+			"Changes to these addresses are applied immediately."))
+
+	p.finish(func() (change, error) {
+		content := event.RoomCanonicalAliasEvent{
+			Alias:    strings.TrimSpace(mainAlias.Text()),
+			AltAlias: strings.Fields(textViewText(altAliases)),
+		}
+
+		if content.Alias != "" {
+			if err := validateAlias(content.Alias); err != nil {
+				return change{}, err
+			}
+		}
+
+		for _, alias := range content.AltAlias {
+			if err := validateAlias(alias); err != nil {
+				return change{}, err
+			}
+		}
+
+		apply := func(client *gotktrix.Client) error {
+			return client.SetRoomState(roomID, event.TypeRoomCanonicalAlias, "", content)
+		}
+
+		return change{apply: apply}, nil
+	})
+
+	return p
+}
+
+func validateAlias(alias string) error {
+	if !strings.HasPrefix(alias, "#") || !strings.Contains(alias, ":") {
+		return errors.Errorf("invalid room address %q", alias)
+	}
+	return nil
+}
+
+// localAliases is the list of aliases that point to the room on the user's
+// server.
+type localAliases struct {
+	*gtk.Box
+	ctx    context.Context
+	roomID matrix.RoomID
+
+	list  *gtk.ListBox
+	entry *gtk.Entry
+}
+
+var localAliasesCSS = cssutil.Applier("roomsettings-localaliases", `
+	.roomsettings-localaliases > list {
+		margin-bottom: 4px;
+	}
+	.roomsettings-localaliases row > box > label {
+		margin-left: 6px;
+	}
+`)
+
+func newLocalAliases(ctx context.Context, roomID matrix.RoomID) *localAliases {
+	l := localAliases{
+		ctx:    ctx,
+		roomID: roomID,
+	}
+
+	l.list = gtk.NewListBox()
+	l.list.AddCSSClass("frame")
+	l.list.SetSelectionMode(gtk.SelectionNone)
+	l.list.SetPlaceholder(gtk.NewLabel(locale.S(ctx, "No addresses yet.")))
+
+	_, server, _ := gotktrix.FromContext(ctx).UserID.Parse()
+
+	l.entry = gtk.NewEntry()
+	l.entry.SetHExpand(true)
+	l.entry.SetPlaceholderText("#name:" + server)
+	l.entry.ConnectActivate(l.add)
+
+	add := gtk.NewButtonFromIconName("list-add-symbolic")
+	add.SetTooltipText(locale.S(ctx, "Add Address"))
+	add.ConnectClicked(l.add)
+
+	addBox := gtk.NewBox(gtk.OrientationHorizontal, 0)
+	addBox.AddCSSClass("linked")
+	addBox.Append(l.entry)
+	addBox.Append(add)
+
+	l.Box = gtk.NewBox(gtk.OrientationVertical, 0)
+	l.Box.Append(l.list)
+	l.Box.Append(addBox)
+	localAliasesCSS(l.Box)
+
+	client := gotktrix.FromContext(ctx)
+
+	gtkutil.Async(ctx, func() func() {
+		aliases, err := client.RoomAliases(roomID)
+		if err != nil {
+			app.Error(ctx, errors.Wrap(err, "failed to get room addresses"))
+			return nil
+		}
+
+		return func() {
+			for _, alias := range aliases {
+				l.addRow(alias)
+			}
+		}
+	})
+
+	return &l
+}
+
+func (l *localAliases) add() {
+	alias := strings.TrimSpace(l.entry.Text())
+	if alias == "" {
+		return
+	}
+
+	if err := validateAlias(alias); err != nil {
+		app.Error(l.ctx, err)
+		return
+	}
+
+	l.entry.SetSensitive(false)
+	client := gotktrix.FromContext(l.ctx)
+
+	gtkutil.Async(l.ctx, func() func() {
+		err := client.RoomAliasCreate(alias, l.roomID)
+		return func() {
+			l.entry.SetSensitive(true)
+			if err != nil {
+				app.Error(l.ctx, errors.Wrap(err, "failed to add room address"))
+				return
+			}
+
+			l.entry.SetText("")
+			l.addRow(alias)
+		}
+	})
+}
+
+func (l *localAliases) addRow(alias string) {
+	label := gtk.NewLabel(alias)
+	label.SetXAlign(0)
+	label.SetHExpand(true)
+	label.SetSelectable(true)
+
+	remove := gtk.NewButtonFromIconName("list-remove-symbolic")
+	remove.SetTooltipText(locale.S(l.ctx, "Remove Address"))
+	remove.SetHasFrame(false)
+
+	box := gtk.NewBox(gtk.OrientationHorizontal, 0)
+	box.Append(label)
+	box.Append(remove)
+
+	row := gtk.NewListBoxRow()
+	row.SetActivatable(false)
+	row.SetChild(box)
+	l.list.Append(row)
+
+	client := gotktrix.FromContext(l.ctx)
+
+	remove.ConnectClicked(func() {
+		row.SetSensitive(false)
+
+		gtkutil.Async(l.ctx, func() func() {
+			err := client.RoomAliasDelete(alias)
+			return func() {
+				if err != nil {
+					row.SetSensitive(true)
+					app.Error(l.ctx, errors.Wrap(err, "failed to remove room address"))
+					return
+				}
+
+				l.list.Remove(row)
+			}
+		})
+	})
+}
@@ -0,0 +1,150 @@
+package roomsettings
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/diamondburned/gotk4/pkg/gio/v2"
+	"github.com/diamondburned/gotk4/pkg/gtk/v4"
+	"github.com/diamondburned/gotkit/app/locale"
+	"github.com/diamondburned/gotkit/components/onlineimage"
+	"github.com/diamondburned/gotktrix/internal/components/filepick"
+	"github.com/diamondburned/gotktrix/internal/components/uploadutil"
+	"github.com/diamondburned/gotktrix/internal/gotktrix"
+	"github.com/diamondburned/gotrix/event"
+	"github.com/diamondburned/gotrix/matrix"
+	"github.com/pkg/errors"
+)
+
+// AvatarSize is the size of the room avatar in the General page.
+const AvatarSize = 64
+
+func newGeneralPage(ctx context.Context, roomID matrix.RoomID) *page {
+	p := newPage(ctx, roomID)
+	client := gotktrix.FromContext(ctx).Offline()
+
+	var oldName, oldTopic string
+	var oldAvatar matrix.URL
+
+	if e, _ := client.RoomState(roomID, event.TypeRoomName, ""); e != nil {
+		oldName = e.(*event.RoomNameEvent).Name
+	}
+	if e, _ := client.RoomState(roomID, event.TypeRoomTopic, ""); e != nil {
+		oldTopic = e.(*event.RoomTopicEvent).Topic
+	}
+	if e, _ := client.RoomState(roomID, event.TypeRoomAvatar, ""); e != nil {
+		oldAvatar = e.(*event.RoomAvatarEvent).URL
+	}
+
+	name := gtk.NewEntry()
+	name.SetText(oldName)
+	p.add(locale.S(ctx, "Name"), name)
+	p.bindPower(name, event.TypeRoomName)
+
+	topic := newTextView(oldTopic)
+	p.add(locale.S(ctx, "Topic"), wrapTextView(topic))
+	p.bindPower(topic, event.TypeRoomTopic)
+
+	// avatarPath is the path to the new avatar that the user has picked.
+	var avatarPath string
+
+	avatar := onlineimage.NewAvatar(ctx, gotktrix.AvatarProvider, AvatarSize)
+	avatar.SetInitials(oldName)
+	avatar.SetFromURL(string(oldAvatar))
+
+	avatarButton := gtk.NewButtonWithLabel(locale.S(ctx, "Change Avatar..."))
+	avatarButton.SetVAlign(gtk.AlignCenter)
+	avatarButton.ConnectClicked(func() {
+		filter := gtk.NewFileFilter()
+		filter.AddMIMEType("image/*")
+
+		chooser := filepick.NewLocalize(
+			ctx, "Choose Avatar", gtk.FileChooserActionOpen, "Choose", "Cancel")
+		chooser.AddFilter(filter)
+		chooser.ConnectAccept(func() {
+			files := chooser.Files()
+			if files.NItems() == 0 {
+				return
+			}
+
+			f := gio.File{Object: files.Item(0)}
+			avatarPath = f.Path()
+			avatarButton.SetLabel(filepath.Base(avatarPath))
+		})
+		chooser.Show()
+	})
+
+	avatarBox := gtk.NewBox(gtk.OrientationHorizontal, 8)
+	avatarBox.Append(avatar)
+	avatarBox.Append(avatarButton)
+	p.add(locale.S(ctx, "Avatar"), avatarBox)
+	p.bindPower(avatarButton, event.TypeRoomAvatar)
+
+	p.finish(func() (change, error) {
+		newName := strings.TrimSpace(name.Text())
+		newTopic := strings.TrimSpace(textViewText(topic))
+		newAvatar := avatarPath
+
+		nameChanged := newName != oldName
+		topicChanged := newTopic != oldTopic
+
+		apply := func(client *gotktrix.Client) error {
+			if nameChanged {
+				err := client.SetRoomState(roomID, event.TypeRoomName, "", map[string]string{
+					"name": newName,
+				})
+				if err != nil {
+					return err
+				}
+			}
+
+			if topicChanged {
+				err := client.SetRoomState(roomID, event.TypeRoomTopic, "", map[string]string{
+					"topic": newTopic,
+				})
+				if err != nil {
+					return err
+				}
+			}
+
+			if newAvatar != "" {
+				u, err := uploadAvatar(client, newAvatar)
+				if err != nil {
+					return err
+				}
+
+				err = client.SetRoomState(roomID, event.TypeRoomAvatar, "", map[string]string{
+					"url": string(u),
+				})
+				if err != nil {
+					return err
+				}
+			}
+
+			return nil
+		}
+
+		done := func() {
+			oldName = newName
+			oldTopic = newTopic
+			avatarPath = ""
+			avatarButton.SetLabel(locale.S(ctx, "Change Avatar..."))
+		}
+
+		return change{apply, done}, nil
+	})
+
+	return p
+}
+
+func uploadAvatar(client *gotktrix.Client, path string) (matrix.URL, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to open avatar")
+	}
+	defer f.Close()
+
+	return uploadutil.Upload(client, f, filepath.Base(path))
+}
@@ -0,0 +1,229 @@
+package roomsettings
+
+import (
+	"context"
+
+	"github.com/diamondburned/gotk4/pkg/core/glib"
+	"github.com/diamondburned/gotk4/pkg/gtk/v4"
+	"github.com/diamondburned/gotk4/pkg/pango"
+	"github.com/diamondburned/gotkit/app/locale"
+	"github.com/diamondburned/gotkit/gtkutil/cssutil"
+	"github.com/diamondburned/gotkit/gtkutil/textutil"
+	"github.com/diamondburned/gotktrix/internal/gotktrix"
+	"github.com/diamondburned/gotrix/event"
+	"github.com/diamondburned/gotrix/matrix"
+)
+
+// change is a pending change to the settings of a page.
+type change struct {
+	// apply applies the change. It is called in a goroutine, so it must not
+	// touch any widget.
+	apply func(*gotktrix.Client) error
+	// done is optionally called in the main thread after the change is
+	// applied.
+	done func()
+}
+
+// page is a page of settings with an Apply button at the bottom.
+type page struct {
+	*gtk.Box
+	ctx    context.Context
+	roomID matrix.RoomID
+
+	errLabel *gtk.Label
+	apply    *gtk.Button
+	// editable is true if any of the settings in the page can be changed.
+	editable bool
+}
+
+var pageCSS = cssutil.Applier("roomsettings-page", `
+	.roomsettings-page > label.roomsettings-label {
+		margin-top: 8px;
+		margin-left: .5em;
+		margin-bottom: 2px;
+	}
+	.roomsettings-page > label.roomsettings-description {
+		margin-left: .5em;
+		margin-bottom: 2px;
+		font-size: 0.85em;
+		color: alpha(@theme_fg_color, 0.75);
+	}
+	.roomsettings-page > .roomsettings-error {
+		margin-top: 8px;
+	}
+	.roomsettings-page > button.roomsettings-apply {
+		margin-top: 12px;
+	}
+`)
+
+var labelAttrs = textutil.Attrs(
+	pango.NewAttrWeight(pango.WeightBold),
+)
+
+func newPage(ctx context.Context, roomID matrix.RoomID) *page {
+	box := gtk.NewBox(gtk.OrientationVertical, 0)
+	pageCSS(box)
+
+	errLabel := textutil.ErrorLabel("")
+	errLabel.AddCSSClass("roomsettings-error")
+	errLabel.SetWrap(true)
+	errLabel.SetWrapMode(pango.WrapWordChar)
+	errLabel.Hide()
+
+	apply := gtk.NewButtonWithLabel(locale.S(ctx, "Apply"))
+	apply.AddCSSClass("roomsettings-apply")
+	apply.AddCSSClass("suggested-action")
+	apply.SetHAlign(gtk.AlignEnd)
+
+	return &page{
+		Box:      box,
+		ctx:      ctx,
+		roomID:   roomID,
+		errLabel: errLabel,
+		apply:    apply,
+	}
+}
+
+// add adds the given widget with a label on top of it.
+func (p *page) add(name string, w gtk.Widgetter) {
+	label := gtk.NewLabel(name)
+	label.AddCSSClass("roomsettings-label")
+	label.SetXAlign(0)
+	label.SetAttributes(labelAttrs)
+
+	p.Append(label)
+	p.Append(w)
+}
+
+// addDescription adds a dimmed line of text explaining the setting above it.
+func (p *page) addDescription(desc string) {
+	label := gtk.NewLabel(desc)
+	label.AddCSSClass("roomsettings-description")
+	label.SetXAlign(0)
+	label.SetWrap(true)
+	label.SetWrapMode(pango.WrapWordChar)
+
+	p.Append(label)
+}
+
+// bindPower makes the given widget insensitive if the user can't send the
+// state event of the given type. True is returned if the user can.
+func (p *page) bindPower(w gtk.Widgetter, typ event.Type) bool {
+	client := gotktrix.FromContext(p.ctx).Offline()
+
+	can := client.HasPower(p.roomID, gotktrix.StateAction(typ))
+	if can {
+		p.editable = true
+	}
+
+	gtk.BaseWidget(w).SetSensitive(can)
+	return can
+}
+
+// finish adds the Apply button to the bottom of the page. When the button is
+// clicked, collect is called to gather the settings from the widgets, and the
+// returned change is then applied in the background. If the user can't change
+// anything in the page, then the button is disabled.
+func (p *page) finish(collect func() (change, error)) {
+	p.Append(p.errLabel)
+	p.Append(p.apply)
+
+	p.apply.SetSensitive(p.editable)
+	p.apply.ConnectClicked(func() {
+		p.errLabel.Hide()
+
+		change, err := collect()
+		if err != nil {
+			p.showError(err)
+			return
+		}
+
+		p.apply.SetSensitive(false)
+		client := gotktrix.FromContext(p.ctx)
+
+		go func() {
+			err := change.apply(client)
+			glib.IdleAdd(func() {
+				p.apply.SetSensitive(true)
+				if err != nil {
+					p.showError(err)
+					return
+				}
+				if change.done != nil {
+					change.done()
+				}
+			})
+		}()
+	})
+}
+
+func (p *page) showError(err error) {
+	p.errLabel.SetMarkup(textutil.ErrorMarkup(err.Error()))
+	p.errLabel.Show()
+}
+
+// newTextView creates a new multi-line text input with the given text.
+func newTextView(text string) *gtk.TextView {
+	view := gtk.NewTextView()
+	view.SetWrapMode(gtk.WrapWordChar)
+	view.SetAcceptsTab(false)
+	view.SetSizeRequest(-1, 60)
+	view.Buffer().SetText(text)
+	return view
+}
+
+var textViewCSS = cssutil.Applier("roomsettings-textview-frame", `
+	.roomsettings-textview-frame textview {
+		padding: 4px;
+	}
+`)
+
+// wrapTextView wraps the text view inside a frame so that it looks like an
+// entry.
+func wrapTextView(view *gtk.TextView) *gtk.Frame {
+	frame := gtk.NewFrame("")
+	frame.SetChild(view)
+	textViewCSS(frame)
+	return frame
+}
+
+func textViewText(view *gtk.TextView) string {
+	buf := view.Buffer()
+	start, end := buf.Bounds()
+	return buf.Text(start, end, false)
+}
+
+// newOptions creates a drop-down of the given options. The option whose value
+// is the given value is selected.
+func newOptions(options []option, value string) *gtk.DropDown {
+	names := make([]string, len(options))
+	for i, opt := range options {
+		names[i] = opt.name
+	}
+
+	dropdown := gtk.NewDropDownFromStrings(names)
+	for i, opt := range options {
+		if opt.value == value {
+			dropdown.SetSelected(uint(i))
+			break
+		}
+	}
+
+	return dropdown
+}
+
+// option is an option of a drop-down.
+type option struct {
+	value string
+	name  string
+}
+
+// selectedOption returns the value of the selected option in the drop-down
+// created using newOptions.
+func selectedOption(dropdown *gtk.DropDown, options []option) string {
+	i := dropdown.Selected()
+	if i >= uint(len(options)) {
+		return ""
+	}
+	return options[i].value
+}
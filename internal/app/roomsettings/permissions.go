@@ -0,0 +1,358 @@
+package roomsettings
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/diamondburned/gotk4/pkg/gtk/v4"
+	"github.com/diamondburned/gotk4/pkg/pango"
+	"github.com/diamondburned/gotkit/app"
+	"github.com/diamondburned/gotkit/app/locale"
+	"github.com/diamondburned/gotkit/gtkutil/cssutil"
+	"github.com/diamondburned/gotktrix/internal/gotktrix"
+	"github.com/diamondburned/gotktrix/internal/gotktrix/events/m"
+	"github.com/diamondburned/gotrix/event"
+	"github.com/diamondburned/gotrix/matrix"
+	"github.com/pkg/errors"
+)
+
+// maxLevel is the highest power level that can be typed in, unless someone
+// already has a higher level. The lowest is -maxLevel, since users can be
+// muted using negative levels.
+const maxLevel = 100
+
+// stateEventNames is the list of state events whose power levels can be
+// changed, in the order that they are shown.
+var stateEventNames = []struct {
+	typ  event.Type
+	name string
+}{
+	{event.TypeRoomName, "Change the name"},
+	{event.TypeRoomTopic, "Change the topic"},
+	{event.TypeRoomAvatar, "Change the avatar"},
+	{event.TypeRoomCanonicalAlias, "Change the main address"},
+	{event.TypeRoomJoinRules, "Change who can join"},
+	{event.TypeRoomHistoryVisibility, "Change who can read the history"},
+	{event.TypeRoomGuestAccess, "Change guest access"},
+	{event.TypeRoomPinned, "Pin messages"},
+	{event.TypeRoomPowerLevels, "Change permissions"},
+	{m.EncryptionEventType, "Enable encryption"},
+	{event.TypeRoomTombstone, "Upgrade the room"},
+}
+
+var levelsGridCSS = cssutil.Applier("roomsettings-levels", `
+	.roomsettings-levels {
+		margin: 2px 0 2px .5em;
+	}
+`)
+
+// levelsGrid is a grid of power level inputs with their names on the left.
+type levelsGrid struct {
+	*gtk.Grid
+	rows int
+	// ours is the power level of the current user.
+	ours int
+	// editable is whether the user can edit the power levels at all.
+	editable bool
+}
+
+func newLevelsGrid(ours int, editable bool) *levelsGrid {
+	grid := gtk.NewGrid()
+	grid.SetRowSpacing(2)
+	grid.SetColumnSpacing(8)
+	levelsGridCSS(grid)
+
+	return &levelsGrid{
+		Grid:     grid,
+		ours:     ours,
+		editable: editable,
+	}
+}
+
+// add adds a new row with the given name and power level. The returned spin
+// button is insensitive if the level is higher than the user's, since the
+// user can't change it.
+func (g *levelsGrid) add(name string, level int) *levelSpin {
+	label := gtk.NewLabel(name)
+	label.SetXAlign(0)
+	label.SetHExpand(true)
+	label.SetEllipsize(pango.EllipsizeEnd)
+	label.SetTooltipText(name)
+
+	spin := newLevelSpin(level, g.ours)
+	spin.SetSensitive(g.editable && level <= g.ours)
+
+	g.Attach(label, 0, g.rows, 1, 1)
+	g.Attach(spin, 1, g.rows, 1, 1)
+	g.rows++
+
+	return spin
+}
+
+// levelSpin is a power level input that remembers the level that it was
+// created with.
+type levelSpin struct {
+	*gtk.SpinButton
+	level int
+}
+
+func newLevelSpin(level, ours int) *levelSpin {
+	// Users can't give a higher power level than theirs.
+	max := maxLevel
+	if ours < max {
+		max = ours
+	}
+	if level > max {
+		max = level
+	}
+
+	min := -maxLevel
+	if level < min {
+		min = level
+	}
+
+	spin := gtk.NewSpinButtonWithRange(float64(min), float64(max), 1)
+	spin.SetDigits(0)
+	spin.SetNumeric(true)
+	spin.SetValue(float64(level))
+
+	return &levelSpin{
+		SpinButton: spin,
+		level:      level,
+	}
+}
+
+// changed returns the typed level and whether the user changed it.
+func (s *levelSpin) changed() (int, bool) {
+	level := s.ValueAsInt()
+	return level, level != s.level
+}
+
+// set sets *dst to the typed level if the user changed it, so that levels that
+// the user didn't touch are kept as they are.
+func (s *levelSpin) set(dst *int) {
+	if level, ok := s.changed(); ok {
+		*dst = level
+	}
+}
+
+func newPermissionsPage(ctx context.Context, roomID matrix.RoomID) *page {
+	p := newPage(ctx, roomID)
+	client := gotktrix.FromContext(ctx).Offline()
+
+	levels, err := client.RoomPowerLevels(roomID)
+	if err != nil {
+		p.add(locale.S(ctx, "Permissions"), gtk.NewLabel(locale.S(ctx,
+			"This room has no permissions set.")))
+		return p
+	}
+
+	// Use the real power level even for the room creator, since they may have
+	// been demoted.
+	ours := levels.UserLevel(client.UserID)
+	editable := levels.Can(client.UserID, gotktrix.StateAction(event.TypeRoomPowerLevels))
+	p.editable = editable
+
+	general := newLevelsGrid(ours, editable)
+	p.add(locale.S(ctx, "General"), general)
+
+	usersDefault := general.add(locale.S(ctx, "Default level"), levels.UsersDefault)
+	eventsDefault := general.add(locale.S(ctx, "Send messages"), levels.EventsDefault)
+	stateDefault := general.add(locale.S(ctx, "Change settings"), levels.StateDefault)
+	invite := general.add(locale.S(ctx, "Invite users"), levels.Invite)
+	kick := general.add(locale.S(ctx, "Kick users"), levels.Kick)
+	ban := general.add(locale.S(ctx, "Ban users"), levels.Ban)
+	redact := general.add(locale.S(ctx, "Remove messages sent by others"), levels.Redact)
+	notifyRoom := general.add(locale.S(ctx, "Notify everyone"), levels.NotifyRoom)
+
+	settings := newLevelsGrid(ours, editable)
+	p.add(locale.S(ctx, "Settings"), settings)
+	p.addDescription(locale.S(ctx,
+		"These override the level required to change settings."))
+
+	eventSpins := make(map[event.Type]*levelSpin, len(stateEventNames))
+	for _, ev := range stateEventNames {
+		level := levels.ActionLevel(gotktrix.StateAction(ev.typ))
+		eventSpins[ev.typ] = settings.add(locale.S(ctx, ev.name), level)
+	}
+
+	users := newUserLevels(ctx, roomID, levels, ours, editable)
+	p.add(locale.S(ctx, "Users"), users)
+
+	p.finish(func() (change, error) {
+		// Start from the levels as they were every time, since Apply may be
+		// clicked more than once.
+		levels := copyPowerLevels(levels)
+
+		usersDefault.set(&levels.UsersDefault)
+		eventsDefault.set(&levels.EventsDefault)
+		stateDefault.set(&levels.StateDefault)
+		invite.set(&levels.Invite)
+		kick.set(&levels.Kick)
+		ban.set(&levels.Ban)
+		redact.set(&levels.Redact)
+		notifyRoom.set(&levels.NotifyRoom)
+
+		for typ, spin := range eventSpins {
+			level, changed := spin.changed()
+			if !changed {
+				continue
+			}
+			// Only override the default if we have to, so that changing the
+			// default later still applies to this event.
+			if _, ok := levels.Events[typ]; ok || level != levels.StateDefault {
+				levels.Events[typ] = level
+			}
+		}
+
+		users.defaultLevel = levels.UsersDefault
+		users.apply(levels.Users)
+
+		apply := func(client *gotktrix.Client) error {
+			return client.SetRoomPowerLevels(roomID, levels)
+		}
+
+		return change{apply: apply}, nil
+	})
+
+	return p
+}
+
+// copyPowerLevels returns a copy of the given power levels that can be changed
+// without changing the original.
+func copyPowerLevels(levels *gotktrix.PowerLevels) *gotktrix.PowerLevels {
+	cpy := *levels
+
+	cpy.Events = make(map[event.Type]int, len(levels.Events))
+	for typ, level := range levels.Events {
+		cpy.Events[typ] = level
+	}
+
+	cpy.Users = make(map[matrix.UserID]int, len(levels.Users))
+	for userID, level := range levels.Users {
+		cpy.Users[userID] = level
+	}
+
+	return &cpy
+}
+
+// userLevels is the list of users with their own power levels.
+type userLevels struct {
+	*gtk.Box
+	ctx    context.Context
+	roomID matrix.RoomID
+
+	grid  *levelsGrid
+	spins map[matrix.UserID]*levelSpin
+	// explicit is the set of users that already had their own power levels.
+	explicit map[matrix.UserID]bool
+	// defaultLevel is the level of users without their own power levels.
+	defaultLevel int
+}
+
+func newUserLevels(
+	ctx context.Context, roomID matrix.RoomID,
+	levels *gotktrix.PowerLevels, ours int, editable bool) *userLevels {
+
+	u := userLevels{
+		ctx:          ctx,
+		roomID:       roomID,
+		grid:         newLevelsGrid(ours, editable),
+		spins:        make(map[matrix.UserID]*levelSpin, len(levels.Users)),
+		explicit:     make(map[matrix.UserID]bool, len(levels.Users)),
+		defaultLevel: levels.UsersDefault,
+	}
+
+	userIDs := make([]matrix.UserID, 0, len(levels.Users))
+	for userID := range levels.Users {
+		userIDs = append(userIDs, userID)
+	}
+
+	// Show users with the highest levels first.
+	sort.Slice(userIDs, func(i, j int) bool {
+		li := levels.Users[userIDs[i]]
+		lj := levels.Users[userIDs[j]]
+		if li != lj {
+			return li > lj
+		}
+		return userIDs[i] < userIDs[j]
+	})
+
+	for _, userID := range userIDs {
+		u.explicit[userID] = true
+		u.add(userID, levels.Users[userID])
+	}
+
+	_, server, _ := gotktrix.FromContext(ctx).UserID.Parse()
+
+	entry := gtk.NewEntry()
+	entry.SetHExpand(true)
+	entry.SetPlaceholderText("@user:" + server)
+
+	add := gtk.NewButtonFromIconName("list-add-symbolic")
+	add.SetTooltipText(locale.S(ctx, "Add User"))
+
+	addUser := func() {
+		userID := matrix.UserID(strings.TrimSpace(entry.Text()))
+		if userID == "" {
+			return
+		}
+		if _, _, err := userID.Parse(); err != nil {
+			app.Error(ctx, errors.Errorf("invalid user ID %q", userID))
+			return
+		}
+		if _, ok := u.spins[userID]; ok {
+			return
+		}
+
+		u.add(userID, u.defaultLevel)
+		entry.SetText("")
+	}
+
+	entry.ConnectActivate(addUser)
+	add.ConnectClicked(addUser)
+
+	addBox := gtk.NewBox(gtk.OrientationHorizontal, 0)
+	addBox.AddCSSClass("linked")
+	addBox.Append(entry)
+	addBox.Append(add)
+	addBox.SetSensitive(editable)
+
+	u.Box = gtk.NewBox(gtk.OrientationVertical, 4)
+	u.Box.Append(u.grid)
+	u.Box.Append(addBox)
+
+	return &u
+}
+
+func (u *userLevels) add(userID matrix.UserID, level int) {
+	client := gotktrix.FromContext(u.ctx).Offline()
+
+	name := string(userID)
+	if member, err := client.MemberName(u.roomID, userID, false); err == nil {
+		name = member.Name
+	}
+
+	spin := u.grid.add(name, level)
+	spin.SetTooltipText(string(userID))
+	u.spins[userID] = spin
+}
+
+// apply sets the levels that the user changed into the given power levels of
+// users. Added users whose levels are the default are omitted.
+func (u *userLevels) apply(levels map[matrix.UserID]int) {
+	for userID, spin := range u.spins {
+		level, changed := spin.changed()
+		if u.explicit[userID] {
+			if changed {
+				levels[userID] = level
+			}
+			continue
+		}
+
+		if level != u.defaultLevel {
+			levels[userID] = level
+		}
+	}
+}
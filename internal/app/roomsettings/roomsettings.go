@@ -0,0 +1,65 @@
+// Package roomsettings provides the window for changing the settings of a room.
+// Each setting can only be changed if the user has enough power in the room to
+// send the state event behind it.
+package roomsettings
+
+import (
+	"context"
+
+	"github.com/diamondburned/gotk4/pkg/gtk/v4"
+	"github.com/diamondburned/gotkit/app"
+	"github.com/diamondburned/gotkit/app/locale"
+	"github.com/diamondburned/gotkit/gtkutil/cssutil"
+	"github.com/diamondburned/gotktrix/internal/gotktrix"
+	"github.com/diamondburned/gotrix/matrix"
+)
+
+var settingsCSS = cssutil.Applier("roomsettings", `
+	.roomsettings > stackswitcher {
+		margin: 6px;
+	}
+	.roomsettings-page {
+		margin: 6px 12px 12px 12px;
+	}
+`)
+
+// Show shows the settings window of the given room.
+func Show(ctx context.Context, roomID matrix.RoomID) {
+	client := gotktrix.FromContext(ctx).Offline()
+	name, _ := client.RoomName(roomID)
+
+	stack := gtk.NewStack()
+	stack.SetTransitionType(gtk.StackTransitionTypeCrossfade)
+	stack.SetVExpand(true)
+
+	addPage := func(name, title string, p *page) {
+		scroll := gtk.NewScrolledWindow()
+		scroll.SetPolicy(gtk.PolicyNever, gtk.PolicyAutomatic)
+		scroll.SetChild(p)
+
+		stack.AddTitled(scroll, name, title)
+	}
+
+	addPage("general", locale.S(ctx, "General"), newGeneralPage(ctx, roomID))
+	addPage("access", locale.S(ctx, "Access"), newAccessPage(ctx, roomID))
+	addPage("addresses", locale.S(ctx, "Addresses"), newAddressesPage(ctx, roomID))
+	addPage("permissions", locale.S(ctx, "Permissions"), newPermissionsPage(ctx, roomID))
+
+	switcher := gtk.NewStackSwitcher()
+	switcher.SetStack(stack)
+	switcher.SetHAlign(gtk.AlignCenter)
+
+	box := gtk.NewBox(gtk.OrientationVertical, 0)
+	box.Append(switcher)
+	box.Append(stack)
+	settingsCSS(box)
+
+	dialog := gtk.NewDialog()
+	dialog.SetTransientFor(app.GTKWindowFromContext(ctx))
+	dialog.SetDefaultSize(450, 550)
+	dialog.SetChild(box)
+	dialog.SetTitle(app.FromContext(ctx).SuffixedTitle(
+		locale.Sprintf(ctx, "%s Settings", name),
+	))
+	dialog.Show()
+}
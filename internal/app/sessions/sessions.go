@@ -0,0 +1,261 @@
+// Package sessions provides a dialog for managing the devices that the user is
+// logged in on.
+package sessions
+
+import (
+	"context"
+
+	"github.com/diamondburned/gotk4/pkg/core/glib"
+	"github.com/diamondburned/gotk4/pkg/gtk/v4"
+	"github.com/diamondburned/gotk4/pkg/pango"
+	"github.com/diamondburned/gotkit/app"
+	"github.com/diamondburned/gotkit/app/locale"
+	"github.com/diamondburned/gotkit/gtkutil/cssutil"
+	"github.com/diamondburned/gotktrix/internal/app/uiauth"
+	"github.com/diamondburned/gotktrix/internal/gotktrix"
+	"github.com/pkg/errors"
+)
+
+var sessionsCSS = cssutil.Applier("sessions", `
+	.sessions {
+		margin: 12px;
+	}
+	.sessions-row {
+		padding: 6px;
+	}
+	.sessions-name {
+		font-weight: bold;
+	}
+	.sessions-details {
+		font-size: 0.9em;
+	}
+`)
+
+// Show shows the dialog listing the user's sessions.
+func Show(ctx context.Context) {
+	d := dialog{ctx: ctx}
+
+	d.list = gtk.NewListBox()
+	d.list.SetSelectionMode(gtk.SelectionNone)
+	d.list.SetPlaceholder(gtk.NewLabel(locale.S(ctx, "Loading sessions...")))
+
+	scroll := gtk.NewScrolledWindow()
+	scroll.SetVExpand(true)
+	scroll.SetPolicy(gtk.PolicyNever, gtk.PolicyAutomatic)
+	scroll.SetChild(d.list)
+
+	d.box = gtk.NewBox(gtk.OrientationVertical, 0)
+	d.box.Append(scroll)
+	sessionsCSS(d.box)
+
+	d.Dialog = gtk.NewDialogWithFlags(
+		app.FromContext(ctx).SuffixedTitle(locale.S(ctx, "Sessions")),
+		app.GTKWindowFromContext(ctx),
+		gtk.DialogDestroyWithParent|gtk.DialogUseHeaderBar,
+	)
+	d.SetDefaultSize(450, 400)
+	d.ContentArea().Append(d.box)
+	d.Show()
+
+	d.update()
+}
+
+type dialog struct {
+	*gtk.Dialog
+	ctx  context.Context
+	box  *gtk.Box
+	list *gtk.ListBox
+	rows []*gtk.ListBoxRow
+}
+
+// do runs f in a goroutine while the dialog is insensitive. The sessions are
+// reloaded afterwards.
+func (d *dialog) do(f func(*gotktrix.Client) error) {
+	client := gotktrix.FromContext(d.ctx)
+	d.box.SetSensitive(false)
+
+	go func() {
+		err := f(client)
+		glib.IdleAdd(func() {
+			d.box.SetSensitive(true)
+			if err != nil {
+				app.Error(d.ctx, err)
+			}
+			d.update()
+		})
+	}()
+}
+
+func (d *dialog) update() {
+	client := gotktrix.FromContext(d.ctx)
+
+	go func() {
+		devices, err := client.Devices()
+		glib.IdleAdd(func() {
+			if err != nil {
+				app.Error(d.ctx, err)
+				return
+			}
+			d.setDevices(devices)
+		})
+	}()
+}
+
+func (d *dialog) setDevices(devices []gotktrix.Device) {
+	for _, row := range d.rows {
+		d.list.Remove(row)
+	}
+	d.rows = d.rows[:0]
+
+	d.list.SetPlaceholder(gtk.NewLabel(locale.S(d.ctx, "No sessions.")))
+
+	currentID := gotktrix.FromContext(d.ctx).DeviceID
+
+	for _, device := range devices {
+		row := d.newRow(device, device.ID == currentID)
+		d.list.Append(row)
+		d.rows = append(d.rows, row)
+	}
+}
+
+func (d *dialog) newRow(device gotktrix.Device, current bool) *gtk.ListBoxRow {
+	name := device.DisplayName
+	if name == "" {
+		name = string(device.ID)
+	}
+	if current {
+		name = locale.Sprintf(d.ctx, "%s (this session)", name)
+	}
+
+	nameLabel := gtk.NewLabel(name)
+	nameLabel.AddCSSClass("sessions-name")
+	nameLabel.SetXAlign(0)
+	nameLabel.SetEllipsize(pango.EllipsizeEnd)
+
+	details := string(device.ID)
+	if device.LastSeenIP != "" {
+		details += " · " + device.LastSeenIP
+	}
+	if lastSeen := device.LastSeen(); !lastSeen.IsZero() {
+		details += " · " + locale.Sprintf(d.ctx, "last seen %s", locale.TimeAgo(d.ctx, lastSeen))
+	}
+
+	detailsLabel := gtk.NewLabel(details)
+	detailsLabel.AddCSSClass("sessions-details")
+	detailsLabel.SetXAlign(0)
+	detailsLabel.SetEllipsize(pango.EllipsizeMiddle)
+	detailsLabel.SetSelectable(true)
+
+	labels := gtk.NewBox(gtk.OrientationVertical, 0)
+	labels.SetHExpand(true)
+	labels.Append(nameLabel)
+	labels.Append(detailsLabel)
+
+	rename := gtk.NewButtonFromIconName("document-edit-symbolic")
+	rename.SetTooltipText(locale.S(d.ctx, "Rename"))
+	rename.SetVAlign(gtk.AlignCenter)
+	rename.ConnectClicked(func() { d.rename(device) })
+
+	box := gtk.NewBox(gtk.OrientationHorizontal, 6)
+	box.AddCSSClass("sessions-row")
+	box.Append(labels)
+	box.Append(rename)
+
+	// The current session is logged out using Log Out instead, which also
+	// deletes the local data.
+	if !current {
+		remove := gtk.NewButtonFromIconName("user-trash-symbolic")
+		remove.SetTooltipText(locale.S(d.ctx, "Sign Out"))
+		remove.SetVAlign(gtk.AlignCenter)
+		remove.AddCSSClass("destructive-action")
+		remove.ConnectClicked(func() { d.delete(device) })
+		box.Append(remove)
+	}
+
+	row := gtk.NewListBoxRow()
+	row.SetActivatable(false)
+	row.SetChild(box)
+
+	return row
+}
+
+func (d *dialog) rename(device gotktrix.Device) {
+	entry := gtk.NewEntry()
+	entry.SetText(device.DisplayName)
+	entry.SetPlaceholderText(string(device.ID))
+
+	prompt := d.newPrompt(locale.S(d.ctx, "Rename Session"), locale.S(d.ctx, "Rename"), entry)
+	prompt.ConnectResponse(func(id int) {
+		defer prompt.Close()
+
+		if id != int(gtk.ResponseAccept) {
+			return
+		}
+
+		name := entry.Text()
+		d.do(func(client *gotktrix.Client) error {
+			return client.RenameDevice(device.ID, name)
+		})
+	})
+	entry.ConnectActivate(func() { prompt.Response(int(gtk.ResponseAccept)) })
+
+	prompt.Show()
+}
+
+func (d *dialog) delete(device gotktrix.Device) {
+	name := device.DisplayName
+	if name == "" {
+		name = string(device.ID)
+	}
+
+	label := gtk.NewLabel(locale.Sprintf(d.ctx,
+		"Sign out of %s? The session will need to log in again.", name))
+	label.SetWrap(true)
+
+	prompt := d.newPrompt(locale.S(d.ctx, "Sign Out"), locale.S(d.ctx, "Sign Out"), label)
+	prompt.ConnectResponse(func(id int) {
+		defer prompt.Close()
+
+		if id != int(gtk.ResponseAccept) {
+			return
+		}
+
+		d.box.SetSensitive(false)
+
+		client := gotktrix.FromContext(d.ctx)
+		go func() {
+			ia, err := client.DeleteDevice(device.ID)
+			glib.IdleAdd(func() {
+				d.box.SetSensitive(true)
+				switch {
+				case err != nil:
+					app.Error(d.ctx, errors.Wrap(err, "failed to sign out session"))
+				case ia != nil:
+					uiauth.Show(d.ctx, locale.S(d.ctx, "Sign Out"), ia, d.update)
+				default:
+					d.update()
+				}
+			})
+		}()
+	})
+
+	prompt.Show()
+}
+
+func (d *dialog) newPrompt(title, accept string, child gtk.Widgetter) *gtk.Dialog {
+	prompt := gtk.NewDialogWithFlags(title, &d.Window, gtk.DialogModal|gtk.DialogDestroyWithParent)
+	prompt.SetDefaultSize(300, -1)
+	prompt.AddButton(locale.S(d.ctx, "Cancel"), int(gtk.ResponseCancel))
+	prompt.AddButton(accept, int(gtk.ResponseAccept))
+	prompt.SetDefaultResponse(int(gtk.ResponseAccept))
+
+	content := prompt.ContentArea()
+	content.SetSpacing(6)
+	content.SetMarginTop(12)
+	content.SetMarginBottom(12)
+	content.SetMarginStart(12)
+	content.SetMarginEnd(12)
+	content.Append(child)
+
+	return prompt
+}
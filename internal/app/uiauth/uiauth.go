@@ -0,0 +1,320 @@
+// Package uiauth goes through user-interactive authentications using the steps
+// of an assistant. Each stage that needs the user is shown as its own step.
+package uiauth
+
+import (
+	"context"
+
+	"github.com/diamondburned/gotk4/pkg/core/glib"
+	"github.com/diamondburned/gotk4/pkg/gtk/v4"
+	"github.com/diamondburned/gotk4/pkg/pango"
+	"github.com/diamondburned/gotkit/app"
+	"github.com/diamondburned/gotkit/app/locale"
+	"github.com/diamondburned/gotkit/gtkutil/cssutil"
+	"github.com/diamondburned/gotkit/gtkutil/textutil"
+	"github.com/diamondburned/gotktrix/internal/components/assistant"
+	"github.com/diamondburned/gotktrix/internal/gotktrix"
+	"github.com/diamondburned/gotrix/matrix"
+	"github.com/pkg/errors"
+)
+
+var stepCSS = cssutil.Applier("uiauth-step", `
+	.uiauth-step > label.uiauth-error {
+		padding-top: 4px;
+	}
+`)
+
+// Show shows a new assistant dialog that goes through the stages of ia. The
+// dialog is closed and done is called once ia is done.
+func Show(ctx context.Context, title string, ia *gotktrix.InteractiveAuth, done func()) {
+	ass := assistant.New(app.GTKWindowFromContext(ctx), nil)
+	ass.SetTitle(title)
+	ass.Show()
+
+	Run(ctx, ass, ia, func() {
+		ass.Close()
+		done()
+	})
+}
+
+// Run goes through the stages of ia in the given assistant. A step is added
+// for each stage, and done is called once ia is done. The assistant is left
+// busy when done is called.
+func Run(ctx context.Context, ass *assistant.Assistant, ia *gotktrix.InteractiveAuth, done func()) {
+	r := runner{
+		ctx:  ctx,
+		ass:  ass,
+		ia:   ia,
+		done: done,
+	}
+	r.next()
+}
+
+type runner struct {
+	ctx  context.Context
+	ass  *assistant.Assistant
+	ia   *gotktrix.InteractiveAuth
+	done func()
+
+	// stage is the stage that is currently shown.
+	stage matrix.LoginMethod
+	// errLabel is the error label of the current step. It's nil until the
+	// first step is added.
+	errLabel *gtk.Label
+}
+
+// next shows the next stage, or calls done if there are none left.
+func (r *runner) next() {
+	if r.ia.Done() {
+		r.done()
+		return
+	}
+
+	stage, ok := r.ia.NextStage()
+	if !ok {
+		r.fail(errors.New(locale.S(r.ctx, "The homeserver asks for authentication that isn't supported.")))
+		return
+	}
+
+	if stage == r.stage {
+		// The homeserver didn't accept the stage, so stay on it.
+		if r.ia.Error != "" {
+			r.fail(errors.New(r.ia.Error))
+		} else {
+			r.fail(errors.New(locale.S(r.ctx, "This step isn't completed yet.")))
+		}
+		return
+	}
+
+	r.stage = stage
+
+	switch stage {
+	case matrix.LoginDummy:
+		r.submit(r.ia.AuthDummy)
+	case matrix.LoginPassword:
+		r.addStep(r.passwordStep())
+	case matrix.LoginEmail:
+		if r.ia.CanVerifyEmail() {
+			r.addStep(r.emailStep())
+		} else {
+			r.addStep(r.fallbackStep(stage))
+		}
+	case gotktrix.LoginTerms:
+		r.addStep(r.termsStep())
+	default:
+		// This includes m.login.recaptcha, which needs a browser anyway.
+		r.addStep(r.fallbackStep(stage))
+	}
+}
+
+// submit calls f in a goroutine while the assistant is busy, then goes on to
+// the next stage.
+func (r *runner) submit(f func() error) {
+	// The assistant can't be busy without a step.
+	if r.errLabel != nil {
+		r.ass.Busy()
+	}
+
+	go func() {
+		err := f()
+		glib.IdleAdd(func() {
+			if err != nil {
+				r.fail(err)
+				return
+			}
+			r.next()
+		})
+	}()
+}
+
+// fail shows the error on the current step.
+func (r *runner) fail(err error) {
+	if r.errLabel == nil {
+		app.Error(r.ctx, err)
+		return
+	}
+
+	r.errLabel.SetMarkup(textutil.ErrorMarkup(err.Error()))
+	r.errLabel.Show()
+	r.ass.Continue()
+}
+
+func (r *runner) addStep(step *assistant.Step) {
+	r.ass.AddStep(step)
+	r.ass.SetStep(step)
+}
+
+// newStep creates a step with the given description.
+func (r *runner) newStep(title, okLabel, description string) (*assistant.Step, *gtk.Box) {
+	desc := gtk.NewLabel(description)
+	desc.SetWrap(true)
+	desc.SetWrapMode(pango.WrapWordChar)
+	desc.SetXAlign(0)
+
+	errLabel := textutil.ErrorLabel("")
+	errLabel.AddCSSClass("uiauth-error")
+	errLabel.Hide()
+
+	step := assistant.NewStep(title, okLabel)
+	// Completed stages can't be undone, but going back before the first one
+	// just starts over.
+	step.CanBack = len(r.ia.Completed) == 0
+	step.SwitchedTo = func(*assistant.Step) { r.errLabel = errLabel }
+
+	// The stage's widgets go into body, so that the error label stays at the
+	// bottom.
+	body := gtk.NewBox(gtk.OrientationVertical, 6)
+	body.Append(desc)
+
+	content := step.ContentArea()
+	content.SetOrientation(gtk.OrientationVertical)
+	content.SetSizeRequest(250, -1)
+	content.Append(body)
+	content.Append(errLabel)
+	stepCSS(content)
+
+	return step, body
+}
+
+func (r *runner) activateOK() {
+	r.ass.OKButton().Activate()
+}
+
+func (r *runner) passwordStep() *assistant.Step {
+	step, content := r.newStep(
+		locale.S(r.ctx, "Password"), locale.S(r.ctx, "Continue"),
+		locale.S(r.ctx, "Enter your password to continue."),
+	)
+
+	entry := gtk.NewPasswordEntry()
+	entry.SetShowPeekIcon(true)
+	entry.ConnectActivate(r.activateOK)
+	content.Append(entry)
+
+	step.Done = func(*assistant.Step) {
+		password := entry.Text()
+		r.submit(func() error { return r.ia.AuthPassword(password) })
+	}
+
+	return step
+}
+
+func (r *runner) emailStep() *assistant.Step {
+	step, content := r.newStep(
+		locale.S(r.ctx, "Email"), locale.S(r.ctx, "Send"),
+		locale.S(r.ctx, "Enter your email address. A link will be sent to it to verify that it's yours."),
+	)
+
+	entry := gtk.NewEntry()
+	entry.SetInputPurpose(gtk.InputPurposeEmail)
+	entry.ConnectActivate(r.activateOK)
+	content.Append(entry)
+
+	step.Done = func(*assistant.Step) {
+		address := entry.Text()
+		r.ass.Busy()
+
+		go func() {
+			err := r.ia.RequestEmailToken(address)
+			glib.IdleAdd(func() {
+				if err != nil {
+					r.fail(err)
+					return
+				}
+				r.addStep(r.emailVerifyStep(address))
+			})
+		}()
+	}
+
+	return step
+}
+
+func (r *runner) emailVerifyStep(address string) *assistant.Step {
+	step, content := r.newStep(
+		locale.S(r.ctx, "Verify Email"), locale.S(r.ctx, "Continue"),
+		locale.Sprintf(r.ctx, "An email was sent to %s. Open the link in it, then press Continue.", address),
+	)
+	// Allow going back to fix the address.
+	step.CanBack = true
+
+	resend := gtk.NewButtonWithLabel(locale.S(r.ctx, "Resend Email"))
+	resend.SetHAlign(gtk.AlignStart)
+	resend.ConnectClicked(func() {
+		r.ass.Busy()
+
+		go func() {
+			err := r.ia.RequestEmailToken(address)
+			glib.IdleAdd(func() {
+				if err != nil {
+					r.fail(err)
+					return
+				}
+				r.ass.Continue()
+			})
+		}()
+	})
+	content.Append(resend)
+
+	step.Done = func(*assistant.Step) {
+		r.submit(r.ia.AuthEmail)
+	}
+
+	return step
+}
+
+func (r *runner) termsStep() *assistant.Step {
+	step, content := r.newStep(
+		locale.S(r.ctx, "Terms"), locale.S(r.ctx, "Accept"),
+		locale.S(r.ctx, "The homeserver asks you to read and accept the following:"),
+	)
+
+	for _, policy := range r.ia.Policies() {
+		link := gtk.NewLinkButtonWithLabel(policy.URL, policy.Name)
+		link.SetHAlign(gtk.AlignStart)
+		if policy.URL == "" {
+			link.SetSensitive(false)
+		}
+		content.Append(link)
+	}
+
+	accept := gtk.NewCheckButtonWithLabel(locale.S(r.ctx, "I accept the terms above"))
+	content.Append(accept)
+
+	step.Done = func(*assistant.Step) {
+		if !accept.Active() {
+			r.fail(errors.New(locale.S(r.ctx, "You must accept the terms to continue.")))
+			return
+		}
+		r.submit(r.ia.AuthTerms)
+	}
+
+	return step
+}
+
+// fallbackStep asks the user to complete the stage in their browser.
+func (r *runner) fallbackStep(stage matrix.LoginMethod) *assistant.Step {
+	var title, description string
+
+	switch stage {
+	case matrix.LoginRecaptcha:
+		title = locale.S(r.ctx, "CAPTCHA")
+		description = locale.S(r.ctx,
+			"Prove that you're not a robot in your browser, then press Continue.")
+	default:
+		title = locale.S(r.ctx, "Verification")
+		description = locale.Sprintf(r.ctx,
+			"Complete the %s step in your browser, then press Continue.", stage)
+	}
+
+	step, content := r.newStep(title, locale.S(r.ctx, "Continue"), description)
+
+	open := gtk.NewLinkButtonWithLabel(r.ia.FallbackURL(stage), locale.S(r.ctx, "Open in Browser"))
+	open.SetHAlign(gtk.AlignStart)
+	content.Append(open)
+
+	step.Done = func(*assistant.Step) {
+		r.submit(r.ia.AuthFallback)
+	}
+
+	return step
+}
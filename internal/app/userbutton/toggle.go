@@ -2,6 +2,7 @@ package userbutton
 
 import (
 	"context"
+	"strconv"
 
 	"github.com/diamondburned/gotk4/pkg/gtk/v4"
 	"github.com/diamondburned/gotkit/components/onlineimage"
@@ -17,6 +18,7 @@ type Toggle struct {
 	MenuItems []gtkutil.PopoverMenuItem
 
 	avatar *onlineimage.Avatar
+	badge  *gtk.Label
 	ctx    context.Context
 
 	menuFn    func() []gtkutil.PopoverMenuItem
@@ -33,6 +35,15 @@ var toggleCSS = cssutil.Applier("userbutton-toggle", `
 	.userbutton-toggle:checked {
 		background-color: @theme_selected_bg_color;
 	}
+	.userbutton-badge {
+		font-size: 0.7em;
+		font-weight: bold;
+		min-width: 1.4em;
+		padding: 0 2px;
+		border-radius: 999px;
+		color: @theme_selected_fg_color;
+		background-color: @theme_selected_bg_color;
+	}
 `)
 
 // NewToggle creates a new Toggle instance. It takes parameters similar to
@@ -45,8 +56,18 @@ func NewToggle(ctx context.Context) *Toggle {
 	t.avatar = onlineimage.NewAvatar(ctx, gotktrix.AvatarProvider, 32)
 	t.avatar.SetInitials(username)
 
+	t.badge = gtk.NewLabel("")
+	t.badge.AddCSSClass("userbutton-badge")
+	t.badge.SetHAlign(gtk.AlignEnd)
+	t.badge.SetVAlign(gtk.AlignStart)
+	t.badge.SetVisible(false)
+
+	overlay := gtk.NewOverlay()
+	overlay.SetChild(t.avatar)
+	overlay.AddOverlay(t.badge)
+
 	t.ToggleButton = gtk.NewToggleButton()
-	t.SetChild(t.avatar)
+	t.SetChild(overlay)
 	t.ConnectClicked(func() {
 		if t.menuFn == nil {
 			t.SetActive(false)
@@ -93,3 +114,14 @@ func (t *Toggle) SetMenuFunc(f func() []gtkutil.PopoverMenuItem) {
 func (t *Toggle) SetPopoverFunc(f func(*gtk.PopoverMenu)) {
 	t.popoverFn = f
 }
+
+// SetBadge sets the number shown over the avatar. The badge is hidden if n is
+// 0.
+func (t *Toggle) SetBadge(n int) {
+	t.badge.SetVisible(n > 0)
+	if n > 99 {
+		t.badge.SetText("99+")
+	} else {
+		t.badge.SetText(strconv.Itoa(n))
+	}
+}
@@ -0,0 +1,162 @@
+package userview
+
+import (
+	"context"
+	"strings"
+
+	"github.com/diamondburned/gotk4/pkg/core/glib"
+	"github.com/diamondburned/gotk4/pkg/gtk/v4"
+	"github.com/diamondburned/gotk4/pkg/pango"
+	"github.com/diamondburned/gotkit/app"
+	"github.com/diamondburned/gotkit/app/locale"
+	"github.com/diamondburned/gotkit/gtkutil"
+	"github.com/diamondburned/gotkit/gtkutil/cssutil"
+	"github.com/diamondburned/gotktrix/internal/gotktrix"
+	"github.com/diamondburned/gotktrix/internal/gotktrix/events/m"
+	"github.com/diamondburned/gotrix/matrix"
+	"github.com/pkg/errors"
+)
+
+var ignoredCSS = cssutil.Applier("userview-ignored", `
+	.userview-ignored {
+		margin: 12px;
+	}
+	.userview-ignored > * {
+		margin-bottom: 6px;
+	}
+	.userview-ignored-description {
+		font-size: 0.9em;
+	}
+	.userview-ignored-row {
+		padding: 4px 6px;
+	}
+`)
+
+// ShowIgnored shows the dialog for managing the list of ignored users. The list
+// is saved per account on the homeserver, so the dialog is opened from the
+// account menu instead of the preferences, which are shared by all accounts.
+func ShowIgnored(ctx context.Context) {
+	client := gotktrix.FromContext(ctx)
+	_, server, _ := client.UserID.Parse()
+
+	description := gtk.NewLabel(locale.S(ctx,
+		"Messages and invites from ignored users are hidden. "+
+			"The list is saved on your homeserver."))
+	description.AddCSSClass("userview-ignored-description")
+	description.SetWrap(true)
+	description.SetXAlign(0)
+
+	entry := gtk.NewEntry()
+	entry.SetHExpand(true)
+	entry.SetPlaceholderText("@user:" + server)
+
+	add := gtk.NewButtonWithLabel(locale.S(ctx, "Ignore"))
+
+	addBox := gtk.NewBox(gtk.OrientationHorizontal, 6)
+	addBox.Append(entry)
+	addBox.Append(add)
+
+	list := gtk.NewListBox()
+	list.SetSelectionMode(gtk.SelectionNone)
+	list.SetPlaceholder(gtk.NewLabel(locale.S(ctx, "No one is ignored.")))
+
+	scroll := gtk.NewScrolledWindow()
+	scroll.SetVExpand(true)
+	scroll.SetPolicy(gtk.PolicyNever, gtk.PolicyAutomatic)
+	scroll.SetChild(list)
+
+	box := gtk.NewBox(gtk.OrientationVertical, 0)
+	box.Append(description)
+	box.Append(addBox)
+	box.Append(scroll)
+	ignoredCSS(box)
+
+	dialog := gtk.NewDialogWithFlags(
+		app.FromContext(ctx).SuffixedTitle(locale.S(ctx, "Ignored Users")),
+		app.GTKWindowFromContext(ctx),
+		gtk.DialogDestroyWithParent|gtk.DialogUseHeaderBar,
+	)
+	dialog.SetDefaultSize(400, 400)
+	dialog.ContentArea().Append(box)
+
+	var rows []*gtk.ListBoxRow
+
+	var update func()
+	do := func(f func(*gotktrix.Client) error) {
+		box.SetSensitive(false)
+
+		go func() {
+			err := f(client)
+			glib.IdleAdd(func() {
+				box.SetSensitive(true)
+				if err != nil {
+					app.Error(ctx, err)
+					return
+				}
+				update()
+			})
+		}()
+	}
+
+	update = func() {
+		for _, row := range rows {
+			list.Remove(row)
+		}
+		rows = rows[:0]
+
+		for _, userID := range client.Offline().IgnoredUsers() {
+			userID := userID
+
+			label := gtk.NewLabel(string(userID))
+			label.SetHExpand(true)
+			label.SetXAlign(0)
+			label.SetEllipsize(pango.EllipsizeMiddle)
+			label.SetSelectable(true)
+
+			unignore := gtk.NewButtonWithLabel(locale.S(ctx, "Unignore"))
+			unignore.ConnectClicked(func() {
+				do(func(client *gotktrix.Client) error {
+					return errors.Wrap(client.UnignoreUser(userID), "failed to unignore user")
+				})
+			})
+
+			rowBox := gtk.NewBox(gtk.OrientationHorizontal, 6)
+			rowBox.AddCSSClass("userview-ignored-row")
+			rowBox.Append(label)
+			rowBox.Append(unignore)
+
+			row := gtk.NewListBoxRow()
+			row.SetActivatable(false)
+			row.SetChild(rowBox)
+
+			list.Append(row)
+			rows = append(rows, row)
+		}
+	}
+
+	submit := func() {
+		userID := matrix.UserID(strings.TrimSpace(entry.Text()))
+		if _, _, err := userID.Parse(); err != nil {
+			app.Error(ctx, errors.Errorf("invalid user ID %q", userID))
+			return
+		}
+
+		entry.SetText("")
+		do(func(client *gotktrix.Client) error {
+			return errors.Wrap(client.IgnoreUser(userID), "failed to ignore user")
+		})
+	}
+
+	add.ConnectClicked(submit)
+	entry.ConnectActivate(submit)
+
+	// Keep the list updated with changes made by other clients.
+	gtkutil.BindSubscribe(dialog, func() func() {
+		return client.SubscribeUser(m.IgnoredUserListEventType, func() {
+			glib.IdleAdd(update)
+		})
+	})
+
+	update()
+	dialog.Show()
+}
@@ -0,0 +1,455 @@
+// Package userview provides a popover showing the profile of a user.
+package userview
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/diamondburned/gotk4/pkg/gtk/v4"
+	"github.com/diamondburned/gotk4/pkg/pango"
+	"github.com/diamondburned/gotkit/app"
+	"github.com/diamondburned/gotkit/app/locale"
+	"github.com/diamondburned/gotkit/components/onlineimage"
+	"github.com/diamondburned/gotkit/gtkutil"
+	"github.com/diamondburned/gotkit/gtkutil/cssutil"
+	"github.com/diamondburned/gotktrix/internal/gotktrix"
+	"github.com/diamondburned/gotktrix/internal/gotktrix/events/pronouns"
+	"github.com/diamondburned/gotrix/matrix"
+	"github.com/pkg/errors"
+)
+
+// AvatarSize is the size of the avatar in the popover.
+const AvatarSize = 64
+
+// Controller is the controller that the popover uses for its actions. A
+// popover without a controller doesn't show the actions that need it.
+type Controller interface {
+	// OpenRoom opens the given room.
+	OpenRoom(matrix.RoomID)
+	// OpenJoinedRoom opens a room that the user has just joined.
+	OpenJoinedRoom(matrix.RoomID)
+	// MentionUser inserts a mention of the given user into the composer.
+	MentionUser(matrix.UserID)
+}
+
+type ctxKey uint
+
+const (
+	_ ctxKey = iota
+	controllerCtxKey
+)
+
+// WithController returns a new context with the given controller, which is
+// used by all popovers made using the context.
+func WithController(ctx context.Context, ctrl Controller) context.Context {
+	return context.WithValue(ctx, controllerCtxKey, ctrl)
+}
+
+func controllerFromContext(ctx context.Context) Controller {
+	ctrl, _ := ctx.Value(controllerCtxKey).(Controller)
+	return ctrl
+}
+
+// Bind makes clicking on the given widget show the profile of the given user.
+func Bind(ctx context.Context, w gtk.Widgetter, roomID matrix.RoomID, userID matrix.UserID) {
+	click := gtk.NewGestureClick()
+	click.SetButton(1)
+	click.ConnectReleased(func(nPress int, x, y float64) {
+		if nPress == 1 {
+			Popup(ctx, w, roomID, userID)
+		}
+	})
+
+	widget := gtk.BaseWidget(w)
+	widget.AddController(click)
+	widget.SetCursorFromName("pointer")
+}
+
+var popoverCSS = cssutil.Applier("userview", `
+	.userview {
+		padding: 6px;
+	}
+	.userview-name {
+		font-size: 1.2em;
+		font-weight: bold;
+	}
+	.userview-id,
+	.userview-details {
+		font-size: 0.9em;
+	}
+	.userview-id {
+		color: alpha(@theme_fg_color, 0.75);
+	}
+	.userview-rooms {
+		margin-top: 4px;
+	}
+	.userview-rooms list {
+		background: none;
+	}
+	.userview-rooms row {
+		padding: 2px 4px;
+	}
+	.userview-actions {
+		margin-top: 6px;
+	}
+`)
+
+// Popup shows the profile of the given user in a popover that points to the
+// given widget. The room ID is optional; if given, then the user's name, avatar
+// and power level in that room are shown.
+func Popup(ctx context.Context, parent gtk.Widgetter, roomID matrix.RoomID, userID matrix.UserID) *gtk.Popover {
+	p := newProfile(ctx, roomID, userID)
+
+	popover := gtk.NewPopover()
+	popover.SetParent(parent)
+	popover.SetPosition(gtk.PosBottom)
+	popover.SetAutohide(true)
+	popover.SetChild(p)
+	p.popover = popover
+
+	gtkutil.PopupFinally(popover)
+	p.load()
+
+	return popover
+}
+
+type profile struct {
+	*gtk.Box
+	popover *gtk.Popover
+
+	avatar   *onlineimage.Avatar
+	name     *gtk.Label
+	details  *gtk.Label
+	presence *gtk.Label
+	rooms    *gtk.Expander
+
+	ctx    context.Context
+	ctrl   Controller
+	roomID matrix.RoomID
+	userID matrix.UserID
+}
+
+func newProfile(ctx context.Context, roomID matrix.RoomID, userID matrix.UserID) *profile {
+	p := profile{
+		ctx:    ctx,
+		ctrl:   controllerFromContext(ctx),
+		roomID: roomID,
+		userID: userID,
+	}
+
+	client := gotktrix.FromContext(ctx).Offline()
+	self := userID == client.UserID
+
+	p.avatar = onlineimage.NewAvatar(ctx, gotktrix.AvatarProvider, AvatarSize)
+	p.avatar.SetInitials(string(userID))
+
+	p.name = gtk.NewLabel(string(userID))
+	p.name.AddCSSClass("userview-name")
+	p.name.SetXAlign(0)
+	p.name.SetWrap(true)
+	p.name.SetWrapMode(pango.WrapWordChar)
+	p.name.SetSelectable(true)
+
+	id := gtk.NewLabel(string(userID))
+	id.AddCSSClass("userview-id")
+	id.SetXAlign(0)
+	id.SetWrap(true)
+	id.SetWrapMode(pango.WrapWordChar)
+	id.SetSelectable(true)
+
+	p.details = gtk.NewLabel("")
+	p.details.AddCSSClass("userview-details")
+	p.details.SetXAlign(0)
+	p.details.SetWrap(true)
+	p.details.Hide()
+
+	p.presence = gtk.NewLabel("")
+	p.presence.AddCSSClass("userview-details")
+	p.presence.SetXAlign(0)
+	p.presence.SetWrap(true)
+
+	names := gtk.NewBox(gtk.OrientationVertical, 0)
+	names.SetVAlign(gtk.AlignCenter)
+	names.Append(p.name)
+	names.Append(id)
+
+	top := gtk.NewBox(gtk.OrientationHorizontal, 8)
+	top.Append(p.avatar)
+	top.Append(names)
+
+	p.rooms = gtk.NewExpander("")
+	p.rooms.AddCSSClass("userview-rooms")
+	p.rooms.Hide()
+
+	message := gtk.NewButtonWithLabel(locale.S(ctx, "Message"))
+	message.SetSensitive(!self && p.ctrl != nil)
+	message.ConnectClicked(p.startDirect)
+
+	mention := gtk.NewButtonWithLabel(locale.S(ctx, "Mention"))
+	mention.SetSensitive(p.ctrl != nil)
+	mention.ConnectClicked(func() {
+		p.popover.Popdown()
+		p.ctrl.MentionUser(p.userID)
+	})
+
+	ignored := client.IsIgnored(userID)
+
+	ignore := gtk.NewButtonWithLabel(locale.S(ctx, "Ignore"))
+	if ignored {
+		ignore.SetLabel(locale.S(ctx, "Unignore"))
+	} else {
+		ignore.AddCSSClass("destructive-action")
+	}
+	ignore.SetSensitive(!self)
+	ignore.ConnectClicked(func() { p.setIgnored(!ignored) })
+
+	copyID := gtk.NewButtonWithLabel(locale.S(ctx, "Copy ID"))
+	copyID.SetTooltipText(locale.S(ctx, "Copy the Matrix ID"))
+	copyID.ConnectClicked(func() {
+		p.Clipboard().SetText(string(p.userID))
+		p.popover.Popdown()
+	})
+
+	actions := gtk.NewBox(gtk.OrientationHorizontal, 4)
+	actions.AddCSSClass("userview-actions")
+	actions.SetHomogeneous(true)
+	actions.Append(message)
+	actions.Append(mention)
+	actions.Append(copyID)
+	actions.Append(ignore)
+
+	p.Box = gtk.NewBox(gtk.OrientationVertical, 4)
+	p.Box.SetSizeRequest(300, -1)
+	p.Box.Append(top)
+	p.Box.Append(p.details)
+	p.Box.Append(p.presence)
+	p.Box.Append(p.rooms)
+	p.Box.Append(actions)
+	popoverCSS(p)
+
+	return &p
+}
+
+// load loads everything known from the state, and then asynchronously fetches
+// what's missing.
+func (p *profile) load() {
+	client := gotktrix.FromContext(p.ctx).Offline()
+
+	if p.roomID != "" {
+		name, err := client.MemberName(p.roomID, p.userID, false)
+		if err == nil {
+			p.setName(name.Name)
+		}
+
+		mxc, _ := client.MemberAvatar(p.roomID, p.userID)
+		if mxc != nil {
+			p.avatar.SetFromURL(string(*mxc))
+		}
+	}
+
+	p.setDetails(client)
+	p.setPresence(client.UserPresence(p.userID), gotktrix.UserStatus{})
+
+	online := gotktrix.FromContext(p.ctx)
+	userID := p.userID
+	roomID := p.roomID
+
+	gtkutil.Async(p.ctx, func() func() {
+		var name *string
+		var mxc *matrix.URL
+
+		if roomID == "" {
+			// There's no room to take these from, so ask the homeserver.
+			name, _ = online.DisplayName(userID)
+			mxc, _ = online.AvatarURL(userID)
+		}
+
+		shared := sharedRooms(online, userID)
+
+		status, err := online.UserStatus(userID)
+		if err != nil {
+			// Presence may be disabled on the homeserver. The presence in the
+			// state is good enough.
+			status.Presence = online.UserPresence(userID)
+		}
+
+		return func() {
+			if name != nil && *name != "" {
+				p.setName(*name)
+			}
+			if mxc != nil {
+				p.avatar.SetFromURL(string(*mxc))
+			}
+			p.setPresence(status.Presence, status)
+			p.setSharedRooms(shared)
+		}
+	})
+}
+
+func (p *profile) setName(name string) {
+	p.name.SetText(name)
+	p.avatar.SetInitials(name)
+}
+
+// setDetails sets the pronouns of the user and their power level in the room.
+func (p *profile) setDetails(client *gotktrix.Client) {
+	var details []string
+
+	pronoun := pronouns.UserPronouns(client, p.roomID, p.userID).Pronoun()
+	if pronoun != "" {
+		details = append(details, locale.Sprintf(p.ctx, "Pronouns: %s", pronoun))
+	}
+
+	if p.roomID != "" {
+		levels, err := client.RoomPowerLevels(p.roomID)
+		if err == nil {
+			level := levels.UserLevel(p.userID)
+			details = append(details, powerLevelText(p.ctx, level))
+		}
+	}
+
+	p.details.SetText(strings.Join(details, "\n"))
+	p.details.SetVisible(len(details) > 0)
+}
+
+func powerLevelText(ctx context.Context, level int) string {
+	switch {
+	case level >= 100:
+		return locale.Sprintf(ctx, "Administrator (power level %d)", level)
+	case level >= 50:
+		return locale.Sprintf(ctx, "Moderator (power level %d)", level)
+	default:
+		return locale.Sprintf(ctx, "Power level %d", level)
+	}
+}
+
+func (p *profile) setPresence(presence matrix.Presence, status gotktrix.UserStatus) {
+	var text string
+
+	switch presence {
+	case matrix.PresenceOnline:
+		text = locale.S(p.ctx, "Online")
+	case matrix.PresenceIdle:
+		text = locale.S(p.ctx, "Away")
+	default:
+		text = locale.S(p.ctx, "Offline")
+	}
+
+	switch {
+	case status.CurrentlyActive:
+		text += " · " + locale.S(p.ctx, "active now")
+	case !status.LastActive.IsZero():
+		text += " · " + locale.Sprintf(p.ctx, "last active %s", locale.TimeAgo(p.ctx, status.LastActive))
+	}
+
+	if status.Message != "" {
+		text += "\n" + status.Message
+	}
+
+	p.presence.SetText(text)
+}
+
+type sharedRoom struct {
+	id   matrix.RoomID
+	name string
+}
+
+func sharedRooms(client *gotktrix.Client, userID matrix.UserID) []sharedRoom {
+	if userID == client.UserID {
+		return nil
+	}
+
+	roomIDs := client.SharedRooms(userID)
+	rooms := make([]sharedRoom, len(roomIDs))
+
+	for i, roomID := range roomIDs {
+		name, _ := client.Offline().RoomName(roomID)
+		rooms[i] = sharedRoom{roomID, name}
+	}
+
+	sort.SliceStable(rooms, func(i, j int) bool {
+		return strings.ToLower(rooms[i].name) < strings.ToLower(rooms[j].name)
+	})
+
+	return rooms
+}
+
+func (p *profile) setSharedRooms(rooms []sharedRoom) {
+	if len(rooms) == 0 {
+		p.rooms.Hide()
+		return
+	}
+
+	list := gtk.NewListBox()
+	list.SetSelectionMode(gtk.SelectionNone)
+	list.SetActivateOnSingleClick(true)
+
+	for _, room := range rooms {
+		label := gtk.NewLabel(room.name)
+		label.SetXAlign(0)
+		label.SetEllipsize(pango.EllipsizeEnd)
+		label.SetTooltipText(string(room.id))
+
+		row := gtk.NewListBoxRow()
+		row.SetChild(label)
+		row.SetActivatable(p.ctrl != nil)
+		list.Append(row)
+	}
+
+	list.ConnectRowActivated(func(row *gtk.ListBoxRow) {
+		p.popover.Popdown()
+		p.ctrl.OpenRoom(rooms[row.Index()].id)
+	})
+
+	scroll := gtk.NewScrolledWindow()
+	scroll.SetPolicy(gtk.PolicyNever, gtk.PolicyAutomatic)
+	scroll.SetPropagateNaturalHeight(true)
+	scroll.SetMaxContentHeight(150)
+	scroll.SetChild(list)
+
+	p.rooms.SetLabel(locale.Sprintf(p.ctx, "%d rooms in common", len(rooms)))
+	p.rooms.SetChild(scroll)
+	p.rooms.Show()
+}
+
+// startDirect opens the direct messaging room with the user, creating one if
+// there isn't one yet.
+func (p *profile) startDirect() {
+	p.popover.Popdown()
+
+	ctx := p.ctx
+	ctrl := p.ctrl
+	userID := p.userID
+	client := gotktrix.FromContext(ctx)
+
+	gtkutil.Async(ctx, func() func() {
+		roomID, err := client.StartDirect(userID)
+		if err != nil {
+			return func() { app.Error(ctx, err) }
+		}
+
+		return func() { ctrl.OpenJoinedRoom(roomID) }
+	})
+}
+
+// setIgnored ignores or unignores the user.
+func (p *profile) setIgnored(ignore bool) {
+	p.popover.Popdown()
+
+	ctx := p.ctx
+	userID := p.userID
+	client := gotktrix.FromContext(ctx)
+
+	go func() {
+		if ignore {
+			if err := client.IgnoreUser(userID); err != nil {
+				app.Error(ctx, errors.Wrap(err, "failed to ignore user"))
+			}
+		} else {
+			if err := client.UnignoreUser(userID); err != nil {
+				app.Error(ctx, errors.Wrap(err, "failed to unignore user"))
+			}
+		}
+	}()
+}
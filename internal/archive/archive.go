@@ -0,0 +1,222 @@
+// Package archive writes the history of a room into JSON, HTML or plain text
+// archives.
+package archive
+
+import (
+	"encoding/json"
+	"io"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/diamondburned/gotktrix/internal/gotktrix/events/m"
+	"github.com/diamondburned/gotrix/event"
+	"github.com/diamondburned/gotrix/matrix"
+	"github.com/pkg/errors"
+)
+
+// Format is the format of an archive.
+type Format string
+
+const (
+	// JSON is a dump of the raw events.
+	JSON Format = "json"
+	// HTML is a self-contained HTML transcript.
+	HTML Format = "html"
+	// Text is a plain text log.
+	Text Format = "txt"
+)
+
+// Formats is the list of all formats.
+var Formats = []Format{HTML, Text, JSON}
+
+// Ext returns the file extension of the format, including the dot.
+func (f Format) Ext() string {
+	return "." + string(f)
+}
+
+// Name returns the human-readable name of the format.
+func (f Format) Name() string {
+	switch f {
+	case JSON:
+		return "JSON"
+	case HTML:
+		return "HTML"
+	case Text:
+		return "Plain Text"
+	default:
+		return string(f)
+	}
+}
+
+// Room describes the archived room.
+type Room struct {
+	ID   matrix.RoomID
+	Name string
+	// Exported is the time that the archive was made.
+	Exported time.Time
+}
+
+// Resolver resolves what the archived events refer to.
+type Resolver interface {
+	// MemberName returns the display name of the given user.
+	MemberName(matrix.UserID) string
+	// MediaPath returns the path of the downloaded media relative to the
+	// archive, or an empty string if the media wasn't downloaded.
+	MediaPath(matrix.URL) string
+}
+
+// Write writes the given events, oldest first, as an archive in the given
+// format.
+func Write(w io.Writer, f Format, room Room, events []event.RoomEvent, r Resolver) error {
+	switch f {
+	case JSON:
+		return writeJSON(w, room, events)
+	case HTML:
+		return writeHTML(w, room, events, r)
+	case Text:
+		return writeText(w, room, events, r)
+	default:
+		return errors.Errorf("unknown archive format %q", f)
+	}
+}
+
+type partialRoomEvent struct {
+	event.RoomEventInfo
+	Content interface{} `json:"content"`
+}
+
+// rawEvent returns the raw JSON of the given event. Events without one, such
+// as ones made locally, are marshaled again.
+func rawEvent(ev event.RoomEvent) (json.RawMessage, error) {
+	if raw := ev.Info().Raw; raw != nil {
+		return json.RawMessage(raw), nil
+	}
+
+	return json.Marshal(partialRoomEvent{
+		RoomEventInfo: *ev.RoomInfo(),
+		Content:       ev,
+	})
+}
+
+func writeJSON(w io.Writer, room Room, events []event.RoomEvent) error {
+	dump := struct {
+		RoomID   matrix.RoomID     `json:"room_id"`
+		Name     string            `json:"name,omitempty"`
+		Exported int64             `json:"exported_ts"`
+		Events   []json.RawMessage `json:"events"`
+	}{
+		RoomID:   room.ID,
+		Name:     room.Name,
+		Exported: room.Exported.UnixMilli(),
+		Events:   make([]json.RawMessage, 0, len(events)),
+	}
+
+	for _, ev := range events {
+		raw, err := rawEvent(ev)
+		if err != nil {
+			return errors.Wrapf(err, "failed to marshal event %q", ev.RoomInfo().ID)
+		}
+		dump.Events = append(dump.Events, raw)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	if err := enc.Encode(dump); err != nil {
+		return errors.Wrap(err, "failed to write JSON")
+	}
+
+	return nil
+}
+
+// MediaDir returns the directory that the media of the archive at the given
+// path is downloaded into.
+func MediaDir(archivePath string) string {
+	return strings.TrimSuffix(archivePath, filepath.Ext(archivePath)) + "_files"
+}
+
+// MediaFileName returns the file name to download the given media into. The
+// name is derived from the media ID so that downloads can be resumed, and the
+// extension is taken from the given file name if any.
+func MediaFileName(u matrix.URL, name string) string {
+	mxc, err := url.Parse(string(u))
+	if err != nil || mxc.Scheme != "mxc" || mxc.Host == "" {
+		return ""
+	}
+
+	id := strings.Trim(mxc.Path, "/")
+	if id == "" || strings.ContainsAny(id, `/\`) {
+		return ""
+	}
+
+	file := safeName(mxc.Host) + "_" + safeName(id)
+	if ext := path.Ext(name); ext != "" && len(ext) <= 8 {
+		file += safeName(ext)
+	}
+
+	return file
+}
+
+func safeName(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		case r == '.', r == '-', r == '_':
+			return r
+		default:
+			return '_'
+		}
+	}, s)
+}
+
+// Media is a media file that is referenced by an event.
+type Media struct {
+	URL matrix.URL
+	// Name is the file name given by the event, if any.
+	Name string
+}
+
+// EventMedia returns the media that is referenced by the given event. Media
+// in encrypted attachments isn't included, since it can't be downloaded as-is.
+func EventMedia(ev event.RoomEvent) []Media {
+	var media []Media
+
+	switch ev := ev.(type) {
+	case *event.RoomMessageEvent:
+		if ev.URL != "" {
+			media = append(media, Media{URL: ev.URL, Name: ev.Body})
+		}
+		if ev.Format == event.FormatHTML {
+			for _, u := range htmlImages(ev.FormattedBody) {
+				media = append(media, Media{URL: u})
+			}
+		}
+	case *m.StickerEvent:
+		if ev.URL != "" {
+			media = append(media, Media{URL: ev.URL, Name: ev.Body})
+		}
+	}
+
+	return media
+}
+
+// memberName returns the name of the given user, falling back to the user ID.
+func memberName(r Resolver, userID matrix.UserID) string {
+	if r != nil {
+		if name := r.MemberName(userID); name != "" {
+			return name
+		}
+	}
+	return string(userID)
+}
+
+func mediaPath(r Resolver, u matrix.URL) string {
+	if r == nil {
+		return ""
+	}
+	return r.MediaPath(u)
+}
@@ -0,0 +1,201 @@
+package archive
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/diamondburned/gotktrix/internal/gotktrix/events/sys"
+	"github.com/diamondburned/gotrix/event"
+	"github.com/diamondburned/gotrix/matrix"
+)
+
+const testRoomID = matrix.RoomID("!room:example.com")
+
+type testResolver struct{}
+
+func (testResolver) MemberName(userID matrix.UserID) string {
+	if userID == "@alice:example.com" {
+		return "Alice"
+	}
+	return ""
+}
+
+func (testResolver) MediaPath(u matrix.URL) string {
+	if u == "mxc://example.com/cat" {
+		return "room_files/example.com_cat.png"
+	}
+	return ""
+}
+
+func testEvent(t *testing.T, id string, ts int64, content string) event.RoomEvent {
+	raw, err := json.Marshal(map[string]interface{}{
+		"type":             "m.room.message",
+		"event_id":         id,
+		"sender":           "@alice:example.com",
+		"origin_server_ts": ts,
+		"content":          json.RawMessage(content),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sys.ParseTimeline(raw, testRoomID)
+}
+
+func TestSanitizeHTML(t *testing.T) {
+	tests := []struct {
+		in, out string
+	}{
+		{
+			in:  `<b>hi</b> <script>alert(1)</script><i onclick="x()">there</i>`,
+			out: `<b>hi</b> <i>there</i>`,
+		},
+		{
+			in:  `<mx-reply><blockquote>quoted</blockquote></mx-reply>reply`,
+			out: `reply`,
+		},
+		{
+			in:  `<a href="javascript:alert(1)">bad</a> <a href="https://example.com">good</a>`,
+			out: `<a>bad</a> <a href="https://example.com">good</a>`,
+		},
+		{
+			in:  `<img src="mxc://example.com/cat" alt="cat"><img src="mxc://example.com/dog" alt="dog">`,
+			out: `<img src="room_files/example.com_cat.png" alt="cat">dog`,
+		},
+		{
+			in:  `<span data-mx-spoiler="">secret</span> <code class="language-go">x &lt; y</code>`,
+			out: `<span data-mx-spoiler="">secret</span> <code class="language-go">x &lt; y</code>`,
+		},
+	}
+
+	for _, test := range tests {
+		out, err := sanitizeHTML(test.in, testResolver{})
+		if err != nil {
+			t.Errorf("sanitizeHTML(%q) failed: %v", test.in, err)
+			continue
+		}
+		if out != test.out {
+			t.Errorf("sanitizeHTML(%q)\ngot      %q\nexpected %q", test.in, out, test.out)
+		}
+	}
+}
+
+func TestMediaFileName(t *testing.T) {
+	tests := []struct {
+		url  matrix.URL
+		name string
+		out  string
+	}{
+		{"mxc://example.com/abc123", "cat.png", "example.com_abc123.png"},
+		{"mxc://example.com/abc123", "no extension", "example.com_abc123"},
+		{"mxc://example.com/../etc", "", ""},
+		{"https://example.com/abc", "", ""},
+	}
+
+	for _, test := range tests {
+		if out := MediaFileName(test.url, test.name); out != test.out {
+			t.Errorf("MediaFileName(%q, %q) = %q, expected %q", test.url, test.name, out, test.out)
+		}
+	}
+}
+
+func TestCheckpoint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "room.html")
+
+	c, err := OpenCheckpoint(path, testRoomID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Pagination gives the newest events first.
+	newer := []event.RoomEvent{
+		testEvent(t, "$3", 3000, `{"msgtype":"m.text","body":"three"}`),
+		testEvent(t, "$4", 4000, `{"msgtype":"m.text","body":"four"}`),
+	}
+	if n, err := c.Add(newer); err != nil || n != 2 {
+		t.Fatalf("Add = (%d, %v), expected 2 new events", n, err)
+	}
+	c.Close()
+
+	// Simulate an export interrupted while writing an event.
+	f, err := os.OpenFile(CheckpointPath(path), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"type":"m.room.mess`)
+	f.Close()
+
+	c, err = OpenCheckpoint(path, testRoomID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if c.Len() != 2 || !c.Has("$3") {
+		t.Fatalf("resumed checkpoint has %d events, expected 2", c.Len())
+	}
+
+	older := []event.RoomEvent{
+		testEvent(t, "$1", 1000, `{"msgtype":"m.text","body":"one"}`),
+		testEvent(t, "$2", 2000, `{"msgtype":"m.emote","body":"waves"}`),
+		testEvent(t, "$3", 3000, `{"msgtype":"m.text","body":"three"}`),
+	}
+	if n, err := c.Add(older); err != nil || n != 2 {
+		t.Fatalf("Add = (%d, %v), expected 2 new events", n, err)
+	}
+
+	room := Room{ID: testRoomID, Name: "Room", Exported: time.Unix(0, 0).UTC()}
+
+	var buf bytes.Buffer
+	if err := Write(&buf, JSON, room, c.Events(), testResolver{}); err != nil {
+		t.Fatal(err)
+	}
+
+	var dump struct {
+		Events []struct {
+			ID matrix.EventID `json:"event_id"`
+		} `json:"events"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &dump); err != nil {
+		t.Fatal("invalid JSON archive:", err)
+	}
+
+	var ids []string
+	for _, ev := range dump.Events {
+		ids = append(ids, string(ev.ID))
+	}
+	if got := strings.Join(ids, " "); got != "$1 $2 $3 $4" {
+		t.Fatalf("archived events are %q, expected oldest first", got)
+	}
+}
+
+func TestWriteText(t *testing.T) {
+	events := []event.RoomEvent{
+		testEvent(t, "$1", 0, `{"msgtype":"m.text","body":"hello\nworld"}`),
+		testEvent(t, "$2", 0, `{"msgtype":"m.emote","body":"waves"}`),
+		testEvent(t, "$3", 0, `{"msgtype":"m.image","body":"cat.png","url":"mxc://example.com/cat"}`),
+	}
+
+	room := Room{ID: testRoomID, Exported: time.Unix(0, 0)}
+
+	var buf bytes.Buffer
+	if err := Write(&buf, Text, room, events, testResolver{}); err != nil {
+		t.Fatal(err)
+	}
+
+	ts := time.Unix(0, 0).Format("2006-01-02 15:04:05")
+	expect := strings.Join([]string{
+		"[" + ts + "] <Alice> hello",
+		"                      world",
+		"[" + ts + "] * Alice waves",
+		"[" + ts + "] <Alice> [cat.png] (room_files/example.com_cat.png)",
+	}, "\n")
+
+	if !strings.Contains(buf.String(), expect) {
+		t.Fatalf("unexpected text archive:\n%s", buf.String())
+	}
+}
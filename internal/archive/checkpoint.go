@@ -0,0 +1,179 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/diamondburned/gotktrix/internal/gotktrix/events/sys"
+	"github.com/diamondburned/gotrix/event"
+	"github.com/diamondburned/gotrix/matrix"
+	"github.com/pkg/errors"
+)
+
+// maxEventSize is the maximum size of an event, which is 65536 bytes per the
+// specification. Decrypted events may be slightly larger, so be generous.
+const maxEventSize = 1 << 20
+
+// Checkpoint keeps the events collected by an export in a file next to the
+// archive, so that an interrupted export can be resumed. Each line of the file
+// is a raw event.
+type Checkpoint struct {
+	f      *os.File
+	roomID matrix.RoomID
+	events []event.RoomEvent
+	seen   map[matrix.EventID]struct{}
+}
+
+// CheckpointPath returns the path of the checkpoint of the archive at the
+// given path.
+func CheckpointPath(archivePath string) string {
+	return archivePath + ".partial"
+}
+
+// OpenCheckpoint opens the checkpoint of the archive at the given path, or
+// creates a new one if there's none.
+func OpenCheckpoint(archivePath string, roomID matrix.RoomID) (*Checkpoint, error) {
+	f, err := os.OpenFile(CheckpointPath(archivePath), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open checkpoint")
+	}
+
+	c := &Checkpoint{
+		f:      f,
+		roomID: roomID,
+		seen:   make(map[matrix.EventID]struct{}),
+	}
+
+	if err := c.load(); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (c *Checkpoint) load() error {
+	r := bufio.NewReader(c.f)
+	var good int64
+
+	for {
+		line, err := r.ReadBytes('\n')
+		if err != nil {
+			if err != io.EOF {
+				return errors.Wrap(err, "failed to read checkpoint")
+			}
+			// A line without a new line was interrupted while being written.
+			break
+		}
+
+		raw := bytes.TrimSpace(line)
+		if !json.Valid(raw) {
+			break
+		}
+
+		c.add(sys.ParseTimeline(raw, c.roomID))
+		good += int64(len(line))
+	}
+
+	// Drop the broken tail, if any, so that new events are appended right
+	// after the last good one.
+	if err := c.f.Truncate(good); err != nil {
+		return errors.Wrap(err, "failed to truncate checkpoint")
+	}
+
+	if _, err := c.f.Seek(good, io.SeekStart); err != nil {
+		return errors.Wrap(err, "failed to seek checkpoint")
+	}
+
+	return nil
+}
+
+func (c *Checkpoint) add(ev event.RoomEvent) bool {
+	id := ev.RoomInfo().ID
+	if _, ok := c.seen[id]; ok {
+		return false
+	}
+
+	c.seen[id] = struct{}{}
+	c.events = append(c.events, ev)
+	return true
+}
+
+// Len returns the number of collected events.
+func (c *Checkpoint) Len() int {
+	return len(c.events)
+}
+
+// Has returns true if the event with the given ID is already collected.
+func (c *Checkpoint) Has(id matrix.EventID) bool {
+	_, ok := c.seen[id]
+	return ok
+}
+
+// Add collects the given events and saves them. Events that are already
+// collected are ignored. The number of new events is returned.
+func (c *Checkpoint) Add(events []event.RoomEvent) (int, error) {
+	var buf bytes.Buffer
+	var added int
+
+	for _, ev := range events {
+		if c.Has(ev.RoomInfo().ID) {
+			continue
+		}
+
+		raw, err := rawEvent(ev)
+		if err != nil {
+			return added, errors.Wrapf(err, "failed to marshal event %q", ev.RoomInfo().ID)
+		}
+
+		buf.Reset()
+		if err := json.Compact(&buf, raw); err != nil {
+			return added, errors.Wrapf(err, "invalid event %q", ev.RoomInfo().ID)
+		}
+		if buf.Len() > maxEventSize {
+			continue
+		}
+		buf.WriteByte('\n')
+
+		if _, err := c.f.Write(buf.Bytes()); err != nil {
+			return added, errors.Wrap(err, "failed to write checkpoint")
+		}
+
+		c.add(ev)
+		added++
+	}
+
+	return added, nil
+}
+
+// Events returns the collected events, oldest first.
+func (c *Checkpoint) Events() []event.RoomEvent {
+	events := make([]event.RoomEvent, len(c.events))
+	copy(events, c.events)
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].RoomInfo().OriginServerTime < events[j].RoomInfo().OriginServerTime
+	})
+
+	return events
+}
+
+// Close closes the checkpoint. The export can be resumed later.
+func (c *Checkpoint) Close() error {
+	return c.f.Close()
+}
+
+// Remove closes and deletes the checkpoint once the archive is written.
+func (c *Checkpoint) Remove() error {
+	c.f.Close()
+
+	if err := os.Remove(c.f.Name()); err != nil {
+		return errors.Wrap(err, "failed to remove checkpoint")
+	}
+
+	return nil
+}
@@ -0,0 +1,415 @@
+package archive
+
+import (
+	"bufio"
+	"fmt"
+	"html/template"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/diamondburned/gotktrix/internal/gotktrix/events/m"
+	"github.com/diamondburned/gotrix/event"
+	"github.com/diamondburned/gotrix/matrix"
+	"github.com/pkg/errors"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+var htmlHeader = template.Must(template.New("header").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Name}}</title>
+<style>
+	body {
+		max-width: 50em;
+		margin: 0 auto;
+		padding: 1em;
+		font-family: sans-serif;
+		line-height: 1.4;
+	}
+	header {
+		margin-bottom: 1em;
+		border-bottom: 1px solid #8888;
+	}
+	header p {
+		color: #888;
+		font-size: 0.85em;
+	}
+	.message {
+		margin: 0.5em 0;
+	}
+	.message > .author {
+		font-weight: bold;
+	}
+	.message > time {
+		color: #888;
+		font-size: 0.85em;
+		margin-left: 0.5em;
+	}
+	.message.notice > .content,
+	.message.unknown > .content {
+		color: #888;
+	}
+	.message.emote > .content {
+		font-style: italic;
+	}
+	.content img {
+		max-width: 100%;
+		max-height: 350px;
+	}
+	.content blockquote {
+		margin-left: 0;
+		padding-left: 0.5em;
+		border-left: 3px solid #8888;
+	}
+	.content pre {
+		overflow-x: auto;
+	}
+	span[data-mx-spoiler] {
+		background-color: currentColor;
+	}
+	span[data-mx-spoiler]:hover {
+		background-color: transparent;
+	}
+</style>
+</head>
+<body>
+<header>
+<h1>{{.Name}}</h1>
+<p>{{.ID}} &middot; exported {{.Exported.Format "2006-01-02 15:04"}}</p>
+</header>
+`))
+
+const htmlFooter = `</body>
+</html>
+`
+
+func writeHTML(w io.Writer, room Room, events []event.RoomEvent, r Resolver) error {
+	bw := bufio.NewWriter(w)
+
+	if room.Name == "" {
+		room.Name = string(room.ID)
+	}
+
+	if err := htmlHeader.Execute(bw, room); err != nil {
+		return errors.Wrap(err, "failed to write HTML header")
+	}
+
+	for _, ev := range events {
+		writeHTMLEvent(bw, ev, r)
+	}
+
+	bw.WriteString(htmlFooter)
+
+	if err := bw.Flush(); err != nil {
+		return errors.Wrap(err, "failed to write HTML")
+	}
+
+	return nil
+}
+
+func writeHTMLEvent(w *bufio.Writer, ev event.RoomEvent, r Resolver) {
+	var class string
+	var content string
+
+	switch ev := ev.(type) {
+	case *event.RoomMessageEvent:
+		class, content = htmlMessage(ev, r)
+	case *m.StickerEvent:
+		class = "sticker"
+		content = htmlMedia(ev.URL, ev.Body, true, r)
+	case *m.EncryptedEvent:
+		class = "unknown"
+		content = "Encrypted message."
+	default:
+		return
+	}
+
+	info := ev.RoomInfo()
+	ts := info.OriginServerTime.Time()
+
+	fmt.Fprintf(w,
+		`<div class="message %s" id="%s">`+
+			`<span class="author" title="%s">%s</span>`+
+			`<time datetime="%s">%s</time>`+
+			`<div class="content">%s</div></div>`+"\n",
+		class, html.EscapeString(string(info.ID)),
+		html.EscapeString(string(info.Sender)), html.EscapeString(memberName(r, info.Sender)),
+		ts.Format("2006-01-02T15:04:05Z07:00"), ts.Format("2006-01-02 15:04"),
+		content,
+	)
+}
+
+func htmlMessage(ev *event.RoomMessageEvent, r Resolver) (class, content string) {
+	switch ev.MessageType {
+	case event.RoomMessageImage:
+		return "image", htmlMedia(ev.URL, ev.Body, true, r)
+	case event.RoomMessageFile, event.RoomMessageAudio, event.RoomMessageVideo:
+		return "file", htmlMedia(ev.URL, ev.Body, false, r)
+	case event.RoomMessageEmote:
+		class = "emote"
+	case event.RoomMessageNotice:
+		class = "notice"
+	default:
+		class = "text"
+	}
+
+	if ev.Format == event.FormatHTML && ev.FormattedBody != "" {
+		if s, err := sanitizeHTML(ev.FormattedBody, r); err == nil {
+			return class, s
+		}
+	}
+
+	return class, textToHTML(ev.Body)
+}
+
+func htmlMedia(u matrix.URL, name string, image bool, r Resolver) string {
+	path := mediaPath(r, u)
+	if path == "" {
+		if u == "" {
+			// Encrypted attachments have no URL.
+			return textToHTML(name) + " <em>(encrypted, not downloaded)</em>"
+		}
+		return textToHTML(name) + " <em>(not downloaded)</em>"
+	}
+
+	href := html.EscapeString(pathURL(path))
+	if image {
+		return fmt.Sprintf(`<a href="%s"><img src="%[1]s" alt="%s"></a>`, href, html.EscapeString(name))
+	}
+	return fmt.Sprintf(`<a href="%s">%s</a>`, href, html.EscapeString(name))
+}
+
+// pathURL escapes the given relative file path for use in a URL.
+func pathURL(path string) string {
+	return (&url.URL{Path: path}).EscapedPath()
+}
+
+func textToHTML(text string) string {
+	return strings.ReplaceAll(html.EscapeString(text), "\n", "<br>")
+}
+
+// allowedTags is the list of tags that may be used in message HTML.
+// https://spec.matrix.org/v1.4/client-server-api/#mroommessage-msgtypes
+var allowedTags = map[string][]string{
+	"font":       {"data-mx-bg-color", "data-mx-color", "color"},
+	"span":       {"data-mx-bg-color", "data-mx-color", "data-mx-spoiler"},
+	"a":          {"href"},
+	"img":        {"width", "height", "alt", "title", "src"},
+	"ol":         {"start"},
+	"code":       {"class"},
+	"del":        nil,
+	"h1":         nil,
+	"h2":         nil,
+	"h3":         nil,
+	"h4":         nil,
+	"h5":         nil,
+	"h6":         nil,
+	"blockquote": nil,
+	"p":          nil,
+	"ul":         nil,
+	"sup":        nil,
+	"sub":        nil,
+	"li":         nil,
+	"b":          nil,
+	"i":          nil,
+	"u":          nil,
+	"strong":     nil,
+	"em":         nil,
+	"strike":     nil,
+	"hr":         nil,
+	"br":         nil,
+	"div":        nil,
+	"table":      nil,
+	"thead":      nil,
+	"tbody":      nil,
+	"tr":         nil,
+	"th":         nil,
+	"td":         nil,
+	"caption":    nil,
+	"pre":        nil,
+	"details":    nil,
+	"summary":    nil,
+}
+
+// droppedTags is the list of tags whose content is dropped entirely.
+var droppedTags = map[string]bool{
+	"mx-reply": true,
+	"script":   true,
+	"style":    true,
+}
+
+var allowedSchemes = map[string]bool{
+	"http":   true,
+	"https":  true,
+	"ftp":    true,
+	"mailto": true,
+	"magnet": true,
+}
+
+// sanitizeHTML keeps only the tags and attributes that are allowed in message
+// HTML, and points images to the downloaded media.
+func sanitizeHTML(body string, r Resolver) (string, error) {
+	nodes, err := html.ParseFragment(strings.NewReader(body), &html.Node{
+		Type:     html.ElementNode,
+		Data:     "div",
+		DataAtom: atom.Div,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	for _, n := range nodes {
+		sanitizeNode(&b, n, r)
+	}
+
+	return b.String(), nil
+}
+
+func sanitizeNode(b *strings.Builder, n *html.Node, r Resolver) {
+	switch n.Type {
+	case html.TextNode:
+		b.WriteString(html.EscapeString(n.Data))
+		return
+	case html.ElementNode:
+		// ok
+	default:
+		return
+	}
+
+	if droppedTags[n.Data] {
+		return
+	}
+
+	attrs, ok := allowedTags[n.Data]
+	if ok && n.Data == "img" {
+		src := attrValue(n, "src")
+		path := mediaPath(r, matrix.URL(src))
+		if path == "" {
+			// Only media that's downloaded may be shown, so that the archive
+			// doesn't make any requests. Show the alt text instead.
+			b.WriteString(html.EscapeString(attrValue(n, "alt")))
+			return
+		}
+		n.Attr = setAttr(n.Attr, "src", pathURL(path))
+	}
+
+	if ok {
+		b.WriteByte('<')
+		b.WriteString(n.Data)
+		for _, attr := range n.Attr {
+			if !allowedAttr(n.Data, attrs, attr) {
+				continue
+			}
+			b.WriteByte(' ')
+			b.WriteString(attr.Key)
+			b.WriteString(`="`)
+			b.WriteString(html.EscapeString(attr.Val))
+			b.WriteByte('"')
+		}
+		b.WriteByte('>')
+	}
+
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		sanitizeNode(b, c, r)
+	}
+
+	if ok && !isVoid(n.Data) {
+		b.WriteString("</")
+		b.WriteString(n.Data)
+		b.WriteByte('>')
+	}
+}
+
+func allowedAttr(tag string, attrs []string, attr html.Attribute) bool {
+	if attr.Namespace != "" {
+		return false
+	}
+
+	var found bool
+	for _, allowed := range attrs {
+		if attr.Key == allowed {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return false
+	}
+
+	switch {
+	case tag == "a" && attr.Key == "href":
+		u, err := url.Parse(attr.Val)
+		return err == nil && allowedSchemes[strings.ToLower(u.Scheme)]
+	case tag == "code" && attr.Key == "class":
+		return strings.HasPrefix(attr.Val, "language-")
+	}
+
+	return true
+}
+
+func isVoid(tag string) bool {
+	switch tag {
+	case "br", "hr", "img":
+		return true
+	default:
+		return false
+	}
+}
+
+func attrValue(n *html.Node, key string) string {
+	for _, attr := range n.Attr {
+		if attr.Key == key {
+			return attr.Val
+		}
+	}
+	return ""
+}
+
+func setAttr(attrs []html.Attribute, key, val string) []html.Attribute {
+	for i, attr := range attrs {
+		if attr.Key == key {
+			attrs[i].Val = val
+			return attrs
+		}
+	}
+	return append(attrs, html.Attribute{Key: key, Val: val})
+}
+
+// htmlImages returns the URLs of all images in the given message HTML.
+func htmlImages(body string) []matrix.URL {
+	if !strings.Contains(body, "<img") {
+		return nil
+	}
+
+	nodes, err := html.ParseFragment(strings.NewReader(body), &html.Node{
+		Type:     html.ElementNode,
+		Data:     "div",
+		DataAtom: atom.Div,
+	})
+	if err != nil {
+		return nil
+	}
+
+	var urls []matrix.URL
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.Data == "img" {
+			if src := attrValue(n, "src"); strings.HasPrefix(src, "mxc://") {
+				urls = append(urls, matrix.URL(src))
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+
+	for _, n := range nodes {
+		walk(n)
+	}
+
+	return urls
+}
@@ -0,0 +1,77 @@
+package archive
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/diamondburned/gotktrix/internal/gotktrix/events/m"
+	"github.com/diamondburned/gotrix/event"
+	"github.com/diamondburned/gotrix/matrix"
+	"github.com/pkg/errors"
+)
+
+func writeText(w io.Writer, room Room, events []event.RoomEvent, r Resolver) error {
+	bw := bufio.NewWriter(w)
+
+	if room.Name != "" {
+		fmt.Fprintf(bw, "# %s (%s)\n", room.Name, room.ID)
+	} else {
+		fmt.Fprintf(bw, "# %s\n", room.ID)
+	}
+	fmt.Fprintf(bw, "# Exported %s\n\n", room.Exported.Format("2006-01-02 15:04"))
+
+	for _, ev := range events {
+		writeTextEvent(bw, ev, r)
+	}
+
+	if err := bw.Flush(); err != nil {
+		return errors.Wrap(err, "failed to write text")
+	}
+
+	return nil
+}
+
+func writeTextEvent(w *bufio.Writer, ev event.RoomEvent, r Resolver) {
+	info := ev.RoomInfo()
+	name := memberName(r, info.Sender)
+
+	var line string
+
+	switch ev := ev.(type) {
+	case *event.RoomMessageEvent:
+		switch ev.MessageType {
+		case event.RoomMessageEmote:
+			line = "* " + name + " " + ev.Body
+		case event.RoomMessageNotice:
+			line = "-" + name + "- " + ev.Body
+		case event.RoomMessageImage, event.RoomMessageFile, event.RoomMessageAudio, event.RoomMessageVideo:
+			line = "<" + name + "> " + textMedia(ev.URL, ev.Body, r)
+		default:
+			line = "<" + name + "> " + ev.Body
+		}
+	case *m.StickerEvent:
+		line = "<" + name + "> " + textMedia(ev.URL, ev.Body, r)
+	case *m.EncryptedEvent:
+		line = "<" + name + "> (encrypted message)"
+	default:
+		return
+	}
+
+	ts := info.OriginServerTime.Time().Format("2006-01-02 15:04:05")
+
+	// Indent the following lines of multi-line messages to line up with the
+	// first one.
+	indent := strings.Repeat(" ", len(ts)+3)
+	line = strings.ReplaceAll(line, "\n", "\n"+indent)
+
+	fmt.Fprintf(w, "[%s] %s\n", ts, line)
+}
+
+func textMedia(u matrix.URL, name string, r Resolver) string {
+	if path := mediaPath(r, u); path != "" {
+		return fmt.Sprintf("[%s] (%s)", name, path)
+	}
+	return fmt.Sprintf("[%s] (not downloaded)", name)
+}
@@ -2,10 +2,13 @@ package gotktrix
 
 import (
 	"context"
+	"encoding/json"
 
 	"github.com/diamondburned/gotrix"
 	"github.com/diamondburned/gotrix/api"
+	"github.com/diamondburned/gotrix/api/httputil"
 	"github.com/diamondburned/gotrix/matrix"
+	"github.com/pkg/errors"
 )
 
 // ClientAuth holds a partial client.
@@ -40,10 +43,57 @@ func (a *ClientAuth) WithContext(ctx context.Context) *ClientAuth {
 	}
 }
 
+// loginArg extends api.LoginArg to ask for a refresh token.
+type loginArg struct {
+	api.LoginArg
+	RefreshToken bool `json:"refresh_token"`
+}
+
+// sessionResponse is the response of the login and register endpoints.
+type sessionResponse struct {
+	UserID       matrix.UserID   `json:"user_id"`
+	AccessToken  string          `json:"access_token"`
+	DeviceID     matrix.DeviceID `json:"device_id"`
+	RefreshToken string          `json:"refresh_token"`
+	ExpiresInMs  int64           `json:"expires_in_ms"`
+}
+
+// login logs in using the given arguments. A refresh token is requested; if
+// the homeserver gives one, then the returned client refreshes its access
+// token on its own.
+func (a *ClientAuth) login(arg api.LoginArg) (*Client, error) {
+	var resp sessionResponse
+
+	err := a.c.Request(
+		"POST", a.c.Endpoints.Login(), &resp,
+		httputil.WithJSONBody(loginArg{arg, true}),
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "error logging in")
+	}
+
+	return a.useSession(resp)
+}
+
+// useSession creates a Client from the given session.
+func (a *ClientAuth) useSession(resp sessionResponse) (*Client, error) {
+	a.c.UserID = resp.UserID
+	a.c.AccessToken = resp.AccessToken
+	a.c.DeviceID = resp.DeviceID
+
+	c, err := wrapClient(a.c, a.o)
+	if err != nil {
+		return nil, err
+	}
+
+	c.UseRefreshToken(resp.RefreshToken, expiryFromMillis(resp.ExpiresInMs))
+	return c, nil
+}
+
 // LoginPassword authenticates the client using the provided username and
 // password.
 func (a *ClientAuth) LoginPassword(username, password string) (*Client, error) {
-	err := a.c.Client.Login(api.LoginArg{
+	return a.login(api.LoginArg{
 		Type: matrix.LoginPassword,
 		Identifier: matrix.Identifier{
 			Type: matrix.IdentifierUser,
@@ -52,23 +102,15 @@ func (a *ClientAuth) LoginPassword(username, password string) (*Client, error) {
 		Password:                 password,
 		InitialDeviceDisplayName: deviceName,
 	})
-	if err != nil {
-		return nil, err
-	}
-	return wrapClient(a.c, a.o)
 }
 
 // LoginToken authenticates the client using the provided token.
 func (a *ClientAuth) LoginToken(token string) (*Client, error) {
-	err := a.c.Client.Login(api.LoginArg{
+	return a.login(api.LoginArg{
 		Type:                     matrix.LoginToken,
-		Token:                    deviceName,
+		Token:                    token,
 		InitialDeviceDisplayName: deviceName,
 	})
-	if err != nil {
-		return nil, err
-	}
-	return wrapClient(a.c, a.o)
 }
 
 // LoginSSO returns the HTTP address for logging in as SSO and the channel
@@ -91,6 +133,69 @@ func (a *ClientAuth) LoginSSO(done func(*Client, error)) (string, error) {
 	return address, nil
 }
 
+// Registration is an account registration. The account is only created once
+// the InteractiveAuth is done.
+type Registration struct {
+	*InteractiveAuth
+	a *ClientAuth
+}
+
+// registerArg is the request body of the register endpoint.
+type registerArg struct {
+	Auth                     interface{} `json:"auth,omitempty"`
+	Username                 string      `json:"username"`
+	Password                 string      `json:"password"`
+	InitialDeviceDisplayName string      `json:"initial_device_display_name,omitempty"`
+	RefreshToken             bool        `json:"refresh_token"`
+}
+
+// Register starts registering a new account with the given username and
+// password. Most homeservers want the user to go through some stages first,
+// such as accepting their terms; the Registration's InteractiveAuth is not done
+// in that case.
+func (a *ClientAuth) Register(username, password string) (*Registration, error) {
+	arg := registerArg{
+		Username:                 username,
+		Password:                 password,
+		InitialDeviceDisplayName: deviceName,
+		RefreshToken:             true,
+	}
+
+	ia := &InteractiveAuth{
+		c:              a.c,
+		emailTokenPath: a.c.Endpoints.RegisterRequestToken("email"),
+	}
+	ia.request = func(auth, to interface{}) error {
+		arg.Auth = auth
+		return a.c.Request(
+			"POST", a.c.Endpoints.Register(), to,
+			httputil.WithJSONBody(arg),
+			httputil.WithQuery(map[string]string{"kind": "user"}),
+		)
+	}
+
+	if err := ia.Auth(nil); err != nil {
+		return nil, errors.Wrap(err, "error registering")
+	}
+
+	return &Registration{ia, a}, nil
+}
+
+// Client returns the client of the newly registered account. It can only be
+// called once the InteractiveAuth is done.
+func (r *Registration) Client() (*Client, error) {
+	if !r.Done() {
+		return nil, errors.New("registration is not done")
+	}
+
+	var resp sessionResponse
+	if err := json.Unmarshal(r.result, &resp); err != nil {
+		return nil, errors.Wrap(err, "failed to decode registration response")
+	}
+
+	return r.a.useSession(resp)
+}
+
 // LoginMethods returns the login methods supported by the homeserver.
 func (a *ClientAuth) LoginMethods() ([]matrix.LoginMethod, error) {
 	return a.c.Client.GetLoginMethods()
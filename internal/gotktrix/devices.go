@@ -0,0 +1,88 @@
+package gotktrix
+
+import (
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/diamondburned/gotrix/api/httputil"
+	"github.com/diamondburned/gotrix/matrix"
+	"github.com/pkg/errors"
+)
+
+// Device is a device, or a session, that the user is logged in on.
+type Device struct {
+	ID          matrix.DeviceID `json:"device_id"`
+	DisplayName string          `json:"display_name,omitempty"`
+	LastSeenIP  string          `json:"last_seen_ip,omitempty"`
+	// LastSeenMs is the Unix time in milliseconds that the device was last
+	// seen. Use LastSeen instead.
+	LastSeenMs int64 `json:"last_seen_ts,omitempty"`
+}
+
+// LastSeen returns the time that the device was last seen, or the zero time if
+// it's unknown.
+func (d Device) LastSeen() time.Time {
+	if d.LastSeenMs == 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(d.LastSeenMs)
+}
+
+func (c *Client) deviceEndpoint(deviceID matrix.DeviceID) string {
+	return c.Endpoints.Base() + "/devices/" + url.PathEscape(string(deviceID))
+}
+
+// Devices returns the devices that the user is logged in on, with the most
+// recently seen ones first.
+func (c *Client) Devices() ([]Device, error) {
+	var resp struct {
+		Devices []Device `json:"devices"`
+	}
+
+	err := c.Request(
+		"GET", c.Endpoints.Base()+"/devices", &resp,
+		httputil.WithToken(),
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get devices")
+	}
+
+	sort.SliceStable(resp.Devices, func(i, j int) bool {
+		return resp.Devices[i].LastSeenMs > resp.Devices[j].LastSeenMs
+	})
+
+	return resp.Devices, nil
+}
+
+// RenameDevice sets the display name of the given device.
+func (c *Client) RenameDevice(deviceID matrix.DeviceID, name string) error {
+	request := map[string]string{"display_name": name}
+
+	err := c.Request(
+		"PUT", c.deviceEndpoint(deviceID), nil,
+		httputil.WithToken(), httputil.WithJSONBody(request),
+	)
+	if err != nil {
+		return errors.Wrap(err, "failed to rename device")
+	}
+
+	return nil
+}
+
+// DeleteDevice deletes the given device, logging it out. The homeserver
+// usually wants the user to authenticate first, in which case an
+// InteractiveAuth is returned, and the device is deleted once it's done.
+func (c *Client) DeleteDevice(deviceID matrix.DeviceID) (*InteractiveAuth, error) {
+	return c.newInteractiveAuth(func(auth, to interface{}) error {
+		request := map[string]interface{}{}
+		if auth != nil {
+			request["auth"] = auth
+		}
+
+		return c.Request(
+			"DELETE", c.deviceEndpoint(deviceID), to,
+			httputil.WithToken(), httputil.WithJSONBody(request),
+		)
+	})
+}
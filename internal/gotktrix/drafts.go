@@ -0,0 +1,37 @@
+package gotktrix
+
+import (
+	"time"
+
+	"github.com/diamondburned/gotktrix/internal/gotktrix/internal/state"
+	"github.com/diamondburned/gotrix/matrix"
+)
+
+// Draft is a message that the user was composing in a room but hasn't sent.
+type Draft = state.Draft
+
+// DraftAnchor is a widget embedded in a draft's text.
+type DraftAnchor = state.DraftAnchor
+
+// RoomDraft returns the saved draft of the given room. False is returned if the
+// room has none.
+func (c *Client) RoomDraft(roomID matrix.RoomID) (Draft, bool) {
+	draft, err := c.State.RoomDraft(roomID)
+	if err != nil || draft.IsEmpty() {
+		return Draft{}, false
+	}
+	return draft, true
+}
+
+// SetRoomDraft saves the draft of the given room and notifies everything
+// subscribed using SubscribeRoomDraft. An empty draft deletes the saved one.
+func (c *Client) SetRoomDraft(roomID matrix.RoomID, draft Draft) error {
+	draft.UpdatedAt = matrix.Timestamp(time.Now().UnixMilli())
+
+	if err := c.State.SetRoomDraft(roomID, draft); err != nil {
+		return err
+	}
+
+	c.InvokeRoomDraft(roomID)
+	return nil
+}
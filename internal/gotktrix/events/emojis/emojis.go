@@ -16,6 +16,33 @@ type EmojiMap = map[EmojiName]Emoji
 // EmoticonEventData is a subevent struct that describes part of an emoji event.
 type EmoticonEventData struct {
 	Emoticons EmojiMap `json:"emoticons"`
+	// Pack is the optional information of the whole pack.
+	Pack *PackInfo `json:"pack,omitempty"`
+}
+
+// PackInfo describes the information of an emoji pack.
+type PackInfo struct {
+	DisplayName string     `json:"display_name,omitempty"`
+	AvatarURL   matrix.URL `json:"avatar_url,omitempty"`
+	// Usage is the default usage of the emojis in the pack.
+	Usage []EmojiUsage `json:"usage,omitempty"`
+}
+
+// Filter returns only the emojis that can be used as the given usage.
+func (d EmoticonEventData) Filter(usage EmojiUsage) EmojiMap {
+	var packUsage []EmojiUsage
+	if d.Pack != nil {
+		packUsage = d.Pack.Usage
+	}
+
+	filtered := make(EmojiMap, len(d.Emoticons))
+	for name, emoji := range d.Emoticons {
+		if emoji.HasUsage(usage, packUsage) {
+			filtered[name] = emoji
+		}
+	}
+
+	return filtered
 }
 
 // EmojiName describes the name of an emoji, which is surrounded by colons, such
@@ -25,9 +52,50 @@ type EmojiName string
 // Name returns the emoji name without the colons.
 func (n EmojiName) Name() string { return strings.Trim(string(n), ":") }
 
+// EmojiUsage describes what an emoji can be used as.
+type EmojiUsage string
+
+const (
+	// EmoticonUsage is for emojis that can be used inline in messages and as
+	// reactions.
+	EmoticonUsage EmojiUsage = "emoticon"
+	// StickerUsage is for emojis that can be sent as m.sticker events.
+	StickerUsage EmojiUsage = "sticker"
+)
+
 // Emoji describes the information of an emoji.
 type Emoji struct {
 	URL matrix.URL `json:"url"`
+	// Body is the optional text description of the emoji. It is used as the
+	// body of sticker events.
+	Body string `json:"body,omitempty"`
+	// Info is the optional image information of the emoji. It is kept as-is,
+	// since it is copied into sticker events.
+	Info json.RawMessage `json:"info,omitempty"`
+	// Usage is what the emoji can be used as. If it's empty, then the usage
+	// of the pack is used.
+	Usage []EmojiUsage `json:"usage,omitempty"`
+}
+
+// HasUsage returns true if the emoji can be used as the given usage. The given
+// pack usage is used if the emoji doesn't have its own; if neither has any,
+// then the emoji can be used as anything.
+func (e Emoji) HasUsage(usage EmojiUsage, packUsage []EmojiUsage) bool {
+	usages := e.Usage
+	if len(usages) == 0 {
+		usages = packUsage
+	}
+	if len(usages) == 0 {
+		return true
+	}
+
+	for _, u := range usages {
+		if u == usage {
+			return true
+		}
+	}
+
+	return false
 }
 
 func init() {
@@ -68,6 +136,16 @@ func parseUserEmotesEvent(content json.RawMessage) (event.Event, error) {
 
 // UserEmotes gets the current user's emojis.
 func UserEmotes(c *gotktrix.Client) (EmojiMap, error) {
+	d, err := UserPack(c)
+	if err != nil || d == nil {
+		return nil, err
+	}
+
+	return d.Emoticons, nil
+}
+
+// UserPack gets the current user's emoji pack.
+func UserPack(c *gotktrix.Client) (*EmoticonEventData, error) {
 	e, err := c.UserEvent(UserEmotesEventType)
 	if err != nil {
 		return nil, err
@@ -78,7 +156,7 @@ func UserEmotes(c *gotktrix.Client) (EmojiMap, error) {
 		return nil, nil
 	}
 
-	return ev.Emoticons, nil
+	return &ev.EmoticonEventData, nil
 }
 
 // RoomHasEmotes returns true if the room is known to have emojis.
@@ -89,6 +167,16 @@ func RoomHasEmotes(c *gotktrix.Client, roomID matrix.RoomID) bool {
 
 // RoomEmotes gets the room's emojis.
 func RoomEmotes(c *gotktrix.Client, roomID matrix.RoomID) (EmojiMap, error) {
+	d, err := RoomPack(c, roomID)
+	if err != nil || d == nil {
+		return nil, err
+	}
+
+	return d.Emoticons, nil
+}
+
+// RoomPack gets the room's emoji pack.
+func RoomPack(c *gotktrix.Client, roomID matrix.RoomID) (*EmoticonEventData, error) {
 	e, err := c.RoomState(roomID, RoomEmotesEventType, "")
 	if err != nil {
 		return nil, err
@@ -99,5 +187,51 @@ func RoomEmotes(c *gotktrix.Client, roomID matrix.RoomID) (EmojiMap, error) {
 		return nil, nil
 	}
 
-	return ev.Emoticons, nil
+	return &ev.EmoticonEventData, nil
+}
+
+// StickerPack is a list of stickers from either the user or a room.
+type StickerPack struct {
+	// Name is the display name of the pack. It may be empty for the user's
+	// own pack.
+	Name string
+	// RoomID is the room that the pack belongs to. It is empty for the user's
+	// own pack.
+	RoomID   matrix.RoomID
+	Stickers EmojiMap
+}
+
+// StickerPacks returns the sticker packs that can be used in the given room.
+// The user's pack comes first, and empty packs are omitted.
+func StickerPacks(c *gotktrix.Client, roomID matrix.RoomID) []StickerPack {
+	var packs []StickerPack
+
+	if d, _ := UserPack(c); d != nil {
+		if stickers := d.Filter(StickerUsage); len(stickers) > 0 {
+			packs = append(packs, StickerPack{
+				Name:     packName(d, ""),
+				Stickers: stickers,
+			})
+		}
+	}
+
+	if d, _ := RoomPack(c, roomID); d != nil {
+		if stickers := d.Filter(StickerUsage); len(stickers) > 0 {
+			name, _ := c.RoomName(roomID)
+			packs = append(packs, StickerPack{
+				Name:     packName(d, name),
+				RoomID:   roomID,
+				Stickers: stickers,
+			})
+		}
+	}
+
+	return packs
+}
+
+func packName(d *EmoticonEventData, fallback string) string {
+	if d.Pack != nil && d.Pack.DisplayName != "" {
+		return d.Pack.DisplayName
+	}
+	return fallback
 }
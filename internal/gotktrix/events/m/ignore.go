@@ -0,0 +1,47 @@
+package m
+
+import (
+	"encoding/json"
+
+	"github.com/diamondburned/gotrix/event"
+	"github.com/diamondburned/gotrix/matrix"
+)
+
+func init() {
+	event.RegisterDefault(IgnoredUserListEventType, parseIgnoredUserListEvent)
+}
+
+// IgnoredUserListEventType is the account data type for m.ignored_user_list.
+const IgnoredUserListEventType event.Type = "m.ignored_user_list"
+
+// IgnoredUserListEvent is the account data event that lists the users that the
+// current user has ignored. The server stops sending invites from them, and
+// clients should hide their events.
+type IgnoredUserListEvent struct {
+	event.EventInfo `json:"-"`
+
+	// IgnoredUsers is the set of ignored users. The values are always empty
+	// objects.
+	IgnoredUsers map[matrix.UserID]json.RawMessage `json:"ignored_users"`
+}
+
+func parseIgnoredUserListEvent(content json.RawMessage) (event.Event, error) {
+	var ev IgnoredUserListEvent
+	err := json.Unmarshal(content, &ev)
+	return &ev, err
+}
+
+// IsIgnored returns true if the given user is in the list.
+func (ev *IgnoredUserListEvent) IsIgnored(userID matrix.UserID) bool {
+	_, ok := ev.IgnoredUsers[userID]
+	return ok
+}
+
+// UserIDs returns the user IDs in the list in no particular order.
+func (ev *IgnoredUserListEvent) UserIDs() []matrix.UserID {
+	ids := make([]matrix.UserID, 0, len(ev.IgnoredUsers))
+	for id := range ev.IgnoredUsers {
+		ids = append(ids, id)
+	}
+	return ids
+}
@@ -14,6 +14,8 @@ func init() {
 	event.RegisterDefault(SpaceChildEventType, parseSpaceChildEvent)
 	event.RegisterDefault(SpaceParentEventType, parseSpaceParentEvent)
 	event.RegisterDefault(ReactionEventType, parseReactionEvent)
+	event.RegisterDefault(EncryptionEventType, parseEncryptionEvent)
+	event.RegisterDefault(EncryptedEventType, parseEncryptedEvent)
 }
 
 // FullyReadEventType is the event type for m.fully_read.
@@ -143,6 +145,54 @@ func (ev *SpaceParentEvent) SpaceRoomID() matrix.RoomID {
 	return matrix.RoomID(ev.StateEventInfo.StateKey)
 }
 
+// EncryptionEventType is the event type for m.room.encryption.
+const EncryptionEventType event.Type = "m.room.encryption"
+
+// EncryptionEvent is a state event that enables end-to-end encryption in a
+// room. Once set, it cannot be unset.
+type EncryptionEvent struct {
+	event.StateEventInfo `json:"-"`
+
+	Algorithm string `json:"algorithm"`
+	// RotationPeriodMs is how long a Megolm session should be used before
+	// it is rotated. It defaults to a week.
+	RotationPeriodMs int64 `json:"rotation_period_ms,omitempty"`
+	// RotationPeriodMsgs is how many messages should be sent before the
+	// Megolm session is rotated. It defaults to 100.
+	RotationPeriodMsgs int `json:"rotation_period_msgs,omitempty"`
+}
+
+func parseEncryptionEvent(content json.RawMessage) (event.Event, error) {
+	var ev EncryptionEvent
+	err := json.Unmarshal(content, &ev)
+	return &ev, err
+}
+
+// EncryptedEventType is the event type for m.room.encrypted.
+const EncryptedEventType event.Type = "m.room.encrypted"
+
+// EncryptedEvent is an encrypted room or to-device event. A room event of this
+// type that's given to the user means that it couldn't be decrypted.
+type EncryptedEvent struct {
+	event.RoomEventInfo `json:"-"`
+
+	Algorithm string          `json:"algorithm"`
+	SenderKey string          `json:"sender_key"`
+	DeviceID  matrix.DeviceID `json:"device_id,omitempty"`
+	SessionID string          `json:"session_id,omitempty"`
+	// Ciphertext is a string for Megolm and an object for Olm.
+	Ciphertext json.RawMessage `json:"ciphertext"`
+	// RelatesTo is kept unencrypted so that the server can aggregate
+	// relations.
+	RelatesTo json.RawMessage `json:"m.relates_to,omitempty"`
+}
+
+func parseEncryptedEvent(content json.RawMessage) (event.Event, error) {
+	var ev EncryptedEvent
+	err := json.Unmarshal(content, &ev)
+	return &ev, err
+}
+
 // DiscordMember describes a Discord member, which sits inside a field labeled
 // "uk.half-shot.discord.member" in the RoomMemberEvent.
 type DiscordMember struct {
@@ -0,0 +1,51 @@
+package m
+
+import (
+	"encoding/json"
+
+	"github.com/diamondburned/gotrix/event"
+	"github.com/diamondburned/gotrix/matrix"
+)
+
+// ReceiptType is the type of a receipt inside an m.receipt event.
+type ReceiptType string
+
+const (
+	// ReadReceipt is the m.read receipt, which is visible to everyone in the
+	// room.
+	ReadReceipt ReceiptType = "m.read"
+	// PrivateReadReceipt is the m.read.private receipt, which is only visible
+	// to the user that sent it.
+	PrivateReadReceipt ReceiptType = "m.read.private"
+)
+
+// MainThreadID is the thread ID of receipts that apply to the main timeline
+// instead of a single thread.
+const MainThreadID = "main"
+
+// Receipt is a single user's receipt.
+type Receipt struct {
+	Timestamp matrix.Timestamp `json:"ts"`
+	// ThreadID is the root of the thread that the receipt is for. It is empty
+	// for unthreaded receipts.
+	ThreadID matrix.EventID `json:"thread_id,omitempty"`
+}
+
+// ReceiptContent is the content of an m.receipt event. It maps event IDs to
+// the receipts of each type, which map user IDs to their receipts.
+//
+// It's used over event.ReceiptEvent, which only knows about m.read.
+type ReceiptContent map[matrix.EventID]map[ReceiptType]map[matrix.UserID]Receipt
+
+// ParseReceiptContent parses the content of the given raw m.receipt event.
+func ParseReceiptContent(raw event.RawEvent) (ReceiptContent, error) {
+	var ev struct {
+		Content ReceiptContent `json:"content"`
+	}
+
+	if err := json.Unmarshal(raw, &ev); err != nil {
+		return nil, err
+	}
+
+	return ev.Content, nil
+}
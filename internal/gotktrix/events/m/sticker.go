@@ -0,0 +1,59 @@
+package m
+
+import (
+	"encoding/json"
+
+	"github.com/diamondburned/gotrix/event"
+	"github.com/diamondburned/gotrix/matrix"
+)
+
+func init() {
+	event.RegisterDefault(StickerEventType, parseStickerEvent)
+}
+
+// StickerEventType is the event type for m.sticker.
+const StickerEventType event.Type = "m.sticker"
+
+// StickerMessageType is the pseudo message type of room messages converted
+// from sticker events using AsMessage. No real m.room.message has it.
+const StickerMessageType event.MessageType = "m.sticker"
+
+// StickerEvent is a sticker event of type m.sticker.
+type StickerEvent struct {
+	event.RoomEventInfo `json:"-"`
+
+	// Body is the text description of the sticker.
+	Body string     `json:"body"`
+	URL  matrix.URL `json:"url"`
+	// AdditionalInfo is the image information of the sticker. Use ImageInfo
+	// to parse it.
+	AdditionalInfo json.RawMessage `json:"info,omitempty"`
+
+	RelatesTo json.RawMessage `json:"m.relates_to,omitempty"`
+}
+
+func parseStickerEvent(content json.RawMessage) (event.Event, error) {
+	var ev StickerEvent
+	err := json.Unmarshal(content, &ev)
+	return &ev, err
+}
+
+// ImageInfo parses the sticker's image information.
+func (ev *StickerEvent) ImageInfo() (event.ImageInfo, error) {
+	var info event.ImageInfo
+	err := json.Unmarshal(ev.AdditionalInfo, &info)
+	return info, err
+}
+
+// AsMessage converts the sticker into a room message with the message type
+// StickerMessageType, so that it can be shown like any other message.
+func (ev *StickerEvent) AsMessage() *event.RoomMessageEvent {
+	return &event.RoomMessageEvent{
+		RoomEventInfo:  ev.RoomEventInfo,
+		Body:           ev.Body,
+		MessageType:    StickerMessageType,
+		RelatesTo:      ev.RelatesTo,
+		URL:            ev.URL,
+		AdditionalInfo: ev.AdditionalInfo,
+	}
+}
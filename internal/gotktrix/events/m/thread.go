@@ -0,0 +1,71 @@
+package m
+
+import (
+	"encoding/json"
+
+	"github.com/diamondburned/gotrix/event"
+	"github.com/diamondburned/gotrix/matrix"
+)
+
+// ThreadRelType is the rel_type of events that belong to a thread.
+const ThreadRelType = "m.thread"
+
+// ThreadRelation is the m.relates_to object of an event in a thread.
+type ThreadRelation struct {
+	RelType string `json:"rel_type"`
+	// EventID is the ID of the thread root.
+	EventID matrix.EventID `json:"event_id"`
+	// IsFallingBack is true if InReplyTo is only there for clients that don't
+	// understand threads.
+	IsFallingBack bool       `json:"is_falling_back,omitempty"`
+	InReplyTo     *InReplyTo `json:"m.in_reply_to,omitempty"`
+}
+
+// InReplyTo is the m.in_reply_to object inside m.relates_to.
+type InReplyTo struct {
+	EventID matrix.EventID `json:"event_id"`
+}
+
+// ThreadRoot returns the ID of the thread root that the event with the given
+// m.relates_to belongs to, or an empty string if it's not in a thread.
+func ThreadRoot(relatesTo json.RawMessage) matrix.EventID {
+	if len(relatesTo) == 0 {
+		return ""
+	}
+
+	var rel ThreadRelation
+	if err := json.Unmarshal(relatesTo, &rel); err != nil || rel.RelType != ThreadRelType {
+		return ""
+	}
+
+	return rel.EventID
+}
+
+// ThreadSummary is the thread information that the server bundles into the
+// unsigned field of a thread root.
+type ThreadSummary struct {
+	// Count is the number of events in the thread, not counting the root.
+	Count int `json:"count"`
+	// Latest is the latest event in the thread.
+	Latest event.RawEvent `json:"latest_event,omitempty"`
+	// Participated is true if the current user has sent an event in the thread.
+	Participated bool `json:"current_user_participated"`
+}
+
+// ParseThreadSummary parses the bundled thread summary of the given raw event.
+// False is returned if the event isn't a thread root.
+func ParseThreadSummary(raw event.RawEvent) (ThreadSummary, bool) {
+	var ev struct {
+		Unsigned struct {
+			Relations struct {
+				Thread *ThreadSummary `json:"m.thread"`
+			} `json:"m.relations"`
+		} `json:"unsigned"`
+	}
+
+	if err := json.Unmarshal(raw, &ev); err != nil || ev.Unsigned.Relations.Thread == nil {
+		return ThreadSummary{}, false
+	}
+
+	return *ev.Unsigned.Relations.Thread, true
+}
@@ -0,0 +1,205 @@
+package gotktrix
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+
+	"github.com/diamondburned/gotktrix/internal/gotktrix/internal/httptrick"
+	"github.com/diamondburned/gotktrix/internal/gotktrix/internal/state"
+	"github.com/diamondburned/gotrix/event"
+	"github.com/diamondburned/gotrix/matrix"
+	"github.com/pkg/errors"
+)
+
+// SyncProfile describes how much the sync loop asks the homeserver for.
+type SyncProfile uint8
+
+const (
+	// FullSync syncs everything, including presence and typing, and the
+	// latest TimelimeLimit events of each room.
+	FullSync SyncProfile = iota
+	// LowBandwidthSync doesn't sync presence and typing, and it only syncs the
+	// latest LowBandwidthTimelineLimit events of each room.
+	LowBandwidthSync
+)
+
+// LowBandwidthTimelineLimit is the number of latest timeline events that each
+// sync fetches using LowBandwidthSync.
+const LowBandwidthTimelineLimit = 20
+
+// everything matches all event types in a filter.
+const everything event.Type = "*"
+
+// SyncFilter returns the sync filter of the given profile. Quiet rooms, which
+// are the muted or low priority ones, get no ephemeral events such as typing
+// and read receipts.
+func SyncFilter(profile SyncProfile, quietRooms []matrix.RoomID) event.GlobalFilter {
+	filter := SyncOptions.Filter
+
+	switch profile {
+	case LowBandwidthSync:
+		filter.Presence.ExcludedTypes = []event.Type{everything}
+		filter.Room.Ephemeral.ExcludedTypes = []event.Type{event.TypeTyping}
+		filter.Room.Timeline.Limit = LowBandwidthTimelineLimit
+	}
+
+	if len(quietRooms) > 0 {
+		// Sort the rooms, so that the same rooms always give the same filter,
+		// which can then be reused.
+		rooms := append([]matrix.RoomID(nil), quietRooms...)
+		sort.Slice(rooms, func(i, j int) bool { return rooms[i] < rooms[j] })
+
+		filter.Room.Ephemeral.ExcludedRooms = rooms
+	}
+
+	return filter
+}
+
+// SetSyncProfile sets the sync profile of the client. It takes effect the next
+// time the client is opened.
+func (c *Client) SetSyncProfile(profile SyncProfile) {
+	c.loop.mu.Lock()
+	c.loop.profile = profile
+	c.loop.mu.Unlock()
+}
+
+// syncFilter returns the sync filter of the client's profile.
+func (c *Client) syncFilter() event.GlobalFilter {
+	c.loop.mu.Lock()
+	profile := c.loop.profile
+	c.loop.mu.Unlock()
+
+	return SyncFilter(profile, c.quietRooms())
+}
+
+// quietRooms returns the joined rooms that are muted or low priority.
+func (c *Client) quietRooms() []matrix.RoomID {
+	roomIDs, err := c.State.Rooms()
+	if err != nil {
+		return nil
+	}
+
+	muted := c.mutedRooms()
+
+	var quiet []matrix.RoomID
+
+	for _, roomID := range roomIDs {
+		if muted[roomID] || c.roomHasTag(roomID, matrix.TagLowPriority) {
+			quiet = append(quiet, roomID)
+		}
+	}
+
+	return quiet
+}
+
+// mutedRooms returns the rooms whose push rules don't notify at all.
+func (c *Client) mutedRooms() map[matrix.RoomID]bool {
+	e, err := c.State.UserEvent(event.TypePushRules)
+	if err != nil {
+		return nil
+	}
+
+	rules := e.(*event.PushRulesEvent).Global
+	muted := make(map[matrix.RoomID]bool)
+
+	// Room rules use the room ID as their rule ID. Clients also mute rooms
+	// using an override rule of the same ID.
+	for _, list := range []matrix.PushRules{rules.Override, rules.Room} {
+		for _, rule := range list {
+			if !rule.Enabled || rule.RuleID.IsServerDefault() {
+				continue
+			}
+
+			switch rule.Actions.Action {
+			case matrix.NotifyAction, matrix.CoalesceAction:
+				continue
+			}
+
+			muted[matrix.RoomID(rule.RuleID)] = true
+		}
+	}
+
+	return muted
+}
+
+func (c *Client) roomHasTag(roomID matrix.RoomID, tag matrix.TagName) bool {
+	e, err := c.State.RoomEvent(roomID, event.TypeTag)
+	if err != nil {
+		return false
+	}
+
+	_, ok := e.(*event.TagEvent).Tags[tag]
+	return ok
+}
+
+// filterIntercept reuses the ID of a filter that was uploaded before instead
+// of uploading the same filter again. The IDs are kept in the state, keyed by
+// the hash of the filter.
+func filterIntercept(s *state.State, path string) httptrick.InterceptFullFunc {
+	return func(r *http.Request, next func() (*http.Response, error)) (*http.Response, error) {
+		if r.Method != "POST" || r.Body == nil || r.URL.EscapedPath() != path {
+			return next()
+		}
+
+		body, err := io.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read filter")
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		hash := sha256.Sum256(body)
+		key := hex.EncodeToString(hash[:])
+
+		if id, ok := s.FilterID(key); ok {
+			return filterResponse(r, id), nil
+		}
+
+		resp, err := next()
+		if err != nil || resp.StatusCode != http.StatusOK {
+			return resp, err
+		}
+
+		b, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read filter response")
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(b))
+
+		var uploaded struct {
+			FilterID string `json:"filter_id"`
+		}
+
+		if err := json.Unmarshal(b, &uploaded); err == nil && uploaded.FilterID != "" {
+			if err := s.SetFilterID(key, uploaded.FilterID); err != nil {
+				log.Println("cannot cache filter ID:", err)
+			}
+		}
+
+		return resp, nil
+	}
+}
+
+// filterResponse fakes the response of a filter upload.
+func filterResponse(r *http.Request, filterID string) *http.Response {
+	b, _ := json.Marshal(map[string]string{"filter_id": filterID})
+
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {"application/json"}},
+		Body:          io.NopCloser(bytes.NewReader(b)),
+		ContentLength: int64(len(b)),
+		Request:       r,
+	}
+}
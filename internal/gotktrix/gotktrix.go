@@ -19,9 +19,12 @@ import (
 	"github.com/diamondburned/gotktrix/internal/gotktrix/events/sys"
 	"github.com/diamondburned/gotktrix/internal/gotktrix/indexer"
 	"github.com/diamondburned/gotktrix/internal/gotktrix/internal/db"
+	"github.com/diamondburned/gotktrix/internal/gotktrix/internal/e2ee"
 	"github.com/diamondburned/gotktrix/internal/gotktrix/internal/handler"
 	"github.com/diamondburned/gotktrix/internal/gotktrix/internal/httptrick"
+	"github.com/diamondburned/gotktrix/internal/gotktrix/internal/slidingsync"
 	"github.com/diamondburned/gotktrix/internal/gotktrix/internal/state"
+	"github.com/diamondburned/gotktrix/internal/secret"
 	"github.com/diamondburned/gotrix"
 	"github.com/diamondburned/gotrix/api"
 	"github.com/diamondburned/gotrix/api/httputil"
@@ -33,7 +36,8 @@ import (
 // EachBreak can be returned if the user wants to break out of an interation.
 var EachBreak = db.EachBreak
 
-// TimelimeLimit is the number of timeline events that the database keeps.
+// TimelimeLimit is the number of latest timeline events that the database
+// always keeps and that each sync fetches.
 const TimelimeLimit = state.TimelineKeepLast
 
 // SyncOptions is used to sync.
@@ -137,6 +141,12 @@ func Base64UserID(uID matrix.UserID) string {
 type Opts struct {
 	Client     httputil.Client
 	ConfigPath ConfigPather
+	// NoCrypto disables end-to-end encryption. It must be set if the device's
+	// keys are owned by another client, such as one in another process.
+	NoCrypto bool
+	// Secrets keeps the key that the crypto database is encrypted with.
+	// End-to-end encryption is disabled without it.
+	Secrets secret.Driver
 }
 
 var defaultOpts = Opts{
@@ -144,10 +154,13 @@ var defaultOpts = Opts{
 	ConfigPath: constConfigPath(os.TempDir()),
 }
 
-// must swaps o out for the default if it's empty.
+// init fills in the defaults of the fields that are empty.
 func (o *Opts) init() {
-	if *o == (Opts{}) {
-		*o = defaultOpts
+	if o.Client == (httputil.Client{}) {
+		o.Client = defaultOpts.Client
+	}
+	if o.ConfigPath == nil {
+		o.ConfigPath = defaultOpts.ConfigPath
 	}
 }
 
@@ -159,6 +172,17 @@ type Client struct {
 	State       *state.State
 	Index       *indexer.Indexer
 	Interceptor *httptrick.Interceptor
+	Outbox      *Outbox
+
+	loop      *syncLoop
+	slider    *slidingsync.Loop
+	compactor *compactor
+	session   *tokenSession
+
+	configPath ConfigPather
+
+	// crypto is nil if the homeserver didn't give us a device ID.
+	crypto *e2ee.Machine
 
 	ctx context.Context
 }
@@ -177,25 +201,38 @@ func New(serverName, token string, opts Opts) (*Client, error) {
 	return wrapClient(c, opts)
 }
 
-/*
-var cachedRoutes = map[string]map[string]string{
-	// TODO: this doesn't work. Investigate.
-	"/_matrix/media/r0/*": {
-		"Cache-Control": httptrick.OverrideCacheControl(4 * time.Hour),
-	},
+// NewCached is like New, except the client uses the given user and device IDs
+// instead of asking the homeserver for them. This allows a client of a known
+// account to be created and used from its state database while offline.
+func NewCached(
+	serverName, token string,
+	userID matrix.UserID, deviceID matrix.DeviceID, opts Opts) (*Client, error) {
+
+	opts.init()
+
+	c, err := gotrix.NewWithClient(opts.Client, serverName)
+	if err != nil {
+		return nil, err
+	}
+
+	c.AccessToken = token
+	c.UserID = userID
+	c.DeviceID = deviceID
+
+	return wrapClient(c, opts)
 }
-*/
 
 func wrapClient(c *gotrix.Client, opts Opts) (*Client, error) {
 	logInit()
 	opts.init()
 
-	if c.UserID == "" {
-		userID, _, err := c.Whoami()
+	if c.UserID == "" || c.DeviceID == "" {
+		userID, deviceID, err := c.Whoami()
 		if err != nil {
 			return nil, errors.Wrap(err, "invalid user account")
 		}
 		c.UserID = userID
+		c.DeviceID = deviceID
 	}
 
 	// URLEncoding is path-safe; StdEncoding is not.
@@ -210,44 +247,103 @@ func wrapClient(c *gotrix.Client, opts Opts) (*Client, error) {
 		c.ClientDriver = &http.Client{Transport: interceptor}
 	}
 
+	// Always send the latest access token, and refresh it when needed.
+	session := newTokenSession(c)
+	interceptor.AddInterceptFull(httptrick.TokenIntercept(session))
+
 	s, err := state.New(opts.ConfigPath.ConfigPath("matrix-state", b64Username), c.UserID)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to make state db")
 	}
 
+	// Don't upload the same sync filter every time the client is opened.
+	interceptor.AddInterceptFull(filterIntercept(s, "/"+c.Endpoints.Filter(c.UserID)))
+
 	idx, err := indexer.Open(opts.ConfigPath.ConfigPath("matrix-index", b64Username))
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to make indexer")
 	}
 
 	registry := handler.New()
+	if e, err := s.UserEvent(m.IgnoredUserListEventType); err == nil {
+		registry.SetIgnoredUsers(e.(*m.IgnoredUserListEvent).UserIDs())
+	}
 	registry.OnSync(func(s *api.SyncResponse) {
 		for _, room := range s.Rooms.Joined {
-			for _, ev := range room.State.Events {
-				if state.GuessType(ev) != event.TypeRoomMember {
-					continue
-				}
-
-				e, err := sys.ParseAs(ev, event.TypeRoomMember)
-				if err == nil {
-					b := idx.Begin()
-					b.IndexRoomMember(e.(*event.RoomMemberEvent))
-					b.Commit()
+			// Members can also join or change their names in the timeline.
+			for _, evs := range [][]event.RawEvent{room.State.Events, room.Timeline.Events} {
+				for _, ev := range evs {
+					if state.GuessType(ev) != event.TypeRoomMember {
+						continue
+					}
+
+					e, err := sys.ParseAs(ev, event.TypeRoomMember)
+					if err == nil {
+						b := idx.Begin()
+						b.IndexRoomMember(e.(*event.RoomMemberEvent))
+						b.Commit()
+					}
 				}
 			}
 		}
 	})
+	registry.OnSync(func(s *api.SyncResponse) {
+		b := idx.Begin()
+		defer b.Commit()
+
+		for roomID, room := range s.Rooms.Joined {
+			indexMessages(b, roomID, room.Timeline.Events)
+		}
+	})
 
 	c.State = registry.Wrap(s)
 	c.SyncOpts = SyncOptions
 
-	return &Client{
+	var crypto *e2ee.Machine
+	if opts.NoCrypto {
+		log.Println("encryption is disabled for this client")
+	} else if c.DeviceID != "" {
+		key, err := e2ee.PickleKey(opts.Secrets, c.UserID)
+		if err != nil {
+			log.Println("cannot get crypto key, encryption is disabled:", err)
+		} else {
+			crypto, err = e2ee.New(opts.ConfigPath.ConfigPath("matrix-crypto", b64Username), c.Client, key)
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to make crypto db")
+			}
+			// Decrypt events before the state and handlers see them.
+			c.State = crypto.Wrap(c.State)
+		}
+	} else {
+		log.Println("homeserver did not give a device ID, encryption is disabled")
+	}
+
+	client := &Client{
 		Client:      c,
 		Registry:    registry,
 		State:       s,
 		Index:       idx,
 		Interceptor: interceptor,
-	}, nil
+		loop:        &syncLoop{},
+		compactor:   newCompactor(),
+		session:     session,
+		configPath:  opts.ConfigPath,
+		crypto:      crypto,
+	}
+
+	client.Outbox = newOutbox(client)
+	client.slider = slidingsync.New(client.slidingSink)
+	// A successful sync means that the homeserver is reachable again.
+	registry.OnSync(func(*api.SyncResponse) { client.Outbox.online() })
+
+	return client, nil
+}
+
+// DatabaseInUse returns true if the databases of the given user in the given
+// config path are opened by another process, such as another running instance.
+// A client of the user cannot be created until that process exits.
+func DatabaseInUse(configPath ConfigPather, userID matrix.UserID) bool {
+	return db.IsLocked(configPath.ConfigPath("matrix-state", Base64UserID(userID)))
 }
 
 // AddHandler will panic.
@@ -259,16 +355,66 @@ func (c *Client) AddHandler(function interface{}) error {
 
 // Open opens the client with the last next batch string.
 func (c *Client) Open() error {
+	if c.crypto != nil {
+		go func() {
+			if err := c.crypto.Upload(c.Client.Client); err != nil {
+				log.Println("cannot upload encryption keys:", err)
+			}
+		}()
+	}
+
+	c.Outbox.start()
+	c.startCompactor()
+
 	next, _ := c.State.NextBatch()
-	return c.Client.OpenWithNext(next)
+
+	c.Client.SyncOpts.Filter = c.syncFilter()
+
+	c.loop.mu.Lock()
+	sliding := c.loop.sliding
+	c.loop.mu.Unlock()
+
+	if sliding {
+		c.openSliding(next)
+		return nil
+	}
+
+	if err := c.Client.OpenWithNext(next); err != nil {
+		if !IsOffline(err) {
+			return err
+		}
+
+		// Keep working from the state database and open the sync loop once
+		// the homeserver is reachable again.
+		log.Println("homeserver is unreachable, working offline:", err)
+		c.reopen(next)
+		return nil
+	}
+
+	c.loop.mu.Lock()
+	c.loop.opened = true
+	c.loop.mu.Unlock()
+
+	return nil
 }
 
 // Close closes the event loop and the internal database, as well as halting all
 // ongoing requests.
 func (c *Client) Close() error {
-	err1 := c.Client.Close()
+	c.Outbox.close()
+	c.stopCompactor()
+
+	err1 := c.closeLoop()
 	err2 := c.State.Close()
 
+	if err := c.Index.Close(); err != nil {
+		log.Println("failed to close indexer:", err)
+	}
+
+	if c.crypto != nil {
+		c.crypto.Close()
+	}
+
 	if err1 != nil {
 		return err1
 	}
@@ -287,12 +433,16 @@ func (c *Client) Online(ctx context.Context) *Client {
 	return c.WithContext(ctx)
 }
 
-// AddSyncInterceptFull adds an InterceptFullFunc for the Sync endpoint.
+// AddSyncInterceptFull adds an InterceptFullFunc for the Sync endpoint. The
+// filter upload that opens the sync loop and the sliding sync endpoint are also
+// intercepted.
 func (c *Client) AddSyncInterceptFull(f httptrick.InterceptFullFunc) func() {
 	return c.Interceptor.AddInterceptFull(
 		func(r *http.Request, next func() (*http.Response, error)) (*http.Response, error) {
 			// Beware: api.EndpointX doesn't have a prefixing slash!
-			if strings.HasPrefix(r.URL.Path, "/"+c.Endpoints.Sync()) {
+			if strings.HasPrefix(r.URL.Path, "/"+c.Endpoints.Sync()) ||
+				strings.HasPrefix(r.URL.Path, "/"+slidingsync.Endpoint) ||
+				r.URL.EscapedPath() == "/"+c.Endpoints.Filter(c.UserID) {
 				return f(r, next)
 			}
 			return next()
@@ -443,7 +593,7 @@ func (c *Client) MessageMediaURL(msg *event.RoomMessageEvent) (string, error) {
 func (c *Client) RoomTimelineEvent(roomID matrix.RoomID, id matrix.EventID) (event.RoomEvent, error) {
 	var found event.RoomEvent
 
-	c.EachTimeline(roomID, func(ev event.RoomEvent) error {
+	c.EachTimelineReverse(roomID, func(ev event.RoomEvent) error {
 		if ev.RoomInfo().ID == id {
 			found = ev
 			return EachBreak
@@ -452,7 +602,7 @@ func (c *Client) RoomTimelineEvent(roomID matrix.RoomID, id matrix.EventID) (eve
 	})
 
 	if found != nil {
-		return found, nil
+		return c.decryptEvent(roomID, found), nil
 	}
 
 	raw, err := c.Client.RoomEvent(roomID, id)
@@ -460,7 +610,7 @@ func (c *Client) RoomTimelineEvent(roomID matrix.RoomID, id matrix.EventID) (eve
 		return nil, errors.Wrap(err, "cannot get event from API")
 	}
 
-	return sys.ParseTimeline(raw, roomID), nil
+	return c.decryptEvent(roomID, sys.ParseTimeline(raw, roomID)), nil
 }
 
 // RoomEvent queries the event with the given type. If the event type implies a
@@ -592,27 +742,13 @@ func (c *Client) hasSeenEvent(roomID matrix.RoomID, eventID matrix.EventID) (see
 		return fullyRead.EventID == eventID, true
 	}
 
-	u, err := c.Whoami()
+	// Query to see if the current user has read the latest message.
+	receipt, err := c.State.RoomUserReceipt(roomID, c.UserID)
 	if err != nil {
-		// Can't get the current user, so just assume that the room is unread.
-		// This would be a bug, but whatever.
 		return false, false
 	}
 
-	// Query to see if the current user has read the latest message.
-	e, _ = c.RoomEvent(roomID, event.TypeReceipt)
-	if e, ok := e.(*event.ReceiptEvent); ok {
-		rc, ok := e.Events[eventID]
-		if !ok {
-			// Nobody has read the latest message, including the current user.
-			return false, true
-		}
-
-		_, read := rc.Read[u]
-		return read, true
-	}
-
-	return false, false
+	return receipt.EventID == eventID, true
 }
 
 // RoomLatestReadEvent gets the latest read eventID. The event ID is an empty
@@ -623,21 +759,9 @@ func (c *Client) RoomLatestReadEvent(roomID matrix.RoomID) matrix.EventID {
 		return e.(*m.FullyReadEvent).EventID
 	}
 
-	u, err := c.Whoami()
-	if err != nil {
-		return ""
-	}
-
-	e, err = c.RoomEvent(roomID, event.TypeReceipt)
+	receipt, err := c.State.RoomUserReceipt(roomID, c.UserID)
 	if err == nil {
-		e := e.(*event.ReceiptEvent)
-
-		for eventID, receipt := range e.Events {
-			_, read := receipt.Read[u]
-			if read {
-				return eventID
-			}
-		}
+		return receipt.EventID
 	}
 
 	return ""
@@ -662,29 +786,49 @@ func (c *Client) RoomCountUnread(roomID matrix.RoomID) (n int, more bool) {
 			found = true
 			return EachBreak
 		}
+		if c.IsIgnored(info.Sender) {
+			return nil
+		}
 		unread++
+		// Don't count through the whole archived history.
+		if unread >= TimelimeLimit {
+			return EachBreak
+		}
 		return nil
 	})
 
 	return unread, !found
 }
 
+// NotificationCount sums up the notification counts of all joined rooms as
+// reported by the homeserver. Only the state is used.
+func (c *Client) NotificationCount() m.NotificationCount {
+	var total m.NotificationCount
+
+	roomIDs, _ := c.State.Rooms()
+	for _, roomID := range roomIDs {
+		count := c.State.RoomNotificationCount(roomID)
+		total.Notification += count.Notification
+		total.Highlight += count.Highlight
+	}
+
+	return total
+}
+
 // MarkRoomAsRead sends to the server that the current user has seen up to the
-// given event in the given room.
-func (c *Client) MarkRoomAsRead(roomID matrix.RoomID, eventID matrix.EventID) error {
+// given event in the given room. The receipt is sent as the given type, which
+// is either m.ReadReceipt or m.PrivateReadReceipt.
+func (c *Client) MarkRoomAsRead(roomID matrix.RoomID, eventID matrix.EventID, typ m.ReceiptType) error {
 	if seen, ok := c.hasSeenEvent(roomID, eventID); ok && seen {
 		// Room is already seen; don't waste an API call.
 		return nil
 	}
 
-	var request struct {
-		FullyRead matrix.EventID `json:"m.fully_read"`
-		Read      matrix.EventID `json:"m.read,omitempty"`
+	request := map[string]matrix.EventID{
+		"m.fully_read": eventID,
+		string(typ):    eventID,
 	}
 
-	request.FullyRead = eventID
-	request.Read = eventID
-
 	return c.Request(
 		"POST", c.Endpoints.Room(roomID)+"/read_markers",
 		nil, httputil.WithToken(), httputil.WithJSONBody(request),
@@ -732,190 +876,35 @@ func (c *Client) RoomEnsureMembers(roomID matrix.RoomID) error {
 	return nil
 }
 
-// RoomPaginator is used to fetch older messages from the API client.
-type RoomPaginator struct {
-	c      *Client
-	roomID matrix.RoomID
-	limit  int
-
-	// buffer holds all the unreturned events.
-	buffer []event.RoomEvent
-	// lastBatch keeps track of the pagination token.
-	lastBatch string
-	// skip counts the number of events to skip during the initial fetch using
-	// prev_batch, since those messages will have been in the state already.
-	skip int
-	// drained is true if the state cache is completely drained.
-	drained bool
-	// onTop is true if we're out of events.
-	onTop bool
-}
-
-// RoomPaginator returns a new paginator that can fetch messages from the bottom
-// up.
-func (c *Client) RoomPaginator(roomID matrix.RoomID, limit int) *RoomPaginator {
-	if limit < 1 {
-		log.Panicln("gotktrix: RoomPaginator limit must be non-zero")
-	}
-
-	return &RoomPaginator{
-		c:      c,
-		limit:  limit,
-		roomID: roomID,
-	}
-}
-
-// TODO: this API is broken because the room list will shift messages over time.
-// Paginate should take a message ID and repaginate if it cannot seek to the
-// right position in the buffer.
-
-// Paginate paginates from the client and the server if the database is drained.
-func (p *RoomPaginator) Paginate(ctx context.Context) ([]event.RoomEvent, error) {
-	if p.onTop {
-		return nil, nil
-	}
-
-	// Fill the paginator's buffer until we have enough events in the buffer.
-	if p.needFill() {
-		if err := p.fill(ctx); err != nil {
-			if len(p.buffer) == 0 {
-				// Buffer completely drained. We got nothing.
-				return nil, err
-			}
-			// If we get an error and a non-empty buffer, then that probably
-			// means we're out of events in the room. Mark it as such and slice
-			// the rest.
-			p.onTop = true
-			return p.buffer, nil
-		}
-	}
+// indexEvents indexes all message events for searching. Redacted messages are
+// removed from the index.
+func (c *Client) indexEvents(events []event.RoomEvent) {
+	b := c.Index.Begin()
+	defer b.Commit()
 
-	// Calculate the boundary to which we should slice the buffer. The boundary
-	// will be calculated starting from the end of buffer.
-	bound := len(p.buffer) - p.limit
-	if bound < 0 {
-		bound = 0
+	for _, ev := range events {
+		indexEvent(b, ev)
 	}
-
-	// Reslice the buffer to not have the region that we're about to split away.
-	new := p.buffer[:bound]
-	// Use all latest n=p.limit events.
-	old := p.buffer[bound:]
-
-	p.buffer = new
-	return old, nil
 }
 
-// fill fills the paginator's buffer.
-func (p *RoomPaginator) fill(ctx context.Context) error {
-	if p.lastBatch == "" {
-		// Acquire the latest known pagination token. This means we'll have to
-		// seek through our cached events, but that's just how it works.
-		b, err := p.c.State.RoomPreviousBatch(p.roomID)
-		if err != nil {
-			return errors.Wrap(err, "failed to get previous batch")
-		}
-		p.lastBatch = b
-	}
-
-	if !p.drained {
-		p.drained = true
-
-		events, err := p.c.State.RoomTimeline(p.roomID)
-		if err == nil {
-			p.skip = len(events)
-			p.prepend(events)
-
-			if !p.needFill() {
-				return nil
-			}
-		}
-	}
-
-	for p.needFill() {
-		// https://spec.matrix.org/v1.1/client-server-api/#get_matrixclientv3roomsroomidmessages
-		// Fill up the last batch from start.
-		r, err := p.c.WithContext(ctx).RoomMessages(p.roomID, api.RoomMessagesQuery{
-			From:      p.lastBatch,
-			Direction: api.RoomMessagesBackward,
-			Limit:     100,
-		})
-		if err != nil {
-			return errors.Wrapf(err, "failed to query messages for room %q", p.roomID)
-		}
-
-		// If End is empty, then we can't go further.
-		if r.End == "" {
-			// log.Println("no more messages")
-			p.onTop = true
+// indexMessages indexes all message events in the given raw timeline. Redacted
+// messages are removed from the index.
+func indexMessages(b indexer.BatchIndexer, roomID matrix.RoomID, raws []event.RawEvent) {
+	for _, raw := range raws {
+		switch state.GuessType(raw) {
+		case event.TypeRoomMessage, event.TypeRoomRedaction:
+			indexEvent(b, sys.ParseTimeline(raw, roomID))
 		}
-
-		// Update the last batch.
-		// End is used to request earlier events if direction is backwards.
-		p.lastBatch = r.End
-
-		// log.Println("new  lastBatch =", p.lastBatch)
-		// log.Println("     start     =", r.Start)
-
-		// Only prepend known ones.
-		if p.skip > 0 {
-			new := p.skip
-			p.skip -= len(r.Chunk)
-
-			if new >= len(r.Chunk) {
-				continue
-			}
-			r.Chunk = r.Chunk[new:]
-		}
-
-		// Flip the message list. Code from SliceTricks.
-		for i, j := 0, len(r.Chunk)-1; i < j; i, j = i+1, j-1 {
-			r.Chunk[i], r.Chunk[j] = r.Chunk[j], r.Chunk[i]
-		}
-
-		// Seek until we stumble on the wanted events.
-		events := sys.ParseAllTimeline(r.Chunk, p.roomID)
-		p.prepend(events)
 	}
-
-	return nil
 }
 
-// needFill returns true if the paginator's buffer needs filling.
-func (p *RoomPaginator) needFill() bool {
-	return p.limit > len(p.buffer) && !p.onTop
-}
-
-// prepend prepends the given events into the paginator buffer.
-func (p *RoomPaginator) prepend(events []event.RoomEvent) {
-	if len(p.buffer)+len(events) == 0 {
-		p.buffer = nil
-		return
-	}
-
-	if len(events) == 0 {
-		return
+func indexEvent(b indexer.BatchIndexer, ev event.RoomEvent) {
+	switch ev := ev.(type) {
+	case *event.RoomMessageEvent:
+		b.IndexRoomMessage(ev)
+	case *event.RoomRedactionEvent:
+		b.DeleteRoomMessage(ev.RoomID, ev.Redacts)
 	}
-
-	// log.Println("current buffer state:")
-	// log.Printf("events | %s", events[0].RoomInfo().OriginServerTime.Time())
-	// log.Printf("       | ...")
-	// log.Printf("       | %s", events[len(events)-1].RoomInfo().OriginServerTime.Time())
-	// if len(p.buffer) > 0 {
-	// 	log.Printf("buffer | %s", p.buffer[0].RoomInfo().OriginServerTime.Time())
-	// 	log.Printf("       | ...")
-	// 	log.Printf("       | %s", p.buffer[len(p.buffer)-1].RoomInfo().OriginServerTime.Time())
-	// }
-	// log.Println("--------")
-
-	// TODO: optimize.
-	new := make([]event.RoomEvent, len(p.buffer)+len(events))
-
-	n := 0
-	n += copy(new[n:], events)
-	n += copy(new[n:], p.buffer)
-
-	p.buffer = new
 }
 
 // RoomTimeline queries the state cache for the timeline of the given room. If
@@ -923,6 +912,7 @@ func (p *RoomPaginator) prepend(events []event.RoomEvent) {
 // events is guaranteed to be latest last.
 func (c *Client) RoomTimeline(roomID matrix.RoomID) ([]event.RoomEvent, error) {
 	if events, err := c.State.RoomTimeline(roomID); err == nil {
+		c.decryptEvents(roomID, events)
 		return events, nil
 	}
 
@@ -935,6 +925,7 @@ func (c *Client) RoomTimeline(roomID matrix.RoomID) ([]event.RoomEvent, error) {
 	// Re-check the state for the timeline, because we don't want to miss out
 	// any events whil we were fetching the previous_batch string.
 	if events, err := c.State.RoomTimeline(roomID); err == nil {
+		c.decryptEvents(roomID, events)
 		return events, nil
 	}
 
@@ -947,7 +938,10 @@ func (c *Client) RoomTimeline(roomID matrix.RoomID) ([]event.RoomEvent, error) {
 		return nil, errors.Wrapf(err, "failed to get messages for room %q", roomID)
 	}
 
-	return sys.ParseAllTimeline(r.Chunk, roomID), nil
+	events := sys.ParseAllTimeline(r.Chunk, roomID)
+	c.decryptEvents(roomID, events)
+
+	return events, nil
 }
 
 // LatestMessage finds the latest room message event from the given list of
@@ -1077,76 +1071,124 @@ func (c *Client) SendRoomEvent(roomID matrix.RoomID, ev event.Event) error {
 		panic("SendRoomEvent: missing event type")
 	}
 
-	_, err := c.Client.RoomEventSend(roomID, ev.Info().Type, ev)
+	_, err := c.RoomEventSend(roomID, ev.Info().Type, ev)
 	return err
 }
 
-// Redact redacts a room event.
-func (c *Client) Redact(roomID matrix.RoomID, ev matrix.EventID, reason string) error {
-	_, err := c.RoomEventRedact(roomID, ev, reason)
-	return err
+// RoomEventSend sends the given event content into the room. If the room has
+// encryption enabled, then the event is encrypted first. It overrides the API
+// method of the same name.
+func (c *Client) RoomEventSend(
+	roomID matrix.RoomID, typ event.Type, content interface{}) (matrix.EventID, error) {
+
+	return c.RoomEventSendTxn(roomID, typ, content, api.NextTransactionID())
 }
 
-// PowerAction describes 1 out of the 4 actions in a PowerLevels event.
-type PowerAction uint8
+// RoomEventSendTxn is like RoomEventSend, except the caller gives the
+// transaction ID. Sending again with the same transaction ID will not create a
+// duplicate event.
+func (c *Client) RoomEventSendTxn(
+	roomID matrix.RoomID, typ event.Type, content interface{}, txnID string) (matrix.EventID, error) {
 
-const (
-	_ PowerAction = iota
-	BanAction
-	InviteAction
-	KickAction
-	RedactAction
-)
+	encryption := c.roomEncryption(roomID)
+	if encryption == nil {
+		return c.roomSend(roomID, typ, content, txnID)
+	}
 
-// HasPower checks if the current user can perform the given action inside the
-// given room.
-func (c *Client) HasPower(roomID matrix.RoomID, action PowerAction) bool {
-	e, err := c.RoomState(roomID, event.TypeRoomPowerLevels, "")
-	if err != nil {
-		// Theoretically, this means we have the power to override the room's
-		// power levels to be whatever we want, but we'll play nice and pretend
-		// that we don't have the power to do that, because that's just stupid.
-		return false
+	if c.crypto == nil {
+		return "", errors.New("cannot send to encrypted room: encryption is disabled")
 	}
 
-	ev := e.(*event.RoomPowerLevelsEvent)
+	if err := c.RoomEnsureMembers(roomID); err != nil {
+		log.Printf("cannot fetch members of %q before encrypting: %v", roomID, err)
+	}
 
-	powerLevel := 50
+	members, err := c.RoomMembers(roomID)
+	if err != nil {
+		return "", errors.Wrap(err, "cannot get members to encrypt for")
+	}
 
-	switch action {
-	case BanAction:
-		if ev.BanRequirement != nil {
-			powerLevel = *ev.BanRequirement
-		}
-	case InviteAction:
-		if ev.InviteRequirement != nil {
-			powerLevel = *ev.InviteRequirement
-		}
-	case KickAction:
-		if ev.KickRequirement != nil {
-			powerLevel = *ev.KickRequirement
-		}
-	case RedactAction:
-		if ev.RedactRequirement != nil {
-			powerLevel = *ev.RedactRequirement
+	userIDs := make([]matrix.UserID, 0, len(members))
+	for _, member := range members {
+		switch member.NewState {
+		case event.MemberJoined, event.MemberInvited:
+			userIDs = append(userIDs, member.UserID)
 		}
 	}
 
-	ourLevel := ev.UserDefault
-	if level, ok := ev.UserLevel[c.UserID]; ok {
-		ourLevel = level
+	encrypted, err := c.crypto.Encrypt(c.Client.Client, roomID, encryption, userIDs, typ, content)
+	if err != nil {
+		return "", errors.Wrap(err, "cannot encrypt event")
 	}
 
-	if ourLevel >= powerLevel {
-		return true
+	return c.roomSend(roomID, m.EncryptedEventType, encrypted, txnID)
+}
+
+func (c *Client) roomSend(
+	roomID matrix.RoomID, typ event.Type, content interface{}, txnID string) (matrix.EventID, error) {
+
+	var resp struct {
+		EventID matrix.EventID `json:"event_id"`
 	}
 
-	if c.IsRoomCreator(roomID) {
-		// User made this room, so they have full power.
-		return true
+	err := c.Request(
+		"PUT", c.Endpoints.RoomSend(roomID, typ, txnID), &resp,
+		httputil.WithToken(), httputil.WithJSONBody(content),
+	)
+	if err != nil {
+		return "", errors.Wrap(err, "error sending room event")
 	}
 
-	return false
+	return resp.EventID, nil
+}
+
+// RoomIsEncrypted returns true if the room has end-to-end encryption enabled.
+func (c *Client) RoomIsEncrypted(roomID matrix.RoomID) bool {
+	return c.roomEncryption(roomID) != nil
+}
+
+// roomEncryption returns the room's m.room.encryption event or nil if it's not
+// encrypted. Only the state is checked, since the event is never lazy-loaded.
+func (c *Client) roomEncryption(roomID matrix.RoomID) *m.EncryptionEvent {
+	e, err := c.State.RoomState(roomID, m.EncryptionEventType, "")
+	if err != nil {
+		return nil
+	}
+	ev, _ := e.(*m.EncryptionEvent)
+	return ev
+}
+
+// decryptEvent tries to decrypt the given event if it's still encrypted, which
+// happens when the room key arrives after the event itself. The event is
+// returned as-is if it cannot be decrypted.
+func (c *Client) decryptEvent(roomID matrix.RoomID, ev event.RoomEvent) event.RoomEvent {
+	if c.crypto == nil {
+		return ev
+	}
+
+	if _, ok := ev.(*m.EncryptedEvent); !ok {
+		return ev
+	}
+
+	raw, err := c.crypto.DecryptRaw(roomID, ev.Info().Raw)
+	if err != nil {
+		return ev
+	}
+
+	return sys.ParseTimeline(raw, roomID)
+}
+
+// decryptEvents calls decryptEvent on all events in place.
+func (c *Client) decryptEvents(roomID matrix.RoomID, events []event.RoomEvent) {
+	for i, ev := range events {
+		events[i] = c.decryptEvent(roomID, ev)
+	}
+}
+
+// Redact redacts a room event.
+func (c *Client) Redact(roomID matrix.RoomID, ev matrix.EventID, reason string) error {
+	_, err := c.RoomEventRedact(roomID, ev, reason)
+	return err
 }
 
 // IsRoomCreator returns true if the current user is the user who made this
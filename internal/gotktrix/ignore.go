@@ -0,0 +1,78 @@
+package gotktrix
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	"github.com/diamondburned/gotktrix/internal/gotktrix/events/m"
+	"github.com/diamondburned/gotrix/matrix"
+	"github.com/pkg/errors"
+)
+
+// IgnoredUsers returns the users that the user has ignored, sorted.
+func (c *Client) IgnoredUsers() []matrix.UserID {
+	e, err := c.State.UserEvent(m.IgnoredUserListEventType)
+	if err != nil {
+		return nil
+	}
+
+	userIDs := e.(*m.IgnoredUserListEvent).UserIDs()
+	sort.Slice(userIDs, func(i, j int) bool { return userIDs[i] < userIDs[j] })
+
+	return userIDs
+}
+
+// IgnoreUser adds the given user into the user's m.ignored_user_list. The
+// server stops sending events and invites from ignored users, and the events
+// that the client already has are hidden.
+func (c *Client) IgnoreUser(userID matrix.UserID) error {
+	return c.updateIgnoredUsers(func(list map[matrix.UserID]json.RawMessage) bool {
+		if _, ok := list[userID]; ok {
+			return false
+		}
+		list[userID] = json.RawMessage("{}")
+		return true
+	})
+}
+
+// UnignoreUser removes the given user from the user's m.ignored_user_list.
+func (c *Client) UnignoreUser(userID matrix.UserID) error {
+	return c.updateIgnoredUsers(func(list map[matrix.UserID]json.RawMessage) bool {
+		if _, ok := list[userID]; !ok {
+			return false
+		}
+		delete(list, userID)
+		return true
+	})
+}
+
+// updateIgnoredUsers fetches the latest m.ignored_user_list from the server
+// and saves it back if f returns true.
+func (c *Client) updateIgnoredUsers(f func(map[matrix.UserID]json.RawMessage) bool) error {
+	var list m.IgnoredUserListEvent
+
+	err := c.ClientConfig(string(m.IgnoredUserListEventType), &list)
+	if err != nil && matrix.StatusCode(err) != http.StatusNotFound {
+		return errors.Wrap(err, "failed to get ignored users")
+	}
+
+	if list.IgnoredUsers == nil {
+		list.IgnoredUsers = make(map[matrix.UserID]json.RawMessage, 1)
+	}
+
+	if !f(list.IgnoredUsers) {
+		return nil
+	}
+
+	if err := c.ClientConfigSet(string(m.IgnoredUserListEventType), list); err != nil {
+		return errors.Wrap(err, "failed to set ignored users")
+	}
+
+	// Apply the list now instead of waiting for the next sync.
+	list.Type = m.IgnoredUserListEventType
+	c.State.SetUserEvent(&list)
+	c.Registry.SetIgnoredUsers(list.UserIDs())
+
+	return nil
+}
@@ -1,6 +1,8 @@
 package indexer
 
 import (
+	"encoding/json"
+
 	"github.com/blevesearch/bleve/v2"
 	"github.com/diamondburned/gotrix/event"
 	"github.com/diamondburned/gotrix/matrix"
@@ -37,3 +39,63 @@ func (m *IndexedRoomMember) Index(b *bleve.Batch) error {
 func (m *IndexedRoomMember) Type() string {
 	return "RoomMember"
 }
+
+// IndexedRoomMessage is the data structure representing an indexed room
+// message.
+type IndexedRoomMessage struct {
+	ID     matrix.EventID `json:"id"`
+	Room   matrix.RoomID  `json:"room_id"`
+	Sender matrix.UserID  `json:"sender"`
+	Body   string         `json:"body"`
+	// Time is the Unix timestamp of the message in milliseconds.
+	Time int64 `json:"time"`
+}
+
+// indexRoomMessage converts the given message event. False is returned if the
+// message has nothing to index. Edits are indexed as the message that they
+// replace, so the latest edit always overrides the original.
+func indexRoomMessage(m *event.RoomMessageEvent) (IndexedRoomMessage, bool) {
+	idx := IndexedRoomMessage{
+		ID:     m.ID,
+		Room:   m.RoomID,
+		Sender: m.Sender,
+		Body:   m.StrippedBody(),
+		Time:   int64(m.OriginServerTime),
+	}
+
+	var body struct {
+		Content struct {
+			NewContent *struct {
+				Body string `json:"body"`
+			} `json:"m.new_content"`
+			RelatesTo struct {
+				RelType string         `json:"rel_type"`
+				EventID matrix.EventID `json:"event_id"`
+			} `json:"m.relates_to"`
+		} `json:"content"`
+	}
+
+	if m.Raw != nil && json.Unmarshal(m.Raw, &body) == nil {
+		if body.Content.RelatesTo.RelType == "m.replace" && body.Content.NewContent != nil {
+			idx.ID = body.Content.RelatesTo.EventID
+			idx.Body = body.Content.NewContent.Body
+		}
+	}
+
+	return idx, idx.ID != "" && idx.Body != ""
+}
+
+// roomMessageDocID returns the ID of the document of the given message.
+func roomMessageDocID(roomID matrix.RoomID, eventID matrix.EventID) string {
+	return string(roomID) + "\x02" + string(eventID)
+}
+
+// Index indexes m into the given Bleve indexer.
+func (m *IndexedRoomMessage) Index(b *bleve.Batch) error {
+	return b.Index(roomMessageDocID(m.Room, m.ID), m)
+}
+
+// Type returns RoomMessage.
+func (m *IndexedRoomMessage) Type() string {
+	return "RoomMessage"
+}
@@ -3,9 +3,13 @@ package indexer
 import (
 	"context"
 	"log"
+	"os"
 	"sync"
+	"time"
 
 	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/analysis/analyzer/keyword"
+	"github.com/blevesearch/bleve/v2/mapping"
 	"github.com/blevesearch/bleve/v2/search"
 	"github.com/blevesearch/bleve/v2/search/query"
 	"github.com/diamondburned/gotrix/event"
@@ -28,6 +32,34 @@ func doInit() {
 	})
 }
 
+// openConfig is the runtime config used to open an existing index. The timeout
+// is the same as the state database's, so that a locked index doesn't block
+// forever.
+var openConfig = map[string]interface{}{
+	"bolt_timeout": "10s",
+}
+
+// indexVersion is the version of the index mapping. Indices of other versions
+// are recreated, since their documents were indexed differently.
+const indexVersion = "1"
+
+var indexVersionKey = []byte("gotktrix_version")
+
+// newIndexMapping creates the mapping of a new index. IDs are indexed as
+// keywords, so that they're matched exactly instead of being split into words.
+func newIndexMapping() mapping.IndexMapping {
+	id := bleve.NewTextFieldMapping()
+	id.Analyzer = keyword.Name
+
+	message := bleve.NewDocumentMapping()
+	message.AddFieldMappingsAt("room_id", id)
+	message.AddFieldMappingsAt("sender", id)
+
+	m := bleve.NewIndexMapping()
+	m.AddDocumentMapping((*IndexedRoomMessage)(nil).Type(), message)
+	return m
+}
+
 // Indexer provides indexing of many types of Matrix data for querying.
 type Indexer struct {
 	idx bleve.Index
@@ -39,16 +71,38 @@ func Open(path string) (*Indexer, error) {
 
 	// Work around Bleve's inherent TOCTTOU racy API.
 	var idx bleve.Index
-	// TODO: index database versioning
 	for {
-		x, err := bleve.Open(path)
+		x, err := bleve.OpenUsing(path, openConfig)
 		if err == nil {
-			idx = x
-			break
+			version, _ := x.GetInternal(indexVersionKey)
+			if string(version) == indexVersion {
+				idx = x
+				break
+			}
+
+			// The index is only a cache, so it can be rebuilt from scratch.
+			log.Printf("indexer: index version %q is outdated, recreating", version)
+			x.Close()
+
+			if err := os.RemoveAll(path); err != nil {
+				return nil, errors.Wrap(err, "failed to remove outdated index")
+			}
+			continue
 		}
 
-		x, err = bleve.New(path, bleve.NewIndexMapping())
+		if !errors.Is(err, bleve.ErrorIndexPathDoesNotExist) &&
+			!errors.Is(err, bleve.ErrorIndexMetaMissing) {
+			// The index exists but cannot be opened, possibly because another
+			// process has it open. Creating a new one would fail forever.
+			return nil, errors.Wrap(err, "failed to open bleve")
+		}
+
+		x, err = bleve.New(path, newIndexMapping())
 		if err == nil {
+			if err := x.SetInternal(indexVersionKey, []byte(indexVersion)); err != nil {
+				x.Close()
+				return nil, errors.Wrap(err, "failed to save index version")
+			}
 			idx = x
 			break
 		}
@@ -64,17 +118,25 @@ func Open(path string) (*Indexer, error) {
 	return &Indexer{idx}, nil
 }
 
+// Close closes the index.
+func (idx *Indexer) Close() error {
+	return idx.idx.Close()
+}
+
 // BatchIndexer wraps around a Bleve indexer for batch writing.
 type BatchIndexer struct {
 	idx bleve.Index
 	b   *bleve.Batch
+	// deleted has the IDs of the messages deleted in this batch.
+	deleted map[string]bool
 }
 
 // Begin creates a new batch indexer.
 func (idx *Indexer) Begin() BatchIndexer {
 	return BatchIndexer{
-		idx: idx.idx,
-		b:   idx.idx.NewBatch(),
+		idx:     idx.idx,
+		b:       idx.idx.NewBatch(),
+		deleted: make(map[string]bool),
 	}
 }
 
@@ -91,6 +153,41 @@ func (b BatchIndexer) IndexRoomMember(m *event.RoomMemberEvent) {
 	b.index(&data)
 }
 
+// IndexRoomMessage indexes the given message event. Messages without any text
+// and deleted messages are ignored.
+func (b BatchIndexer) IndexRoomMessage(m *event.RoomMessageEvent) {
+	data, ok := indexRoomMessage(m)
+	if ok && !b.isDeleted(roomMessageDocID(data.Room, data.ID)) {
+		b.index(&data)
+	}
+}
+
+// DeleteRoomMessage removes the given message from the index, which is done
+// when it's redacted. The message is remembered as deleted, so that its edits
+// don't add it back, since they may be paginated after the redaction.
+func (b BatchIndexer) DeleteRoomMessage(roomID matrix.RoomID, eventID matrix.EventID) {
+	id := roomMessageDocID(roomID, eventID)
+
+	b.b.Delete(id)
+	b.b.SetInternal(deletedKey(id), []byte{1})
+	b.deleted[id] = true
+}
+
+func (b BatchIndexer) isDeleted(id string) bool {
+	if b.deleted[id] {
+		return true
+	}
+
+	v, err := b.idx.GetInternal(deletedKey(id))
+	return err == nil && v != nil
+}
+
+// deletedKey returns the internal key that marks the document with the given
+// ID as deleted.
+func deletedKey(id string) []byte {
+	return []byte("deleted\x02" + id)
+}
+
 type indexable interface {
 	Index(*bleve.Batch) error
 }
@@ -188,3 +285,122 @@ func (s *RoomMemberSearcher) Search(ctx context.Context, str string) []IndexedRo
 
 	return s.res
 }
+
+// MessageFilter narrows down a message search. The zero value matches all
+// messages.
+type MessageFilter struct {
+	// Room, if not empty, only matches messages in this room.
+	Room matrix.RoomID
+	// Sender, if not empty, only matches messages sent by this user.
+	Sender matrix.UserID
+	// After and Before, if not zero, only match messages sent within the
+	// range.
+	After  time.Time
+	Before time.Time
+}
+
+func (f MessageFilter) queries() []query.Query {
+	var queries []query.Query
+
+	if f.Room != "" {
+		queries = append(queries, &query.TermQuery{
+			Term:     string(f.Room),
+			FieldVal: "room_id",
+		})
+	}
+
+	if f.Sender != "" {
+		queries = append(queries, &query.TermQuery{
+			Term:     string(f.Sender),
+			FieldVal: "sender",
+		})
+	}
+
+	if !f.After.IsZero() || !f.Before.IsZero() {
+		var min, max *float64
+		if !f.After.IsZero() {
+			v := float64(f.After.UnixMilli())
+			min = &v
+		}
+		if !f.Before.IsZero() {
+			v := float64(f.Before.UnixMilli())
+			max = &v
+		}
+
+		rangeQuery := query.NewNumericRangeQuery(min, max)
+		rangeQuery.SetField("time")
+		queries = append(queries, rangeQuery)
+	}
+
+	return queries
+}
+
+// MessageSearcher searches indexed room messages.
+type MessageSearcher struct {
+	idx    bleve.Index
+	filter MessageFilter
+	size   int
+	res    []IndexedRoomMessage
+}
+
+// SearchMessages returns a new instance of MessageSearcher that the client can
+// use to search messages matching the given filter.
+func (idx *Indexer) SearchMessages(filter MessageFilter, limit int) MessageSearcher {
+	if limit < 1 {
+		limit = searchLimit
+	}
+
+	return MessageSearcher{
+		idx:    idx.idx,
+		filter: filter,
+		size:   limit,
+		res:    make([]IndexedRoomMessage, 0, limit),
+	}
+}
+
+// Search looks up the indexing database and searches for messages containing
+// the given string. The results are sorted by relevance, then by time with the
+// latest first. The returned list is valid until the next time Search is
+// called.
+func (s *MessageSearcher) Search(ctx context.Context, str string) []IndexedRoomMessage {
+	// Match every word, but allow slight typos in each.
+	body := query.NewMatchQuery(str)
+	body.SetField("body")
+	body.SetFuzziness(1)
+	body.SetOperator(query.MatchQueryOperatorAnd)
+
+	qry := query.NewConjunctionQuery(append(s.filter.queries(), body))
+
+	req := bleve.NewSearchRequestOptions(qry, s.size, 0, false)
+	req.Fields = []string{"id", "room_id", "sender", "body", "time"}
+	req.SortByCustom(search.SortOrder{
+		&search.SortScore{Desc: true},
+		&search.SortField{Field: "time", Desc: true},
+	})
+
+	results, err := s.idx.SearchInContext(ctx, req)
+	if err != nil {
+		log.Println("indexer: query error:", err)
+		return nil
+	}
+
+	s.res = s.res[:0]
+	for _, res := range results.Hits {
+		msg := IndexedRoomMessage{}
+		msg.ID = matrix.EventID(stringField(res.Fields, "id"))
+		msg.Room = matrix.RoomID(stringField(res.Fields, "room_id"))
+		msg.Sender = matrix.UserID(stringField(res.Fields, "sender"))
+		msg.Body = stringField(res.Fields, "body")
+		if t, ok := res.Fields["time"].(float64); ok {
+			msg.Time = int64(t)
+		}
+		s.res = append(s.res, msg)
+	}
+
+	return s.res
+}
+
+func stringField(fields map[string]interface{}, name string) string {
+	str, _ := fields[name].(string)
+	return str
+}
@@ -0,0 +1,66 @@
+package indexer
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/diamondburned/gotrix/event"
+	"github.com/diamondburned/gotrix/matrix"
+)
+
+const testRoomID matrix.RoomID = "!room:example.com"
+
+func testMessage(id matrix.EventID, raw string) *event.RoomMessageEvent {
+	return &event.RoomMessageEvent{
+		RoomEventInfo: event.RoomEventInfo{
+			EventInfo: event.EventInfo{Type: event.TypeRoomMessage, Raw: event.RawEvent(raw)},
+			ID:        id,
+			RoomID:    testRoomID,
+			Sender:    "@alice:example.com",
+		},
+		MessageType: event.RoomMessageText,
+		Body:        "secret plans",
+	}
+}
+
+func TestDeleteRoomMessage(t *testing.T) {
+	idx, err := Open(filepath.Join(t.TempDir(), "index"))
+	if err != nil {
+		t.Fatal("cannot open index:", err)
+	}
+	defer idx.Close()
+
+	search := func() int {
+		s := idx.SearchMessages(MessageFilter{}, 0)
+		return len(s.Search(context.Background(), "plans"))
+	}
+
+	b := idx.Begin()
+	b.IndexRoomMessage(testMessage("$1", ""))
+	b.Commit()
+
+	if n := search(); n != 1 {
+		t.Fatalf("found %d messages before redacting, expected 1", n)
+	}
+
+	b = idx.Begin()
+	b.DeleteRoomMessage(testRoomID, "$1")
+	b.Commit()
+
+	if n := search(); n != 0 {
+		t.Fatalf("found %d messages after redacting, expected 0", n)
+	}
+
+	// An edit of the redacted message is paginated after the redaction.
+	b = idx.Begin()
+	b.IndexRoomMessage(testMessage("$2", `{"content":{
+		"m.new_content":{"msgtype":"m.text","body":"secret plans"},
+		"m.relates_to":{"rel_type":"m.replace","event_id":"$1"}
+	}}`))
+	b.Commit()
+
+	if n := search(); n != 0 {
+		t.Fatalf("found %d messages after indexing an edit, expected 0", n)
+	}
+}
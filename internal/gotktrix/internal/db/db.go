@@ -116,6 +116,22 @@ func NewKVFile(path string) (*KV, error) {
 	}, nil
 }
 
+// IsLocked returns true if the database file at the given path is opened by
+// another process. Since bbolt locks the whole file, the database cannot be
+// opened again until that process closes it.
+func IsLocked(path string) bool {
+	db, err := bbolt.Open(path, 0, &bbolt.Options{
+		Timeout:  50 * time.Millisecond,
+		ReadOnly: true,
+	})
+	if err != nil {
+		return errors.Is(err, bbolt.ErrTimeout)
+	}
+
+	db.Close()
+	return false
+}
+
 // DropPrefix drops the whole given prefix.
 func (kv *KV) DropPrefix(path NodePath) error {
 	return kv.db.Update(func(tx *bbolt.Tx) error {
@@ -414,6 +414,89 @@ func (n Node) EachReverse(fn func(k string, b []byte, len int) error) error {
 	return n.each(true, fn)
 }
 
+// EachReverseBefore is like EachReverse, except the iteration starts from the
+// key right before the given key. If k is empty, then the iteration starts from
+// the last key.
+func (n Node) EachReverseBefore(k string, fn func(k string, b []byte) error) error {
+	return n.TxView(func(n Node) error {
+		b, err := n.bucket()
+		if err != nil {
+			if errors.Is(err, ErrKeyNotFound) {
+				return nil
+			}
+			return err
+		}
+
+		cursor := b.Cursor()
+
+		var key, val []byte
+		if k == "" {
+			key, val = cursor.Last()
+		} else {
+			key, val = cursor.Seek([]byte(k))
+			if key == nil {
+				// Every key is before k.
+				key, val = cursor.Last()
+			} else {
+				key, val = cursor.Prev()
+			}
+		}
+
+		for ; key != nil; key, val = cursor.Prev() {
+			if err := fn(string(key), val); err != nil {
+				if errors.Is(err, EachBreak) {
+					return nil
+				}
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// DropUntil drops all values with keys up to and including the given key. Like
+// DropExceptLast, it relies on the keys being sorted properly.
+func (n Node) DropUntil(k string) error {
+	return n.TxUpdate(func(n Node) error {
+		b, err := n.bucket()
+		if err != nil {
+			if errors.Is(err, ErrKeyNotFound) {
+				return nil
+			}
+			return err
+		}
+
+		var keys, buckets [][]byte
+
+		// Deleting while moving the cursor forward skips keys, so collect them
+		// first.
+		cursor := b.Cursor()
+		for key, v := cursor.First(); key != nil && string(key) <= k; key, v = cursor.Next() {
+			key = append([]byte(nil), key...)
+			if v == nil {
+				buckets = append(buckets, key)
+			} else {
+				keys = append(keys, key)
+			}
+		}
+
+		var lastError error
+
+		for _, key := range keys {
+			if err := b.Delete(key); err != nil {
+				lastError = err
+			}
+		}
+
+		for _, key := range buckets {
+			b.DeleteBucket(key)
+		}
+
+		return lastError
+	})
+}
+
 func (n Node) each(rev bool, fn func(k string, b []byte, len int) error) error {
 	return n.TxView(func(n Node) error {
 		b, err := n.bucket()
@@ -0,0 +1,236 @@
+// Package e2ee implements end-to-end encryption for gotktrix. It manages the
+// device's Olm account and one-time keys, tracks the devices of other users,
+// exchanges Megolm room keys over to-device messages, and decrypts and encrypts
+// room events.
+//
+// The crypto state is kept in its own database so that wiping the state cache
+// does not lose any keys. The private keys in it are encrypted using a pickle
+// key that is kept in a secret driver.
+package e2ee
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"log"
+	"sync"
+
+	"github.com/diamondburned/gotktrix/internal/gotktrix/internal/db"
+	"github.com/diamondburned/gotktrix/internal/gotktrix/internal/e2ee/olm"
+	"github.com/diamondburned/gotktrix/internal/secret"
+	"github.com/diamondburned/gotrix"
+	"github.com/diamondburned/gotrix/api"
+	"github.com/diamondburned/gotrix/matrix"
+	"github.com/pkg/errors"
+)
+
+const (
+	// OlmAlgorithm is the algorithm used for to-device messages.
+	OlmAlgorithm = "m.olm.v1.curve25519-aes-sha2"
+	// MegolmAlgorithm is the algorithm used for room messages.
+	MegolmAlgorithm = "m.megolm.v1.aes-sha2"
+)
+
+// Version is the incremental version of the crypto database. Unlike the state
+// database, a mismatch is an error instead of a wipe, since wiping would lose
+// all keys.
+//
+// Version 2 encrypts the account and sessions, which version 1 kept in
+// plaintext.
+const Version = 2
+
+// pickleKeySize is the size of the pickle key, which is an AES-256 key.
+const pickleKeySize = 32
+
+// PickleKey returns the key that the crypto database of the given user is
+// encrypted with. It is kept in the given secret driver, and a new one is made
+// if there's none yet.
+func PickleKey(driver secret.Driver, userID matrix.UserID) ([]byte, error) {
+	if driver == nil {
+		return nil, errors.New("no secret driver to keep the pickle key in")
+	}
+
+	name := "pickle-key:" + string(userID)
+
+	b, err := driver.Get(name)
+	if err == nil {
+		key, err := base64.StdEncoding.DecodeString(string(b))
+		if err != nil || len(key) != pickleKeySize {
+			return nil, errors.New("invalid pickle key")
+		}
+		return key, nil
+	}
+
+	if !errors.Is(err, secret.ErrNotFound) && !errors.Is(err, secret.ErrUnsupportedPlatform) {
+		return nil, errors.Wrap(err, "failed to get pickle key")
+	}
+
+	key := make([]byte, pickleKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, errors.Wrap(err, "failed to generate pickle key")
+	}
+
+	if err := driver.Set(name, []byte(base64.StdEncoding.EncodeToString(key))); err != nil {
+		return nil, errors.Wrap(err, "failed to save pickle key")
+	}
+
+	return key, nil
+}
+
+// Machine is the encryption state machine of a single device.
+type Machine struct {
+	// mu guards the account and the store. It is never held while talking to
+	// the homeserver, since decrypting needs it and may happen on the UI
+	// thread.
+	mu sync.Mutex
+	// sendMu serializes Encrypt calls.
+	sendMu sync.Mutex
+	// uploadMu serializes key uploads.
+	uploadMu sync.Mutex
+
+	client   *api.Client
+	store    store
+	account  *olm.Account
+	userID   matrix.UserID
+	deviceID matrix.DeviceID
+
+	// replenishing is true if one-time keys are being uploaded in the
+	// background.
+	replenishing bool
+	// deviceChanges counts the device list changes of each user, so that a
+	// device query doesn't clear a change that arrives while it's running.
+	deviceChanges map[matrix.UserID]int
+}
+
+// New opens the crypto database at the given path for the given client. The
+// client must have both its UserID and DeviceID set. The database is encrypted
+// using the given pickle key, which PickleKey returns. If the database belongs
+// to a different device, then it is wiped and a new account is created.
+func New(path string, client *api.Client, pickleKey []byte) (*Machine, error) {
+	if client.UserID == "" || client.DeviceID == "" {
+		return nil, errors.New("client is missing user or device ID")
+	}
+
+	kv, err := db.NewKVFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := newStore(kv, pickleKey)
+	if err != nil {
+		kv.Close()
+		return nil, err
+	}
+
+	var owner deviceOwner
+	s.top.GetAny("owner", &owner)
+
+	if owner.Version > Version {
+		kv.Close()
+		return nil, errors.Errorf("crypto database version %d is too new", owner.Version)
+	}
+
+	switch {
+	case owner.UserID != client.UserID || owner.DeviceID != client.DeviceID:
+		if owner.UserID != "" {
+			log.Printf("crypto: device changed from %s to %s, wiping keys", owner.DeviceID, client.DeviceID)
+		}
+
+		if err := kv.DropPrefix(s.top.path); err != nil {
+			kv.Close()
+			return nil, errors.Wrap(err, "failed to wipe old crypto state")
+		}
+
+		owner = deviceOwner{
+			UserID:   client.UserID,
+			DeviceID: client.DeviceID,
+			Version:  Version,
+		}
+
+		if err := s.top.SetAny("owner", owner); err != nil {
+			kv.Close()
+			return nil, errors.Wrap(err, "failed to write crypto owner")
+		}
+
+	case owner.Version < Version:
+		if err := s.sealPlaintext(); err != nil {
+			kv.Close()
+			return nil, errors.Wrap(err, "failed to encrypt old crypto state")
+		}
+
+		owner.Version = Version
+
+		if err := s.top.SetAny("owner", owner); err != nil {
+			kv.Close()
+			return nil, errors.Wrap(err, "failed to write crypto owner")
+		}
+	}
+
+	account, err := s.account()
+	if err != nil && s.top.Exists("account") {
+		// Don't replace an account that can't be decrypted, since its device
+		// keys have already been uploaded.
+		kv.Close()
+		return nil, errors.Wrap(err, "failed to decrypt account, the pickle key may have changed")
+	}
+
+	if err != nil {
+		account, err = olm.NewAccount()
+		if err != nil {
+			kv.Close()
+			return nil, errors.Wrap(err, "failed to create account")
+		}
+
+		if err := s.setAccount(account); err != nil {
+			kv.Close()
+			return nil, errors.Wrap(err, "failed to save account")
+		}
+	}
+
+	return &Machine{
+		client:   client,
+		store:    s,
+		account:  account,
+		userID:   client.UserID,
+		deviceID: client.DeviceID,
+
+		deviceChanges: map[matrix.UserID]int{},
+	}, nil
+}
+
+type deviceOwner struct {
+	UserID   matrix.UserID   `json:"user_id"`
+	DeviceID matrix.DeviceID `json:"device_id"`
+	Version  int             `json:"version"`
+}
+
+// Close closes the crypto database.
+func (m *Machine) Close() error {
+	return m.store.kv.Close()
+}
+
+// IdentityKey returns the device's Curve25519 identity key.
+func (m *Machine) IdentityKey() string {
+	return m.account.Curve25519()
+}
+
+// FingerprintKey returns the device's Ed25519 fingerprint key.
+func (m *Machine) FingerprintKey() string {
+	return m.account.Ed25519()
+}
+
+// Wrap returns a state wrapper that decrypts the sync response and handles
+// all to-device and device list changes before giving it to the given state.
+// The returned state should be the outermost wrapper.
+func (m *Machine) Wrap(state gotrix.State) gotrix.State {
+	return wrapper{state, m}
+}
+
+type wrapper struct {
+	gotrix.State
+	m *Machine
+}
+
+func (w wrapper) AddEvents(sync *api.SyncResponse) error {
+	w.m.HandleSync(sync)
+	return w.State.AddEvents(sync)
+}
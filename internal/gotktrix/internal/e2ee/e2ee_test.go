@@ -0,0 +1,457 @@
+package e2ee
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/diamondburned/gotktrix/internal/gotktrix/events/m"
+	"github.com/diamondburned/gotktrix/internal/gotktrix/internal/e2ee/olm"
+	"github.com/diamondburned/gotrix/api"
+	"github.com/diamondburned/gotrix/api/httputil"
+	"github.com/diamondburned/gotrix/event"
+	"github.com/diamondburned/gotrix/matrix"
+)
+
+const testRoomID matrix.RoomID = "!room:example.com"
+
+var testSettings = &m.EncryptionEvent{Algorithm: MegolmAlgorithm}
+
+func newTestMachine(t *testing.T, userID matrix.UserID, deviceID matrix.DeviceID) *Machine {
+	t.Helper()
+
+	client := &api.Client{UserID: userID, DeviceID: deviceID}
+	key := make([]byte, pickleKeySize)
+
+	m, err := New(filepath.Join(t.TempDir(), "crypto"), client, key)
+	if err != nil {
+		t.Fatal("cannot create machine:", err)
+	}
+	t.Cleanup(func() { m.Close() })
+
+	// Know our own device, so that nothing is queried from the homeserver.
+	trustDevice(t, m, m)
+	return m
+}
+
+// trustDevice adds the device of other to the devices known by m.
+func trustDevice(t *testing.T, m, other *Machine) {
+	t.Helper()
+	addDevice(t, m, other.userID, other.deviceID, deviceInfo{
+		Curve25519: other.account.Curve25519(),
+		Ed25519:    other.account.Ed25519(),
+	})
+}
+
+func addDevice(t *testing.T, m *Machine, userID matrix.UserID, deviceID matrix.DeviceID, info deviceInfo) {
+	t.Helper()
+
+	devices, ok := m.store.userDevices(userID)
+	if !ok || devices.Devices == nil {
+		devices = &userDevices{Devices: map[matrix.DeviceID]deviceInfo{}}
+	}
+	devices.Devices[deviceID] = info
+
+	if err := m.store.setUserDevices(userID, devices); err != nil {
+		t.Fatal("cannot save devices:", err)
+	}
+}
+
+// sendRoomKey sends the room key of the outbound session of from in the room
+// to the device of to over Olm, the way that a sync would give it to to.
+func sendRoomKey(t *testing.T, from, to *Machine, roomID matrix.RoomID) error {
+	t.Helper()
+
+	if err := to.account.GenerateOneTimeKeys(1); err != nil {
+		t.Fatal("cannot generate one-time keys:", err)
+	}
+
+	var otk string
+	for _, key := range to.account.UnpublishedOneTimeKeys() {
+		otk = key
+	}
+
+	device := deviceRef{
+		UserID:   to.userID,
+		DeviceID: to.deviceID,
+		deviceInfo: deviceInfo{
+			Curve25519: to.account.Curve25519(),
+			Ed25519:    to.account.Ed25519(),
+		},
+	}
+
+	olmSession, err := olm.NewOutboundSession(from.account, device.Curve25519, otk)
+	if err != nil {
+		t.Fatal("cannot create olm session:", err)
+	}
+	if err := from.store.setOlmSessionList(device.Curve25519, olmSessionList{olmSession}); err != nil {
+		t.Fatal("cannot save olm session:", err)
+	}
+
+	session, err := from.outboundSession(roomID, testSettings, []matrix.UserID{from.userID})
+	if err != nil {
+		t.Fatal("cannot get outbound session:", err)
+	}
+	if err := from.store.setOutboundGroupSession(roomID, session); err != nil {
+		t.Fatal("cannot save outbound session:", err)
+	}
+
+	content, err := from.olmEncrypt(device, RoomKeyEventType, roomKeyContent{
+		Algorithm:  MegolmAlgorithm,
+		RoomID:     roomID,
+		SessionID:  session.Session.ID(),
+		SessionKey: session.Session.SessionKey(),
+	})
+	if err != nil {
+		t.Fatal("cannot encrypt room key:", err)
+	}
+
+	b, err := json.Marshal(content)
+	if err != nil {
+		t.Fatal("cannot marshal room key:", err)
+	}
+
+	ev, err := to.decryptOlmEvent(toDeviceEvent{
+		Type:    encryptedEventType,
+		Sender:  from.userID,
+		Content: b,
+	})
+	if err != nil {
+		return err
+	}
+
+	return to.handleOlmEvent(ev)
+}
+
+func encryptMessage(t *testing.T, from *Machine, roomID matrix.RoomID, body string) *m.EncryptedEvent {
+	t.Helper()
+
+	encrypted, err := from.Encrypt(nil, roomID, testSettings, []matrix.UserID{from.userID},
+		event.TypeRoomMessage, map[string]string{"msgtype": "m.text", "body": body})
+	if err != nil {
+		t.Fatal("cannot encrypt:", err)
+	}
+
+	return encrypted
+}
+
+func rawEvent(t *testing.T, eventID matrix.EventID, sender matrix.UserID, content *m.EncryptedEvent) event.RawEvent {
+	t.Helper()
+
+	b, err := json.Marshal(map[string]interface{}{
+		"type":      encryptedEventType,
+		"event_id":  eventID,
+		"sender":    sender,
+		"room_id":   testRoomID,
+		"content":   content,
+		"origin_ts": 0,
+	})
+	if err != nil {
+		t.Fatal("cannot marshal event:", err)
+	}
+
+	return b
+}
+
+func assertDecrypted(t *testing.T, raw event.RawEvent, body string) {
+	t.Helper()
+
+	var ev struct {
+		Type    event.Type `json:"type"`
+		Content struct {
+			Body string `json:"body"`
+		} `json:"content"`
+	}
+
+	if err := json.Unmarshal(raw, &ev); err != nil {
+		t.Fatal("invalid decrypted event:", err)
+	}
+
+	if ev.Type != event.TypeRoomMessage || ev.Content.Body != body {
+		t.Fatalf("unexpected decrypted event %s", raw)
+	}
+}
+
+func TestRoomKey(t *testing.T) {
+	alice := newTestMachine(t, "@alice:example.com", "ALICE")
+	bob := newTestMachine(t, "@bob:example.com", "BOB")
+
+	// Bob is known to have no devices, so they aren't queried.
+	if err := alice.store.setUserDevices(bob.userID, &userDevices{}); err != nil {
+		t.Fatal("cannot save devices:", err)
+	}
+
+	if err := sendRoomKey(t, bob, alice, testRoomID); err == nil {
+		t.Fatal("room key from an unknown device was accepted")
+	}
+
+	// Bob's device claims a different fingerprint key than the one that it
+	// signs the room key with.
+	addDevice(t, alice, bob.userID, bob.deviceID, deviceInfo{
+		Curve25519: bob.account.Curve25519(),
+		Ed25519:    alice.account.Ed25519(),
+	})
+
+	if err := sendRoomKey(t, bob, alice, testRoomID); err == nil {
+		t.Fatal("room key with a mismatched signing key was accepted")
+	}
+
+	trustDevice(t, alice, bob)
+
+	if err := sendRoomKey(t, bob, alice, testRoomID); err != nil {
+		t.Fatal("cannot receive room key:", err)
+	}
+
+	outbound, err := bob.store.outboundGroupSession(testRoomID)
+	if err != nil {
+		t.Fatal("bob has no outbound session:", err)
+	}
+
+	inbound, err := alice.store.inboundGroupSession(testRoomID, outbound.Session.ID())
+	if err != nil {
+		t.Fatal("alice has no inbound session:", err)
+	}
+
+	if inbound.SenderKey != bob.account.Curve25519() || inbound.SigningKey != bob.account.Ed25519() {
+		t.Fatalf("inbound session has unexpected keys %q and %q", inbound.SenderKey, inbound.SigningKey)
+	}
+}
+
+func TestDecryptRaw(t *testing.T) {
+	alice := newTestMachine(t, "@alice:example.com", "ALICE")
+	bob := newTestMachine(t, "@bob:example.com", "BOB")
+	trustDevice(t, alice, bob)
+
+	if err := sendRoomKey(t, bob, alice, testRoomID); err != nil {
+		t.Fatal("cannot receive room key:", err)
+	}
+
+	encrypted := encryptMessage(t, bob, testRoomID, "hello")
+	raw := rawEvent(t, "$1", bob.userID, encrypted)
+
+	decrypted, err := alice.DecryptRaw(testRoomID, raw)
+	if err != nil {
+		t.Fatal("cannot decrypt:", err)
+	}
+	assertDecrypted(t, decrypted, "hello")
+
+	// Bob can read his own messages.
+	decrypted, err = bob.DecryptRaw(testRoomID, raw)
+	if err != nil {
+		t.Fatal("bob cannot decrypt his own message:", err)
+	}
+	assertDecrypted(t, decrypted, "hello")
+
+	t.Run("same event", func(t *testing.T) {
+		if _, err := alice.DecryptRaw(testRoomID, raw); err != nil {
+			t.Fatal("cannot decrypt the same event again:", err)
+		}
+	})
+
+	t.Run("replay", func(t *testing.T) {
+		replayed := rawEvent(t, "$2", bob.userID, encrypted)
+		if _, err := alice.DecryptRaw(testRoomID, replayed); err == nil {
+			t.Fatal("replayed message index was decrypted")
+		}
+	})
+
+	t.Run("missing sender key", func(t *testing.T) {
+		noKey := *encryptMessage(t, bob, testRoomID, "no key")
+		noKey.SenderKey = ""
+
+		decrypted, err := alice.DecryptRaw(testRoomID, rawEvent(t, "$3", bob.userID, &noKey))
+		if err != nil {
+			t.Fatal("cannot decrypt without sender key:", err)
+		}
+		assertDecrypted(t, decrypted, "no key")
+	})
+
+	t.Run("sender key mismatch", func(t *testing.T) {
+		wrongKey := *encryptMessage(t, bob, testRoomID, "wrong key")
+		wrongKey.SenderKey = alice.account.Curve25519()
+
+		if _, err := alice.DecryptRaw(testRoomID, rawEvent(t, "$4", bob.userID, &wrongKey)); err == nil {
+			t.Fatal("message with a mismatched sender key was decrypted")
+		}
+	})
+
+	t.Run("sender mismatch", func(t *testing.T) {
+		encrypted := encryptMessage(t, bob, testRoomID, "impostor")
+
+		// Mallory sends Bob's message as their own.
+		if _, err := alice.DecryptRaw(testRoomID, rawEvent(t, "$5", "@mallory:example.com", encrypted)); err == nil {
+			t.Fatal("message from a device of someone else was decrypted")
+		}
+	})
+
+	t.Run("room mismatch", func(t *testing.T) {
+		const otherRoomID matrix.RoomID = "!other:example.com"
+
+		encrypted := encryptMessage(t, bob, testRoomID, "elsewhere")
+
+		// Pretend that the room key was given for another room.
+		session, err := alice.store.inboundGroupSession(testRoomID, encrypted.SessionID)
+		if err != nil {
+			t.Fatal("alice has no inbound session:", err)
+		}
+		if err := alice.store.setInboundGroupSession(otherRoomID, session); err != nil {
+			t.Fatal("cannot save inbound session:", err)
+		}
+
+		if _, err := alice.DecryptRaw(otherRoomID, rawEvent(t, "$6", bob.userID, encrypted)); err == nil {
+			t.Fatal("message of another room was decrypted")
+		}
+	})
+}
+
+func TestEncryptRotation(t *testing.T) {
+	const carol matrix.UserID = "@carol:example.com"
+
+	bob := newTestMachine(t, "@bob:example.com", "BOB")
+
+	// Carol has no devices, but she's still a member.
+	if err := bob.store.setUserDevices(carol, &userDevices{}); err != nil {
+		t.Fatal("cannot save devices:", err)
+	}
+
+	encrypt := func(settings *m.EncryptionEvent, userIDs ...matrix.UserID) string {
+		t.Helper()
+
+		encrypted, err := bob.Encrypt(nil, testRoomID, settings, userIDs,
+			event.TypeRoomMessage, map[string]string{"msgtype": "m.text", "body": "hi"})
+		if err != nil {
+			t.Fatal("cannot encrypt:", err)
+		}
+
+		return encrypted.SessionID
+	}
+
+	members := []matrix.UserID{bob.userID, carol}
+
+	first := encrypt(testSettings, members...)
+	if id := encrypt(testSettings, members...); id != first {
+		t.Fatal("session was rotated for no reason")
+	}
+
+	// Carol leaves.
+	second := encrypt(testSettings, bob.userID)
+	if second == first {
+		t.Fatal("session wasn't rotated after a member left")
+	}
+
+	// The session was used once, so a limit of 2 messages is hit after one
+	// more message.
+	limited := &m.EncryptionEvent{Algorithm: MegolmAlgorithm, RotationPeriodMsgs: 2}
+	if id := encrypt(limited, bob.userID); id != second {
+		t.Fatal("session was rotated before the message limit")
+	}
+	third := encrypt(limited, bob.userID)
+	if third == second {
+		t.Fatal("session wasn't rotated after the message limit")
+	}
+
+	session, err := bob.store.outboundGroupSession(testRoomID)
+	if err != nil {
+		t.Fatal("bob has no outbound session:", err)
+	}
+	session.CreatedAt = time.Now().Add(-time.Hour).UnixMilli()
+	if err := bob.store.setOutboundGroupSession(testRoomID, session); err != nil {
+		t.Fatal("cannot save outbound session:", err)
+	}
+
+	expiring := &m.EncryptionEvent{Algorithm: MegolmAlgorithm, RotationPeriodMs: time.Minute.Milliseconds()}
+	if id := encrypt(expiring, bob.userID); id == third {
+		t.Fatal("session wasn't rotated after the rotation period")
+	}
+}
+
+func TestEncryptUnlocked(t *testing.T) {
+	bob := newTestMachine(t, "@bob:example.com", "BOB")
+	raw := rawEvent(t, "$1", bob.userID, encryptMessage(t, bob, testRoomID, "hello"))
+
+	queried := make(chan struct{})
+	release := make(chan struct{})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/keys/query") {
+			close(queried)
+			<-release
+		}
+		w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+
+	client := &api.Client{
+		Client:    httputil.NewClient(),
+		Endpoints: api.Endpoints{Version: "v3"},
+		UserID:    bob.userID,
+		DeviceID:  bob.deviceID,
+	}
+	client.HomeServerScheme = "http"
+	client.HomeServer = strings.TrimPrefix(server.URL, "http://")
+
+	// Dave's devices are unknown, so they're queried while sharing the key.
+	encrypted := make(chan struct{})
+	go func() {
+		bob.Encrypt(client, testRoomID, testSettings, []matrix.UserID{bob.userID, "@dave:example.com"},
+			event.TypeRoomMessage, map[string]string{"msgtype": "m.text", "body": "hi"})
+		close(encrypted)
+	}()
+
+	<-queried
+
+	defer func() {
+		close(release)
+		<-encrypted
+	}()
+
+	decrypted := make(chan error, 1)
+	go func() {
+		_, err := bob.DecryptRaw(testRoomID, raw)
+		decrypted <- err
+	}()
+
+	select {
+	case err := <-decrypted:
+		if err != nil {
+			t.Fatal("cannot decrypt:", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("decrypting is blocked by the key query")
+	}
+}
+
+func TestPickleKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "crypto")
+	client := &api.Client{UserID: "@alice:example.com", DeviceID: "ALICE"}
+
+	key := make([]byte, pickleKeySize)
+	otherKey := make([]byte, pickleKeySize)
+	otherKey[0] = 1
+
+	m, err := New(path, client, key)
+	if err != nil {
+		t.Fatal("cannot create machine:", err)
+	}
+	identityKey := m.IdentityKey()
+	m.Close()
+
+	if m, err := New(path, client, otherKey); err == nil {
+		m.Close()
+		t.Fatal("database was opened with another pickle key")
+	}
+
+	m, err = New(path, client, key)
+	if err != nil {
+		t.Fatal("cannot reopen machine:", err)
+	}
+	defer m.Close()
+
+	if m.IdentityKey() != identityKey {
+		t.Fatal("account changed after reopening")
+	}
+}
@@ -0,0 +1,479 @@
+package e2ee
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"log"
+
+	"github.com/diamondburned/gotktrix/internal/gotktrix/internal/e2ee/olm"
+	"github.com/diamondburned/gotrix/api"
+	"github.com/diamondburned/gotrix/api/httputil"
+	"github.com/diamondburned/gotrix/matrix"
+	"github.com/pkg/errors"
+)
+
+const signedCurve25519 = "signed_curve25519"
+
+// targetOneTimeKeys is the number of one-time keys to keep on the server.
+const targetOneTimeKeys = olm.MaxOneTimeKeys / 2
+
+// deviceKeys is the device_keys object as published to the homeserver.
+type deviceKeys struct {
+	UserID     matrix.UserID     `json:"user_id"`
+	DeviceID   matrix.DeviceID   `json:"device_id"`
+	Algorithms []string          `json:"algorithms"`
+	Keys       map[string]string `json:"keys"`
+	Signatures signatures        `json:"signatures,omitempty"`
+	Unsigned   json.RawMessage   `json:"unsigned,omitempty"`
+}
+
+// signedKey is a one-time key signed by its device.
+type signedKey struct {
+	Key        string     `json:"key"`
+	Signatures signatures `json:"signatures,omitempty"`
+}
+
+type signatures map[matrix.UserID]map[string]string
+
+// canonicalJSON marshals v into the canonical JSON form used for signing. The
+// "signatures" and "unsigned" fields are removed.
+func canonicalJSON(v interface{}) ([]byte, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+
+	var obj map[string]interface{}
+	if err := dec.Decode(&obj); err != nil {
+		return nil, err
+	}
+
+	delete(obj, "signatures")
+	delete(obj, "unsigned")
+
+	var buf bytes.Buffer
+
+	// Maps are marshaled with sorted keys, which is what we want.
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(obj); err != nil {
+		return nil, err
+	}
+
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// sign signs v in its canonical JSON form and returns the signatures object.
+func (m *Machine) sign(v interface{}) (signatures, error) {
+	b, err := canonicalJSON(v)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot make canonical JSON")
+	}
+
+	return signatures{
+		m.userID: {
+			"ed25519:" + string(m.deviceID): m.account.Sign(b),
+		},
+	}, nil
+}
+
+// verifySignature verifies that v is signed by the given device's Ed25519 key.
+func verifySignature(v interface{}, sigs signatures, userID matrix.UserID, deviceID matrix.DeviceID, ed25519Key string) error {
+	sig, ok := sigs[userID]["ed25519:"+string(deviceID)]
+	if !ok {
+		return errors.New("missing signature")
+	}
+
+	key, err := olm.Encoding.DecodeString(ed25519Key)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return errors.New("invalid ed25519 key")
+	}
+
+	rawSig, err := olm.Encoding.DecodeString(sig)
+	if err != nil {
+		return errors.Wrap(err, "invalid signature")
+	}
+
+	b, err := canonicalJSON(v)
+	if err != nil {
+		return errors.Wrap(err, "cannot make canonical JSON")
+	}
+
+	if !ed25519.Verify(key, b, rawSig) {
+		return errors.New("signature mismatch")
+	}
+
+	return nil
+}
+
+type keysUploadResponse struct {
+	OneTimeKeyCounts map[string]int `json:"one_time_key_counts"`
+}
+
+// Upload uploads the device keys if they haven't been uploaded yet, and tops
+// up the one-time keys on the server.
+func (m *Machine) Upload(client *api.Client) error {
+	m.uploadMu.Lock()
+	defer m.uploadMu.Unlock()
+
+	return m.upload(client, -1)
+}
+
+// upload uploads the device keys and one-time keys. If serverCount is
+// negative, then the count is queried from the server first. It must be called
+// with uploadMu held.
+func (m *Machine) upload(client *api.Client, serverCount int) error {
+	if serverCount < 0 {
+		m.mu.Lock()
+		body, err := m.uploadBody(-1)
+		m.mu.Unlock()
+
+		if err != nil {
+			return err
+		}
+
+		// Uploading an empty body gives us the key counts.
+		var resp keysUploadResponse
+		if err := m.requestUpload(client, body, &resp); err != nil {
+			return err
+		}
+		serverCount = resp.OneTimeKeyCounts[signedCurve25519]
+	}
+
+	m.mu.Lock()
+	body, err := m.uploadBody(serverCount)
+	m.mu.Unlock()
+
+	if err != nil || len(body) == 0 {
+		return err
+	}
+
+	if err := m.requestUpload(client, body, nil); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.account.MarkKeysAsPublished()
+
+	if err := m.store.setAccount(m.account); err != nil {
+		return errors.Wrap(err, "cannot save account")
+	}
+
+	return nil
+}
+
+// uploadBody returns the body to upload, which has the device keys if they
+// haven't been uploaded yet. If serverCount isn't negative, then one-time keys
+// are generated to top up the server's count and added as well.
+func (m *Machine) uploadBody(serverCount int) (map[string]interface{}, error) {
+	body := map[string]interface{}{}
+
+	if !m.store.uploadedDeviceKeys() {
+		keys := deviceKeys{
+			UserID:     m.userID,
+			DeviceID:   m.deviceID,
+			Algorithms: []string{OlmAlgorithm, MegolmAlgorithm},
+			Keys: map[string]string{
+				"curve25519:" + string(m.deviceID): m.account.Curve25519(),
+				"ed25519:" + string(m.deviceID):    m.account.Ed25519(),
+			},
+		}
+
+		sigs, err := m.sign(keys)
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot sign device keys")
+		}
+		keys.Signatures = sigs
+
+		body["device_keys"] = keys
+	}
+
+	if serverCount < 0 {
+		return body, nil
+	}
+
+	if need := targetOneTimeKeys - serverCount; need > 0 {
+		if err := m.account.GenerateOneTimeKeys(need); err != nil {
+			return nil, err
+		}
+	}
+
+	oneTimeKeys := map[string]signedKey{}
+
+	for id, key := range m.account.UnpublishedOneTimeKeys() {
+		signed := signedKey{Key: key}
+
+		sigs, err := m.sign(signed)
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot sign one-time key")
+		}
+		signed.Signatures = sigs
+
+		oneTimeKeys[signedCurve25519+":"+id] = signed
+	}
+
+	if len(oneTimeKeys) == 0 {
+		return body, nil
+	}
+
+	body["one_time_keys"] = oneTimeKeys
+
+	// Persist the new one-time keys before uploading them, so that we never
+	// publish a key that we don't have.
+	if err := m.store.setAccount(m.account); err != nil {
+		return nil, errors.Wrap(err, "cannot save account")
+	}
+
+	return body, nil
+}
+
+func (m *Machine) requestUpload(client *api.Client, body map[string]interface{}, resp interface{}) error {
+	err := client.Request(
+		"POST", client.Endpoints.Base()+"/keys/upload", resp,
+		httputil.WithToken(), httputil.WithJSONBody(body),
+	)
+	if err != nil {
+		return errors.Wrap(err, "cannot upload keys")
+	}
+
+	if _, ok := body["device_keys"]; ok {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		if err := m.store.setUploadedDeviceKeys(); err != nil {
+			return errors.Wrap(err, "cannot save device key state")
+		}
+	}
+
+	return nil
+}
+
+// replenish uploads more one-time keys in the background if the server is
+// running low. It must be called with the mutex held.
+func (m *Machine) replenish(counts map[string]int) {
+	count, ok := counts[signedCurve25519]
+	if !ok || count >= targetOneTimeKeys || m.replenishing {
+		return
+	}
+
+	m.replenishing = true
+
+	go func() {
+		m.uploadMu.Lock()
+		err := m.upload(m.client, count)
+		m.uploadMu.Unlock()
+
+		if err != nil {
+			log.Println("crypto: cannot replenish one-time keys:", err)
+		}
+
+		m.mu.Lock()
+		m.replenishing = false
+		m.mu.Unlock()
+	}()
+}
+
+type keysQueryResponse struct {
+	DeviceKeys map[matrix.UserID]map[matrix.DeviceID]json.RawMessage `json:"device_keys"`
+	Failures   map[string]json.RawMessage                            `json:"failures"`
+}
+
+// queryDevices updates the device lists of the given users if they're unknown
+// or outdated. It must be called without the mutex held.
+func (m *Machine) queryDevices(client *api.Client, userIDs []matrix.UserID) error {
+	query := map[matrix.UserID][]matrix.DeviceID{}
+	changes := map[matrix.UserID]int{}
+
+	m.mu.Lock()
+
+	for _, userID := range userIDs {
+		devices, ok := m.store.userDevices(userID)
+		if !ok || devices.Outdated {
+			query[userID] = []matrix.DeviceID{}
+			changes[userID] = m.deviceChanges[userID]
+		}
+	}
+
+	m.mu.Unlock()
+
+	if len(query) == 0 {
+		return nil
+	}
+
+	var resp keysQueryResponse
+
+	err := client.Request(
+		"POST", client.Endpoints.Base()+"/keys/query", &resp,
+		httputil.WithToken(), httputil.WithJSONBody(map[string]interface{}{
+			"device_keys": query,
+			"timeout":     10000,
+		}),
+	)
+	if err != nil {
+		return errors.Wrap(err, "cannot query device keys")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for userID := range query {
+		keys, ok := resp.DeviceKeys[userID]
+		if !ok {
+			// The server failed to give us this user; try again later.
+			continue
+		}
+
+		old, _ := m.store.userDevices(userID)
+		devices := &userDevices{
+			Devices: make(map[matrix.DeviceID]deviceInfo, len(keys)),
+			// Query again next time if the devices changed while querying.
+			Outdated: m.deviceChanges[userID] != changes[userID],
+		}
+
+		for deviceID, key := range keys {
+			info, err := verifyDeviceKeys(userID, deviceID, key)
+			if err != nil {
+				log.Printf("crypto: ignoring device %s of %s: %v", deviceID, userID, err)
+				continue
+			}
+
+			// Never allow a device's fingerprint key to change.
+			if old != nil {
+				if oldInfo, ok := old.Devices[deviceID]; ok && oldInfo.Ed25519 != info.Ed25519 {
+					log.Printf("crypto: device %s of %s changed its ed25519 key, ignoring", deviceID, userID)
+					continue
+				}
+			}
+
+			devices.Devices[deviceID] = info
+		}
+
+		if err := m.store.setUserDevices(userID, devices); err != nil {
+			return errors.Wrap(err, "cannot save devices")
+		}
+	}
+
+	return nil
+}
+
+func verifyDeviceKeys(userID matrix.UserID, deviceID matrix.DeviceID, raw json.RawMessage) (deviceInfo, error) {
+	var keys deviceKeys
+	if err := json.Unmarshal(raw, &keys); err != nil {
+		return deviceInfo{}, errors.Wrap(err, "invalid device keys")
+	}
+
+	if keys.UserID != userID || keys.DeviceID != deviceID {
+		return deviceInfo{}, errors.New("mismatched user or device ID")
+	}
+
+	info := deviceInfo{
+		Curve25519: keys.Keys["curve25519:"+string(deviceID)],
+		Ed25519:    keys.Keys["ed25519:"+string(deviceID)],
+	}
+
+	if info.Curve25519 == "" || info.Ed25519 == "" {
+		return info, errors.New("missing keys")
+	}
+
+	// Verify the raw object, since it may have fields that we don't know.
+	if err := verifySignature(raw, keys.Signatures, userID, deviceID, info.Ed25519); err != nil {
+		return info, errors.Wrap(err, "invalid device keys")
+	}
+
+	return info, nil
+}
+
+type keysClaimResponse struct {
+	OneTimeKeys map[matrix.UserID]map[matrix.DeviceID]map[string]json.RawMessage `json:"one_time_keys"`
+}
+
+// deviceRef refers to a single device.
+type deviceRef struct {
+	UserID   matrix.UserID
+	DeviceID matrix.DeviceID
+	deviceInfo
+}
+
+// ensureOlmSessions creates new Olm sessions with the given devices if there
+// are none. It must be called without the mutex held.
+func (m *Machine) ensureOlmSessions(client *api.Client, devices []deviceRef) error {
+	claim := map[matrix.UserID]map[matrix.DeviceID]string{}
+	var claiming []deviceRef
+
+	m.mu.Lock()
+
+	for _, device := range devices {
+		if len(m.store.olmSessionList(device.Curve25519)) > 0 {
+			continue
+		}
+
+		if claim[device.UserID] == nil {
+			claim[device.UserID] = map[matrix.DeviceID]string{}
+		}
+
+		claim[device.UserID][device.DeviceID] = signedCurve25519
+		claiming = append(claiming, device)
+	}
+
+	m.mu.Unlock()
+
+	if len(claiming) == 0 {
+		return nil
+	}
+
+	var resp keysClaimResponse
+
+	err := client.Request(
+		"POST", client.Endpoints.Base()+"/keys/claim", &resp,
+		httputil.WithToken(), httputil.WithJSONBody(map[string]interface{}{
+			"one_time_keys": claim,
+			"timeout":       10000,
+		}),
+	)
+	if err != nil {
+		return errors.Wrap(err, "cannot claim one-time keys")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, device := range claiming {
+		// Another claim may have made a session while this one was running.
+		if len(m.store.olmSessionList(device.Curve25519)) > 0 {
+			continue
+		}
+
+		for _, raw := range resp.OneTimeKeys[device.UserID][device.DeviceID] {
+			var key signedKey
+			if err := json.Unmarshal(raw, &key); err != nil {
+				log.Printf("crypto: invalid one-time key for %s of %s: %v", device.DeviceID, device.UserID, err)
+				break
+			}
+
+			if err := verifySignature(raw, key.Signatures, device.UserID, device.DeviceID, device.Ed25519); err != nil {
+				log.Printf("crypto: invalid one-time key for %s of %s: %v", device.DeviceID, device.UserID, err)
+				break
+			}
+
+			session, err := olm.NewOutboundSession(m.account, device.Curve25519, key.Key)
+			if err != nil {
+				log.Printf("crypto: cannot create session with %s of %s: %v", device.DeviceID, device.UserID, err)
+				break
+			}
+
+			if err := m.store.setOlmSessionList(device.Curve25519, olmSessionList{session}); err != nil {
+				return errors.Wrap(err, "cannot save olm session")
+			}
+
+			break
+		}
+	}
+
+	return nil
+}
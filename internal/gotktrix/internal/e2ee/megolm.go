@@ -0,0 +1,456 @@
+package e2ee
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/diamondburned/gotktrix/internal/gotktrix/events/m"
+	"github.com/diamondburned/gotktrix/internal/gotktrix/internal/e2ee/olm"
+	"github.com/diamondburned/gotrix/api"
+	"github.com/diamondburned/gotrix/event"
+	"github.com/diamondburned/gotrix/matrix"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultRotationPeriod = 7 * 24 * time.Hour
+	defaultRotationMsgs   = 100
+)
+
+// ErrNoSession is returned if the room key for an event hasn't been received.
+var ErrNoSession = errors.New("no room key for event")
+
+// megolmPayload is the plaintext of a Megolm-encrypted event.
+type megolmPayload struct {
+	Type    event.Type      `json:"type"`
+	Content json.RawMessage `json:"content"`
+	RoomID  matrix.RoomID   `json:"room_id"`
+}
+
+// decryptRaws decrypts all encrypted raw events in place. Events that cannot
+// be decrypted are left as-is.
+func (m *Machine) decryptRaws(roomID matrix.RoomID, raws []event.RawEvent) {
+	for i, raw := range raws {
+		decrypted, err := m.decryptRaw(roomID, raw)
+		if err != nil {
+			if !errors.Is(err, errNotEncrypted) {
+				log.Printf("crypto: cannot decrypt event in %s: %v", roomID, err)
+			}
+			continue
+		}
+		raws[i] = decrypted
+	}
+}
+
+var errNotEncrypted = errors.New("event is not encrypted")
+
+// decryptRaw decrypts the given raw room event. The returned event keeps all
+// of the original fields except for its type and content.
+func (m *Machine) decryptRaw(roomID matrix.RoomID, raw event.RawEvent) (event.RawEvent, error) {
+	var ev struct {
+		Type    event.Type     `json:"type"`
+		ID      matrix.EventID `json:"event_id"`
+		Sender  matrix.UserID  `json:"sender"`
+		Content encryptedEvent `json:"content"`
+	}
+
+	if err := json.Unmarshal(raw, &ev); err != nil {
+		return nil, errors.Wrap(err, "invalid event")
+	}
+
+	if ev.Type != encryptedEventType {
+		return nil, errNotEncrypted
+	}
+
+	if ev.Content.Algorithm != MegolmAlgorithm {
+		return nil, errors.Errorf("unsupported algorithm %q", ev.Content.Algorithm)
+	}
+
+	var ciphertext string
+	if err := json.Unmarshal(ev.Content.Ciphertext, &ciphertext); err != nil {
+		return nil, errors.Wrap(err, "invalid ciphertext")
+	}
+
+	session, err := m.store.inboundGroupSession(roomID, ev.Content.SessionID)
+	if err != nil {
+		return nil, ErrNoSession
+	}
+
+	// The sender key is deprecated, so it may be missing.
+	if ev.Content.SenderKey != "" && session.SenderKey != ev.Content.SenderKey {
+		return nil, errors.New("sender key mismatch")
+	}
+
+	if err := m.checkSessionSender(ev.Sender, session); err != nil {
+		return nil, err
+	}
+
+	plaintext, index, err := session.Session.Decrypt(ciphertext)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot decrypt")
+	}
+
+	// Guard against replay attacks: a message index must only ever be used
+	// by a single event.
+	indexKey := fmt.Sprintf("%s:%d", ev.Content.SessionID, index)
+	if seen, ok := m.store.messageIndex(roomID, indexKey); ok && seen != ev.ID {
+		return nil, errors.Errorf("message index %d reused by %s", index, ev.ID)
+	}
+
+	// Persist the advanced ratchet.
+	if err := m.store.setInboundGroupSession(roomID, session); err != nil {
+		log.Println("crypto: cannot save inbound group session:", err)
+	}
+	if ev.ID != "" {
+		m.store.setMessageIndex(roomID, indexKey, ev.ID)
+	}
+
+	var payload megolmPayload
+	if err := json.Unmarshal(plaintext, &payload); err != nil {
+		return nil, errors.Wrap(err, "invalid megolm payload")
+	}
+
+	if payload.RoomID != roomID {
+		return nil, errors.New("megolm payload room mismatch")
+	}
+
+	content := payload.Content
+
+	// Relations are kept in the clear so that the server can see them. Copy
+	// them over if the encrypted content doesn't have them.
+	if ev.Content.RelatesTo != nil {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(content, &fields); err == nil {
+			if _, ok := fields["m.relates_to"]; !ok {
+				fields["m.relates_to"] = ev.Content.RelatesTo
+				content, _ = json.Marshal(fields)
+			}
+		}
+	}
+
+	var whole map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &whole); err != nil {
+		return nil, errors.Wrap(err, "invalid event")
+	}
+
+	whole["type"], _ = json.Marshal(payload.Type)
+	whole["content"] = content
+
+	b, err := json.Marshal(whole)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot marshal decrypted event")
+	}
+
+	return b, nil
+}
+
+// checkSessionSender checks that the inbound session was sent by one of the
+// given user's devices, so that nobody else can send messages as them.
+func (m *Machine) checkSessionSender(sender matrix.UserID, session *inboundGroupSession) error {
+	if sender == m.userID && session.SenderKey == m.account.Curve25519() {
+		return nil
+	}
+
+	device, ok := m.store.deviceByKey(sender, session.SenderKey)
+	if !ok {
+		return errors.Errorf("room key is not from a known device of %s", sender)
+	}
+
+	if device.Ed25519 != session.SigningKey {
+		return errors.New("room key signing key mismatch")
+	}
+
+	return nil
+}
+
+// DecryptRaw decrypts the given raw room event. If the event is not encrypted,
+// then it is returned as-is.
+func (m *Machine) DecryptRaw(roomID matrix.RoomID, raw event.RawEvent) (event.RawEvent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	decrypted, err := m.decryptRaw(roomID, raw)
+	if err != nil {
+		if errors.Is(err, errNotEncrypted) {
+			return raw, nil
+		}
+		return raw, err
+	}
+
+	return decrypted, nil
+}
+
+// DecryptRaws decrypts all encrypted events in place. Events that cannot be
+// decrypted are left as-is.
+func (m *Machine) DecryptRaws(roomID matrix.RoomID, raws []event.RawEvent) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.decryptRaws(roomID, raws)
+}
+
+// Encrypt encrypts the given event content for the room. The room's members
+// are given in userIDs; the room key is shared with all of their devices that
+// haven't received it yet. The returned content should be sent as an
+// m.room.encrypted event.
+func (m *Machine) Encrypt(
+	client *api.Client, roomID matrix.RoomID, settings *m.EncryptionEvent,
+	userIDs []matrix.UserID, typ event.Type, content interface{}) (*m.EncryptedEvent, error) {
+
+	if settings.Algorithm != MegolmAlgorithm {
+		return nil, errors.Errorf("unsupported room algorithm %q", settings.Algorithm)
+	}
+
+	// Only encrypt one message at a time, so that the same room key isn't
+	// rotated or shared twice. The state mutex is only held while the session
+	// is used, since sharing the key talks to the homeserver.
+	m.sendMu.Lock()
+	defer m.sendMu.Unlock()
+
+	m.mu.Lock()
+	session, err := m.outboundSession(roomID, settings, userIDs)
+	m.mu.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := m.shareRoomKey(client, roomID, session, userIDs); err != nil {
+		return nil, errors.Wrap(err, "cannot share room key")
+	}
+
+	// Move the relation out to the unencrypted content.
+	var relatesTo json.RawMessage
+
+	rawContent, err := json.Marshal(content)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot marshal content")
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(rawContent, &fields); err == nil {
+		relatesTo = fields["m.relates_to"]
+	}
+
+	payload, err := json.Marshal(megolmPayload{
+		Type:    typ,
+		Content: rawContent,
+		RoomID:  roomID,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot marshal payload")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ciphertext, _ := json.Marshal(session.Session.Encrypt(payload))
+
+	if err := m.store.setOutboundGroupSession(roomID, session); err != nil {
+		return nil, errors.Wrap(err, "cannot save outbound group session")
+	}
+
+	return &encryptedEvent{
+		RoomEventInfo: event.RoomEventInfo{
+			EventInfo: event.EventInfo{Type: encryptedEventType},
+			RoomID:    roomID,
+		},
+		Algorithm:  MegolmAlgorithm,
+		SenderKey:  m.account.Curve25519(),
+		DeviceID:   m.deviceID,
+		SessionID:  session.Session.ID(),
+		Ciphertext: ciphertext,
+		RelatesTo:  relatesTo,
+	}, nil
+}
+
+// outboundSession returns the current outbound session for the room or a new
+// one if it needs to be rotated.
+func (m *Machine) outboundSession(
+	roomID matrix.RoomID, settings *m.EncryptionEvent, userIDs []matrix.UserID) (*outboundGroupSession, error) {
+
+	session, err := m.store.outboundGroupSession(roomID)
+	if err == nil && !needsRotation(session, settings, userIDs) {
+		return session, nil
+	}
+
+	s, err := olm.NewOutboundGroupSession()
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot create outbound group session")
+	}
+
+	session = &outboundGroupSession{
+		Session:    s,
+		CreatedAt:  time.Now().UnixMilli(),
+		SharedWith: map[matrix.UserID]map[matrix.DeviceID]bool{},
+	}
+
+	// Keep the key ourselves so that we can read our own messages.
+	inbound, err := olm.NewInboundGroupSession(s.SessionKey())
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot create inbound group session")
+	}
+
+	err = m.store.setInboundGroupSession(roomID, &inboundGroupSession{
+		Session:    inbound,
+		SenderKey:  m.account.Curve25519(),
+		SigningKey: m.account.Ed25519(),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot save inbound group session")
+	}
+
+	return session, nil
+}
+
+func needsRotation(session *outboundGroupSession, settings *m.EncryptionEvent, userIDs []matrix.UserID) bool {
+	period := defaultRotationPeriod
+	if settings.RotationPeriodMs > 0 {
+		period = time.Duration(settings.RotationPeriodMs) * time.Millisecond
+	}
+
+	msgs := defaultRotationMsgs
+	if settings.RotationPeriodMsgs > 0 {
+		msgs = settings.RotationPeriodMsgs
+	}
+
+	if time.Since(time.UnixMilli(session.CreatedAt)) > period {
+		return true
+	}
+	if int(session.Session.MessageIndex()) >= msgs {
+		return true
+	}
+
+	// Rotate if anyone who has the key has left the room.
+	members := make(map[matrix.UserID]bool, len(userIDs))
+	for _, userID := range userIDs {
+		members[userID] = true
+	}
+	for userID := range session.SharedWith {
+		if !members[userID] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// shareRoomKey sends the outbound session's key to all devices of the given
+// users that don't have it yet. It must be called without the mutex held.
+func (m *Machine) shareRoomKey(
+	client *api.Client, roomID matrix.RoomID, session *outboundGroupSession, userIDs []matrix.UserID) error {
+
+	if err := m.queryDevices(client, userIDs); err != nil {
+		return err
+	}
+
+	devices, err := m.unsharedDevices(roomID, session, userIDs)
+	if err != nil || len(devices) == 0 {
+		return err
+	}
+
+	if err := m.ensureOlmSessions(client, devices); err != nil {
+		return err
+	}
+
+	key := roomKeyContent{
+		Algorithm:  MegolmAlgorithm,
+		RoomID:     roomID,
+		SessionID:  session.Session.ID(),
+		SessionKey: session.Session.SessionKey(),
+	}
+
+	messages := m.olmEncryptAll(devices, RoomKeyEventType, key)
+
+	if len(messages) > 0 {
+		if err := client.SendToDevice(encryptedEventType, messages); err != nil {
+			return err
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for userID, userDevices := range messages {
+		for deviceID := range userDevices {
+			session.SharedWith[userID][deviceID] = true
+		}
+	}
+
+	return m.store.setOutboundGroupSession(roomID, session)
+}
+
+// unsharedDevices returns the devices of the given users that don't have the
+// outbound session's key yet.
+func (m *Machine) unsharedDevices(
+	roomID matrix.RoomID, session *outboundGroupSession, userIDs []matrix.UserID) ([]deviceRef, error) {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var devices []deviceRef
+
+	for _, userID := range userIDs {
+		userDevices, ok := m.store.userDevices(userID)
+		if !ok {
+			continue
+		}
+
+		for deviceID, info := range userDevices.Devices {
+			if userID == m.userID && deviceID == m.deviceID {
+				continue
+			}
+			if session.SharedWith[userID][deviceID] {
+				continue
+			}
+
+			devices = append(devices, deviceRef{
+				UserID:     userID,
+				DeviceID:   deviceID,
+				deviceInfo: info,
+			})
+		}
+	}
+
+	// Mark all members as shared, including the ones with no devices, so that
+	// the key is rotated if they leave.
+	for _, userID := range userIDs {
+		if session.SharedWith[userID] == nil {
+			session.SharedWith[userID] = map[matrix.DeviceID]bool{}
+		}
+	}
+
+	if len(devices) == 0 {
+		return nil, m.store.setOutboundGroupSession(roomID, session)
+	}
+
+	return devices, nil
+}
+
+// olmEncryptAll encrypts the given event for all of the given devices.
+// Devices that cannot be encrypted for are skipped.
+func (m *Machine) olmEncryptAll(devices []deviceRef, typ event.Type, content interface{}) api.DeviceMessages {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	messages := api.DeviceMessages{}
+
+	for _, device := range devices {
+		encrypted, err := m.olmEncrypt(device, typ, content)
+		if err != nil {
+			// Devices without one-time keys will not be able to read the
+			// message. There's nothing we can do about that.
+			log.Printf("crypto: cannot send %s to %s of %s: %v", typ, device.DeviceID, device.UserID, err)
+			continue
+		}
+
+		if messages[device.UserID] == nil {
+			messages[device.UserID] = map[matrix.DeviceID]interface{}{}
+		}
+		messages[device.UserID][device.DeviceID] = encrypted
+	}
+
+	return messages
+}
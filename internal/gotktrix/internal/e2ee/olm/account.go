@@ -0,0 +1,134 @@
+package olm
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+
+	"github.com/pkg/errors"
+)
+
+// MaxOneTimeKeys is the maximum number of one-time keys that an account keeps.
+// Generating more will discard the oldest keys.
+const MaxOneTimeKeys = 100
+
+// Account is the long-lived Olm identity of a device. It is JSON-serializable.
+type Account struct {
+	IdentityKey Curve25519KeyPair  `json:"identity_key"`
+	SigningKey  ed25519.PrivateKey `json:"signing_key"`
+	OneTimeKeys []OneTimeKey       `json:"one_time_keys"`
+	NextKeyID   uint32             `json:"next_key_id"`
+}
+
+// OneTimeKey is a single Curve25519 one-time key.
+type OneTimeKey struct {
+	ID        uint32            `json:"id"`
+	Key       Curve25519KeyPair `json:"key"`
+	Published bool              `json:"published"`
+}
+
+// KeyID returns the key ID as it is published to the homeserver.
+func (k OneTimeKey) KeyID() string {
+	var id [4]byte
+	binary.BigEndian.PutUint32(id[:], k.ID)
+	return Encoding.EncodeToString(id[:])
+}
+
+// NewAccount creates a new account with new identity keys.
+func NewAccount() (*Account, error) {
+	identity, err := NewCurve25519KeyPair()
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot generate identity key")
+	}
+
+	_, signing, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot generate signing key")
+	}
+
+	return &Account{
+		IdentityKey: identity,
+		SigningKey:  signing,
+		NextKeyID:   1,
+	}, nil
+}
+
+// Curve25519 returns the base64-encoded public Curve25519 identity key.
+func (a *Account) Curve25519() string {
+	return Encoding.EncodeToString(a.IdentityKey.Public)
+}
+
+// Ed25519 returns the base64-encoded public Ed25519 fingerprint key.
+func (a *Account) Ed25519() string {
+	return Encoding.EncodeToString(a.SigningKey.Public().(ed25519.PublicKey))
+}
+
+// Sign signs the given message using the account's Ed25519 key and returns the
+// base64-encoded signature.
+func (a *Account) Sign(message []byte) string {
+	return Encoding.EncodeToString(ed25519.Sign(a.SigningKey, message))
+}
+
+// GenerateOneTimeKeys generates n new one-time keys. If the total exceeds
+// MaxOneTimeKeys, then the oldest keys are discarded.
+func (a *Account) GenerateOneTimeKeys(n int) error {
+	for i := 0; i < n; i++ {
+		key, err := NewCurve25519KeyPair()
+		if err != nil {
+			return errors.Wrap(err, "cannot generate one-time key")
+		}
+
+		a.OneTimeKeys = append(a.OneTimeKeys, OneTimeKey{
+			ID:  a.NextKeyID,
+			Key: key,
+		})
+		a.NextKeyID++
+	}
+
+	if over := len(a.OneTimeKeys) - MaxOneTimeKeys; over > 0 {
+		a.OneTimeKeys = append(a.OneTimeKeys[:0], a.OneTimeKeys[over:]...)
+	}
+
+	return nil
+}
+
+// UnpublishedOneTimeKeys returns the one-time keys that have not been marked as
+// published yet, mapped from key IDs to base64-encoded public keys.
+func (a *Account) UnpublishedOneTimeKeys() map[string]string {
+	keys := make(map[string]string)
+	for _, key := range a.OneTimeKeys {
+		if !key.Published {
+			keys[key.KeyID()] = Encoding.EncodeToString(key.Key.Public)
+		}
+	}
+	return keys
+}
+
+// MarkKeysAsPublished marks all current one-time keys as published.
+func (a *Account) MarkKeysAsPublished() {
+	for i := range a.OneTimeKeys {
+		a.OneTimeKeys[i].Published = true
+	}
+}
+
+// RemoveOneTimeKeys removes the one-time key used by the given inbound session
+// so that it cannot be reused. It returns false if the key is not found.
+func (a *Account) RemoveOneTimeKeys(s *Session) bool {
+	for i, key := range a.OneTimeKeys {
+		if bytes.Equal(key.Key.Public, s.BobOneTimeKey) {
+			a.OneTimeKeys = append(a.OneTimeKeys[:i], a.OneTimeKeys[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (a *Account) lookupOneTimeKey(public []byte) *Curve25519KeyPair {
+	for i, key := range a.OneTimeKeys {
+		if bytes.Equal(key.Key.Public, public) {
+			return &a.OneTimeKeys[i].Key
+		}
+	}
+	return nil
+}
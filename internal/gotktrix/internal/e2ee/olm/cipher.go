@@ -0,0 +1,80 @@
+package olm
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"encoding/base64"
+
+	"github.com/pkg/errors"
+)
+
+var b64 = base64.RawStdEncoding
+
+// macLength is the length of the truncated HMAC-SHA-256 appended to messages.
+const macLength = 8
+
+// ErrBadMAC is returned if a message's authentication code does not match.
+var ErrBadMAC = errors.New("bad message MAC")
+
+// messageKeys is the set of keys derived from a single message key.
+type messageKeys struct {
+	aes []byte
+	mac []byte
+	iv  []byte
+}
+
+func deriveMessageKeys(secret []byte, info string) messageKeys {
+	b := hkdfSHA256(nil, secret, info, 80)
+	return messageKeys{
+		aes: b[:32],
+		mac: b[32:64],
+		iv:  b[64:80],
+	}
+}
+
+func (k messageKeys) encrypt(plaintext []byte) []byte {
+	block, err := aes.NewCipher(k.aes)
+	if err != nil {
+		panic("olm: invalid AES key: " + err.Error())
+	}
+
+	padding := aes.BlockSize - len(plaintext)%aes.BlockSize
+
+	out := make([]byte, len(plaintext)+padding)
+	copy(out, plaintext)
+	copy(out[len(plaintext):], bytes.Repeat([]byte{byte(padding)}, padding))
+
+	cipher.NewCBCEncrypter(block, k.iv).CryptBlocks(out, out)
+	return out
+}
+
+func (k messageKeys) decrypt(ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) == 0 || len(ciphertext)%aes.BlockSize != 0 {
+		return nil, errors.New("invalid ciphertext length")
+	}
+
+	block, err := aes.NewCipher(k.aes)
+	if err != nil {
+		panic("olm: invalid AES key: " + err.Error())
+	}
+
+	out := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, k.iv).CryptBlocks(out, ciphertext)
+
+	padding := int(out[len(out)-1])
+	if padding == 0 || padding > aes.BlockSize || padding > len(out) {
+		return nil, errors.New("invalid padding")
+	}
+
+	return out[:len(out)-padding], nil
+}
+
+func (k messageKeys) sum(data []byte) []byte {
+	return hmacSHA256(k.mac, data)[:macLength]
+}
+
+func (k messageKeys) verify(data, mac []byte) bool {
+	return hmac.Equal(k.sum(data), mac)
+}
@@ -0,0 +1,87 @@
+// Package olm implements the Olm and Megolm cryptographic ratchets used by
+// Matrix for end-to-end encryption. The wire formats are compatible with
+// libolm, but the pickled (persisted) formats are plain JSON.
+package olm
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+)
+
+// Encoding is the unpadded base64 encoding used by Matrix for keys, signatures
+// and ciphertexts.
+var Encoding = rawEncoding{}
+
+type rawEncoding struct{}
+
+// EncodeToString encodes b into unpadded standard base64.
+func (rawEncoding) EncodeToString(b []byte) string {
+	return b64.EncodeToString(b)
+}
+
+// DecodeString decodes s from standard base64, tolerating any padding.
+func (rawEncoding) DecodeString(s string) ([]byte, error) {
+	return b64.DecodeString(strings.TrimRight(s, "="))
+}
+
+// Curve25519KeyPair is a pair of Curve25519 keys.
+type Curve25519KeyPair struct {
+	Private []byte `json:"private"`
+	Public  []byte `json:"public"`
+}
+
+// NewCurve25519KeyPair generates a new random Curve25519 key pair.
+func NewCurve25519KeyPair() (Curve25519KeyPair, error) {
+	private := make([]byte, curve25519.ScalarSize)
+	if _, err := io.ReadFull(rand.Reader, private); err != nil {
+		return Curve25519KeyPair{}, errors.Wrap(err, "cannot read random bytes")
+	}
+
+	public, err := curve25519.X25519(private, curve25519.Basepoint)
+	if err != nil {
+		return Curve25519KeyPair{}, errors.Wrap(err, "cannot derive public key")
+	}
+
+	return Curve25519KeyPair{
+		Private: private,
+		Public:  public,
+	}, nil
+}
+
+// SharedSecret computes the Diffie-Hellman shared secret between this key
+// pair and the given public key.
+func (k Curve25519KeyPair) SharedSecret(public []byte) ([]byte, error) {
+	if len(public) != curve25519.PointSize {
+		return nil, errors.New("invalid public key length")
+	}
+	return curve25519.X25519(k.Private, public)
+}
+
+func hmacSHA256(key, data []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+func hkdfSHA256(salt, secret []byte, info string, n int) []byte {
+	out := make([]byte, n)
+
+	r := hkdf.New(sha256.New, secret, salt, []byte(info))
+	if _, err := io.ReadFull(r, out); err != nil {
+		// Only happens if n is too large, which is a programmer error.
+		panic("olm: hkdf failed: " + err.Error())
+	}
+
+	return out
+}
+
+func cloneBytes(b []byte) []byte {
+	return append([]byte(nil), b...)
+}
@@ -0,0 +1,275 @@
+package olm
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+const megolmKeysInfo = "MEGOLM_KEYS"
+
+const (
+	megolmRatchetParts      = 4
+	megolmRatchetPartLength = 32
+	megolmRatchetLength     = megolmRatchetParts * megolmRatchetPartLength
+)
+
+const (
+	sessionKeyVersion       = 2
+	sessionExportKeyVersion = 1
+)
+
+// ErrUnknownMessageIndex is returned if a Megolm message was sent at an index
+// earlier than the first known index of the inbound session.
+var ErrUnknownMessageIndex = errors.New("unknown message index")
+
+// megolmRatchet is the Megolm ratchet state. See
+// https://gitlab.matrix.org/matrix-org/olm/-/blob/master/docs/megolm.md.
+type megolmRatchet struct {
+	Data    []byte `json:"data"`
+	Counter uint32 `json:"counter"`
+}
+
+func (r megolmRatchet) clone() megolmRatchet {
+	return megolmRatchet{
+		Data:    cloneBytes(r.Data),
+		Counter: r.Counter,
+	}
+}
+
+func (r *megolmRatchet) part(i int) []byte {
+	return r.Data[i*megolmRatchetPartLength : (i+1)*megolmRatchetPartLength]
+}
+
+// rehash sets the part to to HMAC(part from, to).
+func (r *megolmRatchet) rehash(from, to int) {
+	copy(r.part(to), hmacSHA256(r.part(from), []byte{byte(to)}))
+}
+
+func (r *megolmRatchet) advance() {
+	r.Counter++
+
+	// Figure out how much of the ratchet needs to be rehashed.
+	h := 0
+	mask := uint32(0x00FFFFFF)
+	for h < megolmRatchetParts-1 && r.Counter&mask != 0 {
+		h++
+		mask >>= 8
+	}
+
+	// Rehash from the highest part down, since the lower parts are derived
+	// from it.
+	for i := megolmRatchetParts - 1; i >= h; i-- {
+		r.rehash(h, i)
+	}
+}
+
+func (r *megolmRatchet) advanceTo(to uint32) {
+	for j := 0; j < megolmRatchetParts; j++ {
+		shift := uint((megolmRatchetParts - j - 1) * 8)
+		mask := ^uint32(0) << shift
+
+		// How many times we need to rehash this part. The & 0xFF ensures that
+		// we handle wraparound correctly.
+		steps := ((to >> shift) - (r.Counter >> shift)) & 0xFF
+		if steps == 0 {
+			continue
+		}
+
+		// For all but the last step, we can just bump R(j) without regard to
+		// the lower parts.
+		for ; steps > 1; steps-- {
+			r.rehash(j, j)
+		}
+
+		// On the last step, we also need to bump the lower parts.
+		for k := megolmRatchetParts - 1; k >= j; k-- {
+			r.rehash(j, k)
+		}
+
+		r.Counter = to & mask
+	}
+}
+
+func (r *megolmRatchet) keys() messageKeys {
+	return deriveMessageKeys(r.Data, megolmKeysInfo)
+}
+
+// OutboundGroupSession is the sending side of a Megolm session. It is
+// JSON-serializable.
+type OutboundGroupSession struct {
+	Ratchet    megolmRatchet      `json:"ratchet"`
+	SigningKey ed25519.PrivateKey `json:"signing_key"`
+}
+
+// NewOutboundGroupSession creates a new random outbound group session.
+func NewOutboundGroupSession() (*OutboundGroupSession, error) {
+	data := make([]byte, megolmRatchetLength)
+	if _, err := io.ReadFull(rand.Reader, data); err != nil {
+		return nil, errors.Wrap(err, "cannot read random bytes")
+	}
+
+	_, signing, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot generate signing key")
+	}
+
+	return &OutboundGroupSession{
+		Ratchet:    megolmRatchet{Data: data},
+		SigningKey: signing,
+	}, nil
+}
+
+// ID returns the base64-encoded session ID.
+func (s *OutboundGroupSession) ID() string {
+	return Encoding.EncodeToString(s.SigningKey.Public().(ed25519.PublicKey))
+}
+
+// MessageIndex returns the index that the next message will be sent at.
+func (s *OutboundGroupSession) MessageIndex() uint32 {
+	return s.Ratchet.Counter
+}
+
+// SessionKey returns the base64-encoded session key at the current message
+// index. It is shared with other devices using m.room_key.
+func (s *OutboundGroupSession) SessionKey() string {
+	b := make([]byte, 0, 1+4+megolmRatchetLength+ed25519.PublicKeySize+ed25519.SignatureSize)
+	b = append(b, sessionKeyVersion)
+	b = appendUint32(b, s.Ratchet.Counter)
+	b = append(b, s.Ratchet.Data...)
+	b = append(b, s.SigningKey.Public().(ed25519.PublicKey)...)
+	b = append(b, ed25519.Sign(s.SigningKey, b)...)
+	return Encoding.EncodeToString(b)
+}
+
+// Encrypt encrypts the given plaintext and returns the base64-encoded
+// ciphertext. The ratchet is advanced afterwards.
+func (s *OutboundGroupSession) Encrypt(plaintext []byte) string {
+	keys := s.Ratchet.keys()
+
+	msg := megolmMessage{
+		Index:      s.Ratchet.Counter,
+		Ciphertext: keys.encrypt(plaintext),
+	}
+
+	b := msg.encode()
+	b = append(b, keys.sum(b)...)
+	b = append(b, ed25519.Sign(s.SigningKey, b)...)
+
+	s.Ratchet.advance()
+
+	return Encoding.EncodeToString(b)
+}
+
+// InboundGroupSession is the receiving side of a Megolm session. It is
+// JSON-serializable.
+type InboundGroupSession struct {
+	InitialRatchet megolmRatchet     `json:"initial_ratchet"`
+	LatestRatchet  megolmRatchet     `json:"latest_ratchet"`
+	SigningKey     ed25519.PublicKey `json:"signing_key"`
+}
+
+// NewInboundGroupSession creates a new inbound group session from the given
+// base64-encoded session key, as produced by SessionKey. Exported session keys
+// (without a signature) are also accepted.
+func NewInboundGroupSession(sessionKey string) (*InboundGroupSession, error) {
+	b, err := Encoding.DecodeString(sessionKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid session key")
+	}
+
+	const exportLength = 1 + 4 + megolmRatchetLength + ed25519.PublicKeySize
+
+	switch {
+	case len(b) == exportLength+ed25519.SignatureSize && b[0] == sessionKeyVersion:
+		pub := ed25519.PublicKey(b[1+4+megolmRatchetLength : exportLength])
+		if !ed25519.Verify(pub, b[:exportLength], b[exportLength:]) {
+			return nil, errors.New("invalid session key signature")
+		}
+	case len(b) == exportLength && b[0] == sessionExportKeyVersion:
+		// ok
+	default:
+		return nil, errors.Wrap(ErrBadMessageFormat, "invalid session key")
+	}
+
+	ratchet := megolmRatchet{
+		Counter: binary.BigEndian.Uint32(b[1:5]),
+		Data:    cloneBytes(b[5 : 5+megolmRatchetLength]),
+	}
+
+	return &InboundGroupSession{
+		InitialRatchet: ratchet,
+		LatestRatchet:  ratchet.clone(),
+		SigningKey:     cloneBytes(b[5+megolmRatchetLength : exportLength]),
+	}, nil
+}
+
+// ID returns the base64-encoded session ID.
+func (s *InboundGroupSession) ID() string {
+	return Encoding.EncodeToString(s.SigningKey)
+}
+
+// FirstKnownIndex returns the first message index that this session can
+// decrypt.
+func (s *InboundGroupSession) FirstKnownIndex() uint32 {
+	return s.InitialRatchet.Counter
+}
+
+// Decrypt decrypts the given base64-encoded Megolm message. The message index
+// is also returned so that the caller can detect replay attacks.
+func (s *InboundGroupSession) Decrypt(body string) ([]byte, uint32, error) {
+	raw, err := Encoding.DecodeString(body)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "invalid message body")
+	}
+
+	if len(raw) <= macLength+ed25519.SignatureSize {
+		return nil, 0, ErrBadMessageFormat
+	}
+
+	sigStart := len(raw) - ed25519.SignatureSize
+	if !ed25519.Verify(s.SigningKey, raw[:sigStart], raw[sigStart:]) {
+		return nil, 0, errors.New("bad message signature")
+	}
+
+	macStart := sigStart - macLength
+
+	msg, err := decodeMegolmMessage(raw[:macStart])
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if msg.Index < s.InitialRatchet.Counter {
+		return nil, 0, ErrUnknownMessageIndex
+	}
+
+	var ratchet megolmRatchet
+	if msg.Index >= s.LatestRatchet.Counter {
+		s.LatestRatchet.advanceTo(msg.Index)
+		ratchet = s.LatestRatchet
+	} else {
+		ratchet = s.InitialRatchet.clone()
+		ratchet.advanceTo(msg.Index)
+	}
+
+	keys := ratchet.keys()
+	if !keys.verify(raw[:macStart], raw[macStart:sigStart]) {
+		return nil, 0, ErrBadMAC
+	}
+
+	plaintext, err := keys.decrypt(msg.Ciphertext)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return plaintext, msg.Index, nil
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], v)
+	return append(b, buf[:]...)
+}
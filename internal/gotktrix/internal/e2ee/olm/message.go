@@ -0,0 +1,218 @@
+package olm
+
+import (
+	"encoding/binary"
+
+	"github.com/pkg/errors"
+)
+
+// protocolVersion is the version byte prefixed to all Olm and Megolm messages.
+const protocolVersion = 3
+
+// ErrBadMessageFormat is returned if a message cannot be decoded.
+var ErrBadMessageFormat = errors.New("bad message format")
+
+const (
+	wireVarint = 0
+	wireBytes  = 2
+)
+
+type messageWriter []byte
+
+func (w *messageWriter) varint(tag byte, v uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+
+	*w = append(*w, tag<<3|wireVarint)
+	*w = append(*w, buf[:n]...)
+}
+
+func (w *messageWriter) bytes(tag byte, b []byte) {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], uint64(len(b)))
+
+	*w = append(*w, tag<<3|wireBytes)
+	*w = append(*w, buf[:n]...)
+	*w = append(*w, b...)
+}
+
+// decodeFields decodes the protobuf-like body of a message. Each known field
+// is given to the callbacks; unknown fields are skipped.
+func decodeFields(b []byte, onVarint func(tag byte, v uint64), onBytes func(tag byte, v []byte)) error {
+	for len(b) > 0 {
+		key := b[0]
+		b = b[1:]
+
+		switch key & 0x7 {
+		case wireVarint:
+			v, n := binary.Uvarint(b)
+			if n <= 0 {
+				return ErrBadMessageFormat
+			}
+			b = b[n:]
+			onVarint(key>>3, v)
+
+		case wireBytes:
+			l, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < l {
+				return ErrBadMessageFormat
+			}
+			b = b[n:]
+			onBytes(key>>3, b[:l])
+			b = b[l:]
+
+		default:
+			return ErrBadMessageFormat
+		}
+	}
+
+	return nil
+}
+
+// olmMessage is a normal Olm message, excluding its MAC.
+type olmMessage struct {
+	RatchetKey []byte
+	Counter    uint32
+	Ciphertext []byte
+}
+
+func (m olmMessage) encode() []byte {
+	w := messageWriter{protocolVersion}
+	w.bytes(1, m.RatchetKey)
+	w.varint(2, uint64(m.Counter))
+	w.bytes(4, m.Ciphertext)
+	return w
+}
+
+func decodeOlmMessage(b []byte) (olmMessage, error) {
+	var m olmMessage
+
+	if len(b) == 0 || b[0] != protocolVersion {
+		return m, errors.Wrap(ErrBadMessageFormat, "unknown version")
+	}
+
+	err := decodeFields(b[1:],
+		func(tag byte, v uint64) {
+			if tag == 2 {
+				m.Counter = uint32(v)
+			}
+		},
+		func(tag byte, v []byte) {
+			switch tag {
+			case 1:
+				m.RatchetKey = v
+			case 4:
+				m.Ciphertext = v
+			}
+		},
+	)
+	if err != nil {
+		return m, err
+	}
+
+	if len(m.RatchetKey) != 32 || m.Ciphertext == nil {
+		return m, errors.Wrap(ErrBadMessageFormat, "missing fields")
+	}
+
+	return m, nil
+}
+
+// preKeyMessage is an Olm pre-key message, which wraps a normal message along
+// with the keys needed to establish an inbound session.
+type preKeyMessage struct {
+	OneTimeKey  []byte
+	BaseKey     []byte
+	IdentityKey []byte
+	Message     []byte
+}
+
+func (m preKeyMessage) encode() []byte {
+	w := messageWriter{protocolVersion}
+	w.bytes(1, m.OneTimeKey)
+	w.bytes(2, m.BaseKey)
+	w.bytes(3, m.IdentityKey)
+	w.bytes(4, m.Message)
+	return w
+}
+
+func decodePreKeyMessage(b []byte) (preKeyMessage, error) {
+	var m preKeyMessage
+
+	if len(b) == 0 || b[0] != protocolVersion {
+		return m, errors.Wrap(ErrBadMessageFormat, "unknown version")
+	}
+
+	err := decodeFields(b[1:],
+		func(byte, uint64) {},
+		func(tag byte, v []byte) {
+			switch tag {
+			case 1:
+				m.OneTimeKey = v
+			case 2:
+				m.BaseKey = v
+			case 3:
+				m.IdentityKey = v
+			case 4:
+				m.Message = v
+			}
+		},
+	)
+	if err != nil {
+		return m, err
+	}
+
+	if len(m.OneTimeKey) != 32 || len(m.BaseKey) != 32 || len(m.IdentityKey) != 32 {
+		return m, errors.Wrap(ErrBadMessageFormat, "missing keys")
+	}
+	if len(m.Message) <= macLength {
+		return m, errors.Wrap(ErrBadMessageFormat, "missing message")
+	}
+
+	return m, nil
+}
+
+// megolmMessage is a Megolm message, excluding its MAC and signature.
+type megolmMessage struct {
+	Index      uint32
+	Ciphertext []byte
+}
+
+func (m megolmMessage) encode() []byte {
+	w := messageWriter{protocolVersion}
+	w.varint(1, uint64(m.Index))
+	w.bytes(2, m.Ciphertext)
+	return w
+}
+
+func decodeMegolmMessage(b []byte) (megolmMessage, error) {
+	var m megolmMessage
+
+	if len(b) == 0 || b[0] != protocolVersion {
+		return m, errors.Wrap(ErrBadMessageFormat, "unknown version")
+	}
+
+	var hasIndex bool
+
+	err := decodeFields(b[1:],
+		func(tag byte, v uint64) {
+			if tag == 1 {
+				m.Index = uint32(v)
+				hasIndex = true
+			}
+		},
+		func(tag byte, v []byte) {
+			if tag == 2 {
+				m.Ciphertext = v
+			}
+		},
+	)
+	if err != nil {
+		return m, err
+	}
+
+	if !hasIndex || m.Ciphertext == nil {
+		return m, errors.Wrap(ErrBadMessageFormat, "missing fields")
+	}
+
+	return m, nil
+}
@@ -0,0 +1,204 @@
+package olm
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestSession(t *testing.T) {
+	alice, err := NewAccount()
+	if err != nil {
+		t.Fatal("cannot create alice:", err)
+	}
+
+	bob, err := NewAccount()
+	if err != nil {
+		t.Fatal("cannot create bob:", err)
+	}
+
+	if err := bob.GenerateOneTimeKeys(1); err != nil {
+		t.Fatal("cannot generate one-time keys:", err)
+	}
+
+	var bobOTK string
+	for _, key := range bob.UnpublishedOneTimeKeys() {
+		bobOTK = key
+	}
+
+	aliceSession, err := NewOutboundSession(alice, bob.Curve25519(), bobOTK)
+	if err != nil {
+		t.Fatal("cannot create outbound session:", err)
+	}
+
+	typ, body := mustEncrypt(t, aliceSession, "hello, bob")
+	if typ != PreKeyMessage {
+		t.Fatalf("first message has type %d, expected pre-key", typ)
+	}
+
+	bobSession, err := NewInboundSession(bob, alice.Curve25519(), body)
+	if err != nil {
+		t.Fatal("cannot create inbound session:", err)
+	}
+
+	if !bobSession.MatchesInbound(alice.Curve25519(), body) {
+		t.Fatal("inbound session does not match its own pre-key message")
+	}
+
+	if aliceSession.ID() != bobSession.ID() {
+		t.Fatalf("session IDs mismatch: %q != %q", aliceSession.ID(), bobSession.ID())
+	}
+
+	mustDecrypt(t, bobSession, typ, body, "hello, bob")
+
+	if !bob.RemoveOneTimeKeys(bobSession) {
+		t.Fatal("one-time key was not removed")
+	}
+
+	// Bob replies, which switches Alice over to normal messages.
+	typ, body = mustEncrypt(t, bobSession, "hello, alice")
+	if typ != NormalMessage {
+		t.Fatalf("reply has type %d, expected normal", typ)
+	}
+	mustDecrypt(t, aliceSession, typ, body, "hello, alice")
+
+	// Send out of order messages.
+	typ1, body1 := mustEncrypt(t, aliceSession, "one")
+	typ2, body2 := mustEncrypt(t, aliceSession, "two")
+	typ3, body3 := mustEncrypt(t, aliceSession, "three")
+
+	mustDecrypt(t, bobSession, typ3, body3, "three")
+	mustDecrypt(t, bobSession, typ1, body1, "one")
+	mustDecrypt(t, bobSession, typ2, body2, "two")
+
+	// Replays must fail.
+	if _, err := bobSession.Decrypt(typ2, body2); err == nil {
+		t.Fatal("replayed message was decrypted")
+	}
+
+	// Ensure the session still works after a round trip through JSON.
+	var restored Session
+	b, err := json.Marshal(bobSession)
+	if err != nil {
+		t.Fatal("cannot marshal session:", err)
+	}
+	if err := json.Unmarshal(b, &restored); err != nil {
+		t.Fatal("cannot unmarshal session:", err)
+	}
+
+	typ, body = mustEncrypt(t, &restored, "restored")
+	mustDecrypt(t, aliceSession, typ, body, "restored")
+}
+
+func TestSessionTampered(t *testing.T) {
+	alice, _ := NewAccount()
+	bob, _ := NewAccount()
+	bob.GenerateOneTimeKeys(1)
+
+	session, err := NewOutboundSession(alice, bob.Curve25519(), Encoding.EncodeToString(bob.OneTimeKeys[0].Key.Public))
+	if err != nil {
+		t.Fatal("cannot create outbound session:", err)
+	}
+
+	typ, body := mustEncrypt(t, session, "hello")
+
+	raw, _ := Encoding.DecodeString(body)
+	raw[len(raw)-1] ^= 0xFF
+	body = Encoding.EncodeToString(raw)
+
+	inbound, err := NewInboundSession(bob, "", body)
+	if err != nil {
+		t.Fatal("cannot create inbound session:", err)
+	}
+
+	if _, err := inbound.Decrypt(typ, body); err == nil {
+		t.Fatal("tampered message was decrypted")
+	}
+}
+
+func TestGroupSession(t *testing.T) {
+	outbound, err := NewOutboundGroupSession()
+	if err != nil {
+		t.Fatal("cannot create outbound group session:", err)
+	}
+
+	// Skip a message so that the inbound session starts at index 1.
+	outbound.Encrypt([]byte("unseen"))
+
+	inbound, err := NewInboundGroupSession(outbound.SessionKey())
+	if err != nil {
+		t.Fatal("cannot create inbound group session:", err)
+	}
+
+	if inbound.ID() != outbound.ID() {
+		t.Fatalf("session IDs mismatch: %q != %q", inbound.ID(), outbound.ID())
+	}
+	if inbound.FirstKnownIndex() != 1 {
+		t.Fatalf("first known index is %d, expected 1", inbound.FirstKnownIndex())
+	}
+
+	var bodies []string
+	for i := 0; i < 300; i++ {
+		bodies = append(bodies, outbound.Encrypt([]byte{byte(i)}))
+	}
+
+	for _, i := range []int{299, 3, 0, 298, 256, 255} {
+		plaintext, index, err := inbound.Decrypt(bodies[i])
+		if err != nil {
+			t.Fatalf("cannot decrypt message %d: %v", i, err)
+		}
+		if index != uint32(i+1) {
+			t.Fatalf("message %d has index %d", i, index)
+		}
+		if !bytes.Equal(plaintext, []byte{byte(i)}) {
+			t.Fatalf("message %d has plaintext %v", i, plaintext)
+		}
+	}
+}
+
+func TestMegolmAdvanceTo(t *testing.T) {
+	initial := megolmRatchet{Data: make([]byte, megolmRatchetLength)}
+	for i := range initial.Data {
+		initial.Data[i] = byte(i)
+	}
+
+	stepped := initial.clone()
+	for i := 0; i < 0x10203; i++ {
+		stepped.advance()
+	}
+
+	jumped := initial.clone()
+	jumped.advanceTo(0x102)
+	jumped.advanceTo(0x10203)
+
+	if stepped.Counter != jumped.Counter {
+		t.Fatalf("counter mismatch: %d != %d", stepped.Counter, jumped.Counter)
+	}
+	if !bytes.Equal(stepped.Data, jumped.Data) {
+		t.Fatal("ratchet data mismatch")
+	}
+}
+
+func mustEncrypt(t *testing.T, s *Session, plaintext string) (MessageType, string) {
+	t.Helper()
+
+	typ, body, err := s.Encrypt([]byte(plaintext))
+	if err != nil {
+		t.Fatalf("cannot encrypt %q: %v", plaintext, err)
+	}
+
+	return typ, body
+}
+
+func mustDecrypt(t *testing.T, s *Session, typ MessageType, body, expect string) {
+	t.Helper()
+
+	plaintext, err := s.Decrypt(typ, body)
+	if err != nil {
+		t.Fatalf("cannot decrypt %q: %v", expect, err)
+	}
+
+	if string(plaintext) != expect {
+		t.Fatalf("decrypted %q, expected %q", plaintext, expect)
+	}
+}
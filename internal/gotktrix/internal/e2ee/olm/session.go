@@ -0,0 +1,461 @@
+package olm
+
+import (
+	"bytes"
+	"crypto/sha256"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	olmRootInfo    = "OLM_ROOT"
+	olmRatchetInfo = "OLM_RATCHET"
+	olmKeysInfo    = "OLM_KEYS"
+)
+
+const (
+	maxReceiverChains = 5
+	maxSkippedKeys    = 40
+	maxMessageGap     = 2000
+)
+
+// MessageType is the type of an Olm message.
+type MessageType int
+
+const (
+	// PreKeyMessage is sent until the session has received a message from
+	// the other side.
+	PreKeyMessage MessageType = 0
+	// NormalMessage is sent after the session is established.
+	NormalMessage MessageType = 1
+)
+
+var (
+	// ErrUnknownOneTimeKey is returned if a pre-key message uses a one-time
+	// key that the account does not have.
+	ErrUnknownOneTimeKey = errors.New("unknown one-time key")
+	// ErrMessageKeyNotFound is returned if a message key has already been
+	// used or discarded.
+	ErrMessageKeyNotFound = errors.New("message key not found")
+)
+
+type chainKey struct {
+	Index uint32 `json:"index"`
+	Key   []byte `json:"key"`
+}
+
+func (c chainKey) messageKey() []byte {
+	return hmacSHA256(c.Key, []byte{0x01})
+}
+
+func (c chainKey) next() chainKey {
+	return chainKey{
+		Index: c.Index + 1,
+		Key:   hmacSHA256(c.Key, []byte{0x02}),
+	}
+}
+
+type senderChain struct {
+	RatchetKey Curve25519KeyPair `json:"ratchet_key"`
+	ChainKey   chainKey          `json:"chain_key"`
+}
+
+type receiverChain struct {
+	RatchetKey []byte   `json:"ratchet_key"`
+	ChainKey   chainKey `json:"chain_key"`
+}
+
+type skippedKey struct {
+	RatchetKey []byte `json:"ratchet_key"`
+	Index      uint32 `json:"index"`
+	Key        []byte `json:"key"`
+}
+
+// ratchet is the Double Ratchet state of an Olm session.
+type ratchet struct {
+	RootKey        []byte          `json:"root_key"`
+	SenderChains   []senderChain   `json:"sender_chains,omitempty"`
+	ReceiverChains []receiverChain `json:"receiver_chains,omitempty"`
+	SkippedKeys    []skippedKey    `json:"skipped_keys,omitempty"`
+}
+
+func advanceRoot(root []byte, ours Curve25519KeyPair, theirs []byte) ([]byte, chainKey, error) {
+	secret, err := ours.SharedSecret(theirs)
+	if err != nil {
+		return nil, chainKey{}, errors.Wrap(err, "cannot compute ratchet secret")
+	}
+
+	b := hkdfSHA256(root, secret, olmRatchetInfo, 64)
+	return b[:32], chainKey{Key: b[32:]}, nil
+}
+
+func (r *ratchet) encrypt(plaintext []byte) ([]byte, error) {
+	if len(r.SenderChains) == 0 {
+		if len(r.ReceiverChains) == 0 {
+			return nil, errors.New("session has no chains")
+		}
+
+		key, err := NewCurve25519KeyPair()
+		if err != nil {
+			return nil, err
+		}
+
+		root, chain, err := advanceRoot(r.RootKey, key, r.ReceiverChains[0].RatchetKey)
+		if err != nil {
+			return nil, err
+		}
+
+		r.RootKey = root
+		r.SenderChains = []senderChain{{RatchetKey: key, ChainKey: chain}}
+	}
+
+	chain := &r.SenderChains[0]
+
+	keys := deriveMessageKeys(chain.ChainKey.messageKey(), olmKeysInfo)
+	msg := olmMessage{
+		RatchetKey: chain.RatchetKey.Public,
+		Counter:    chain.ChainKey.Index,
+		Ciphertext: keys.encrypt(plaintext),
+	}
+
+	chain.ChainKey = chain.ChainKey.next()
+
+	b := msg.encode()
+	return append(b, keys.sum(b)...), nil
+}
+
+func (r *ratchet) decrypt(body []byte) ([]byte, error) {
+	if len(body) <= macLength {
+		return nil, ErrBadMessageFormat
+	}
+
+	msg, err := decodeOlmMessage(body[:len(body)-macLength])
+	if err != nil {
+		return nil, err
+	}
+
+	var chain *receiverChain
+	for i := range r.ReceiverChains {
+		if bytes.Equal(r.ReceiverChains[i].RatchetKey, msg.RatchetKey) {
+			chain = &r.ReceiverChains[i]
+			break
+		}
+	}
+
+	if chain == nil {
+		// The other side has started a new chain. Verify the message using
+		// the would-be chain before committing to it.
+		if len(r.SenderChains) == 0 {
+			return nil, errors.New("message uses an unknown ratchet key")
+		}
+
+		root, ck, err := advanceRoot(r.RootKey, r.SenderChains[0].RatchetKey, msg.RatchetKey)
+		if err != nil {
+			return nil, err
+		}
+
+		newChain := receiverChain{RatchetKey: cloneBytes(msg.RatchetKey), ChainKey: ck}
+
+		plaintext, skipped, err := decryptChain(&newChain, msg, body)
+		if err != nil {
+			return nil, err
+		}
+
+		r.RootKey = root
+		r.ReceiverChains = append([]receiverChain{newChain}, r.ReceiverChains...)
+		if len(r.ReceiverChains) > maxReceiverChains {
+			r.ReceiverChains = r.ReceiverChains[:maxReceiverChains]
+		}
+		// Our next message will start a new sender chain.
+		r.SenderChains = nil
+		r.addSkipped(skipped)
+
+		return plaintext, nil
+	}
+
+	if msg.Counter < chain.ChainKey.Index {
+		for i, key := range r.SkippedKeys {
+			if key.Index != msg.Counter || !bytes.Equal(key.RatchetKey, msg.RatchetKey) {
+				continue
+			}
+
+			plaintext, err := decryptWithKey(key.Key, msg, body)
+			if err != nil {
+				return nil, err
+			}
+
+			r.SkippedKeys = append(r.SkippedKeys[:i], r.SkippedKeys[i+1:]...)
+			return plaintext, nil
+		}
+
+		return nil, ErrMessageKeyNotFound
+	}
+
+	advanced := *chain
+
+	plaintext, skipped, err := decryptChain(&advanced, msg, body)
+	if err != nil {
+		return nil, err
+	}
+
+	*chain = advanced
+	r.addSkipped(skipped)
+
+	return plaintext, nil
+}
+
+func (r *ratchet) addSkipped(skipped []skippedKey) {
+	r.SkippedKeys = append(r.SkippedKeys, skipped...)
+	if over := len(r.SkippedKeys) - maxSkippedKeys; over > 0 {
+		r.SkippedKeys = append(r.SkippedKeys[:0], r.SkippedKeys[over:]...)
+	}
+}
+
+// decryptChain advances the given chain up to the message's counter and
+// decrypts it. The chain is only valid if no error is returned.
+func decryptChain(chain *receiverChain, msg olmMessage, body []byte) ([]byte, []skippedKey, error) {
+	if msg.Counter-chain.ChainKey.Index > maxMessageGap {
+		return nil, nil, errors.New("message counter is too far ahead")
+	}
+
+	var skipped []skippedKey
+
+	ck := chain.ChainKey
+	for ck.Index < msg.Counter {
+		skipped = append(skipped, skippedKey{
+			RatchetKey: chain.RatchetKey,
+			Index:      ck.Index,
+			Key:        ck.messageKey(),
+		})
+		ck = ck.next()
+	}
+
+	plaintext, err := decryptWithKey(ck.messageKey(), msg, body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	chain.ChainKey = ck.next()
+	return plaintext, skipped, nil
+}
+
+func decryptWithKey(messageKey []byte, msg olmMessage, body []byte) ([]byte, error) {
+	keys := deriveMessageKeys(messageKey, olmKeysInfo)
+
+	macStart := len(body) - macLength
+	if !keys.verify(body[:macStart], body[macStart:]) {
+		return nil, ErrBadMAC
+	}
+
+	return keys.decrypt(msg.Ciphertext)
+}
+
+// Session is a one-to-one Olm session between two devices. It is
+// JSON-serializable.
+type Session struct {
+	AliceIdentityKey []byte  `json:"alice_identity_key"`
+	AliceBaseKey     []byte  `json:"alice_base_key"`
+	BobOneTimeKey    []byte  `json:"bob_one_time_key"`
+	ReceivedMessage  bool    `json:"received_message"`
+	Ratchet          ratchet `json:"ratchet"`
+}
+
+// NewOutboundSession creates a new session to the device with the given
+// base64-encoded identity and one-time keys.
+func NewOutboundSession(a *Account, theirIdentityKey, theirOneTimeKey string) (*Session, error) {
+	identity, err := Encoding.DecodeString(theirIdentityKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid identity key")
+	}
+
+	oneTime, err := Encoding.DecodeString(theirOneTimeKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid one-time key")
+	}
+
+	base, err := NewCurve25519KeyPair()
+	if err != nil {
+		return nil, err
+	}
+
+	ratchetKey, err := NewCurve25519KeyPair()
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := tripleDH(
+		[3]Curve25519KeyPair{a.IdentityKey, base, base},
+		[3][]byte{oneTime, identity, oneTime},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	b := hkdfSHA256(nil, secret, olmRootInfo, 64)
+
+	return &Session{
+		AliceIdentityKey: cloneBytes(a.IdentityKey.Public),
+		AliceBaseKey:     base.Public,
+		BobOneTimeKey:    oneTime,
+		Ratchet: ratchet{
+			RootKey: b[:32],
+			SenderChains: []senderChain{{
+				RatchetKey: ratchetKey,
+				ChainKey:   chainKey{Key: b[32:]},
+			}},
+		},
+	}, nil
+}
+
+// NewInboundSession creates a new session from the given base64-encoded
+// pre-key message. If theirIdentityKey is not empty, then it must match the
+// message's. The caller should call Decrypt with the same message and then
+// remove the used one-time key from the account.
+func NewInboundSession(a *Account, theirIdentityKey, body string) (*Session, error) {
+	raw, err := Encoding.DecodeString(body)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid message body")
+	}
+
+	pk, err := decodePreKeyMessage(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	if theirIdentityKey != "" {
+		identity, err := Encoding.DecodeString(theirIdentityKey)
+		if err != nil || !bytes.Equal(identity, pk.IdentityKey) {
+			return nil, errors.New("identity key mismatch")
+		}
+	}
+
+	inner, err := decodeOlmMessage(pk.Message[:len(pk.Message)-macLength])
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid inner message")
+	}
+
+	oneTime := a.lookupOneTimeKey(pk.OneTimeKey)
+	if oneTime == nil {
+		return nil, ErrUnknownOneTimeKey
+	}
+
+	secret, err := tripleDH(
+		[3]Curve25519KeyPair{*oneTime, a.IdentityKey, *oneTime},
+		[3][]byte{pk.IdentityKey, pk.BaseKey, pk.BaseKey},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	b := hkdfSHA256(nil, secret, olmRootInfo, 64)
+
+	return &Session{
+		AliceIdentityKey: cloneBytes(pk.IdentityKey),
+		AliceBaseKey:     cloneBytes(pk.BaseKey),
+		BobOneTimeKey:    cloneBytes(pk.OneTimeKey),
+		Ratchet: ratchet{
+			RootKey: b[:32],
+			ReceiverChains: []receiverChain{{
+				RatchetKey: cloneBytes(inner.RatchetKey),
+				ChainKey:   chainKey{Key: b[32:]},
+			}},
+		},
+	}, nil
+}
+
+func tripleDH(ours [3]Curve25519KeyPair, theirs [3][]byte) ([]byte, error) {
+	secret := make([]byte, 0, 96)
+	for i := range ours {
+		s, err := ours[i].SharedSecret(theirs[i])
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot compute shared secret")
+		}
+		secret = append(secret, s...)
+	}
+	return secret, nil
+}
+
+// ID returns the base64-encoded session ID.
+func (s *Session) ID() string {
+	h := sha256.New()
+	h.Write(s.AliceIdentityKey)
+	h.Write(s.AliceBaseKey)
+	h.Write(s.BobOneTimeKey)
+	return Encoding.EncodeToString(h.Sum(nil))
+}
+
+// MatchesInbound returns true if the given base64-encoded pre-key message was
+// sent for this session. If theirIdentityKey is not empty, then it is also
+// checked.
+func (s *Session) MatchesInbound(theirIdentityKey, body string) bool {
+	raw, err := Encoding.DecodeString(body)
+	if err != nil {
+		return false
+	}
+
+	pk, err := decodePreKeyMessage(raw)
+	if err != nil {
+		return false
+	}
+
+	if theirIdentityKey != "" {
+		identity, err := Encoding.DecodeString(theirIdentityKey)
+		if err != nil || !bytes.Equal(identity, s.AliceIdentityKey) {
+			return false
+		}
+	}
+
+	return bytes.Equal(pk.IdentityKey, s.AliceIdentityKey) &&
+		bytes.Equal(pk.BaseKey, s.AliceBaseKey) &&
+		bytes.Equal(pk.OneTimeKey, s.BobOneTimeKey)
+}
+
+// Encrypt encrypts the given plaintext and returns the message type and the
+// base64-encoded body.
+func (s *Session) Encrypt(plaintext []byte) (MessageType, string, error) {
+	msg, err := s.Ratchet.encrypt(plaintext)
+	if err != nil {
+		return 0, "", err
+	}
+
+	if s.ReceivedMessage {
+		return NormalMessage, Encoding.EncodeToString(msg), nil
+	}
+
+	pk := preKeyMessage{
+		OneTimeKey:  s.BobOneTimeKey,
+		BaseKey:     s.AliceBaseKey,
+		IdentityKey: s.AliceIdentityKey,
+		Message:     msg,
+	}
+
+	return PreKeyMessage, Encoding.EncodeToString(pk.encode()), nil
+}
+
+// Decrypt decrypts the given base64-encoded message.
+func (s *Session) Decrypt(typ MessageType, body string) ([]byte, error) {
+	raw, err := Encoding.DecodeString(body)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid message body")
+	}
+
+	switch typ {
+	case PreKeyMessage:
+		pk, err := decodePreKeyMessage(raw)
+		if err != nil {
+			return nil, err
+		}
+		raw = pk.Message
+	case NormalMessage:
+	default:
+		return nil, errors.Errorf("unknown message type %d", typ)
+	}
+
+	plaintext, err := s.Ratchet.decrypt(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	s.ReceivedMessage = true
+	return plaintext, nil
+}
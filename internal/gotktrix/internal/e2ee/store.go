@@ -0,0 +1,308 @@
+package e2ee
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+
+	"github.com/diamondburned/gotktrix/internal/gotktrix/internal/db"
+	"github.com/diamondburned/gotktrix/internal/gotktrix/internal/e2ee/olm"
+	"github.com/diamondburned/gotrix/matrix"
+	"github.com/pkg/errors"
+)
+
+// store is the on-disk crypto store. All of its methods must be called with
+// the Machine's mutex held.
+//
+// The account and sessions are pickled into JSON, which has their private
+// keys, so they're encrypted using the pickle key before being stored.
+type store struct {
+	kv   *db.KV
+	top  storeNode
+	aead cipher.AEAD
+
+	// olmSessions maps sender keys to olmSessionList.
+	olmSessions db.Node
+	// inbound maps room IDs to session IDs to inboundGroupSession.
+	inbound db.Node
+	// outbound maps room IDs to outboundGroupSession.
+	outbound db.Node
+	// devices maps user IDs to userDevices.
+	devices db.Node
+	// indices maps room IDs to "session_id:index" keys to event IDs. It is
+	// used to detect replayed Megolm messages.
+	indices db.Node
+}
+
+type storeNode struct {
+	db.Node
+	path db.NodePath
+}
+
+func newStore(kv *db.KV, pickleKey []byte) (store, error) {
+	c, err := aes.NewCipher(pickleKey)
+	if err != nil {
+		return store{}, errors.Wrap(err, "invalid pickle key")
+	}
+
+	aead, err := cipher.NewGCM(c)
+	if err != nil {
+		return store{}, errors.Wrap(err, "cannot create GCM cipher")
+	}
+
+	topPath := db.NewNodePath("e2ee")
+	top := kv.NodeFromPath(topPath)
+
+	return store{
+		kv:          kv,
+		top:         storeNode{top, topPath},
+		aead:        aead,
+		olmSessions: top.Node("olm_sessions"),
+		inbound:     top.Node("inbound_group_sessions"),
+		outbound:    top.Node("outbound_group_sessions"),
+		devices:     top.Node("devices"),
+		indices:     top.Node("message_indices"),
+	}, nil
+}
+
+// seal encrypts the given plaintext. The nonce is prepended.
+func (s store) seal(plaintext []byte) []byte {
+	nonce := make([]byte, s.aead.NonceSize(), s.aead.NonceSize()+len(plaintext)+s.aead.Overhead())
+	rand.Read(nonce)
+
+	return s.aead.Seal(nonce, nonce, plaintext, nil)
+}
+
+// open decrypts the value encrypted by seal.
+func (s store) open(sealed []byte) ([]byte, error) {
+	size := s.aead.NonceSize()
+	if len(sealed) < size {
+		return nil, errors.New("sealed value is too short")
+	}
+
+	plaintext, err := s.aead.Open(nil, sealed[:size], sealed[size:], nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot decrypt sealed value")
+	}
+
+	return plaintext, nil
+}
+
+// getSealed gets the value set by setSealed into v.
+func (s store) getSealed(n db.Node, k string, v interface{}) error {
+	return n.Get(k, func(b []byte) error {
+		plaintext, err := s.open(b)
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(plaintext, v)
+	})
+}
+
+// setSealed marshals v into JSON and sets it encrypted.
+func (s store) setSealed(n db.Node, k string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return errors.Wrap(err, "cannot marshal")
+	}
+	return n.Set(k, s.seal(b))
+}
+
+// sealPlaintext encrypts the account and sessions that version 1 of the
+// database kept in plaintext.
+func (s store) sealPlaintext() error {
+	if s.top.Exists("account") {
+		if err := s.sealKeys(s.top.Node, "account"); err != nil {
+			return err
+		}
+	}
+
+	for _, n := range []db.Node{s.olmSessions, s.outbound} {
+		if err := s.sealKeys(n); err != nil {
+			return err
+		}
+	}
+
+	var roomIDs []string
+	s.inbound.Each(func(k string, b []byte, _ int) error {
+		// Rooms are nodes, which have no values.
+		if b == nil {
+			roomIDs = append(roomIDs, k)
+		}
+		return nil
+	})
+
+	for _, roomID := range roomIDs {
+		if err := s.sealKeys(s.inbound.Node(roomID)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sealKeys encrypts the plaintext values of the given keys in the node, or all
+// of its values if there are no keys.
+func (s store) sealKeys(n db.Node, keys ...string) error {
+	if len(keys) == 0 {
+		n.Each(func(k string, b []byte, _ int) error {
+			if b != nil {
+				keys = append(keys, k)
+			}
+			return nil
+		})
+	}
+
+	for _, k := range keys {
+		var plaintext []byte
+		if err := n.Get(k, func(b []byte) error {
+			plaintext = append(plaintext, b...)
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		if err := n.Set(k, s.seal(plaintext)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s store) account() (*olm.Account, error) {
+	var acc olm.Account
+	if err := s.getSealed(s.top.Node, "account", &acc); err != nil {
+		return nil, err
+	}
+	return &acc, nil
+}
+
+func (s store) setAccount(acc *olm.Account) error {
+	return s.setSealed(s.top.Node, "account", acc)
+}
+
+// uploadedDeviceKeys returns true if the device keys have been uploaded.
+func (s store) uploadedDeviceKeys() bool {
+	return s.top.Exists("uploaded_device_keys")
+}
+
+func (s store) setUploadedDeviceKeys() error {
+	return s.top.Set("uploaded_device_keys", []byte("1"))
+}
+
+// olmSessionList is the list of Olm sessions with a single device. The most
+// recently used session is first.
+type olmSessionList []*olm.Session
+
+func (s store) olmSessionList(senderKey string) olmSessionList {
+	var sessions olmSessionList
+	s.getSealed(s.olmSessions, senderKey, &sessions)
+	return sessions
+}
+
+func (s store) setOlmSessionList(senderKey string, sessions olmSessionList) error {
+	const maxSessions = 10
+	if len(sessions) > maxSessions {
+		sessions = sessions[:maxSessions]
+	}
+	return s.setSealed(s.olmSessions, senderKey, sessions)
+}
+
+// inboundGroupSession is an inbound Megolm session along with the keys of the
+// device that sent it.
+type inboundGroupSession struct {
+	Session    *olm.InboundGroupSession `json:"session"`
+	SenderKey  string                   `json:"sender_key"`
+	SigningKey string                   `json:"signing_key"`
+}
+
+func (s store) inboundGroupSession(roomID matrix.RoomID, sessionID string) (*inboundGroupSession, error) {
+	var session inboundGroupSession
+	if err := s.getSealed(s.inbound.Node(string(roomID)), sessionID, &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (s store) setInboundGroupSession(roomID matrix.RoomID, session *inboundGroupSession) error {
+	return s.setSealed(s.inbound.Node(string(roomID)), session.Session.ID(), session)
+}
+
+// outboundGroupSession is an outbound Megolm session for a room.
+type outboundGroupSession struct {
+	Session *olm.OutboundGroupSession `json:"session"`
+	// CreatedAt is the Unix timestamp in milliseconds.
+	CreatedAt int64 `json:"created_at"`
+	// SharedWith is the set of devices that the session key was sent to.
+	SharedWith map[matrix.UserID]map[matrix.DeviceID]bool `json:"shared_with"`
+}
+
+func (s store) outboundGroupSession(roomID matrix.RoomID) (*outboundGroupSession, error) {
+	var session outboundGroupSession
+	if err := s.getSealed(s.outbound, string(roomID), &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (s store) setOutboundGroupSession(roomID matrix.RoomID, session *outboundGroupSession) error {
+	return s.setSealed(s.outbound, string(roomID), session)
+}
+
+// userDevices is the list of known devices of a user.
+type userDevices struct {
+	// Outdated is true if the device list has to be queried again.
+	Outdated bool                           `json:"outdated"`
+	Devices  map[matrix.DeviceID]deviceInfo `json:"devices"`
+}
+
+// deviceInfo is a verified device.
+type deviceInfo struct {
+	Curve25519 string `json:"curve25519"`
+	Ed25519    string `json:"ed25519"`
+}
+
+func (s store) userDevices(userID matrix.UserID) (*userDevices, bool) {
+	var devices userDevices
+	if err := s.devices.GetAny(string(userID), &devices); err != nil {
+		return nil, false
+	}
+	return &devices, true
+}
+
+func (s store) setUserDevices(userID matrix.UserID, devices *userDevices) error {
+	return s.devices.SetAny(string(userID), devices)
+}
+
+// deviceByKey returns the device of the user with the given Curve25519
+// identity key.
+func (s store) deviceByKey(userID matrix.UserID, curve25519 string) (deviceRef, bool) {
+	devices, ok := s.userDevices(userID)
+	if !ok {
+		return deviceRef{}, false
+	}
+
+	for deviceID, info := range devices.Devices {
+		if info.Curve25519 == curve25519 {
+			return deviceRef{UserID: userID, DeviceID: deviceID, deviceInfo: info}, true
+		}
+	}
+
+	return deviceRef{}, false
+}
+
+// messageIndex returns the event ID that the given message index was first
+// seen in.
+func (s store) messageIndex(roomID matrix.RoomID, key string) (matrix.EventID, bool) {
+	var eventID string
+	if err := s.indices.Node(string(roomID)).Get(key, db.StringFunc(&eventID)); err != nil {
+		return "", false
+	}
+	return matrix.EventID(eventID), true
+}
+
+func (s store) setMessageIndex(roomID matrix.RoomID, key string, eventID matrix.EventID) error {
+	return s.indices.Node(string(roomID)).Set(key, []byte(eventID))
+}
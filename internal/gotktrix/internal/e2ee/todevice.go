@@ -0,0 +1,344 @@
+package e2ee
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/diamondburned/gotktrix/internal/gotktrix/events/m"
+	"github.com/diamondburned/gotktrix/internal/gotktrix/internal/e2ee/olm"
+	"github.com/diamondburned/gotrix/api"
+	"github.com/diamondburned/gotrix/event"
+	"github.com/diamondburned/gotrix/matrix"
+	"github.com/pkg/errors"
+)
+
+// RoomKeyEventType is the to-device event type for m.room_key.
+const RoomKeyEventType event.Type = "m.room_key"
+
+const encryptedEventType = m.EncryptedEventType
+
+// Aliases of the events in package m, since the Machine receiver shadows it.
+type (
+	encryptionEvent = m.EncryptionEvent
+	encryptedEvent  = m.EncryptedEvent
+)
+
+// toDeviceEvent is a to-device event as received in a sync.
+type toDeviceEvent struct {
+	Type    event.Type      `json:"type"`
+	Sender  matrix.UserID   `json:"sender"`
+	Content json.RawMessage `json:"content"`
+}
+
+// olmCiphertext is a single Olm message inside m.room.encrypted.
+type olmCiphertext struct {
+	Type olm.MessageType `json:"type"`
+	Body string          `json:"body"`
+}
+
+// olmPayload is the plaintext of an Olm-encrypted event.
+type olmPayload struct {
+	Type          event.Type        `json:"type"`
+	Content       json.RawMessage   `json:"content"`
+	Sender        matrix.UserID     `json:"sender"`
+	SenderDevice  matrix.DeviceID   `json:"sender_device,omitempty"`
+	Recipient     matrix.UserID     `json:"recipient"`
+	RecipientKeys map[string]string `json:"recipient_keys"`
+	Keys          map[string]string `json:"keys"`
+}
+
+// roomKeyContent is the content of an m.room_key event.
+type roomKeyContent struct {
+	Algorithm  string        `json:"algorithm"`
+	RoomID     matrix.RoomID `json:"room_id"`
+	SessionID  string        `json:"session_id"`
+	SessionKey string        `json:"session_key"`
+}
+
+// olmEvent is a decrypted Olm to-device event.
+type olmEvent struct {
+	Sender    matrix.UserID
+	SenderKey string
+	Payload   olmPayload
+}
+
+// HandleSync handles the encryption-related parts of the sync response. It
+// also decrypts all room timeline events in place, so it must be called before
+// anything else reads the response.
+func (m *Machine) HandleSync(sync *api.SyncResponse) {
+	m.mu.Lock()
+
+	// The devices of users that we no longer share a room with are kept, since
+	// they're needed to verify their older messages.
+	for _, userIDs := range [][]matrix.UserID{sync.DeviceLists.Changed, sync.DeviceLists.Left} {
+		for _, userID := range userIDs {
+			m.deviceChanges[userID]++
+
+			if devices, ok := m.store.userDevices(userID); ok {
+				devices.Outdated = true
+				m.store.setUserDevices(userID, devices)
+			}
+		}
+	}
+
+	var events []*olmEvent
+	var senders []matrix.UserID
+
+	for _, raw := range sync.ToDevice.Events {
+		var ev toDeviceEvent
+		if err := json.Unmarshal(raw, &ev); err != nil {
+			continue
+		}
+
+		if ev.Type != encryptedEventType {
+			continue
+		}
+
+		olmEv, err := m.decryptOlmEvent(ev)
+		if err != nil {
+			log.Printf("crypto: cannot handle to-device event from %s: %v", ev.Sender, err)
+			continue
+		}
+
+		events = append(events, olmEv)
+		senders = append(senders, olmEv.Sender)
+	}
+
+	m.mu.Unlock()
+
+	// Room keys must come from a known device, so query the senders' devices
+	// without holding the mutex.
+	if len(senders) > 0 {
+		if err := m.queryDevices(m.client, senders); err != nil {
+			log.Println("crypto: cannot query devices of to-device senders:", err)
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, ev := range events {
+		if err := m.handleOlmEvent(ev); err != nil {
+			log.Printf("crypto: cannot handle to-device event from %s: %v", ev.Sender, err)
+		}
+	}
+
+	m.replenish(sync.DeviceOneTimeKeysCount)
+
+	for roomID, room := range sync.Rooms.Joined {
+		m.decryptRaws(roomID, room.Timeline.Events)
+	}
+
+	for roomID, room := range sync.Rooms.Left {
+		m.decryptRaws(roomID, room.Timeline.Events)
+	}
+}
+
+// decryptOlmEvent decrypts the given m.room.encrypted to-device event.
+func (m *Machine) decryptOlmEvent(ev toDeviceEvent) (*olmEvent, error) {
+	var content struct {
+		Algorithm  string                   `json:"algorithm"`
+		SenderKey  string                   `json:"sender_key"`
+		Ciphertext map[string]olmCiphertext `json:"ciphertext"`
+	}
+
+	if err := json.Unmarshal(ev.Content, &content); err != nil {
+		return nil, errors.Wrap(err, "invalid m.room.encrypted")
+	}
+
+	if content.Algorithm != OlmAlgorithm {
+		return nil, errors.Errorf("unsupported algorithm %q", content.Algorithm)
+	}
+
+	ciphertext, ok := content.Ciphertext[m.account.Curve25519()]
+	if !ok {
+		return nil, errors.New("event is not encrypted for this device")
+	}
+
+	plaintext, err := m.olmDecrypt(content.SenderKey, ciphertext)
+	if err != nil {
+		return nil, err
+	}
+
+	var payload olmPayload
+	if err := json.Unmarshal(plaintext, &payload); err != nil {
+		return nil, errors.Wrap(err, "invalid olm payload")
+	}
+
+	if payload.Sender != ev.Sender {
+		return nil, errors.New("olm payload sender mismatch")
+	}
+	if payload.Recipient != m.userID || payload.RecipientKeys["ed25519"] != m.account.Ed25519() {
+		return nil, errors.New("olm payload is not meant for this device")
+	}
+
+	return &olmEvent{
+		Sender:    ev.Sender,
+		SenderKey: content.SenderKey,
+		Payload:   payload,
+	}, nil
+}
+
+// handleOlmEvent handles the given decrypted to-device event. The sender's
+// devices should already be queried.
+func (m *Machine) handleOlmEvent(ev *olmEvent) error {
+	switch ev.Payload.Type {
+	case RoomKeyEventType:
+		return m.handleRoomKey(ev.Sender, ev.SenderKey, ev.Payload)
+	default:
+		return nil
+	}
+}
+
+// olmDecrypt decrypts the given Olm message from the device with the given
+// identity key, creating a new inbound session if needed.
+func (m *Machine) olmDecrypt(senderKey string, ciphertext olmCiphertext) ([]byte, error) {
+	sessions := m.store.olmSessionList(senderKey)
+
+	for i, session := range sessions {
+		if ciphertext.Type == olm.PreKeyMessage && !session.MatchesInbound(senderKey, ciphertext.Body) {
+			continue
+		}
+
+		plaintext, err := session.Decrypt(ciphertext.Type, ciphertext.Body)
+		if err != nil {
+			if ciphertext.Type == olm.PreKeyMessage {
+				// The message matched this session, so no other session can
+				// decrypt it.
+				return nil, errors.Wrap(err, "cannot decrypt with matching session")
+			}
+			continue
+		}
+
+		// Move the session to the front, since it's the latest one.
+		sessions = append(sessions[:i], sessions[i+1:]...)
+		sessions = append(olmSessionList{session}, sessions...)
+
+		if err := m.store.setOlmSessionList(senderKey, sessions); err != nil {
+			return nil, errors.Wrap(err, "cannot save olm session")
+		}
+
+		return plaintext, nil
+	}
+
+	if ciphertext.Type != olm.PreKeyMessage {
+		return nil, errors.New("no olm session can decrypt message")
+	}
+
+	session, err := olm.NewInboundSession(m.account, senderKey, ciphertext.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot create inbound session")
+	}
+
+	plaintext, err := session.Decrypt(ciphertext.Type, ciphertext.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot decrypt with new inbound session")
+	}
+
+	m.account.RemoveOneTimeKeys(session)
+
+	if err := m.store.setAccount(m.account); err != nil {
+		return nil, errors.Wrap(err, "cannot save account")
+	}
+
+	sessions = append(olmSessionList{session}, sessions...)
+
+	if err := m.store.setOlmSessionList(senderKey, sessions); err != nil {
+		return nil, errors.Wrap(err, "cannot save olm session")
+	}
+
+	return plaintext, nil
+}
+
+// handleRoomKey saves the room key sent by the device with the given identity
+// key. The device must be a known device of the sender whose fingerprint key
+// is the one that the key claims to be signed by.
+func (m *Machine) handleRoomKey(sender matrix.UserID, senderKey string, payload olmPayload) error {
+	device, ok := m.store.deviceByKey(sender, senderKey)
+	if !ok {
+		return errors.Errorf("room key is from an unknown device of %s", sender)
+	}
+
+	if payload.SenderDevice != "" && payload.SenderDevice != device.DeviceID {
+		return errors.New("room key sender device mismatch")
+	}
+
+	if payload.Keys["ed25519"] != device.Ed25519 {
+		return errors.New("room key signing key mismatch")
+	}
+
+	var content roomKeyContent
+	if err := json.Unmarshal(payload.Content, &content); err != nil {
+		return errors.Wrap(err, "invalid m.room_key")
+	}
+
+	if content.Algorithm != MegolmAlgorithm {
+		return errors.Errorf("unsupported room key algorithm %q", content.Algorithm)
+	}
+
+	session, err := olm.NewInboundGroupSession(content.SessionKey)
+	if err != nil {
+		return errors.Wrap(err, "invalid room key")
+	}
+
+	if session.ID() != content.SessionID {
+		return errors.New("room key session ID mismatch")
+	}
+
+	// Don't replace a session that can decrypt earlier messages.
+	if old, err := m.store.inboundGroupSession(content.RoomID, content.SessionID); err == nil {
+		if old.Session.FirstKnownIndex() <= session.FirstKnownIndex() {
+			return nil
+		}
+	}
+
+	return m.store.setInboundGroupSession(content.RoomID, &inboundGroupSession{
+		Session:    session,
+		SenderKey:  senderKey,
+		SigningKey: device.Ed25519,
+	})
+}
+
+// olmEncrypt encrypts the given event for the given device. The device must
+// already have an Olm session.
+func (m *Machine) olmEncrypt(device deviceRef, typ event.Type, content interface{}) (interface{}, error) {
+	sessions := m.store.olmSessionList(device.Curve25519)
+	if len(sessions) == 0 {
+		return nil, errors.New("no olm session")
+	}
+
+	rawContent, err := json.Marshal(content)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot marshal content")
+	}
+
+	payload, err := json.Marshal(olmPayload{
+		Type:          typ,
+		Content:       rawContent,
+		Sender:        m.userID,
+		SenderDevice:  m.deviceID,
+		Recipient:     device.UserID,
+		RecipientKeys: map[string]string{"ed25519": device.Ed25519},
+		Keys:          map[string]string{"ed25519": m.account.Ed25519()},
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot marshal payload")
+	}
+
+	msgType, body, err := sessions[0].Encrypt(payload)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot encrypt")
+	}
+
+	if err := m.store.setOlmSessionList(device.Curve25519, sessions); err != nil {
+		return nil, errors.Wrap(err, "cannot save olm session")
+	}
+
+	return map[string]interface{}{
+		"algorithm":  OlmAlgorithm,
+		"sender_key": m.account.Curve25519(),
+		"ciphertext": map[string]olmCiphertext{
+			device.Curve25519: {Type: msgType, Body: body},
+		},
+	}, nil
+}
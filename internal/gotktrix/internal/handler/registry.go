@@ -2,8 +2,10 @@ package handler
 
 import (
 	"context"
+	"encoding/json"
 	"sync"
 
+	"github.com/diamondburned/gotktrix/internal/gotktrix/events/m"
 	"github.com/diamondburned/gotktrix/internal/gotktrix/events/sys"
 	"github.com/diamondburned/gotktrix/internal/gotktrix/internal/state"
 	"github.com/diamondburned/gotktrix/internal/registry"
@@ -23,6 +25,11 @@ type Registry struct {
 	// on-sync handlers
 	sync registry.Registry
 
+	// ignored is the set of users whose timeline events aren't dispatched. It
+	// has its own mutex, since handlers may check it while being invoked.
+	ignored    map[matrix.UserID]struct{}
+	ignoredMut sync.RWMutex
+
 	caughtUp bool
 }
 
@@ -42,6 +49,72 @@ func (r *Registry) Wrap(state gotrix.State) gotrix.State {
 	return wrapper{state, r}
 }
 
+// SetIgnoredUsers sets the users whose timeline events are not dispatched to
+// any handler. It is updated by the m.ignored_user_list event in each sync.
+func (r *Registry) SetIgnoredUsers(userIDs []matrix.UserID) {
+	ignored := make(map[matrix.UserID]struct{}, len(userIDs))
+	for _, userID := range userIDs {
+		ignored[userID] = struct{}{}
+	}
+
+	r.ignoredMut.Lock()
+	r.ignored = ignored
+	r.ignoredMut.Unlock()
+}
+
+// IsIgnored returns true if the given user is ignored.
+func (r *Registry) IsIgnored(userID matrix.UserID) bool {
+	r.ignoredMut.RLock()
+	defer r.ignoredMut.RUnlock()
+
+	_, ok := r.ignored[userID]
+	return ok
+}
+
+// updateIgnored updates the ignored users if the given account data events
+// contain the m.ignored_user_list event.
+func (r *Registry) updateIgnored(raws []event.RawEvent) {
+	for _, raw := range raws {
+		if state.GuessType(raw) != m.IgnoredUserListEventType {
+			continue
+		}
+
+		e, err := sys.ParseAs(raw, m.IgnoredUserListEventType)
+		if err == nil {
+			r.SetIgnoredUsers(e.(*m.IgnoredUserListEvent).UserIDs())
+		}
+	}
+}
+
+// timelineSender should be kept in sync with the sender field of room events.
+type timelineSender struct {
+	Sender matrix.UserID `json:"sender"`
+}
+
+// filterIgnored returns the given events without the ones sent by ignored
+// users. The given slice is returned if there are none.
+func (r *Registry) filterIgnored(raws []event.RawEvent) []event.RawEvent {
+	r.ignoredMut.RLock()
+	defer r.ignoredMut.RUnlock()
+
+	if len(r.ignored) == 0 {
+		return raws
+	}
+
+	filtered := raws[:0:0]
+
+	for i, raw := range raws {
+		var sender timelineSender
+		json.Unmarshal(raw, &sender)
+
+		if _, ok := r.ignored[sender.Sender]; !ok {
+			filtered = append(filtered, raws[i])
+		}
+	}
+
+	return filtered
+}
+
 // OnSync is called after the state updates on every sync.
 func (r *Registry) OnSync(f func(*api.SyncResponse)) func() {
 	r.mut.Lock()
@@ -144,6 +217,28 @@ func (r *Registry) SubscribeRoomSync(rID matrix.RoomID, f func()) func() {
 	return r.SubscribeRoom(rID, roomSyncEventType, f)
 }
 
+type roomDraftEvent struct{}
+
+const roomDraftEventType event.Type = "__roomDraftEvent"
+
+func (ev roomDraftEvent) Info() *event.EventInfo {
+	return &event.EventInfo{Type: roomDraftEventType}
+}
+
+// SubscribeRoomDraft subscribes f to be called every time the room's draft is
+// saved.
+func (r *Registry) SubscribeRoomDraft(rID matrix.RoomID, f func()) func() {
+	return r.SubscribeRoom(rID, roomDraftEventType, f)
+}
+
+// InvokeRoomDraft calls all functions subscribed to the room's draft.
+func (r *Registry) InvokeRoomDraft(rID matrix.RoomID) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	r.invokeRoomSingle(rID, roomDraftEvent{})
+}
+
 // SubscribeRoomStateKey is similarly to SubscribeRoom, except it only filters
 // for the given state key.
 func (r *Registry) SubscribeRoomStateKey(
@@ -180,6 +275,10 @@ func (r *Registry) AddEvents(sync *api.SyncResponse) error {
 	r.mut.Lock()
 	defer r.mut.Unlock()
 
+	// Update the ignored users first, so that the events in the same sync are
+	// filtered using the new list.
+	r.updateIgnored(sync.AccountData.Events)
+
 	invokeSync(r.sync, sync)
 
 	r.invokeUser(sync.Presence.Events)
@@ -260,6 +359,7 @@ func (r *Registry) invokeRoomSingle(rID matrix.RoomID, ev event.Event) {
 }
 
 func (r *Registry) invokeTimeline(rID matrix.RoomID, raws []event.RawEvent) {
+	raws = r.filterIgnored(raws)
 	if len(raws) == 0 {
 		return
 	}
@@ -0,0 +1,128 @@
+package httptrick
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+)
+
+// maxRetryBody is the largest request body that TokenIntercept keeps in memory
+// so that the request can be retried. Larger requests, such as uploads, fail
+// instead of being retried.
+const maxRetryBody = 1 << 20 // 1MB
+
+// TokenSource supplies the access token of authenticated requests.
+type TokenSource interface {
+	// Token returns the current access token. The token may be refreshed
+	// first if it's about to expire.
+	Token(ctx context.Context) (string, error)
+	// Refresh refreshes the token after the homeserver rejected the given old
+	// one. If the token has already been refreshed since, then the new token
+	// is returned as-is.
+	Refresh(ctx context.Context, old string) (string, error)
+}
+
+// TokenIntercept returns an InterceptFullFunc that sets the Authorization
+// header of authenticated requests to the token from src. If the homeserver
+// rejects the token with M_UNKNOWN_TOKEN and soft_logout, then the token is
+// refreshed and the request is retried once.
+func TokenIntercept(src TokenSource) InterceptFullFunc {
+	return func(r *http.Request, next func() (*http.Response, error)) (*http.Response, error) {
+		if r.Header.Get("Authorization") == "" {
+			return next()
+		}
+
+		token, err := src.Token(r.Context())
+		if err != nil {
+			log.Println("cannot get a fresh access token:", err)
+		} else {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		canRetry := rewindableBody(r)
+
+		resp, err := next()
+		if err != nil || resp.StatusCode != http.StatusUnauthorized || !canRetry {
+			return resp, err
+		}
+
+		if !isSoftLogout(resp) {
+			return resp, nil
+		}
+
+		newToken, err := src.Refresh(r.Context(), token)
+		if err != nil {
+			log.Println("cannot refresh access token:", err)
+			return resp, nil
+		}
+
+		if r.GetBody != nil {
+			body, err := r.GetBody()
+			if err != nil {
+				return resp, nil
+			}
+			r.Body = body
+		}
+
+		resp.Body.Close()
+		r.Header.Set("Authorization", "Bearer "+newToken)
+
+		return next()
+	}
+}
+
+// rewindableBody ensures that the request's body can be read again through
+// GetBody. False is returned if it can't.
+func rewindableBody(r *http.Request) bool {
+	if r.Body == nil || r.Body == http.NoBody || r.GetBody != nil {
+		return true
+	}
+
+	if r.ContentLength < 0 || r.ContentLength > maxRetryBody {
+		return false
+	}
+
+	b, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		r.Body = io.NopCloser(errReader{err})
+		return false
+	}
+
+	r.Body = io.NopCloser(bytes.NewReader(b))
+	r.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(b)), nil
+	}
+
+	return true
+}
+
+type errReader struct{ err error }
+
+func (r errReader) Read([]byte) (int, error) { return 0, r.err }
+
+// isSoftLogout returns true if the response is an M_UNKNOWN_TOKEN error with
+// soft_logout set. The response body is restored afterwards.
+func isSoftLogout(resp *http.Response) bool {
+	b, err := io.ReadAll(io.LimitReader(resp.Body, maxRetryBody))
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(b))
+
+	if err != nil {
+		return false
+	}
+
+	var apiErr struct {
+		Code       string `json:"errcode"`
+		SoftLogout bool   `json:"soft_logout"`
+	}
+
+	if err := json.Unmarshal(b, &apiErr); err != nil {
+		return false
+	}
+
+	return apiErr.Code == "M_UNKNOWN_TOKEN" && apiErr.SoftLogout
+}
@@ -0,0 +1,119 @@
+package httptrick
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type testTokenSource struct {
+	mu        sync.Mutex
+	token     string
+	refreshes int
+}
+
+func (s *testTokenSource) Token(context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.token, nil
+}
+
+func (s *testTokenSource) Refresh(_ context.Context, old string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token == old {
+		s.token = "new"
+		s.refreshes++
+	}
+
+	return s.token, nil
+}
+
+func TestTokenIntercept(t *testing.T) {
+	var bodies []string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(b))
+
+		switch r.Header.Get("Authorization") {
+		case "":
+			w.Write([]byte(`{}`))
+		case "Bearer new":
+			w.Write([]byte(`{"ok":true}`))
+		case "Bearer dead":
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"errcode":"M_UNKNOWN_TOKEN","soft_logout":false}`))
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"errcode":"M_UNKNOWN_TOKEN","soft_logout":true}`))
+		}
+	}))
+	defer srv.Close()
+
+	src := &testTokenSource{token: "old"}
+
+	interceptor := WrapInterceptor(nil)
+	interceptor.AddInterceptFull(TokenIntercept(src))
+	client := http.Client{Transport: interceptor}
+
+	do := func(auth, body string) *http.Response {
+		t.Helper()
+
+		req, err := http.NewRequest("POST", srv.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Mimic gotrix, which sets the body without GetBody.
+		req.Body = io.NopCloser(strings.NewReader(body))
+		req.ContentLength = int64(len(body))
+		if auth != "" {
+			req.Header.Set("Authorization", "Bearer "+auth)
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	if resp := do("old", "hello"); resp.StatusCode != http.StatusOK {
+		t.Fatal("request was not retried with the new token, got status", resp.StatusCode)
+	}
+	if src.refreshes != 1 {
+		t.Fatal("unexpected number of refreshes:", src.refreshes)
+	}
+	if len(bodies) != 2 || bodies[0] != "hello" || bodies[1] != "hello" {
+		t.Fatalf("unexpected request bodies: %q", bodies)
+	}
+
+	// Stale tokens in the request are replaced with the current one.
+	if resp := do("old", ""); resp.StatusCode != http.StatusOK {
+		t.Fatal("stale token was not replaced, got status", resp.StatusCode)
+	}
+	if src.refreshes != 1 {
+		t.Fatal("token was refreshed again")
+	}
+
+	// Unauthenticated requests are left alone.
+	bodies = nil
+	if resp := do("", ""); resp.StatusCode != http.StatusOK || len(bodies) != 1 {
+		t.Fatal("unauthenticated request was changed")
+	}
+
+	// Hard logouts aren't retried.
+	src.token = "dead"
+	if resp := do("dead", ""); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatal("unexpected status for hard logout:", resp.StatusCode)
+	}
+	if src.refreshes != 1 {
+		t.Fatal("token was refreshed on a hard logout")
+	}
+}
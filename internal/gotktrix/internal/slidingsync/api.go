@@ -0,0 +1,114 @@
+package slidingsync
+
+import (
+	"github.com/diamondburned/gotrix/api"
+	"github.com/diamondburned/gotrix/event"
+	"github.com/diamondburned/gotrix/matrix"
+)
+
+// Endpoint is the sliding sync endpoint. Beware that, like api.EndpointX, it
+// doesn't have a prefixing slash.
+const Endpoint = "_matrix/client/unstable/org.matrix.msc3575/sync"
+
+// CodeUnknownPos is returned by the homeserver if it has forgotten the
+// position of the connection, in which case the connection must start over.
+const CodeUnknownPos matrix.ErrorCode = "M_UNKNOWN_POS"
+
+// StateKey pairs an event type with a state key. Its state key may be "*" for
+// all state keys, "$ME" for the user and "$LAZY" for the senders of the
+// timeline events.
+type StateKey [2]string
+
+// RoomSubscription describes what is synced of a room.
+type RoomSubscription struct {
+	RequiredState []StateKey `json:"required_state"`
+	TimelineLimit int        `json:"timeline_limit"`
+}
+
+// List requests the rooms within the given ranges of the user's rooms sorted
+// using Sort. Ranges are inclusive.
+type List struct {
+	RoomSubscription
+	Ranges [][2]int `json:"ranges"`
+	Sort   []string `json:"sort,omitempty"`
+}
+
+// Request is the body of a sliding sync request. Room subscriptions are kept
+// by the homeserver until they're unsubscribed.
+type Request struct {
+	Lists             map[string]List                    `json:"lists,omitempty"`
+	RoomSubscriptions map[matrix.RoomID]RoomSubscription `json:"room_subscriptions,omitempty"`
+	UnsubscribeRooms  []matrix.RoomID                    `json:"unsubscribe_rooms,omitempty"`
+	Extensions        RequestExtensions                  `json:"extensions"`
+}
+
+// RequestExtensions enables the extensions that sync what isn't a room.
+type RequestExtensions struct {
+	ToDevice    *ToDeviceRequest `json:"to_device,omitempty"`
+	E2EE        *Extension       `json:"e2ee,omitempty"`
+	AccountData *Extension       `json:"account_data,omitempty"`
+	Typing      *Extension       `json:"typing,omitempty"`
+	Receipts    *Extension       `json:"receipts,omitempty"`
+}
+
+// Extension enables an extension.
+type Extension struct {
+	Enabled bool `json:"enabled"`
+}
+
+// ToDeviceRequest enables the to-device extension. Since is the NextBatch of
+// the last to-device response.
+type ToDeviceRequest struct {
+	Extension
+	Since string `json:"since,omitempty"`
+}
+
+// Response is the response of a sliding sync request.
+type Response struct {
+	Pos        string                  `json:"pos"`
+	Lists      map[string]ListResponse `json:"lists"`
+	Rooms      map[matrix.RoomID]Room  `json:"rooms"`
+	Extensions ResponseExtensions      `json:"extensions"`
+}
+
+// ListResponse describes the changes of a list. Its operations aren't needed,
+// since the room list sorts the rooms itself, so they're left out.
+type ListResponse struct {
+	Count int `json:"count"`
+}
+
+// Room is the room data of a room in a list or a room subscription. Counts
+// are only given when they change, so they're nil otherwise.
+type Room struct {
+	RequiredState     []event.RawEvent      `json:"required_state"`
+	Timeline          []event.RawEvent      `json:"timeline"`
+	InviteState       []event.StrippedEvent `json:"invite_state"`
+	PrevBatch         string                `json:"prev_batch"`
+	Limited           bool                  `json:"limited"`
+	NotificationCount *int                  `json:"notification_count"`
+	HighlightCount    *int                  `json:"highlight_count"`
+	JoinedCount       *int                  `json:"joined_count"`
+	InvitedCount      *int                  `json:"invited_count"`
+}
+
+// ResponseExtensions is the data of the enabled extensions.
+type ResponseExtensions struct {
+	ToDevice *struct {
+		NextBatch string           `json:"next_batch"`
+		Events    []event.RawEvent `json:"events"`
+	} `json:"to_device"`
+	E2EE struct {
+		DeviceLists            api.SyncDeviceLists `json:"device_lists"`
+		DeviceOneTimeKeysCount map[string]int      `json:"device_one_time_keys_count"`
+	} `json:"e2ee"`
+	AccountData struct {
+		Global []event.RawEvent                   `json:"global"`
+		Rooms  map[matrix.RoomID][]event.RawEvent `json:"rooms"`
+	} `json:"account_data"`
+	Typing struct {
+		Rooms map[matrix.RoomID]event.RawEvent `json:"rooms"`
+	} `json:"typing"`
+	Receipts struct {
+		Rooms map[matrix.RoomID]event.RawEvent `json:"rooms"`
+	} `json:"receipts"`
+}
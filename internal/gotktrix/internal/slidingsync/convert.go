@@ -0,0 +1,183 @@
+package slidingsync
+
+import (
+	"encoding/json"
+
+	"github.com/diamondburned/gotrix/api"
+	"github.com/diamondburned/gotrix/event"
+	"github.com/diamondburned/gotrix/matrix"
+)
+
+// RoomCounts are the counts of a room, which sliding sync only sends when they
+// change.
+type RoomCounts struct {
+	Notification int
+	Highlight    int
+	Joined       int
+	Invited      int
+}
+
+// update updates the counts with the ones given in room.
+func (c *RoomCounts) update(room Room) {
+	set := func(dst *int, src *int) {
+		if src != nil {
+			*dst = *src
+		}
+	}
+
+	set(&c.Notification, room.NotificationCount)
+	set(&c.Highlight, room.HighlightCount)
+	set(&c.Joined, room.JoinedCount)
+	set(&c.Invited, room.InvitedCount)
+}
+
+// converter converts sliding sync responses into regular sync responses.
+type converter struct {
+	userID matrix.UserID
+	// known returns the counts of a room that aren't in counts yet.
+	known  func(matrix.RoomID) RoomCounts
+	counts map[matrix.RoomID]RoomCounts
+}
+
+func (c *converter) roomCounts(roomID matrix.RoomID) RoomCounts {
+	counts, ok := c.counts[roomID]
+	if !ok && c.known != nil {
+		counts = c.known(roomID)
+	}
+	return counts
+}
+
+// convert converts resp into a regular sync response. Its NextBatch is left
+// empty.
+func (c *converter) convert(resp *Response) *api.SyncResponse {
+	sync := &api.SyncResponse{}
+	sync.Rooms.Joined = make(map[matrix.RoomID]api.SyncJoinedRoomEvents)
+
+	ext := resp.Extensions
+
+	sync.AccountData.Events = ext.AccountData.Global
+	sync.DeviceLists = ext.E2EE.DeviceLists
+	sync.DeviceOneTimeKeysCount = ext.E2EE.DeviceOneTimeKeysCount
+	if ext.ToDevice != nil {
+		sync.ToDevice.Events = ext.ToDevice.Events
+	}
+
+	for roomID, room := range resp.Rooms {
+		if len(room.InviteState) > 0 {
+			if sync.Rooms.Invited == nil {
+				sync.Rooms.Invited = make(map[matrix.RoomID]api.SyncInvitedRoomEvents)
+			}
+
+			var invited api.SyncInvitedRoomEvents
+			invited.State.Events = room.InviteState
+			sync.Rooms.Invited[roomID] = invited
+			continue
+		}
+
+		if c.hasLeft(room) {
+			if sync.Rooms.Left == nil {
+				sync.Rooms.Left = make(map[matrix.RoomID]api.SyncLeftRoomEvents)
+			}
+
+			sync.Rooms.Left[roomID] = api.SyncLeftRoomEvents{
+				State: api.SyncEvents{Events: room.RequiredState},
+				Timeline: api.SyncTimeline{
+					Events:        room.Timeline,
+					Limited:       room.Limited,
+					PreviousBatch: room.PrevBatch,
+				},
+			}
+
+			delete(c.counts, roomID)
+			continue
+		}
+
+		counts := c.roomCounts(roomID)
+		counts.update(room)
+		c.counts[roomID] = counts
+
+		joined := sync.Rooms.Joined[roomID]
+		joined.State.Events = room.RequiredState
+		joined.Timeline = api.SyncTimeline{
+			Events:        room.Timeline,
+			Limited:       room.Limited,
+			PreviousBatch: room.PrevBatch,
+		}
+		sync.Rooms.Joined[roomID] = joined
+	}
+
+	for roomID, events := range ext.AccountData.Rooms {
+		c.updateJoined(sync, roomID, func(joined *api.SyncJoinedRoomEvents) {
+			joined.AccountData.Events = events
+		})
+	}
+
+	for _, rooms := range []map[matrix.RoomID]event.RawEvent{ext.Typing.Rooms, ext.Receipts.Rooms} {
+		for roomID, ev := range rooms {
+			ev := ev
+			c.updateJoined(sync, roomID, func(joined *api.SyncJoinedRoomEvents) {
+				joined.Ephemeral.Events = append(joined.Ephemeral.Events, ev)
+			})
+		}
+	}
+
+	// The state overrides the counts of every joined room in the response, so
+	// the ones that weren't sent must be filled in.
+	for roomID, joined := range sync.Rooms.Joined {
+		counts := c.roomCounts(roomID)
+		c.counts[roomID] = counts
+
+		joined.UnreadCount.Notification = counts.Notification
+		joined.UnreadCount.Highlight = counts.Highlight
+		joined.Summary.JoinedCount = counts.Joined
+		joined.Summary.InvitedCount = counts.Invited
+		sync.Rooms.Joined[roomID] = joined
+	}
+
+	return sync
+}
+
+// updateJoined updates the joined room of the given ID in sync. Rooms that
+// are invited or left are ignored.
+func (c *converter) updateJoined(
+	sync *api.SyncResponse, roomID matrix.RoomID, f func(*api.SyncJoinedRoomEvents)) {
+
+	if _, ok := sync.Rooms.Invited[roomID]; ok {
+		return
+	}
+	if _, ok := sync.Rooms.Left[roomID]; ok {
+		return
+	}
+
+	joined := sync.Rooms.Joined[roomID]
+	f(&joined)
+	sync.Rooms.Joined[roomID] = joined
+}
+
+// hasLeft returns true if the latest membership of the user in the room shows
+// that they have left or were kicked or banned.
+func (c *converter) hasLeft(room Room) bool {
+	membership := ""
+
+	for _, events := range [][]event.RawEvent{room.RequiredState, room.Timeline} {
+		for _, raw := range events {
+			var ev struct {
+				Type     event.Type `json:"type"`
+				StateKey *string    `json:"state_key"`
+				Content  struct {
+					Membership string `json:"membership"`
+				} `json:"content"`
+			}
+
+			if err := json.Unmarshal(raw, &ev); err != nil {
+				continue
+			}
+
+			if ev.Type == event.TypeRoomMember && ev.StateKey != nil && *ev.StateKey == string(c.userID) {
+				membership = ev.Content.Membership
+			}
+		}
+	}
+
+	return membership == string(event.MemberLeft) || membership == string(event.MemberBanned)
+}
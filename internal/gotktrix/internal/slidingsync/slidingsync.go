@@ -0,0 +1,418 @@
+// Package slidingsync implements a sync loop using sliding sync (MSC3575). Its
+// responses are converted into regular sync responses, so that they're handled
+// the same way as the ones of the regular sync loop.
+//
+// The loop asks for a range of the user's rooms sorted by activity, which
+// grows with each response until it covers all rooms. The rooms that the user
+// can see are subscribed to, so that they're kept up to date regardless of
+// where they are in that range.
+package slidingsync
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/diamondburned/gotrix/api"
+	"github.com/diamondburned/gotrix/api/httputil"
+	"github.com/diamondburned/gotrix/matrix"
+	"github.com/pkg/errors"
+)
+
+// ErrUnsupported is returned by Run if the homeserver doesn't support sliding
+// sync.
+var ErrUnsupported = errors.New("sliding sync is not supported by the homeserver")
+
+// listName is the name of the only list, which has all of the user's rooms.
+const listName = "rooms"
+
+// Options describes how the loop syncs. Zero values use the defaults.
+type Options struct {
+	// UserID is the user that is syncing. It's used to tell which rooms the
+	// user has left.
+	UserID matrix.UserID
+	// PageSize is the number of rooms that the range grows by.
+	PageSize int
+	// TimelineLimit is the number of latest events synced of opened rooms.
+	TimelineLimit int
+	// NoTyping disables syncing typing users.
+	NoTyping bool
+	// Counts returns the counts of the room that are already known, such as
+	// the ones from an earlier sync. It's used until sliding sync sends them.
+	Counts func(matrix.RoomID) RoomCounts
+	// ToDeviceSince is the since token of the to-device extension that was
+	// saved by an earlier run, if any. Without it, the to-device events that
+	// were already handled are sent again.
+	ToDeviceSince string
+	// SaveToDeviceSince is called with the since token of the to-device
+	// extension once the response that it came with has been handled.
+	SaveToDeviceSince func(since string)
+
+	Timeout        time.Duration
+	MinBackoffTime time.Duration
+	MaxBackoffTime time.Duration
+}
+
+// DefaultOptions are the defaults of Options.
+var DefaultOptions = Options{
+	PageSize:       20,
+	TimelineLimit:  50,
+	Timeout:        30 * time.Second,
+	MinBackoffTime: 1 * time.Second,
+	MaxBackoffTime: 300 * time.Second,
+}
+
+func (o *Options) init() {
+	def := DefaultOptions
+
+	if o.PageSize <= 0 {
+		o.PageSize = def.PageSize
+	}
+	if o.TimelineLimit <= 0 {
+		o.TimelineLimit = def.TimelineLimit
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = def.Timeout
+	}
+	if o.MinBackoffTime <= 0 {
+		o.MinBackoffTime = def.MinBackoffTime
+	}
+	if o.MaxBackoffTime <= 0 {
+		o.MaxBackoffTime = def.MaxBackoffTime
+	}
+}
+
+// roomState is the state that the room list needs of every room.
+var roomState = []StateKey{
+	{"m.room.create", ""},
+	{"m.room.name", ""},
+	{"m.room.avatar", ""},
+	{"m.room.canonical_alias", ""},
+	{"m.room.topic", ""},
+	{"m.room.encryption", ""},
+	{"m.room.tombstone", ""},
+	{"m.room.member", "$ME"},
+	{"m.space.child", "*"},
+	{"m.space.parent", "*"},
+}
+
+// subscription is how much a room is subscribed to.
+type subscription uint8
+
+const (
+	// visibleRoom is a room that is shown in the room list.
+	visibleRoom subscription = iota + 1
+	// openedRoom is a room that is opened, so its whole state and more of
+	// its timeline are needed.
+	openedRoom
+)
+
+func (s subscription) params(opts Options) RoomSubscription {
+	switch s {
+	case openedRoom:
+		return RoomSubscription{
+			RequiredState: []StateKey{
+				{"*", ""},
+				{"m.room.member", "$LAZY"},
+				{"m.room.member", "$ME"},
+				{"m.space.child", "*"},
+				{"m.space.parent", "*"},
+			},
+			TimelineLimit: opts.TimelineLimit,
+		}
+	default:
+		return RoomSubscription{
+			RequiredState: roomState,
+			TimelineLimit: 1,
+		}
+	}
+}
+
+// Loop is a sliding sync loop. Its methods are safe to be called from any
+// goroutine, including while it's running.
+type Loop struct {
+	sink func(*api.SyncResponse) error
+
+	mu      sync.Mutex
+	visible map[matrix.RoomID]bool
+	opened  map[matrix.RoomID]int
+	// end is the end of the range, and count is the number of rooms.
+	end   int
+	count int
+	// wake cancels the ongoing request, if any.
+	wake context.CancelFunc
+}
+
+// New creates a new sliding sync loop. Each response is converted and given to
+// sink.
+func New(sink func(*api.SyncResponse) error) *Loop {
+	return &Loop{
+		sink:    sink,
+		visible: make(map[matrix.RoomID]bool),
+		opened:  make(map[matrix.RoomID]int),
+	}
+}
+
+// SetVisibleRooms sets the rooms that are visible in the room list.
+func (l *Loop) SetVisibleRooms(roomIDs []matrix.RoomID) {
+	visible := make(map[matrix.RoomID]bool, len(roomIDs))
+	for _, roomID := range roomIDs {
+		visible[roomID] = true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(visible) == len(l.visible) {
+		same := true
+		for roomID := range visible {
+			if !l.visible[roomID] {
+				same = false
+				break
+			}
+		}
+		if same {
+			return
+		}
+	}
+
+	l.visible = visible
+	l.wakeLocked()
+}
+
+// OpenRoom marks the room as opened until the returned function is called. A
+// room may be opened more than once.
+func (l *Loop) OpenRoom(roomID matrix.RoomID) (close func()) {
+	l.mu.Lock()
+	l.opened[roomID]++
+	l.wakeLocked()
+	l.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+
+			if l.opened[roomID]--; l.opened[roomID] <= 0 {
+				delete(l.opened, roomID)
+			}
+			l.wakeLocked()
+		})
+	}
+}
+
+// wakeLocked makes the ongoing request stop waiting, so that the subscription
+// changes are sent right away.
+func (l *Loop) wakeLocked() {
+	if l.wake != nil {
+		l.wake()
+	}
+}
+
+// subscriptionsLocked returns the subscription of each room that should be
+// subscribed to.
+func (l *Loop) subscriptionsLocked() map[matrix.RoomID]subscription {
+	subs := make(map[matrix.RoomID]subscription, len(l.visible)+len(l.opened))
+	for roomID := range l.visible {
+		subs[roomID] = visibleRoom
+	}
+	for roomID := range l.opened {
+		subs[roomID] = openedRoom
+	}
+	return subs
+}
+
+// session is the state of a connection to the homeserver.
+type session struct {
+	pos        string
+	since      string // to-device
+	subscribed map[matrix.RoomID]subscription
+}
+
+// requestLocked makes the next request. It returns the subscriptions that the
+// homeserver has once the request succeeds.
+func (l *Loop) requestLocked(s *session, opts Options) (Request, map[matrix.RoomID]subscription) {
+	enabled := &Extension{Enabled: true}
+
+	req := Request{
+		Lists: map[string]List{
+			listName: {
+				RoomSubscription: visibleRoom.params(opts),
+				Ranges:           [][2]int{{0, l.end}},
+				Sort:             []string{"by_recency", "by_name"},
+			},
+		},
+		Extensions: RequestExtensions{
+			ToDevice: &ToDeviceRequest{
+				Extension: Extension{Enabled: true},
+				Since:     s.since,
+			},
+			E2EE:        enabled,
+			AccountData: enabled,
+			Typing:      &Extension{Enabled: !opts.NoTyping},
+			Receipts:    enabled,
+		},
+	}
+
+	subs := l.subscriptionsLocked()
+
+	for roomID, sub := range subs {
+		if s.subscribed[roomID] == sub {
+			continue
+		}
+		if req.RoomSubscriptions == nil {
+			req.RoomSubscriptions = make(map[matrix.RoomID]RoomSubscription)
+		}
+		req.RoomSubscriptions[roomID] = sub.params(opts)
+	}
+
+	for roomID := range s.subscribed {
+		if _, ok := subs[roomID]; !ok {
+			req.UnsubscribeRooms = append(req.UnsubscribeRooms, roomID)
+		}
+	}
+
+	return req, subs
+}
+
+// growLocked grows the range by a page if it doesn't cover all rooms yet.
+func (l *Loop) growLocked(opts Options) {
+	if l.count > l.end+1 {
+		l.end += opts.PageSize
+	}
+}
+
+// Run runs the loop until ctx is cancelled or the homeserver turns out to not
+// support sliding sync, in which case ErrUnsupported is returned. Other errors
+// are retried with a backoff.
+func (l *Loop) Run(ctx context.Context, client httputil.Client, opts Options) error {
+	opts.init()
+
+	l.mu.Lock()
+	l.end = opts.PageSize - 1
+	l.count = 0
+	l.mu.Unlock()
+
+	conv := converter{
+		userID: opts.UserID,
+		known:  opts.Counts,
+		counts: make(map[matrix.RoomID]RoomCounts),
+	}
+
+	s := session{since: opts.ToDeviceSince}
+	var backoff time.Duration
+
+	timer := time.NewTimer(0)
+	<-timer.C
+	defer timer.Stop()
+
+	for {
+		reqCtx, cancel := context.WithCancel(ctx)
+
+		l.mu.Lock()
+		req, subs := l.requestLocked(&s, opts)
+		l.wake = cancel
+		l.mu.Unlock()
+
+		resp, err := l.do(client.WithContext(reqCtx), s.pos, opts.Timeout, req)
+		woken := reqCtx.Err() != nil
+
+		l.mu.Lock()
+		l.wake = nil
+		l.mu.Unlock()
+		cancel()
+
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			if woken {
+				// The subscriptions have changed, so send them right away.
+				continue
+			}
+
+			if matrix.ErrCode(err) == CodeUnknownPos {
+				// The homeserver has forgotten the connection and its
+				// subscriptions, so start over.
+				s = session{since: s.since}
+				continue
+			}
+
+			if matrix.StatusCode(err) == http.StatusNotFound || matrix.ErrCode(err) == matrix.CodeUnrecognized {
+				return ErrUnsupported
+			}
+
+			backoff *= 2
+			if backoff < opts.MinBackoffTime {
+				backoff = opts.MinBackoffTime
+			}
+			if backoff > opts.MaxBackoffTime {
+				backoff = opts.MaxBackoffTime
+			}
+
+			log.Printf("sliding sync failed (retrying in %s): %v", backoff, err)
+
+			timer.Reset(backoff)
+			select {
+			case <-timer.C:
+				continue
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		backoff = 0
+
+		s.pos = resp.Pos
+		s.subscribed = subs
+
+		since := s.since
+		if resp.Extensions.ToDevice != nil && resp.Extensions.ToDevice.NextBatch != "" {
+			s.since = resp.Extensions.ToDevice.NextBatch
+		}
+
+		l.mu.Lock()
+		if list, ok := resp.Lists[listName]; ok {
+			l.count = list.Count
+		}
+		l.growLocked(opts)
+		l.mu.Unlock()
+
+		if err := l.sink(conv.convert(resp)); err != nil {
+			log.Println("cannot handle sliding sync response:", err)
+			continue
+		}
+
+		if s.since != since && opts.SaveToDeviceSince != nil {
+			opts.SaveToDeviceSince(s.since)
+		}
+	}
+}
+
+func (l *Loop) do(client httputil.Client, pos string, timeout time.Duration, req Request) (*Response, error) {
+	query := map[string]string{
+		"timeout": strconv.FormatInt(int64(timeout/time.Millisecond), 10),
+	}
+	if pos != "" {
+		query["pos"] = pos
+	}
+
+	var resp Response
+
+	err := client.Request(
+		"POST", Endpoint, &resp,
+		httputil.WithToken(),
+		httputil.WithQuery(query),
+		httputil.WithJSONBody(req),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
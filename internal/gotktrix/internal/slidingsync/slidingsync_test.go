@@ -0,0 +1,347 @@
+package slidingsync
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/diamondburned/gotrix/api"
+	"github.com/diamondburned/gotrix/api/httputil"
+	"github.com/diamondburned/gotrix/matrix"
+)
+
+type fakeRequest struct {
+	Request
+	pos     string
+	respond chan<- string
+}
+
+// fakeServer is a sliding sync server that hands each request to the test,
+// which then responds to it.
+type fakeServer struct {
+	*httptest.Server
+	reqs chan fakeRequest
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	s := &fakeServer{reqs: make(chan fakeRequest)}
+
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/"+Endpoint {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errcode":"M_UNRECOGNIZED","error":"Unrecognized request"}`))
+			return
+		}
+
+		if r.Header.Get("Authorization") != "Bearer token" {
+			t.Errorf("unexpected Authorization header %q", r.Header.Get("Authorization"))
+		}
+
+		var req Request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("cannot decode request: %v", err)
+		}
+
+		respond := make(chan string, 1)
+		select {
+		case s.reqs <- fakeRequest{req, r.URL.Query().Get("pos"), respond}:
+		case <-r.Context().Done():
+			return
+		}
+
+		select {
+		case body := <-respond:
+			if body == `M_UNKNOWN_POS` {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"errcode":"M_UNKNOWN_POS","error":"Unknown position"}`))
+				return
+			}
+			w.Write([]byte(body))
+		case <-r.Context().Done():
+		}
+	}))
+
+	return s
+}
+
+func (s *fakeServer) client() httputil.Client {
+	u, _ := url.Parse(s.URL)
+
+	client := httputil.NewClient()
+	client.HomeServer = u.Host
+	client.HomeServerScheme = u.Scheme
+	client.AccessToken = "token"
+
+	return client
+}
+
+// next waits for the next request that matches f. Requests that don't match
+// must be ones that were cancelled by the loop.
+func (s *fakeServer) next(t *testing.T, f func(fakeRequest) bool) fakeRequest {
+	t.Helper()
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case req := <-s.reqs:
+			if f(req) {
+				return req
+			}
+		case <-timeout:
+			t.Fatal("timed out waiting for request")
+		}
+	}
+}
+
+func nextSync(t *testing.T, syncs <-chan *api.SyncResponse) *api.SyncResponse {
+	t.Helper()
+
+	select {
+	case sync := <-syncs:
+		return sync
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for sync")
+		return nil
+	}
+}
+
+func TestLoop(t *testing.T) {
+	srv := newFakeServer(t)
+	defer srv.Close()
+
+	syncs := make(chan *api.SyncResponse, 10)
+	loop := New(func(sync *api.SyncResponse) error {
+		syncs <- sync
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	savedSince := make(chan string, 10)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- loop.Run(ctx, srv.client(), Options{
+			UserID:        "@me:example.com",
+			PageSize:      2,
+			TimelineLimit: 30,
+			Counts: func(roomID matrix.RoomID) RoomCounts {
+				return RoomCounts{Notification: 7}
+			},
+			ToDeviceSince:     "td0",
+			SaveToDeviceSince: func(since string) { savedSince <- since },
+		})
+	}()
+
+	anyRequest := func(fakeRequest) bool { return true }
+
+	req := srv.next(t, anyRequest)
+	if req.pos != "" {
+		t.Errorf("first request has pos %q", req.pos)
+	}
+	if req.Extensions.ToDevice == nil || req.Extensions.ToDevice.Since != "td0" {
+		t.Errorf("first request doesn't use the saved since: %+v", req.Extensions.ToDevice)
+	}
+	assertRanges(t, req, [2]int{0, 1})
+
+	req.respond <- `{
+		"pos": "1",
+		"lists": {"rooms": {"count": 3}},
+		"rooms": {
+			"!a:example.com": {
+				"timeline": [{"type": "m.room.message", "event_id": "$1", "content": {}}],
+				"notification_count": 2,
+				"joined_count": 5
+			},
+			"!invited:example.com": {
+				"invite_state": [{"type": "m.room.name", "state_key": "", "content": {}}]
+			},
+			"!left:example.com": {
+				"timeline": [{
+					"type": "m.room.member",
+					"state_key": "@me:example.com",
+					"content": {"membership": "leave"}
+				}]
+			}
+		},
+		"extensions": {
+			"to_device": {"next_batch": "td1", "events": []},
+			"account_data": {
+				"global": [{"type": "m.direct", "content": {}}],
+				"rooms": {"!old:example.com": [{"type": "m.tag", "content": {}}]}
+			},
+			"typing": {"rooms": {"!a:example.com": {"type": "m.typing", "content": {}}}}
+		}
+	}`
+
+	sync := nextSync(t, syncs)
+
+	select {
+	case since := <-savedSince:
+		if since != "td1" {
+			t.Errorf("saved since %q, expected td1", since)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the since to be saved")
+	}
+
+	a := sync.Rooms.Joined["!a:example.com"]
+	if len(a.Timeline.Events) != 1 || len(a.Ephemeral.Events) != 1 {
+		t.Errorf("unexpected room !a: %+v", a)
+	}
+	if a.UnreadCount.Notification != 2 || a.Summary.JoinedCount != 5 {
+		t.Errorf("unexpected counts of room !a: %+v %+v", a.UnreadCount, a.Summary)
+	}
+
+	old := sync.Rooms.Joined["!old:example.com"]
+	if len(old.AccountData.Events) != 1 || old.UnreadCount.Notification != 7 {
+		t.Errorf("unexpected room !old: %+v", old)
+	}
+
+	if _, ok := sync.Rooms.Invited["!invited:example.com"]; !ok {
+		t.Error("missing invited room")
+	}
+	if _, ok := sync.Rooms.Left["!left:example.com"]; !ok {
+		t.Error("missing left room")
+	}
+	if _, ok := sync.Rooms.Joined["!left:example.com"]; ok {
+		t.Error("left room is joined")
+	}
+	if len(sync.AccountData.Events) != 1 {
+		t.Errorf("unexpected account data %v", sync.AccountData.Events)
+	}
+
+	// The range grows, since it doesn't cover all 3 rooms yet.
+	req = srv.next(t, anyRequest)
+	if req.pos != "1" {
+		t.Errorf("second request has pos %q", req.pos)
+	}
+	if req.Extensions.ToDevice == nil || req.Extensions.ToDevice.Since != "td1" {
+		t.Errorf("unexpected to-device extension %+v", req.Extensions.ToDevice)
+	}
+	assertRanges(t, req, [2]int{0, 3})
+
+	// Subscribing wakes up the ongoing request.
+	loop.SetVisibleRooms([]matrix.RoomID{"!a:example.com"})
+
+	req = srv.next(t, func(req fakeRequest) bool { return len(req.RoomSubscriptions) > 0 })
+	if req.pos != "1" {
+		t.Errorf("woken request has pos %q", req.pos)
+	}
+	if sub := req.RoomSubscriptions["!a:example.com"]; sub.TimelineLimit != 1 {
+		t.Errorf("unexpected visible subscription %+v", sub)
+	}
+
+	req.respond <- `{
+		"pos": "2",
+		"lists": {"rooms": {"count": 3}},
+		"rooms": {"!a:example.com": {"timeline": []}}
+	}`
+
+	sync = nextSync(t, syncs)
+	if n := sync.Rooms.Joined["!a:example.com"].UnreadCount.Notification; n != 2 {
+		t.Errorf("room !a lost its notification count, got %d", n)
+	}
+
+	req = srv.next(t, anyRequest)
+	if len(req.RoomSubscriptions) > 0 {
+		t.Errorf("subscriptions were sent again: %v", req.RoomSubscriptions)
+	}
+
+	closeRoom := loop.OpenRoom("!a:example.com")
+
+	req = srv.next(t, func(req fakeRequest) bool { return len(req.RoomSubscriptions) > 0 })
+	sub := req.RoomSubscriptions["!a:example.com"]
+	if sub.TimelineLimit != 30 || sub.RequiredState[0] != (StateKey{"*", ""}) {
+		t.Errorf("unexpected opened subscription %+v", sub)
+	}
+	req.respond <- `{"pos": "3"}`
+	nextSync(t, syncs)
+
+	// Closing the room goes back to the visible subscription.
+	closeRoom()
+
+	req = srv.next(t, func(req fakeRequest) bool { return len(req.RoomSubscriptions) > 0 })
+	if sub := req.RoomSubscriptions["!a:example.com"]; sub.TimelineLimit != 1 {
+		t.Errorf("unexpected visible subscription %+v", sub)
+	}
+	req.respond <- `{"pos": "4"}`
+	nextSync(t, syncs)
+
+	loop.SetVisibleRooms(nil)
+
+	req = srv.next(t, func(req fakeRequest) bool { return len(req.UnsubscribeRooms) > 0 })
+	if req.UnsubscribeRooms[0] != "!a:example.com" {
+		t.Errorf("unexpected unsubscribed rooms %v", req.UnsubscribeRooms)
+	}
+
+	// Forgetting the position starts over with the subscriptions.
+	loop.SetVisibleRooms([]matrix.RoomID{"!b:example.com"})
+	req = srv.next(t, func(req fakeRequest) bool { return len(req.RoomSubscriptions) > 0 })
+	req.respond <- `M_UNKNOWN_POS`
+
+	req = srv.next(t, anyRequest)
+	if req.pos != "" {
+		t.Errorf("request after M_UNKNOWN_POS has pos %q", req.pos)
+	}
+	if _, ok := req.RoomSubscriptions["!b:example.com"]; !ok {
+		t.Errorf("subscriptions weren't sent again: %v", req.RoomSubscriptions)
+	}
+
+	cancel()
+
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Errorf("Run returned unexpected error %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run didn't return after cancel")
+	}
+}
+
+func TestLoopUnsupported(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"errcode":"M_UNRECOGNIZED","error":"Unrecognized request"}`))
+	}))
+	defer srv.Close()
+
+	u, _ := url.Parse(srv.URL)
+
+	client := httputil.NewClient()
+	client.HomeServer = u.Host
+	client.HomeServerScheme = u.Scheme
+
+	loop := New(func(*api.SyncResponse) error {
+		t.Error("unexpected sync")
+		return nil
+	})
+
+	if err := loop.Run(context.Background(), client, Options{}); err != ErrUnsupported {
+		t.Fatalf("expected ErrUnsupported, got %v", err)
+	}
+}
+
+func assertRanges(t *testing.T, req fakeRequest, ranges ...[2]int) {
+	t.Helper()
+
+	list, ok := req.Lists[listName]
+	if !ok {
+		t.Fatal("request has no list")
+	}
+
+	if len(list.Ranges) != len(ranges) {
+		t.Fatalf("expected ranges %v, got %v", ranges, list.Ranges)
+	}
+	for i := range ranges {
+		if list.Ranges[i] != ranges[i] {
+			t.Fatalf("expected ranges %v, got %v", ranges, list.Ranges)
+		}
+	}
+}
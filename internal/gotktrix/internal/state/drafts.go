@@ -0,0 +1,95 @@
+package state
+
+import (
+	"strings"
+
+	"github.com/diamondburned/gotrix/matrix"
+	"github.com/pkg/errors"
+)
+
+// Draft is a message that the user was composing in a room but hasn't sent.
+type Draft struct {
+	// Text is the raw text in the composer. Each anchor is an U+FFFC character
+	// inside it.
+	Text    string        `json:"text"`
+	Anchors []DraftAnchor `json:"anchors,omitempty"`
+
+	ReplyingTo matrix.EventID `json:"replying_to,omitempty"`
+	Editing    matrix.EventID `json:"editing,omitempty"`
+
+	// UpdatedAt is the time at which the draft was last saved.
+	UpdatedAt matrix.Timestamp `json:"updated_at"`
+}
+
+// DraftAnchor is a widget embedded in a draft's text, such as a mention or a
+// custom emoji.
+type DraftAnchor struct {
+	// Offset is the character offset of the anchor in the text.
+	Offset int    `json:"offset"`
+	HTML   string `json:"html"`
+	Text   string `json:"text"`
+	// Mention is the user that the anchor mentions, if it's a mention.
+	Mention matrix.UserID `json:"mention,omitempty"`
+	// Emoji is the image of the anchor, if it's a custom emoji.
+	Emoji matrix.URL `json:"emoji,omitempty"`
+}
+
+// IsEmpty returns true if the draft has nothing worth keeping.
+func (d Draft) IsEmpty() bool {
+	return strings.TrimSpace(d.Text) == "" && d.ReplyingTo == "" && d.Editing == ""
+}
+
+// PlainText returns the draft's text with its anchors replaced by their plain
+// text.
+func (d Draft) PlainText() string {
+	if len(d.Anchors) == 0 {
+		return d.Text
+	}
+
+	anchors := make(map[int]string, len(d.Anchors))
+	for _, anchor := range d.Anchors {
+		anchors[anchor.Offset] = anchor.Text
+	}
+
+	var s strings.Builder
+	s.Grow(len(d.Text))
+
+	var offset int
+	for _, r := range d.Text {
+		if text, ok := anchors[offset]; ok && r == '\uFFFC' {
+			s.WriteString(text)
+		} else {
+			s.WriteRune(r)
+		}
+		offset++
+	}
+
+	return s.String()
+}
+
+// RoomDraft returns the saved draft of the given room.
+func (s *State) RoomDraft(roomID matrix.RoomID) (Draft, error) {
+	var draft Draft
+	if err := s.top.FromPath(s.paths.drafts).GetAny(string(roomID), &draft); err != nil {
+		return Draft{}, errors.Wrap(err, "failed to get draft")
+	}
+	return draft, nil
+}
+
+// SetRoomDraft saves the draft of the given room. An empty draft deletes the
+// saved one.
+func (s *State) SetRoomDraft(roomID matrix.RoomID, draft Draft) error {
+	n := s.top.FromPath(s.paths.drafts)
+
+	if draft.IsEmpty() {
+		if err := n.Delete(string(roomID)); err != nil {
+			return errors.Wrap(err, "failed to delete draft")
+		}
+		return nil
+	}
+
+	if err := n.SetAny(string(roomID), draft); err != nil {
+		return errors.Wrap(err, "failed to save draft")
+	}
+	return nil
+}
@@ -0,0 +1,22 @@
+package state
+
+import (
+	"github.com/diamondburned/gotktrix/internal/gotktrix/internal/db"
+	"github.com/pkg/errors"
+)
+
+// FilterID returns the ID of the filter that was uploaded with the given key,
+// which is usually a hash of the filter. False is returned if there isn't one.
+func (s *State) FilterID(key string) (string, bool) {
+	var id string
+	err := s.top.FromPath(s.paths.filters).Get(key, db.StringFunc(&id))
+	return id, err == nil && id != ""
+}
+
+// SetFilterID saves the ID of the filter that was uploaded with the given key.
+func (s *State) SetFilterID(key, id string) error {
+	if err := s.top.FromPath(s.paths.filters).Set(key, []byte(id)); err != nil {
+		return errors.Wrap(err, "failed to save filter ID")
+	}
+	return nil
+}
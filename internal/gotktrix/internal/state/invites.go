@@ -0,0 +1,99 @@
+package state
+
+import (
+	"bytes"
+	"log"
+
+	"github.com/diamondburned/gotktrix/internal/gotktrix/internal/db"
+	"github.com/diamondburned/gotrix/matrix"
+	"github.com/pkg/errors"
+)
+
+// InvitedRooms returns the IDs of the rooms that the user has been invited to
+// but hasn't joined or declined yet. The stripped state of these rooms can be
+// queried like any other room state.
+func (s *State) InvitedRooms() ([]matrix.RoomID, error) {
+	var roomIDs []matrix.RoomID
+
+	err := s.top.FromPath(s.paths.invites).Each(func(k string, _ []byte, l int) error {
+		if roomIDs == nil {
+			roomIDs = make([]matrix.RoomID, 0, l)
+		}
+		roomIDs = append(roomIDs, matrix.RoomID(k))
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read invites")
+	}
+
+	return roomIDs, nil
+}
+
+// IsInvited returns true if the user has a pending invite into the given room.
+func (s *State) IsInvited(roomID matrix.RoomID) bool {
+	return s.top.FromPath(s.paths.invites).Exists(string(roomID))
+}
+
+// ForgetInvite forgets the pending invite into the given room. It is used when
+// the user has accepted or declined the invite but the server hasn't sent the
+// change yet.
+func (s *State) ForgetInvite(roomID matrix.RoomID) {
+	err := s.top.TxUpdate(func(n db.Node) error {
+		s.paths.setInvited(n, roomID, false)
+		return nil
+	})
+	if err != nil {
+		log.Println("ForgetInvite error:", err)
+	}
+}
+
+// inviteNotified is the value of a pending invite that the user was notified
+// of.
+var inviteNotified = []byte("notified")
+
+// MarkInviteNotified marks the pending invite into the given room as notified.
+// False is returned if it was already marked or if there's no such invite, so
+// that each invite is only notified once, even across restarts.
+func (s *State) MarkInviteNotified(roomID matrix.RoomID) bool {
+	var marked bool
+
+	err := s.top.TxUpdate(func(n db.Node) error {
+		n = n.FromPath(s.paths.invites)
+
+		var notified bool
+
+		err := n.Get(string(roomID), func(b []byte) error {
+			notified = bytes.Equal(b, inviteNotified)
+			return nil
+		})
+		if err != nil || notified {
+			return nil
+		}
+
+		marked = true
+		return n.Set(string(roomID), inviteNotified)
+	})
+	if err != nil {
+		log.Println("MarkInviteNotified error:", err)
+		return false
+	}
+
+	return marked
+}
+
+func (p *dbPaths) setInvited(n db.Node, roomID matrix.RoomID, invited bool) {
+	n = n.FromPath(p.invites)
+
+	var err error
+	if invited {
+		// Keep whether the user was notified of the invite, since it's sent
+		// again with every change of its state.
+		err = n.SetIfNone(string(roomID), []byte{})
+	} else {
+		err = n.Delete(string(roomID))
+	}
+
+	if err != nil {
+		log.Printf("failed to save invite for room %q: %v", roomID, err)
+	}
+}
@@ -0,0 +1,54 @@
+package state
+
+import (
+	"testing"
+
+	"github.com/diamondburned/gotrix/api"
+	"github.com/diamondburned/gotrix/matrix"
+)
+
+func syncInvite(t *testing.T, s *State, roomID matrix.RoomID) {
+	err := s.AddEvents(&api.SyncResponse{
+		Rooms: api.SyncRoomEvents{
+			Invited: map[matrix.RoomID]api.SyncInvitedRoomEvents{roomID: {}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMarkInviteNotified(t *testing.T) {
+	s := newTestState(t)
+
+	if s.MarkInviteNotified(testRoomID) {
+		t.Fatal("room without an invite was marked")
+	}
+
+	syncInvite(t, s, testRoomID)
+
+	if !s.MarkInviteNotified(testRoomID) {
+		t.Fatal("new invite wasn't marked")
+	}
+	if s.MarkInviteNotified(testRoomID) {
+		t.Fatal("invite was marked twice")
+	}
+
+	// The invite is sent again, such as in an initial sync.
+	syncInvite(t, s, testRoomID)
+
+	if !s.IsInvited(testRoomID) {
+		t.Fatal("invite is gone")
+	}
+	if s.MarkInviteNotified(testRoomID) {
+		t.Fatal("invite was marked again after being synced again")
+	}
+
+	// A new invite after the old one is declined is notified again.
+	s.ForgetInvite(testRoomID)
+	syncInvite(t, s, testRoomID)
+
+	if !s.MarkInviteNotified(testRoomID) {
+		t.Fatal("new invite after forgetting wasn't marked")
+	}
+}
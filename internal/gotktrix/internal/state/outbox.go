@@ -0,0 +1,61 @@
+package state
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+
+	"github.com/diamondburned/gotrix/event"
+	"github.com/diamondburned/gotrix/matrix"
+	"github.com/pkg/errors"
+)
+
+// OutboxEvent is a room event that is waiting to be sent.
+type OutboxEvent struct {
+	TxnID   string          `json:"txn_id"`
+	RoomID  matrix.RoomID   `json:"room_id"`
+	Type    event.Type      `json:"type"`
+	Content json.RawMessage `json:"content"`
+	// CreatedAt is the time at which the event was queued.
+	CreatedAt matrix.Timestamp `json:"created_at"`
+}
+
+// AddOutbox saves the given event into the outbox.
+func (s *State) AddOutbox(ev OutboxEvent) error {
+	if err := s.top.FromPath(s.paths.outbox).SetAny(ev.TxnID, ev); err != nil {
+		return errors.Wrap(err, "failed to save outbox event")
+	}
+	return nil
+}
+
+// DeleteOutbox deletes the event with the given transaction ID from the
+// outbox.
+func (s *State) DeleteOutbox(txnID string) error {
+	return s.top.FromPath(s.paths.outbox).Delete(txnID)
+}
+
+// Outbox returns all events in the outbox, oldest first.
+func (s *State) Outbox() ([]OutboxEvent, error) {
+	var events []OutboxEvent
+
+	n := s.top.FromPath(s.paths.outbox)
+
+	err := n.Each(func(k string, b []byte, _ int) error {
+		var ev OutboxEvent
+		if err := n.Unmarshal(b, &ev); err != nil {
+			log.Printf("invalid outbox event %q: %v", k, err)
+			return nil
+		}
+		events = append(events, ev)
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read outbox")
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].CreatedAt < events[j].CreatedAt
+	})
+
+	return events, nil
+}
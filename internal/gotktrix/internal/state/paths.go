@@ -20,6 +20,12 @@ type dbPaths struct {
 	directs   db.NodePath
 	summaries db.NodePath
 	timelines db.NodePath
+	outbox    db.NodePath
+	receipts  db.NodePath
+	invites   db.NodePath
+	presences db.NodePath
+	drafts    db.NodePath
+	filters   db.NodePath
 }
 
 func newDBPaths(topPath db.NodePath) dbPaths {
@@ -29,6 +35,12 @@ func newDBPaths(topPath db.NodePath) dbPaths {
 		directs:   topPath.Tail("directs"),
 		summaries: topPath.Tail("summaries"),
 		timelines: topPath.Tail("timelines"),
+		outbox:    topPath.Tail("outbox"),
+		receipts:  topPath.Tail("receipts"),
+		invites:   topPath.Tail("invites"),
+		presences: topPath.Tail("presences"),
+		drafts:    topPath.Tail("drafts"),
+		filters:   topPath.Tail("filters"),
 	}
 }
 
@@ -73,6 +85,28 @@ func (p *dbPaths) setRaws(
 	}
 }
 
+// timelineStateEvent should be kept in sync with StateEventInfo. Unlike
+// eventBase, it can tell state events apart from other events.
+type timelineStateEvent struct {
+	StateKey *string `json:"state_key"`
+}
+
+// setTimelineState sets the state events inside the timeline as the room's
+// state. The state returned by sync is only the state at the start of the
+// timeline, so any state change after that is in the timeline.
+func (p *dbPaths) setTimelineState(n db.Node, roomID matrix.RoomID, raws []event.RawEvent) {
+	n = n.FromPath(p.rooms).Node(string(roomID))
+
+	for _, raw := range raws {
+		var base timelineStateEvent
+		if err := json.Unmarshal(raw, &base); err != nil || base.StateKey == nil {
+			continue
+		}
+
+		setRawEvent(n, roomID, raw, true)
+	}
+}
+
 func (p *dbPaths) setStrippeds(
 	n db.Node, roomID matrix.RoomID, raws []event.StrippedEvent, state bool) {
 
@@ -132,19 +166,28 @@ func timelineEventKey(ev event.RawEvent) string {
 	str := strconv.FormatInt(int64(base.OriginServerTime), 32)
 	// Pad the timestamp with zeroes to validate sorting.
 	if base.OriginServerTime >= 0 {
-		str = i64ZeroPadding[len(i64ZeroPadding)-len(str):] + str
+		str = i64ZeroPadding[:len(i64ZeroPadding)-len(str)] + str
 	} else {
 		// Account for negative number.
-		str = "-" + i64ZeroPadding[len(i64ZeroPadding)-len(str)-2:] + str[1:]
+		str = "-" + i64ZeroPadding[:len(i64ZeroPadding)-len(str)] + str[1:]
 	}
 
 	// use \x01 to avoid colliding delimiter
 	return str + "\x01" + string(base.ID)
 }
 
+func (p *dbPaths) timelineGapsNode(n db.Node, roomID matrix.RoomID) db.Node {
+	return p.timelineNode(n, roomID).Node("gaps")
+}
+
 func (p *dbPaths) setTimeline(n db.Node, roomID matrix.RoomID, tl api.SyncTimeline) {
+	rnode := p.timelineNode(n, roomID)
 	tnode := p.timelineEventsNode(n, roomID)
 
+	// The events aren't contiguous with the stored ones if the timeline is
+	// limited or if there was no timeline before.
+	gapped := tl.Limited || !rnode.Exists("previous_batch")
+
 	for _, raw := range tl.Events {
 		key := timelineEventKey(raw)
 		if err := tnode.Set(key, raw); err != nil {
@@ -152,21 +195,27 @@ func (p *dbPaths) setTimeline(n db.Node, roomID matrix.RoomID, tl api.SyncTimeli
 		}
 	}
 
-	// Clean up the timeline events.
-	if err := tnode.DropExceptLast(TimelineKeepLast); err != nil {
-		log.Printf("failed to clean up Matrix timeline for room %q: %v", roomID, err)
+	if gapped && len(tl.Events) > 0 && tl.PreviousBatch != "" {
+		gnode := p.timelineGapsNode(n, roomID)
+		key := timelineEventKey(tl.Events[0])
+
+		if err := gnode.Set(key, []byte(tl.PreviousBatch)); err != nil {
+			log.Printf("failed to set timeline gap for room %q: %v", roomID, err)
+		}
 	}
 
 	// Write the previous batch string, if any.
 	if tl.PreviousBatch != "" {
-		rnode := p.timelineNode(n, roomID)
-
 		if err := rnode.Set("previous_batch", []byte(tl.PreviousBatch)); err != nil {
 			log.Printf("failed to set previous_batch for room %q: %v", roomID, err)
 		}
 	}
 }
 
+func (p *dbPaths) receiptsNode(n db.Node, roomID matrix.RoomID) db.Node {
+	return n.FromPath(p.receipts).Node(string(roomID))
+}
+
 func (p *dbPaths) deleteTimeline(n db.Node, roomID matrix.RoomID) {
 	n = p.timelineNode(n, roomID)
 
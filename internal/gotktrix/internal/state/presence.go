@@ -0,0 +1,46 @@
+package state
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/diamondburned/gotktrix/internal/gotktrix/internal/db"
+	"github.com/diamondburned/gotrix/event"
+	"github.com/diamondburned/gotrix/matrix"
+	"github.com/pkg/errors"
+)
+
+// UserPresence returns the last presence event of the given user. Presence is
+// only known for users that share a room with the current user.
+func (s *State) UserPresence(userID matrix.UserID) (*event.PresenceEvent, error) {
+	var ev event.Event
+
+	n := s.db.NodeFromPath(s.paths.presences)
+	if err := n.Get(string(userID), eventFunc(&ev, event.TypePresence)); err != nil {
+		return nil, errors.Wrap(err, "presence not found in state")
+	}
+
+	return ev.(*event.PresenceEvent), nil
+}
+
+// presenceSender should be kept in sync with the sender field of m.presence.
+type presenceSender struct {
+	Sender matrix.UserID `json:"sender"`
+}
+
+// setPresences saves the presence events by their senders. Presence events
+// have no state key, so they can't be saved like other user events.
+func (p *dbPaths) setPresences(n db.Node, raws []event.RawEvent) {
+	n = n.FromPath(p.presences)
+
+	for _, raw := range raws {
+		var base presenceSender
+		if err := json.Unmarshal(raw, &base); err != nil || base.Sender == "" {
+			continue
+		}
+
+		if err := n.Set(string(base.Sender), raw); err != nil {
+			log.Printf("failed to set presence for %q: %v", base.Sender, err)
+		}
+	}
+}
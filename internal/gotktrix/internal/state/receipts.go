@@ -0,0 +1,99 @@
+package state
+
+import (
+	"log"
+
+	"github.com/diamondburned/gotktrix/internal/gotktrix/events/m"
+	"github.com/diamondburned/gotktrix/internal/gotktrix/internal/db"
+	"github.com/diamondburned/gotrix/event"
+	"github.com/diamondburned/gotrix/matrix"
+	"github.com/pkg/errors"
+)
+
+// UserReceipt is the latest read receipt of a user in a room.
+type UserReceipt struct {
+	EventID   matrix.EventID   `json:"event_id"`
+	Timestamp matrix.Timestamp `json:"ts"`
+	// Private is true if the receipt is an m.read.private receipt.
+	Private bool `json:"private,omitempty"`
+}
+
+// RoomReceipts returns the users that have read up to each event in the given
+// room, excluding users that have read past it. The user IDs of each event are
+// in no particular order.
+func (s *State) RoomReceipts(roomID matrix.RoomID) (map[matrix.EventID][]matrix.UserID, error) {
+	receipts := make(map[matrix.EventID][]matrix.UserID)
+
+	n := s.paths.receiptsNode(s.top, roomID)
+
+	err := n.Each(func(k string, b []byte, _ int) error {
+		var receipt UserReceipt
+		if err := n.Unmarshal(b, &receipt); err != nil {
+			log.Printf("invalid receipt of user %q: %v", k, err)
+			return nil
+		}
+
+		receipts[receipt.EventID] = append(receipts[receipt.EventID], matrix.UserID(k))
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read receipts")
+	}
+
+	return receipts, nil
+}
+
+// RoomUserReceipt returns the latest read receipt of the given user in the
+// given room.
+func (s *State) RoomUserReceipt(roomID matrix.RoomID, userID matrix.UserID) (UserReceipt, error) {
+	var receipt UserReceipt
+	return receipt, s.paths.receiptsNode(s.top, roomID).GetAny(string(userID), &receipt)
+}
+
+// setReceipts saves the read receipts inside the given m.receipt events. Only
+// receipts that are newer than the saved ones are kept.
+func (p *dbPaths) setReceipts(n db.Node, roomID matrix.RoomID, raws []event.RawEvent) {
+	rnode := p.receiptsNode(n, roomID)
+
+	for _, raw := range raws {
+		if GuessType(raw) != event.TypeReceipt {
+			continue
+		}
+
+		content, err := m.ParseReceiptContent(raw)
+		if err != nil {
+			log.Printf("invalid m.receipt in room %q: %v", roomID, err)
+			continue
+		}
+
+		for eventID, types := range content {
+			for typ, users := range types {
+				if typ != m.ReadReceipt && typ != m.PrivateReadReceipt {
+					continue
+				}
+
+				for userID, receipt := range users {
+					// Receipts of threads don't say anything about the main
+					// timeline.
+					if receipt.ThreadID != "" && receipt.ThreadID != m.MainThreadID {
+						continue
+					}
+
+					var old UserReceipt
+					if rnode.GetAny(string(userID), &old) == nil && old.Timestamp > receipt.Timestamp {
+						continue
+					}
+
+					err := rnode.SetAny(string(userID), UserReceipt{
+						EventID:   eventID,
+						Timestamp: receipt.Timestamp,
+						Private:   typ == m.PrivateReadReceipt,
+					})
+					if err != nil {
+						log.Printf("failed to set receipt for room %q: %v", roomID, err)
+					}
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package state
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Retention is a policy of which timeline events are kept in the state. The
+// zero value keeps every event.
+type Retention struct {
+	// Events is the maximum number of latest events to keep. 0 means no limit.
+	Events int
+	// Age is the maximum age of the events to keep. 0 means no limit.
+	Age time.Duration
+}
+
+// IsUnlimited returns true if the policy keeps every event.
+func (r Retention) IsUnlimited() bool {
+	return r.Events <= 0 && r.Age <= 0
+}
+
+// String formats the policy the same way ParseRetention parses it.
+func (r Retention) String() string {
+	switch {
+	case r.Events > 0:
+		return strconv.Itoa(r.Events)
+	case r.Age > 0:
+		return strconv.Itoa(int(r.Age/(24*time.Hour))) + "d"
+	default:
+		return "unlimited"
+	}
+}
+
+// ParseRetention parses a retention policy. A policy is either "unlimited", a
+// number of events such as "500" or a number of days such as "30d".
+func ParseRetention(s string) (Retention, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+
+	switch s {
+	case "unlimited", "all":
+		return Retention{}, nil
+	}
+
+	if days := strings.TrimSuffix(s, "d"); days != s {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 1 {
+			return Retention{}, errors.Errorf("invalid number of days %q", days)
+		}
+		return Retention{Age: time.Duration(n) * 24 * time.Hour}, nil
+	}
+
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 {
+		return Retention{}, errors.Errorf("invalid retention policy %q", s)
+	}
+
+	return Retention{Events: n}, nil
+}
+
+// ParseRetentionOverrides parses a list of retention policies for specific
+// rooms or room tags. Each item is separated by a comma or a new line and looks
+// like "m.favourite = unlimited" or "!room:example.com = 30d".
+func ParseRetentionOverrides(s string) (map[string]Retention, error) {
+	items := strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == '\n' })
+	if len(items) == 0 {
+		return nil, nil
+	}
+
+	overrides := make(map[string]Retention, len(items))
+
+	for _, item := range items {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, errors.Errorf("invalid override %q, expected target = policy", item)
+		}
+
+		r, err := ParseRetention(parts[1])
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid override %q", item)
+		}
+
+		overrides[strings.TrimSpace(parts[0])] = r
+	}
+
+	return overrides, nil
+}
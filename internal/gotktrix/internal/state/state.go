@@ -17,12 +17,13 @@ import (
 )
 
 const (
-	// TimelineKeepLast determines that, when it's time to clean up, the
-	// database should only keep the last 50 events.
+	// TimelineKeepLast is the number of latest timeline events that are always
+	// kept regardless of the retention policy. It is also the number of events
+	// that RoomTimeline returns.
 	TimelineKeepLast = 100
 	// Version is the incremental database version number. It is incremented
 	// when a breaking change is made in the database that breaks old databases.
-	Version = 6
+	Version = 7
 )
 
 // State is a disk-based database of the Matrix state. Note that methods that
@@ -366,28 +367,21 @@ func (s *State) RoomPreviousBatch(roomID matrix.RoomID) (prev string, err error)
 }
 
 // RoomTimeline returns the latest raw timeline events of a room. The order of
-// the returned events are always guaranteed to be latest last.
+// the returned events are always guaranteed to be latest last. At most
+// TimelineKeepLast events are returned, and only the events after the latest
+// gap are returned.
 func (s *State) RoomTimeline(roomID matrix.RoomID) ([]event.RoomEvent, error) {
-	var evs []event.RoomEvent
-
-	n := s.paths.timelineEventsNode(s.top, roomID)
-
-	if err := n.Each(func(k string, b []byte, l int) error {
-		if evs == nil {
-			evs = make([]event.RoomEvent, 0, l)
-		}
-		evs = append(evs, sys.ParseTimeline(b, roomID))
-		return nil
-	}); err != nil {
+	chunk, err := s.RoomTimelineBefore(roomID, "", TimelineKeepLast)
+	if err != nil {
 		log.Printf("error getting timeline for room %q: %v", roomID, err)
 		return nil, err
 	}
 
-	if evs == nil {
+	if len(chunk.Events) == 0 {
 		return nil, errors.New("empty timeline state")
 	}
 
-	return evs, nil
+	return chunk.Events, nil
 }
 
 // EachTimeline iterates through the timeline.
@@ -482,19 +476,21 @@ func (s *State) NextBatch() (next string, ok bool) {
 	return next, err == nil
 }
 
-// AddRoomStates adds the given room state events. Note that values set here
-// will never override values from /sync.
-func (s *State) AddRoomMessages(roomID matrix.RoomID, resp *api.RoomMessagesResponse) {
-	err := s.top.TxUpdate(func(n db.Node) error {
-		s.paths.setRaws(n, roomID, resp.State, false)
-		s.paths.setTimeline(n, roomID, api.SyncTimeline{
-			Events: resp.Chunk,
-		})
-		return nil
-	})
-	if err != nil {
-		log.Println("AddRoomEvents error:", err)
+// ToDeviceSince returns the since token of the to-device extension of sliding
+// sync, which is like the next batch string of the regular sync. False is
+// returned if there isn't one.
+func (s *State) ToDeviceSince() (since string, ok bool) {
+	err := s.top.Get("to_device_since", db.StringFunc(&since))
+	return since, err == nil && since != ""
+}
+
+// SetToDeviceSince saves the since token of the to-device extension of sliding
+// sync.
+func (s *State) SetToDeviceSince(since string) error {
+	if err := s.top.Set("to_device_since", []byte(since)); err != nil {
+		return errors.Wrap(err, "failed to save to-device since")
 	}
+	return nil
 }
 
 // AddRoomEvents adds the given list of raw events. Note that values set here
@@ -547,6 +543,7 @@ func (s *State) AddEvents(sync *api.SyncResponse) error {
 		s.paths.setRaws(n, "", sync.AccountData.Events, true)
 		s.paths.setRaws(n, "", sync.Presence.Events, true)
 		s.paths.setRaws(n, "", sync.ToDevice.Events, true)
+		s.paths.setPresences(n, sync.Presence.Events)
 
 		for _, ev := range sync.AccountData.Events {
 			if GuessType(ev) != event.TypeDirect {
@@ -564,17 +561,22 @@ func (s *State) AddEvents(sync *api.SyncResponse) error {
 
 		for k, v := range sync.Rooms.Joined {
 			s.paths.setRaws(n, k, v.State.Events, true)
+			s.paths.setTimelineState(n, k, v.Timeline.Events)
 			s.paths.setRaws(n, k, v.AccountData.Events, true)
 			s.paths.setSummary(n, k, v.Summary)
 			s.paths.setTimeline(n, k, v.Timeline)
+			s.paths.setReceipts(n, k, v.Ephemeral.Events)
 			s.paths.setRoomAny(n, k, "__unread_count", v.UnreadCount)
+			s.paths.setInvited(n, k, false)
 		}
 
 		for k, v := range sync.Rooms.Invited {
 			s.paths.setStrippeds(n, k, v.State.Events, true)
+			s.paths.setInvited(n, k, true)
 		}
 
 		for k, v := range sync.Rooms.Left {
+			s.paths.setInvited(n, k, false)
 			s.paths.setRaws(n, k, v.State.Events, true)
 			s.paths.setRaws(n, k, v.AccountData.Events, true)
 			s.paths.deleteTimeline(n, k)
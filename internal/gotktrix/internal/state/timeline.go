@@ -0,0 +1,217 @@
+package state
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/diamondburned/gotktrix/internal/gotktrix/events/sys"
+	"github.com/diamondburned/gotktrix/internal/gotktrix/internal/db"
+	"github.com/diamondburned/gotrix/api"
+	"github.com/diamondburned/gotrix/event"
+	"github.com/diamondburned/gotrix/matrix"
+	"github.com/pkg/errors"
+)
+
+// TimelineGap describes missing events in the stored timeline of a room.
+type TimelineGap struct {
+	// After is the key of the stored event right after the missing events.
+	After string
+	// Token is the pagination token to paginate backwards from to get the
+	// missing events. It is empty if the token isn't known, in which case it
+	// must be obtained from the context of the event after the gap.
+	Token string
+}
+
+// EventID returns the ID of the stored event right after the gap.
+func (g TimelineGap) EventID() matrix.EventID {
+	// See timelineEventKey.
+	i := strings.IndexByte(g.After, '\x01')
+	if i == -1 {
+		return ""
+	}
+	return matrix.EventID(g.After[i+1:])
+}
+
+// TimelineChunk is a list of contiguous stored timeline events.
+type TimelineChunk struct {
+	// Events is the list of events, latest last.
+	Events []event.RoomEvent
+	// Oldest is the key of the oldest event in Events.
+	Oldest string
+	// Gap is non-nil if there are missing events right before the oldest
+	// event.
+	Gap *TimelineGap
+}
+
+// RoomTimelineBefore returns at most limit stored timeline events that are
+// before the event with the given key. If before is empty, then the latest
+// events are returned. The returned chunk stops early at a gap, which the
+// caller should fill before getting older events.
+func (s *State) RoomTimelineBefore(roomID matrix.RoomID, before string, limit int) (TimelineChunk, error) {
+	var chunk TimelineChunk
+
+	err := s.top.TxView(func(n db.Node) error {
+		tnode := s.paths.timelineEventsNode(n, roomID)
+		gnode := s.paths.timelineGapsNode(n, roomID)
+
+		return tnode.EachReverseBefore(before, func(k string, b []byte) error {
+			chunk.Events = append(chunk.Events, sys.ParseTimeline(b, roomID))
+			chunk.Oldest = k
+
+			var token string
+			if err := gnode.Get(k, db.StringFunc(&token)); err == nil {
+				chunk.Gap = &TimelineGap{After: k, Token: token}
+				return db.EachBreak
+			}
+
+			if len(chunk.Events) >= limit {
+				return db.EachBreak
+			}
+
+			return nil
+		})
+	})
+
+	// Flip the events so that the latest one is last.
+	events := chunk.Events
+	for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+		events[i], events[j] = events[j], events[i]
+	}
+
+	return chunk, err
+}
+
+// AddRoomMessages adds the events that are paginated backwards from the given
+// gap. The gap is moved to before the oldest new event, unless the homeserver
+// has no more events or the oldest event is already stored. If the homeserver
+// gave no events but more can be paginated, then the gap stays and is
+// returned. Note that values set here will never override values from /sync.
+func (s *State) AddRoomMessages(
+	roomID matrix.RoomID, gap TimelineGap, resp *api.RoomMessagesResponse) *TimelineGap {
+
+	var stay *TimelineGap
+
+	err := s.top.TxUpdate(func(n db.Node) error {
+		s.paths.setRaws(n, roomID, resp.State, false)
+
+		tnode := s.paths.timelineEventsNode(n, roomID)
+		gnode := s.paths.timelineGapsNode(n, roomID)
+
+		if err := gnode.Delete(gap.After); err != nil {
+			return errors.Wrap(err, "failed to delete filled gap")
+		}
+
+		oldest := gap.After
+		oldestKnown := false
+
+		// The chunk is latest first.
+		for _, raw := range resp.Chunk {
+			oldest = timelineEventKey(raw)
+			oldestKnown = tnode.Exists(oldest)
+
+			if err := tnode.SetIfNone(oldest, raw); err != nil {
+				return errors.Wrap(err, "failed to set timeline event")
+			}
+		}
+
+		if resp.End != "" && !oldestKnown {
+			if err := gnode.Set(oldest, []byte(resp.End)); err != nil {
+				return errors.Wrap(err, "failed to set timeline gap")
+			}
+
+			if oldest == gap.After {
+				stay = &TimelineGap{After: oldest, Token: resp.End}
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		log.Printf("AddRoomMessages error for room %q: %v", roomID, err)
+	}
+
+	return stay
+}
+
+// CompactTimeline drops the stored timeline events of the given room that the
+// retention policy doesn't keep. The latest TimelineKeepLast events are always
+// kept. The number of dropped events is returned.
+func (s *State) CompactTimeline(roomID matrix.RoomID, r Retention, now time.Time) (int, error) {
+	if r.IsUnlimited() {
+		return 0, nil
+	}
+
+	var dropped int
+
+	err := s.top.TxUpdate(func(n db.Node) error {
+		tnode := s.paths.timelineEventsNode(n, roomID)
+		gnode := s.paths.timelineGapsNode(n, roomID)
+
+		var kept, cut string
+		var i int
+
+		err := tnode.EachReverseBefore("", func(k string, b []byte) error {
+			if i >= TimelineKeepLast && !r.keeps(i, b, now) {
+				cut = k
+				return db.EachBreak
+			}
+			kept = k
+			i++
+			return nil
+		})
+		if err != nil || cut == "" {
+			return err
+		}
+
+		total, err := tnode.Length("")
+		if err != nil {
+			return errors.Wrap(err, "failed to count timeline events")
+		}
+		dropped = total - i
+
+		if err := tnode.DropUntil(cut); err != nil {
+			return errors.Wrap(err, "failed to drop timeline events")
+		}
+
+		if err := gnode.DropUntil(cut); err != nil {
+			return errors.Wrap(err, "failed to drop timeline gaps")
+		}
+
+		// The events before the oldest kept event are now missing. The token
+		// for them is unknown, so leave it empty.
+		if !gnode.Exists(kept) {
+			if err := gnode.Set(kept, nil); err != nil {
+				return errors.Wrap(err, "failed to set timeline gap")
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to compact timeline of room %q", roomID)
+	}
+
+	return dropped, nil
+}
+
+// keeps returns true if the retention policy keeps the event with the given
+// index, which counts from the latest event.
+func (r Retention) keeps(i int, raw []byte, now time.Time) bool {
+	if r.Events > 0 && i >= r.Events {
+		return false
+	}
+
+	if r.Age > 0 {
+		var base timelineEventBase
+		if err := json.Unmarshal(raw, &base); err == nil {
+			if now.Sub(base.OriginServerTime.Time()) > r.Age {
+				return false
+			}
+		}
+	}
+
+	return true
+}
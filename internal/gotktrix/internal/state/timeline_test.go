@@ -0,0 +1,252 @@
+package state
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/diamondburned/gotrix/api"
+	"github.com/diamondburned/gotrix/event"
+	"github.com/diamondburned/gotrix/matrix"
+)
+
+const testRoomID = matrix.RoomID("!room:example.com")
+
+func newTestState(t *testing.T) *State {
+	s, err := New(filepath.Join(t.TempDir(), "state"), "@user:example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// testEvents makes the events from ts to ts+n-1, oldest first.
+func testEvents(ts, n int) []event.RawEvent {
+	raws := make([]event.RawEvent, n)
+	for i := range raws {
+		raws[i] = event.RawEvent(fmt.Sprintf(
+			`{"type":"m.room.message","event_id":"$%d","sender":"@a:example.com",`+
+				`"origin_server_ts":%d,"content":{"msgtype":"m.text","body":"%d"}}`,
+			ts+i, ts+i, ts+i,
+		))
+	}
+	return raws
+}
+
+func reversed(raws []event.RawEvent) []event.RawEvent {
+	rev := make([]event.RawEvent, len(raws))
+	for i, raw := range raws {
+		rev[len(raws)-1-i] = raw
+	}
+	return rev
+}
+
+func syncTimeline(t *testing.T, s *State, tl api.SyncTimeline) {
+	err := s.AddEvents(&api.SyncResponse{
+		Rooms: api.SyncRoomEvents{
+			Joined: map[matrix.RoomID]api.SyncJoinedRoomEvents{
+				testRoomID: {Timeline: tl},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func eventIDs(events []event.RoomEvent) []matrix.EventID {
+	ids := make([]matrix.EventID, len(events))
+	for i, ev := range events {
+		ids[i] = ev.RoomInfo().ID
+	}
+	return ids
+}
+
+func expectIDs(t *testing.T, events []event.RoomEvent, from, to int) {
+	t.Helper()
+
+	ids := eventIDs(events)
+	if len(ids) != to-from+1 {
+		t.Fatalf("got %d events %v, expected $%d to $%d", len(ids), ids, from, to)
+	}
+	for i, id := range ids {
+		if expect := matrix.EventID(fmt.Sprintf("$%d", from+i)); id != expect {
+			t.Fatalf("event %d is %q, expected %q", i, id, expect)
+		}
+	}
+}
+
+func TestTimelineGaps(t *testing.T) {
+	s := newTestState(t)
+
+	syncTimeline(t, s, api.SyncTimeline{
+		Events:        testEvents(1000, 10),
+		Limited:       true,
+		PreviousBatch: "p1",
+	})
+	// Not limited, so this is contiguous with the first sync.
+	syncTimeline(t, s, api.SyncTimeline{
+		Events:        testEvents(1010, 5),
+		PreviousBatch: "p2",
+	})
+
+	chunk, err := s.RoomTimelineBefore(testRoomID, "", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectIDs(t, chunk.Events, 1000, 1014)
+
+	if chunk.Gap == nil || chunk.Gap.Token != "p1" || chunk.Gap.EventID() != "$1000" {
+		t.Fatalf("unexpected gap %#v", chunk.Gap)
+	}
+
+	// The homeserver may give nothing while still having more events.
+	gap := s.AddRoomMessages(testRoomID, *chunk.Gap, &api.RoomMessagesResponse{
+		End: "p1.5",
+	})
+	if gap == nil || gap.After != chunk.Oldest || gap.Token != "p1.5" {
+		t.Fatalf("unexpected remaining gap %#v", gap)
+	}
+
+	// Paginate the gap.
+	gap = s.AddRoomMessages(testRoomID, *gap, &api.RoomMessagesResponse{
+		Chunk: reversed(testEvents(990, 10)),
+		End:   "p0",
+	})
+	if gap != nil {
+		t.Fatalf("unexpected remaining gap %#v", gap)
+	}
+
+	chunk, err = s.RoomTimelineBefore(testRoomID, chunk.Oldest, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectIDs(t, chunk.Events, 995, 999)
+
+	if chunk.Gap != nil {
+		t.Fatalf("unexpected gap %#v before the limit", chunk.Gap)
+	}
+
+	chunk, err = s.RoomTimelineBefore(testRoomID, chunk.Oldest, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectIDs(t, chunk.Events, 990, 994)
+
+	if chunk.Gap == nil || chunk.Gap.Token != "p0" {
+		t.Fatalf("unexpected gap %#v", chunk.Gap)
+	}
+
+	// Reach the start of the room.
+	s.AddRoomMessages(testRoomID, *chunk.Gap, &api.RoomMessagesResponse{})
+
+	chunk, err = s.RoomTimelineBefore(testRoomID, chunk.Oldest, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chunk.Events) != 0 || chunk.Gap != nil {
+		t.Fatalf("unexpected chunk %v with gap %#v", eventIDs(chunk.Events), chunk.Gap)
+	}
+
+	// A limited sync leaves a gap between the old and new events.
+	syncTimeline(t, s, api.SyncTimeline{
+		Events:        testEvents(2000, 5),
+		Limited:       true,
+		PreviousBatch: "p3",
+	})
+
+	chunk, err = s.RoomTimelineBefore(testRoomID, "", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectIDs(t, chunk.Events, 2000, 2004)
+
+	if chunk.Gap == nil || chunk.Gap.Token != "p3" {
+		t.Fatalf("unexpected gap %#v", chunk.Gap)
+	}
+}
+
+func TestCompactTimeline(t *testing.T) {
+	s := newTestState(t)
+
+	syncTimeline(t, s, api.SyncTimeline{
+		Events:        testEvents(1000, TimelineKeepLast+50),
+		Limited:       true,
+		PreviousBatch: "p1",
+	})
+
+	dropped, err := s.CompactTimeline(testRoomID, Retention{Events: 10}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The latest TimelineKeepLast events are always kept.
+	if dropped != 50 {
+		t.Errorf("dropped %d events, expected 50", dropped)
+	}
+
+	chunk, err := s.RoomTimelineBefore(testRoomID, "", 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectIDs(t, chunk.Events, 1050, 1000+TimelineKeepLast+49)
+
+	// The dropped events are now a gap with an unknown token.
+	if chunk.Gap == nil || chunk.Gap.Token != "" || chunk.Gap.EventID() != "$1050" {
+		t.Fatalf("unexpected gap %#v", chunk.Gap)
+	}
+
+	// Events are at 1970, so they're all too old, but the latest ones are
+	// still kept.
+	dropped, err = s.CompactTimeline(testRoomID, Retention{Age: 24 * time.Hour}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dropped != 0 {
+		t.Errorf("dropped %d events, expected none", dropped)
+	}
+
+	dropped, err = s.CompactTimeline(testRoomID, Retention{}, time.Now())
+	if err != nil || dropped != 0 {
+		t.Errorf("unlimited retention dropped %d events (err: %v)", dropped, err)
+	}
+}
+
+func TestParseRetention(t *testing.T) {
+	tests := []struct {
+		in  string
+		out Retention
+		err bool
+	}{
+		{in: "unlimited", out: Retention{}},
+		{in: " 500 ", out: Retention{Events: 500}},
+		{in: "30d", out: Retention{Age: 30 * 24 * time.Hour}},
+		{in: "0", err: true},
+		{in: "-3d", err: true},
+		{in: "forever", err: true},
+	}
+
+	for _, test := range tests {
+		r, err := ParseRetention(test.in)
+		if (err != nil) != test.err || r != test.out {
+			t.Errorf("ParseRetention(%q) = (%v, %v), expected %v", test.in, r, err, test.out)
+		}
+	}
+
+	overrides, err := ParseRetentionOverrides("m.favourite = unlimited,\n!a:example.com=30d")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(overrides) != 2 ||
+		!overrides["m.favourite"].IsUnlimited() ||
+		overrides["!a:example.com"].Age != 30*24*time.Hour {
+
+		t.Errorf("unexpected overrides %v", overrides)
+	}
+
+	if _, err := ParseRetentionOverrides("m.favourite"); err == nil {
+		t.Error("missing policy is not an error")
+	}
+}
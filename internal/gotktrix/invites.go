@@ -0,0 +1,125 @@
+package gotktrix
+
+import (
+	"github.com/diamondburned/gotrix/event"
+	"github.com/diamondburned/gotrix/matrix"
+	"github.com/pkg/errors"
+)
+
+// RoomInvite is a pending invite into a room. It is previewed from the stripped
+// state that comes with the invite, since the user can't see the full state of
+// the room until they join it.
+type RoomInvite struct {
+	RoomID matrix.RoomID
+	// Name is the room's name. If the room has no name, then its alias or the
+	// inviter's name is used.
+	Name string
+	// Avatar is the room's avatar. If the room has no avatar, then the
+	// inviter's avatar is used.
+	Avatar matrix.URL
+	// Inviter is the user who sent the invite.
+	Inviter matrix.UserID
+	// InviterName is the display name of the inviter.
+	InviterName string
+	// Reason is the optional reason given by the inviter.
+	Reason string
+	// IsDirect is true if the invite is for a direct messaging room.
+	IsDirect bool
+	// IsSpace is true if the invite is for a space.
+	IsSpace bool
+}
+
+// InvitedRooms returns the IDs of the rooms that the user is invited to.
+func (c *Client) InvitedRooms() ([]matrix.RoomID, error) {
+	return c.State.InvitedRooms()
+}
+
+// MarkInviteNotified marks the pending invite into the given room as notified.
+// It returns true only the first time, so that the user is only notified once
+// of each invite.
+func (c *Client) MarkInviteNotified(roomID matrix.RoomID) bool {
+	return c.State.MarkInviteNotified(roomID)
+}
+
+// RoomInvite returns the pending invite into the given room. Only the state is
+// queried.
+func (c *Client) RoomInvite(roomID matrix.RoomID) (RoomInvite, error) {
+	if !c.State.IsInvited(roomID) {
+		return RoomInvite{}, errors.Errorf("no pending invite into room %q", roomID)
+	}
+
+	e, err := c.State.RoomState(roomID, event.TypeRoomMember, string(c.UserID))
+	if err != nil {
+		return RoomInvite{}, errors.Wrap(err, "invite has no member event")
+	}
+
+	member := e.(*event.RoomMemberEvent)
+
+	invite := RoomInvite{
+		RoomID:   roomID,
+		Inviter:  member.Sender,
+		Reason:   member.Reason,
+		IsDirect: member.IsDirect,
+		IsSpace:  c.Offline().RoomIsSpace(roomID),
+	}
+
+	invite.InviterName = string(invite.Inviter)
+	invite.Avatar = c.strippedMemberAvatar(roomID, invite.Inviter)
+
+	if e, _ := c.State.RoomState(roomID, event.TypeRoomMember, string(invite.Inviter)); e != nil {
+		inviter := e.(*event.RoomMemberEvent)
+		if inviter.DisplayName != nil && *inviter.DisplayName != "" {
+			invite.InviterName = *inviter.DisplayName
+		}
+	}
+
+	if e, _ := c.State.RoomState(roomID, event.TypeRoomAvatar, ""); e != nil {
+		if avatar := e.(*event.RoomAvatarEvent); avatar.URL != "" {
+			invite.Avatar = avatar.URL
+		}
+	}
+
+	invite.Name = invite.InviterName
+
+	if e, _ := c.State.RoomState(roomID, event.TypeRoomCanonicalAlias, ""); e != nil {
+		if alias := e.(*event.RoomCanonicalAliasEvent); alias.Alias != "" {
+			invite.Name = alias.Alias
+		}
+	}
+
+	if e, _ := c.State.RoomState(roomID, event.TypeRoomName, ""); e != nil {
+		if name := e.(*event.RoomNameEvent); name.Name != "" {
+			invite.Name = name.Name
+		}
+	}
+
+	return invite, nil
+}
+
+func (c *Client) strippedMemberAvatar(roomID matrix.RoomID, userID matrix.UserID) matrix.URL {
+	e, _ := c.State.RoomState(roomID, event.TypeRoomMember, string(userID))
+	if e == nil {
+		return ""
+	}
+	return e.(*event.RoomMemberEvent).AvatarURL
+}
+
+// AcceptInvite joins the room that the user is invited to.
+func (c *Client) AcceptInvite(roomID matrix.RoomID) error {
+	if _, err := c.JoinRoom(string(roomID), nil); err != nil {
+		return err
+	}
+
+	c.State.ForgetInvite(roomID)
+	return nil
+}
+
+// DeclineInvite rejects the invite into the given room.
+func (c *Client) DeclineInvite(roomID matrix.RoomID) error {
+	if err := c.Client.RoomLeave(roomID, ""); err != nil {
+		return errors.Wrap(err, "failed to decline invite")
+	}
+
+	c.State.ForgetInvite(roomID)
+	return nil
+}
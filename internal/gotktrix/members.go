@@ -0,0 +1,180 @@
+package gotktrix
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/diamondburned/gotrix/event"
+	"github.com/diamondburned/gotrix/matrix"
+	"github.com/pkg/errors"
+)
+
+// UserPresence returns the presence of the given user. Presence is only known
+// for users that share a room with the current user, so PresenceOffline is
+// returned for everyone else.
+func (c *Client) UserPresence(userID matrix.UserID) matrix.Presence {
+	p, err := c.State.UserPresence(userID)
+	if err != nil {
+		return matrix.PresenceOffline
+	}
+	return p.Presence
+}
+
+// UserStatus is the presence of a user as fetched from the homeserver.
+type UserStatus struct {
+	Presence matrix.Presence
+	// Message is the status message, if any.
+	Message string
+	// LastActive is the time that the user last did anything, or the zero
+	// time if it's unknown.
+	LastActive time.Time
+	// CurrentlyActive is true if the user is actively using a client.
+	CurrentlyActive bool
+}
+
+// UserStatus fetches the presence of the given user from the homeserver. Unlike
+// UserPresence, the last active time is as recent as it can be.
+func (c *Client) UserStatus(userID matrix.UserID) (UserStatus, error) {
+	p, err := c.Client.Presence(userID)
+	if err != nil {
+		return UserStatus{}, err
+	}
+
+	status := UserStatus{Presence: p.Presence}
+	if p.StatusMsg != nil {
+		status.Message = *p.StatusMsg
+	}
+	if p.LastActiveAgo != nil {
+		status.LastActive = time.Now().Add(-time.Duration(*p.LastActiveAgo) * time.Millisecond)
+	}
+	if p.CurrentlyActive != nil {
+		status.CurrentlyActive = *p.CurrentlyActive
+	}
+
+	return status, nil
+}
+
+// SharedRooms returns the joined rooms that the given user is also joined in.
+// Only the state is used.
+func (c *Client) SharedRooms(userID matrix.UserID) []matrix.RoomID {
+	roomIDs, err := c.State.Rooms()
+	if err != nil {
+		return nil
+	}
+
+	var shared []matrix.RoomID
+
+	for _, roomID := range roomIDs {
+		e, err := c.State.RoomState(roomID, event.TypeRoomMember, string(userID))
+		if err != nil {
+			continue
+		}
+
+		if e.(*event.RoomMemberEvent).NewState == event.MemberJoined {
+			shared = append(shared, roomID)
+		}
+	}
+
+	return shared
+}
+
+// SetUserPowerLevel sets the power level of the given user in the given room.
+func (c *Client) SetUserPowerLevel(roomID matrix.RoomID, userID matrix.UserID, level int) error {
+	p, err := c.RoomPowerLevels(roomID)
+	if err != nil {
+		return errors.Wrap(err, "room has no power levels")
+	}
+
+	if level == p.UsersDefault {
+		delete(p.Users, userID)
+	} else {
+		p.Users[userID] = level
+	}
+
+	return c.SetRoomPowerLevels(roomID, p)
+}
+
+// directEvent returns the user's m.direct event. An empty event is returned if
+// the user has none.
+func (c *Client) directEvent() (*event.DirectEvent, error) {
+	e, err := c.UserEvent(event.TypeDirect)
+	if err != nil {
+		if matrix.StatusCode(err) == http.StatusNotFound {
+			return &event.DirectEvent{Rooms: map[matrix.UserID][]matrix.RoomID{}}, nil
+		}
+		return nil, err
+	}
+
+	dir := e.(*event.DirectEvent)
+	if dir.Rooms == nil {
+		dir.Rooms = make(map[matrix.UserID][]matrix.RoomID, 1)
+	}
+
+	return dir, nil
+}
+
+// DirectRoom returns the direct messaging room with the given user that the
+// current user is still in. If there are many, then the latest one is returned.
+func (c *Client) DirectRoom(userID matrix.UserID) (matrix.RoomID, bool) {
+	dir, err := c.directEvent()
+	if err != nil {
+		return "", false
+	}
+
+	roomIDs := dir.Rooms[userID]
+
+	for i := len(roomIDs) - 1; i >= 0; i-- {
+		e, err := c.State.RoomState(roomIDs[i], event.TypeRoomMember, string(c.UserID))
+		if err != nil {
+			continue
+		}
+
+		if e.(*event.RoomMemberEvent).NewState == event.MemberJoined {
+			return roomIDs[i], true
+		}
+	}
+
+	return "", false
+}
+
+// StartDirect returns the direct messaging room with the given user. If there
+// isn't one, then a new room is created and the user is invited into it.
+func (c *Client) StartDirect(userID matrix.UserID) (matrix.RoomID, error) {
+	if roomID, ok := c.DirectRoom(userID); ok {
+		return roomID, nil
+	}
+
+	roomID, err := c.CreateRoom(CreateRoomArg{
+		Direct:    true,
+		Encrypted: true,
+		Invite:    []matrix.UserID{userID},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// Fetch the latest m.direct from the server, since we're overriding it.
+	var rooms map[matrix.UserID][]matrix.RoomID
+
+	err = c.ClientConfig(string(event.TypeDirect), &rooms)
+	if err != nil && matrix.StatusCode(err) != http.StatusNotFound {
+		return roomID, errors.Wrap(err, "failed to get direct rooms")
+	}
+
+	if rooms == nil {
+		rooms = make(map[matrix.UserID][]matrix.RoomID, 1)
+	}
+	rooms[userID] = append(rooms[userID], roomID)
+
+	if err := c.ClientConfigSet(string(event.TypeDirect), rooms); err != nil {
+		return roomID, errors.Wrap(err, "failed to save direct room")
+	}
+
+	dir := &event.DirectEvent{Rooms: rooms}
+	dir.Type = event.TypeDirect
+
+	c.State.UseDirectEvent(dir)
+	c.State.SetUserEvent(dir)
+
+	return roomID, nil
+}
@@ -0,0 +1,107 @@
+package gotktrix
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/diamondburned/gotrix/matrix"
+	"github.com/pkg/errors"
+)
+
+// IsOffline returns true if the given error is caused by the homeserver being
+// unreachable, as opposed to the homeserver rejecting the request.
+func IsOffline(err error) bool {
+	return err != nil &&
+		matrix.StatusCode(err) == -1 &&
+		!errors.Is(err, context.Canceled)
+}
+
+// syncLoop keeps track of the sync loop, which is opened in the background if
+// the homeserver is unreachable when the client is opened. The sliding sync
+// loop is always run in the background.
+type syncLoop struct {
+	mu      sync.Mutex
+	opened  bool
+	stop    chan struct{}
+	done    chan struct{} // nil if not reopening
+	profile SyncProfile
+	sliding bool
+}
+
+// reopen keeps trying to open the sync loop in the background until it
+// succeeds or the client is closed.
+func (c *Client) reopen(next string) {
+	stop := make(chan struct{})
+	done := make(chan struct{})
+
+	c.loop.mu.Lock()
+	c.loop.stop = stop
+	c.loop.done = done
+	c.loop.mu.Unlock()
+
+	go func() {
+		defer close(done)
+
+		backoff := SyncOptions.MinBackoffTime
+
+		timer := time.NewTimer(backoff)
+		defer timer.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-timer.C:
+			}
+
+			err := c.Client.OpenWithNext(next)
+			if err == nil {
+				log.Println("homeserver is reachable again, sync loop opened")
+
+				c.loop.mu.Lock()
+				c.loop.opened = true
+				c.loop.mu.Unlock()
+				return
+			}
+
+			if !IsOffline(err) {
+				log.Println("cannot open sync loop:", err)
+			}
+
+			backoff *= 2
+			if backoff > SyncOptions.MaxBackoffTime {
+				backoff = SyncOptions.MaxBackoffTime
+			}
+
+			timer.Reset(backoff)
+		}
+	}()
+}
+
+// closeLoop stops reopening the sync loop and closes it if it's opened.
+func (c *Client) closeLoop() error {
+	c.loop.mu.Lock()
+	done := c.loop.done
+	if done != nil {
+		close(c.loop.stop)
+		c.loop.done = nil
+	}
+	c.loop.mu.Unlock()
+
+	if done != nil {
+		<-done
+	}
+
+	c.loop.mu.Lock()
+	opened := c.loop.opened
+	c.loop.opened = false
+	c.loop.mu.Unlock()
+
+	if !opened {
+		return nil
+	}
+
+	return c.Client.Close()
+}
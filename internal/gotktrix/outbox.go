@@ -0,0 +1,431 @@
+package gotktrix
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/diamondburned/gotktrix/internal/gotktrix/internal/state"
+	"github.com/diamondburned/gotrix/event"
+	"github.com/diamondburned/gotrix/matrix"
+	"github.com/pkg/errors"
+)
+
+// OutboxEvent is a room event that is waiting to be sent.
+type OutboxEvent = state.OutboxEvent
+
+// OutboxStatus is the sending status of an event in the outbox.
+type OutboxStatus uint8
+
+const (
+	// OutboxPending means the event is being sent or is waiting to be sent.
+	OutboxPending OutboxStatus = iota
+	// OutboxFailed means the last attempt to send the event failed. If
+	// NextRetry is zero, then the event will only be sent again if it's
+	// retried manually.
+	OutboxFailed
+	// OutboxSent means the event is sent and is removed from the outbox.
+	OutboxSent
+	// OutboxCancelled means the user cancelled sending the event.
+	OutboxCancelled
+)
+
+// OutboxUpdate describes a change in an outbox event's status.
+type OutboxUpdate struct {
+	Event  OutboxEvent
+	Status OutboxStatus
+	// EventID is the ID of the sent event. It is only set if Status is
+	// OutboxSent.
+	EventID matrix.EventID
+	// Err is the error of the last attempt. It is only set if Status is
+	// OutboxFailed.
+	Err error
+	// NextRetry is the time of the next automatic attempt.
+	NextRetry time.Time
+}
+
+const (
+	outboxMinBackoff = 2 * time.Second
+	outboxMaxBackoff = 5 * time.Minute
+)
+
+// Outbox is a persistent queue of room events to be sent. Events are sent in
+// the order that they're queued in, and failed events are retried with
+// exponential backoff. Events that aren't sent yet survive restarts.
+type Outbox struct {
+	c *Client
+
+	mu     sync.Mutex
+	events []*outboxItem
+	subs   map[matrix.RoomID]map[*outboxSub]struct{}
+	wake   chan struct{}
+	stop   chan struct{}
+	loaded bool
+}
+
+type outboxItem struct {
+	OutboxEvent
+	attempts int
+	next     time.Time
+	// manual is true if the event failed with an error that retrying
+	// automatically won't fix.
+	manual  bool
+	lastErr error
+}
+
+type outboxSub struct {
+	f func(OutboxUpdate)
+}
+
+func newOutbox(c *Client) *Outbox {
+	return &Outbox{
+		c:    c,
+		subs: make(map[matrix.RoomID]map[*outboxSub]struct{}),
+		wake: make(chan struct{}, 1),
+		stop: make(chan struct{}),
+	}
+}
+
+// start loads the saved outbox from the state and starts the sending loop.
+func (o *Outbox) start() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.loaded {
+		return
+	}
+	o.loaded = true
+
+	events, err := o.c.State.Outbox()
+	if err != nil {
+		log.Println("cannot load outbox:", err)
+	}
+
+	for _, ev := range events {
+		o.events = append(o.events, &outboxItem{OutboxEvent: ev})
+	}
+
+	go o.loop(o.stop)
+}
+
+func (o *Outbox) close() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.loaded {
+		close(o.stop)
+		o.stop = make(chan struct{})
+		o.loaded = false
+	}
+}
+
+// newTxnID creates a new transaction ID. Transaction IDs must be unique across
+// restarts for the same access token, so a counter is not enough.
+func newTxnID() string {
+	var b [4]byte
+	rand.Read(b[:])
+	return fmt.Sprintf("gotktrix.%d.%s", time.Now().UnixNano(), hex.EncodeToString(b[:]))
+}
+
+// Enqueue saves the given event into the outbox and sends it in the
+// background. The returned event contains the new transaction ID.
+func (o *Outbox) Enqueue(roomID matrix.RoomID, typ event.Type, content interface{}) (OutboxEvent, error) {
+	b, err := json.Marshal(content)
+	if err != nil {
+		return OutboxEvent{}, errors.Wrap(err, "failed to marshal event")
+	}
+
+	ev := OutboxEvent{
+		TxnID:     newTxnID(),
+		RoomID:    roomID,
+		Type:      typ,
+		Content:   b,
+		CreatedAt: matrix.Timestamp(time.Now().UnixMilli()),
+	}
+
+	if err := o.c.State.AddOutbox(ev); err != nil {
+		return OutboxEvent{}, err
+	}
+
+	o.mu.Lock()
+	o.events = append(o.events, &outboxItem{OutboxEvent: ev})
+	o.publish(OutboxUpdate{Event: ev, Status: OutboxPending})
+	o.mu.Unlock()
+
+	o.poke()
+	return ev, nil
+}
+
+// Events returns the events in the outbox for the given room, oldest first.
+func (o *Outbox) Events(roomID matrix.RoomID) []OutboxUpdate {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	var updates []OutboxUpdate
+	for _, item := range o.events {
+		if item.RoomID == roomID {
+			updates = append(updates, item.update())
+		}
+	}
+
+	return updates
+}
+
+// Retry sends the event with the given transaction ID again immediately.
+func (o *Outbox) Retry(txnID string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for _, item := range o.events {
+		if item.TxnID == txnID {
+			item.next = time.Time{}
+			item.manual = false
+			item.lastErr = nil
+			o.publish(item.update())
+			break
+		}
+	}
+
+	o.poke()
+}
+
+// Cancel removes the event with the given transaction ID from the outbox. An
+// event that is currently being sent might still arrive.
+func (o *Outbox) Cancel(txnID string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for i, item := range o.events {
+		if item.TxnID == txnID {
+			o.events = append(o.events[:i], o.events[i+1:]...)
+			o.publish(OutboxUpdate{Event: item.OutboxEvent, Status: OutboxCancelled})
+			break
+		}
+	}
+
+	if err := o.c.State.DeleteOutbox(txnID); err != nil {
+		log.Printf("cannot delete outbox event %q: %v", txnID, err)
+	}
+}
+
+// Subscribe subscribes f to all outbox updates of the given room. f is called
+// in a background goroutine with the outbox locked, so it must not call any of
+// the Outbox's methods.
+func (o *Outbox) Subscribe(roomID matrix.RoomID, f func(OutboxUpdate)) (rm func()) {
+	sub := &outboxSub{f}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	subs, ok := o.subs[roomID]
+	if !ok {
+		subs = make(map[*outboxSub]struct{})
+		o.subs[roomID] = subs
+	}
+	subs[sub] = struct{}{}
+
+	return func() {
+		o.mu.Lock()
+		defer o.mu.Unlock()
+
+		delete(subs, sub)
+		if len(subs) == 0 {
+			delete(o.subs, roomID)
+		}
+	}
+}
+
+// publish must be called with the mutex held.
+func (o *Outbox) publish(update OutboxUpdate) {
+	for sub := range o.subs[update.Event.RoomID] {
+		sub.f(update)
+	}
+}
+
+// online is called when the homeserver becomes reachable. All events that
+// failed because of a connection error are retried immediately.
+func (o *Outbox) online() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	retry := false
+	for _, item := range o.events {
+		if !item.manual && !item.next.IsZero() && matrix.StatusCode(item.lastErr) == -1 {
+			item.next = time.Time{}
+			retry = true
+		}
+	}
+
+	if retry {
+		o.poke()
+	}
+}
+
+func (o *Outbox) poke() {
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (o *Outbox) loop(stop <-chan struct{}) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-o.wake:
+		case <-timer.C:
+		}
+
+		wait := o.sendDue()
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		if wait > 0 {
+			timer.Reset(wait)
+		}
+	}
+}
+
+// sendDue sends all events that are due in order. It returns the duration
+// until the next event is due, or 0 if there's nothing to wait for.
+func (o *Outbox) sendDue() time.Duration {
+	for {
+		o.mu.Lock()
+		item, wait := o.nextDue()
+		o.mu.Unlock()
+
+		if item == nil {
+			return wait
+		}
+
+		o.send(item)
+	}
+}
+
+// nextDue returns the first event that should be sent now. If there's none,
+// then the duration until the next one is returned. Events in the same room
+// are sent in order, so a failed event holds back the events after it.
+func (o *Outbox) nextDue() (*outboxItem, time.Duration) {
+	now := time.Now()
+	blocked := make(map[matrix.RoomID]bool)
+
+	var wait time.Duration
+
+	for _, item := range o.events {
+		if blocked[item.RoomID] {
+			continue
+		}
+		blocked[item.RoomID] = true
+
+		if item.manual {
+			continue
+		}
+
+		if item.next.IsZero() || !item.next.After(now) {
+			return item, 0
+		}
+
+		if until := item.next.Sub(now); wait == 0 || until < wait {
+			wait = until
+		}
+	}
+
+	return nil, wait
+}
+
+func (o *Outbox) send(item *outboxItem) {
+	eventID, err := o.c.RoomEventSendTxn(item.RoomID, item.Type, item.Content, item.TxnID)
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	// Check that the event wasn't cancelled while we were sending it.
+	index := -1
+	for i, it := range o.events {
+		if it == item {
+			index = i
+			break
+		}
+	}
+
+	if err == nil {
+		if index != -1 {
+			o.events = append(o.events[:index], o.events[index+1:]...)
+		}
+
+		if err := o.c.State.DeleteOutbox(item.TxnID); err != nil {
+			log.Printf("cannot delete sent outbox event %q: %v", item.TxnID, err)
+		}
+
+		o.publish(OutboxUpdate{
+			Event:   item.OutboxEvent,
+			Status:  OutboxSent,
+			EventID: eventID,
+		})
+		return
+	}
+
+	if index == -1 {
+		return
+	}
+
+	log.Printf("cannot send outbox event %q (attempt %d): %v", item.TxnID, item.attempts+1, err)
+
+	item.attempts++
+	item.lastErr = err
+
+	if isPermanentSendError(err) {
+		item.manual = true
+		item.next = time.Time{}
+	} else {
+		backoff := outboxMinBackoff << (item.attempts - 1)
+		if backoff > outboxMaxBackoff || backoff <= 0 {
+			backoff = outboxMaxBackoff
+		}
+		item.next = time.Now().Add(backoff)
+	}
+
+	o.publish(item.update())
+}
+
+func (item *outboxItem) update() OutboxUpdate {
+	if item.lastErr == nil {
+		return OutboxUpdate{Event: item.OutboxEvent, Status: OutboxPending}
+	}
+
+	return OutboxUpdate{
+		Event:     item.OutboxEvent,
+		Status:    OutboxFailed,
+		Err:       item.lastErr,
+		NextRetry: item.next,
+	}
+}
+
+// isPermanentSendError returns true if the homeserver rejected the event in a
+// way that sending it again won't fix.
+func isPermanentSendError(err error) bool {
+	code := matrix.StatusCode(err)
+	if code < 400 || code >= 500 {
+		return false
+	}
+
+	switch code {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return false
+	default:
+		return true
+	}
+}
@@ -0,0 +1,283 @@
+package gotktrix
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/diamondburned/gotrix/api"
+	"github.com/diamondburned/gotrix/event"
+	"github.com/diamondburned/gotrix/matrix"
+	"github.com/pkg/errors"
+)
+
+// PowerAction describes an action inside a room that requires a certain power
+// level. Besides the predefined actions, sending any event is also an action;
+// see StateAction and EventAction.
+type PowerAction string
+
+const (
+	BanAction    PowerAction = "ban"
+	InviteAction PowerAction = "invite"
+	KickAction   PowerAction = "kick"
+	RedactAction PowerAction = "redact"
+	// NotifyRoomAction is the action of notifying the whole room using @room.
+	NotifyRoomAction PowerAction = "notifications.room"
+)
+
+const (
+	stateActionPrefix = "state:"
+	eventActionPrefix = "event:"
+)
+
+// StateAction returns the action of sending a state event of the given type.
+func StateAction(typ event.Type) PowerAction {
+	return PowerAction(stateActionPrefix + string(typ))
+}
+
+// EventAction returns the action of sending a message event of the given type.
+func EventAction(typ event.Type) PowerAction {
+	return PowerAction(eventActionPrefix + string(typ))
+}
+
+// EventType returns the event type of the action if it's made using
+// StateAction or EventAction.
+func (a PowerAction) EventType() (typ event.Type, state, ok bool) {
+	switch {
+	case strings.HasPrefix(string(a), stateActionPrefix):
+		return event.Type(strings.TrimPrefix(string(a), stateActionPrefix)), true, true
+	case strings.HasPrefix(string(a), eventActionPrefix):
+		return event.Type(strings.TrimPrefix(string(a), eventActionPrefix)), false, true
+	default:
+		return "", false, false
+	}
+}
+
+// PowerLevels is the content of a room's m.room.power_levels event with the
+// defaults from the specification filled in.
+type PowerLevels struct {
+	Ban        int
+	Invite     int
+	Kick       int
+	Redact     int
+	NotifyRoom int
+
+	// Events is the power level required to send each event type. It
+	// overrides EventsDefault and StateDefault.
+	Events        map[event.Type]int
+	EventsDefault int
+	StateDefault  int
+
+	Users        map[matrix.UserID]int
+	UsersDefault int
+
+	// raw is the original content, which is kept so that fields unknown to
+	// us aren't lost when the power levels are sent back.
+	raw map[string]json.RawMessage
+}
+
+// powerLevelsContent is the content of m.room.power_levels. Unlike
+// event.RoomPowerLevelsEvent, it can tell missing fields from zero values.
+type powerLevelsContent struct {
+	Ban           *int                  `json:"ban"`
+	Invite        *int                  `json:"invite"`
+	Kick          *int                  `json:"kick"`
+	Redact        *int                  `json:"redact"`
+	Events        map[event.Type]int    `json:"events"`
+	EventsDefault *int                  `json:"events_default"`
+	StateDefault  *int                  `json:"state_default"`
+	Users         map[matrix.UserID]int `json:"users"`
+	UsersDefault  *int                  `json:"users_default"`
+	Notifications struct {
+		Room *int `json:"room"`
+	} `json:"notifications"`
+}
+
+func intOr(v *int, or int) int {
+	if v != nil {
+		return *v
+	}
+	return or
+}
+
+// ParsePowerLevels parses the given m.room.power_levels event.
+func ParsePowerLevels(ev *event.RoomPowerLevelsEvent) (*PowerLevels, error) {
+	var raw struct {
+		Content json.RawMessage `json:"content"`
+	}
+
+	if err := json.Unmarshal(ev.Info().Raw, &raw); err != nil {
+		return nil, errors.Wrap(err, "invalid m.room.power_levels event")
+	}
+
+	return parsePowerLevelsContent(raw.Content)
+}
+
+func parsePowerLevelsContent(b json.RawMessage) (*PowerLevels, error) {
+	var content powerLevelsContent
+	if err := json.Unmarshal(b, &content); err != nil {
+		return nil, errors.Wrap(err, "invalid m.room.power_levels content")
+	}
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, errors.Wrap(err, "invalid m.room.power_levels content")
+	}
+
+	p := PowerLevels{
+		Ban:           intOr(content.Ban, 50),
+		Invite:        intOr(content.Invite, 0),
+		Kick:          intOr(content.Kick, 50),
+		Redact:        intOr(content.Redact, 50),
+		NotifyRoom:    intOr(content.Notifications.Room, 50),
+		Events:        content.Events,
+		EventsDefault: intOr(content.EventsDefault, 0),
+		StateDefault:  intOr(content.StateDefault, 50),
+		Users:         content.Users,
+		UsersDefault:  intOr(content.UsersDefault, 0),
+		raw:           raw,
+	}
+
+	if p.Events == nil {
+		p.Events = make(map[event.Type]int)
+	}
+	if p.Users == nil {
+		p.Users = make(map[matrix.UserID]int)
+	}
+
+	return &p, nil
+}
+
+// UserLevel returns the power level of the given user.
+func (p *PowerLevels) UserLevel(userID matrix.UserID) int {
+	if level, ok := p.Users[userID]; ok {
+		return level
+	}
+	return p.UsersDefault
+}
+
+// ActionLevel returns the power level required to do the given action.
+// Unknown actions require the power level of an administrator.
+func (p *PowerLevels) ActionLevel(action PowerAction) int {
+	switch action {
+	case BanAction:
+		return p.Ban
+	case InviteAction:
+		return p.Invite
+	case KickAction:
+		return p.Kick
+	case RedactAction:
+		return p.Redact
+	case NotifyRoomAction:
+		return p.NotifyRoom
+	}
+
+	typ, state, ok := action.EventType()
+	if !ok {
+		return 100
+	}
+
+	if level, ok := p.Events[typ]; ok {
+		return level
+	}
+
+	if state {
+		return p.StateDefault
+	}
+	return p.EventsDefault
+}
+
+// Can returns true if the given user can do the given action.
+func (p *PowerLevels) Can(userID matrix.UserID, action PowerAction) bool {
+	return p.UserLevel(userID) >= p.ActionLevel(action)
+}
+
+// Content returns the event content of the power levels. Fields that
+// PowerLevels doesn't know about are kept as-is.
+func (p *PowerLevels) Content() map[string]json.RawMessage {
+	content := make(map[string]json.RawMessage, len(p.raw)+10)
+	for k, v := range p.raw {
+		content[k] = v
+	}
+
+	set := func(k string, v interface{}) {
+		b, err := json.Marshal(v)
+		if err != nil {
+			panic("cannot marshal power level: " + err.Error())
+		}
+		content[k] = b
+	}
+
+	set("ban", p.Ban)
+	set("invite", p.Invite)
+	set("kick", p.Kick)
+	set("redact", p.Redact)
+	set("events", p.Events)
+	set("events_default", p.EventsDefault)
+	set("state_default", p.StateDefault)
+	set("users", p.Users)
+	set("users_default", p.UsersDefault)
+
+	var notifications map[string]json.RawMessage
+	json.Unmarshal(content["notifications"], &notifications)
+	if notifications == nil {
+		notifications = make(map[string]json.RawMessage, 1)
+	}
+	notifications["room"], _ = json.Marshal(p.NotifyRoom)
+	set("notifications", notifications)
+
+	return content
+}
+
+// RoomPowerLevels returns the power levels of the given room.
+func (c *Client) RoomPowerLevels(roomID matrix.RoomID) (*PowerLevels, error) {
+	e, err := c.RoomState(roomID, event.TypeRoomPowerLevels, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return ParsePowerLevels(e.(*event.RoomPowerLevelsEvent))
+}
+
+// SetRoomPowerLevels replaces the power levels of the given room.
+func (c *Client) SetRoomPowerLevels(roomID matrix.RoomID, p *PowerLevels) error {
+	return c.SetRoomState(roomID, event.TypeRoomPowerLevels, "", p.Content())
+}
+
+// SetRoomState sends a state event with the given content into the room.
+func (c *Client) SetRoomState(
+	roomID matrix.RoomID, typ event.Type, key string, content interface{}) error {
+
+	_, err := c.RoomStateSend(roomID, api.RoomStateSendArg{
+		Type:     typ,
+		StateKey: key,
+		Content:  content,
+	})
+	if err != nil {
+		return errors.Wrapf(err, "failed to set %s", typ)
+	}
+
+	return nil
+}
+
+// HasPower checks if the current user can perform the given action inside the
+// given room.
+func (c *Client) HasPower(roomID matrix.RoomID, action PowerAction) bool {
+	p, err := c.RoomPowerLevels(roomID)
+	if err != nil {
+		// Theoretically, this means we have the power to override the room's
+		// power levels to be whatever we want, but we'll play nice and pretend
+		// that we don't have the power to do that, because that's just stupid.
+		return false
+	}
+
+	if p.Can(c.UserID, action) {
+		return true
+	}
+
+	if c.IsRoomCreator(roomID) {
+		// User made this room, so they have full power.
+		return true
+	}
+
+	return false
+}
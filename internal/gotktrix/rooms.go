@@ -0,0 +1,250 @@
+package gotktrix
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/diamondburned/gotktrix/internal/gotktrix/events/m"
+	"github.com/diamondburned/gotktrix/internal/gotktrix/internal/e2ee"
+	"github.com/diamondburned/gotrix/api"
+	"github.com/diamondburned/gotrix/api/httputil"
+	"github.com/diamondburned/gotrix/event"
+	"github.com/diamondburned/gotrix/matrix"
+	"github.com/pkg/errors"
+)
+
+// CreateRoomArg is the argument to CreateRoom.
+type CreateRoomArg struct {
+	Name  string
+	Topic string
+	// AliasName is the local part of the room alias, or an empty string if the
+	// room should not have an alias.
+	AliasName string
+	// Public, if true, publishes the room into the server's room directory
+	// and lets anyone join it.
+	Public bool
+	// Encrypted, if true, enables end-to-end encryption in the new room.
+	Encrypted bool
+	// Invite is the list of users to invite into the new room.
+	Invite []matrix.UserID
+	// Direct, if true, makes the room a direct messaging room with the
+	// invited users. Public is ignored.
+	Direct bool
+}
+
+// initialState is a state event in the initial_state field of /createRoom.
+type initialState struct {
+	Type     event.Type  `json:"type"`
+	StateKey string      `json:"state_key"`
+	Content  interface{} `json:"content"`
+}
+
+// CreateRoom creates a new room and returns its ID.
+func (c *Client) CreateRoom(arg CreateRoomArg) (matrix.RoomID, error) {
+	var request struct {
+		api.RoomCreateArg
+		// InitialState shadows the field in api.RoomCreateArg, which cannot
+		// be marshaled properly.
+		InitialState []initialState `json:"initial_state,omitempty"`
+	}
+
+	request.Name = arg.Name
+	request.Topic = arg.Topic
+	request.AliasName = arg.AliasName
+	request.Invite = arg.Invite
+
+	switch {
+	case arg.Direct:
+		request.Visibility = api.RoomPrivate
+		request.Preset = api.PresetTrustedPrivateChat
+		request.IsDirectMessage = true
+	case arg.Public:
+		request.Visibility = api.RoomPublic
+		request.Preset = api.PresetPublicChat
+	default:
+		request.Visibility = api.RoomPrivate
+		request.Preset = api.PresetPrivateChat
+	}
+
+	if arg.Encrypted {
+		request.InitialState = append(request.InitialState, initialState{
+			Type:    m.EncryptionEventType,
+			Content: m.EncryptionEvent{Algorithm: e2ee.MegolmAlgorithm},
+		})
+	}
+
+	var resp struct {
+		RoomID matrix.RoomID `json:"room_id"`
+	}
+
+	err := c.Request(
+		"POST", c.Endpoints.RoomCreate(), &resp,
+		httputil.WithToken(), httputil.WithJSONBody(request),
+	)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create room")
+	}
+
+	return resp.RoomID, nil
+}
+
+// JoinRoom joins the room with the given ID or alias. The given servers are
+// used to find the room if the homeserver isn't in it yet. The ID of the
+// joined room is returned.
+func (c *Client) JoinRoom(idOrAlias string, servers []string) (matrix.RoomID, error) {
+	var resp struct {
+		RoomID matrix.RoomID `json:"room_id"`
+	}
+
+	// https://spec.matrix.org/v1.4/client-server-api/#post_matrixclientv3joinroomidoralias
+	err := c.Request(
+		"POST", c.Endpoints.Base()+"/join/"+url.PathEscape(idOrAlias), &resp,
+		httputil.WithToken(),
+		httputil.WithJSONBody(struct{}{}),
+		httputil.WithFullQuery(map[string][]string{"server_name": servers}),
+	)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to join %q", idOrAlias)
+	}
+
+	return resp.RoomID, nil
+}
+
+// RoomLink is a reference to a room, parsed from a room ID, a room alias or a
+// matrix.to link.
+type RoomLink struct {
+	// IDOrAlias is either a room ID or a room alias.
+	IDOrAlias string
+	// Via is the list of servers that the room can be found through.
+	Via []string
+}
+
+// IsAlias returns true if the link points to a room alias.
+func (l RoomLink) IsAlias() bool {
+	return strings.HasPrefix(l.IDOrAlias, "#")
+}
+
+// ParseRoomLink parses the given room ID, room alias, matrix.to link or
+// matrix: URI.
+func ParseRoomLink(str string) (RoomLink, error) {
+	str = strings.TrimSpace(str)
+
+	switch {
+	case strings.HasPrefix(str, "https://matrix.to/#/"):
+		return parseMatrixTo(strings.TrimPrefix(str, "https://matrix.to/#/"))
+	case strings.HasPrefix(str, "matrix:"):
+		return parseMatrixURI(strings.TrimPrefix(str, "matrix:"))
+	case strings.HasPrefix(str, "#"), strings.HasPrefix(str, "!"):
+		if !strings.Contains(str, ":") {
+			return RoomLink{}, errors.Errorf("%q is missing the server name", str)
+		}
+		return RoomLink{IDOrAlias: str}, nil
+	default:
+		return RoomLink{}, errors.Errorf("%q is not a room ID, alias or link", str)
+	}
+}
+
+// parseMatrixTo parses the fragment of a matrix.to link, which looks like
+// "#alias:server?via=server".
+func parseMatrixTo(fragment string) (RoomLink, error) {
+	path, query := fragment, ""
+	if i := strings.IndexByte(fragment, '?'); i != -1 {
+		path, query = fragment[:i], fragment[i+1:]
+	}
+
+	// Event links have the event ID after the room.
+	if i := strings.IndexByte(path, '/'); i != -1 {
+		path = path[:i]
+	}
+
+	id, err := url.PathUnescape(path)
+	if err != nil {
+		return RoomLink{}, errors.Wrap(err, "invalid matrix.to link")
+	}
+
+	if !strings.HasPrefix(id, "#") && !strings.HasPrefix(id, "!") {
+		return RoomLink{}, errors.Errorf("matrix.to link does not point to a room")
+	}
+
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return RoomLink{}, errors.Wrap(err, "invalid matrix.to query")
+	}
+
+	return RoomLink{IDOrAlias: id, Via: values["via"]}, nil
+}
+
+// parseMatrixURI parses a matrix: URI without the scheme, which looks like
+// "r/alias:server" or "roomid/id:server?via=server".
+func parseMatrixURI(uri string) (RoomLink, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return RoomLink{}, errors.Wrap(err, "invalid matrix URI")
+	}
+
+	parts := strings.SplitN(u.Path, "/", 3)
+	if len(parts) < 2 {
+		return RoomLink{}, errors.New("matrix URI does not point to a room")
+	}
+
+	link := RoomLink{Via: u.Query()["via"]}
+
+	switch parts[0] {
+	case "r":
+		link.IDOrAlias = "#" + parts[1]
+	case "roomid":
+		link.IDOrAlias = "!" + parts[1]
+	default:
+		return RoomLink{}, errors.New("matrix URI does not point to a room")
+	}
+
+	return link, nil
+}
+
+// DirectoryRooms is a page of rooms in a room directory.
+type DirectoryRooms struct {
+	Rooms []api.PublicRoom
+	// Next is the token to fetch the next page with, or an empty string if
+	// this is the last page.
+	Next string
+}
+
+// SearchDirectory searches the public room directory of the given server. If
+// server is empty, then the user's homeserver is used. If term is empty, then
+// all rooms are listed. Since is the Next token of the previous page.
+func (c *Client) SearchDirectory(server, term, since string, limit int) (DirectoryRooms, error) {
+	query := map[string]string{}
+	if server != "" {
+		query["server"] = server
+	}
+
+	var body struct {
+		Limit  int    `json:"limit,omitempty"`
+		Since  string `json:"since,omitempty"`
+		Filter struct {
+			Term string `json:"generic_search_term,omitempty"`
+		} `json:"filter"`
+	}
+	body.Limit = limit
+	body.Since = since
+	body.Filter.Term = term
+
+	var resp api.PublicRoomsResponse
+
+	// gotrix's PublicRoomsSearch doesn't send the access token, which
+	// homeservers require for this endpoint.
+	err := c.Request(
+		"POST", c.Endpoints.PublicRooms(), &resp,
+		httputil.WithToken(), httputil.WithQuery(query), httputil.WithJSONBody(body),
+	)
+	if err != nil {
+		return DirectoryRooms{}, errors.Wrap(err, "failed to search room directory")
+	}
+
+	rooms := DirectoryRooms{Rooms: resp.Chunk}
+	if resp.NextBatch != nil {
+		rooms.Next = *resp.NextBatch
+	}
+
+	return rooms, nil
+}
@@ -0,0 +1,204 @@
+package gotktrix
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/diamondburned/gotrix"
+	"github.com/diamondburned/gotrix/api/httputil"
+	"github.com/diamondburned/gotrix/matrix"
+	"github.com/pkg/errors"
+)
+
+// refreshEarly is how long before the access token expires that it's
+// refreshed.
+const refreshEarly = 30 * time.Second
+
+// Session describes the tokens that authenticate the client.
+type Session struct {
+	AccessToken string
+	// RefreshToken is empty if the homeserver didn't give one, in which case
+	// the access token never expires.
+	RefreshToken string
+	// Expiry is the time that the access token expires, or the zero time if it
+	// doesn't.
+	Expiry time.Time
+}
+
+// expiryFromMillis returns the expiry time of a token that expires in the
+// given milliseconds.
+func expiryFromMillis(ms int64) time.Time {
+	if ms <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(time.Duration(ms) * time.Millisecond)
+}
+
+// tokenSession keeps the client's Session and refreshes it. It implements
+// httptrick.TokenSource, so every authenticated request uses the latest access
+// token, even ones made by copies of the client.
+type tokenSession struct {
+	client *gotrix.Client
+
+	mu        sync.Mutex
+	session   Session
+	onRefresh []func(Session)
+}
+
+func newTokenSession(client *gotrix.Client) *tokenSession {
+	return &tokenSession{
+		client:  client,
+		session: Session{AccessToken: client.AccessToken},
+	}
+}
+
+func (s *tokenSession) get() Session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.session
+}
+
+func (s *tokenSession) set(session Session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.session = session
+}
+
+// Token implements httptrick.TokenSource.
+func (s *tokenSession) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.session.RefreshToken == "" || s.session.Expiry.IsZero() {
+		return s.session.AccessToken, nil
+	}
+
+	if time.Until(s.session.Expiry) > refreshEarly {
+		return s.session.AccessToken, nil
+	}
+
+	if err := s.refresh(ctx); err != nil {
+		// Try the old token anyway; the homeserver will tell us if it's
+		// really expired.
+		return s.session.AccessToken, err
+	}
+
+	return s.session.AccessToken, nil
+}
+
+// Refresh implements httptrick.TokenSource.
+func (s *tokenSession) Refresh(ctx context.Context, old string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.session.AccessToken != old {
+		// Someone else already refreshed the token.
+		return s.session.AccessToken, nil
+	}
+
+	if s.session.RefreshToken == "" {
+		return "", errors.New("the homeserver did not give a refresh token")
+	}
+
+	if err := s.refresh(ctx); err != nil {
+		return "", err
+	}
+
+	return s.session.AccessToken, nil
+}
+
+// refresh refreshes the access token. s.mu must be held, so that only one
+// refresh happens at a time: the homeserver may invalidate the old refresh
+// token.
+func (s *tokenSession) refresh(ctx context.Context) error {
+	var resp struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+		ExpiresInMs  int64  `json:"expires_in_ms"`
+	}
+
+	request := map[string]string{"refresh_token": s.session.RefreshToken}
+
+	// This request isn't authenticated, so it won't recurse into the
+	// interceptor.
+	err := s.client.WithContext(ctx).Request(
+		"POST", "_matrix/client/v3/refresh", &resp,
+		httputil.WithJSONBody(request),
+	)
+	if err != nil {
+		return errors.Wrap(err, "failed to refresh access token")
+	}
+
+	s.session.AccessToken = resp.AccessToken
+	s.session.Expiry = expiryFromMillis(resp.ExpiresInMs)
+	// The homeserver may keep using the old refresh token.
+	if resp.RefreshToken != "" {
+		s.session.RefreshToken = resp.RefreshToken
+	}
+
+	session := s.session
+	for _, f := range s.onRefresh {
+		f(session)
+	}
+
+	return nil
+}
+
+// Session returns the current tokens of the client.
+func (c *Client) Session() Session {
+	return c.session.get()
+}
+
+// UseRefreshToken sets the refresh token of the client and the expiry time of
+// its access token. It should be called right after the client is created from
+// a stored session.
+func (c *Client) UseRefreshToken(refreshToken string, expiry time.Time) {
+	session := c.session.get()
+	session.RefreshToken = refreshToken
+	session.Expiry = expiry
+	c.session.set(session)
+}
+
+// OnSessionRefresh adds f to be called every time the access token is
+// refreshed. The new session should be stored, since the old refresh token may
+// no longer work. f is called in the goroutine that made the request; it must
+// not make any requests using the client.
+func (c *Client) OnSessionRefresh(f func(Session)) {
+	c.session.mu.Lock()
+	defer c.session.mu.Unlock()
+
+	c.session.onRefresh = append(c.session.onRefresh, f)
+}
+
+// Logout invalidates the client's access token on the homeserver, closes the
+// client and deletes all of the account's local data. The client must not be
+// used afterwards.
+func (c *Client) Logout() error {
+	err := c.Client.Logout()
+	if err != nil && matrix.StatusCode(err) != http.StatusUnauthorized {
+		// A 401 means that the token is already invalid, which is what we
+		// want anyway.
+		return errors.Wrap(err, "failed to log out")
+	}
+
+	if err := c.Close(); err != nil {
+		log.Println("failed to close client while logging out:", err)
+	}
+
+	// The device is gone, so its keys are of no use either.
+	b64Username := Base64UserID(c.UserID)
+	for _, name := range []string{"matrix-state", "matrix-index", "matrix-crypto"} {
+		path := c.configPath.ConfigPath(name, b64Username)
+		if err := os.RemoveAll(path); err != nil {
+			return errors.Wrapf(err, "failed to delete %s", name)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,125 @@
+package gotktrix
+
+import (
+	"context"
+	"log"
+
+	"github.com/diamondburned/gotktrix/internal/gotktrix/internal/slidingsync"
+	"github.com/diamondburned/gotrix/api"
+	"github.com/diamondburned/gotrix/matrix"
+	"github.com/pkg/errors"
+)
+
+// SetSlidingSync sets whether the client syncs using sliding sync (MSC3575)
+// instead of the regular sync. The homeserver, or a proxy in front of it, must
+// support it; otherwise, the regular sync is used. It takes effect the next
+// time the client is opened.
+func (c *Client) SetSlidingSync(sliding bool) {
+	c.loop.mu.Lock()
+	c.loop.sliding = sliding
+	c.loop.mu.Unlock()
+}
+
+// SetVisibleRooms sets the rooms that are visible in the room list. Sliding
+// sync keeps them up to date even if they're not among the latest rooms. It
+// does nothing using the regular sync.
+func (c *Client) SetVisibleRooms(roomIDs []matrix.RoomID) {
+	c.slider.SetVisibleRooms(roomIDs)
+}
+
+// WatchRoom tells sliding sync that the room is opened, so that its whole
+// state and more of its timeline are synced. Call the returned function once
+// the room is closed. It does nothing using the regular sync.
+func (c *Client) WatchRoom(roomID matrix.RoomID) (unwatch func()) {
+	return c.slider.OpenRoom(roomID)
+}
+
+// slidingSink adds a sliding sync response into the state and handlers the
+// same way as a regular sync response.
+func (c *Client) slidingSink(sync *api.SyncResponse) error {
+	// The state saves the next batch of every sync, so keep the one of the
+	// regular sync for when it's used again.
+	sync.NextBatch, _ = c.State.NextBatch()
+
+	return c.Client.State.AddEvents(sync)
+}
+
+// slidingOptions returns the sliding sync options of the client's profile.
+func (c *Client) slidingOptions() slidingsync.Options {
+	c.loop.mu.Lock()
+	profile := c.loop.profile
+	c.loop.mu.Unlock()
+
+	filter := SyncFilter(profile, nil)
+	since, _ := c.State.ToDeviceSince()
+
+	return slidingsync.Options{
+		UserID:        c.UserID,
+		TimelineLimit: filter.Room.Timeline.Limit,
+		NoTyping:      profile == LowBandwidthSync,
+		Counts: func(roomID matrix.RoomID) slidingsync.RoomCounts {
+			unread := c.State.RoomNotificationCount(roomID)
+			summary, _ := c.State.RoomSummary(roomID)
+
+			return slidingsync.RoomCounts{
+				Notification: unread.Notification,
+				Highlight:    unread.Highlight,
+				Joined:       summary.JoinedCount,
+				Invited:      summary.InvitedCount,
+			}
+		},
+		ToDeviceSince: since,
+		SaveToDeviceSince: func(since string) {
+			if err := c.State.SetToDeviceSince(since); err != nil {
+				log.Println("cannot save to-device since:", err)
+			}
+		},
+		MinBackoffTime: SyncOptions.MinBackoffTime,
+		MaxBackoffTime: SyncOptions.MaxBackoffTime,
+	}
+}
+
+// openSliding runs the sliding sync loop in the background until the client is
+// closed. The regular sync loop is opened instead if the homeserver doesn't
+// support sliding sync.
+func (c *Client) openSliding(next string) {
+	stop := make(chan struct{})
+	done := make(chan struct{})
+
+	c.loop.mu.Lock()
+	c.loop.stop = stop
+	c.loop.done = done
+	c.loop.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-stop
+		cancel()
+	}()
+
+	go func() {
+		defer close(done)
+
+		err := c.slider.Run(ctx, c.Client.Client.Client, c.slidingOptions())
+		if !errors.Is(err, slidingsync.ErrUnsupported) {
+			return
+		}
+
+		log.Println("homeserver does not support sliding sync, using the regular sync")
+
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		if err := c.Client.OpenWithNext(next); err != nil {
+			log.Println("cannot open sync loop:", err)
+			return
+		}
+
+		c.loop.mu.Lock()
+		c.loop.opened = true
+		c.loop.mu.Unlock()
+	}()
+}
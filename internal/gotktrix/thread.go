@@ -0,0 +1,84 @@
+package gotktrix
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+
+	"github.com/diamondburned/gotktrix/internal/gotktrix/events/m"
+	"github.com/diamondburned/gotktrix/internal/gotktrix/events/sys"
+	"github.com/diamondburned/gotrix/api/httputil"
+	"github.com/diamondburned/gotrix/event"
+	"github.com/diamondburned/gotrix/matrix"
+	"github.com/pkg/errors"
+)
+
+// ThreadPaginator fetches the events of a thread from the homeserver, newest
+// first.
+type ThreadPaginator struct {
+	c      *Client
+	roomID matrix.RoomID
+	rootID matrix.EventID
+	limit  int
+
+	nextBatch string
+	onTop     bool
+}
+
+// ThreadPaginator returns a new paginator for the thread with the given root.
+func (c *Client) ThreadPaginator(roomID matrix.RoomID, rootID matrix.EventID, limit int) *ThreadPaginator {
+	return &ThreadPaginator{
+		c:      c,
+		roomID: roomID,
+		rootID: rootID,
+		limit:  limit,
+	}
+}
+
+// Paginate fetches the next older batch of events in the thread. The returned
+// events are ordered latest last, like RoomPaginator. Nil is returned once
+// there are no more events.
+func (p *ThreadPaginator) Paginate(ctx context.Context) ([]event.RoomEvent, error) {
+	if p.onTop {
+		return nil, nil
+	}
+
+	query := map[string]string{
+		"dir":   "b",
+		"limit": strconv.Itoa(p.limit),
+	}
+	if p.nextBatch != "" {
+		query["from"] = p.nextBatch
+	}
+
+	var resp struct {
+		Chunk     []event.RawEvent `json:"chunk"`
+		NextBatch string           `json:"next_batch"`
+	}
+
+	// https://spec.matrix.org/v1.4/client-server-api/#get_matrixclientv1roomsroomidrelationseventidreltype
+	endpoint := "_matrix/client/v1/rooms/" + url.PathEscape(string(p.roomID)) +
+		"/relations/" + url.PathEscape(string(p.rootID)) + "/" + m.ThreadRelType
+
+	err := p.c.WithContext(ctx).Request(
+		"GET", endpoint, &resp,
+		httputil.WithToken(), httputil.WithQuery(query),
+	)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to query thread %q", p.rootID)
+	}
+
+	p.nextBatch = resp.NextBatch
+	p.onTop = resp.NextBatch == ""
+
+	// Flip the events so that the latest one is last.
+	for i, j := 0, len(resp.Chunk)-1; i < j; i, j = i+1, j-1 {
+		resp.Chunk[i], resp.Chunk[j] = resp.Chunk[j], resp.Chunk[i]
+	}
+
+	events := sys.ParseAllTimeline(resp.Chunk, p.roomID)
+	p.c.decryptEvents(p.roomID, events)
+	p.c.indexEvents(events)
+
+	return events, nil
+}
@@ -0,0 +1,367 @@
+package gotktrix
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/diamondburned/gotktrix/internal/gotktrix/events/sys"
+	"github.com/diamondburned/gotktrix/internal/gotktrix/internal/state"
+	"github.com/diamondburned/gotrix/api"
+	"github.com/diamondburned/gotrix/api/httputil"
+	"github.com/diamondburned/gotrix/event"
+	"github.com/diamondburned/gotrix/matrix"
+	"github.com/pkg/errors"
+)
+
+// Retention is a policy of which timeline events are kept in the state
+// database. The zero value keeps every event.
+type Retention = state.Retention
+
+var (
+	// ParseRetention parses a retention policy, which is either "unlimited", a
+	// number of events such as "500" or a number of days such as "30d".
+	ParseRetention = state.ParseRetention
+	// ParseRetentionOverrides parses a list of retention policies for rooms
+	// or room tags, such as "m.favourite = unlimited, !room:example.com = 30d".
+	ParseRetentionOverrides = state.ParseRetentionOverrides
+)
+
+// DefaultRetention is the default retention policy of rooms.
+var DefaultRetention = Retention{Events: 1000}
+
+// RetentionPolicy is the retention policy of all rooms.
+type RetentionPolicy struct {
+	// Default is the policy of rooms that aren't overridden.
+	Default Retention
+	// Overrides maps room IDs and room tag names to their own policies. Room
+	// IDs take precedence over tags.
+	Overrides map[string]Retention
+}
+
+const (
+	// compactInterval is the interval between timeline compactions.
+	compactInterval = time.Hour
+	// compactDelay is the delay before compacting after the client is opened
+	// or the retention policy is changed.
+	compactDelay = time.Minute
+)
+
+// compactor periodically drops the timeline events that the retention policy
+// doesn't keep.
+type compactor struct {
+	mu     sync.Mutex
+	policy RetentionPolicy
+	wake   chan struct{}
+	stop   chan struct{} // nil if not started
+}
+
+func newCompactor() *compactor {
+	return &compactor{
+		policy: RetentionPolicy{Default: DefaultRetention},
+		wake:   make(chan struct{}, 1),
+	}
+}
+
+// SetRetention sets the retention policy of the stored timelines. The
+// timelines are compacted in the background.
+func (c *Client) SetRetention(policy RetentionPolicy) {
+	c.compactor.mu.Lock()
+	c.compactor.policy = policy
+	c.compactor.mu.Unlock()
+
+	select {
+	case c.compactor.wake <- struct{}{}:
+	default:
+	}
+}
+
+// RoomRetention returns the retention policy of the given room.
+func (c *Client) RoomRetention(roomID matrix.RoomID) Retention {
+	c.compactor.mu.Lock()
+	policy := c.compactor.policy
+	c.compactor.mu.Unlock()
+
+	return policy.roomRetention(c.State, roomID)
+}
+
+func (p RetentionPolicy) roomRetention(s *state.State, roomID matrix.RoomID) Retention {
+	if r, ok := p.Overrides[string(roomID)]; ok {
+		return r
+	}
+
+	if len(p.Overrides) > 0 {
+		if e, err := s.RoomEvent(roomID, event.TypeTag); err == nil {
+			tags := make([]string, 0, len(e.(*event.TagEvent).Tags))
+			for tag := range e.(*event.TagEvent).Tags {
+				tags = append(tags, string(tag))
+			}
+			// Use the first tag in order, so that the policy doesn't change
+			// randomly if the room has more than one.
+			sort.Strings(tags)
+
+			for _, tag := range tags {
+				if r, ok := p.Overrides[tag]; ok {
+					return r
+				}
+			}
+		}
+	}
+
+	return p.Default
+}
+
+func (c *Client) startCompactor() {
+	c.compactor.mu.Lock()
+	defer c.compactor.mu.Unlock()
+
+	if c.compactor.stop != nil {
+		return
+	}
+
+	stop := make(chan struct{})
+	c.compactor.stop = stop
+
+	go func() {
+		// Wait a bit so that the startup isn't slowed down.
+		timer := time.NewTimer(compactDelay)
+		defer timer.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-c.compactor.wake:
+				// Wait for the policy to settle down.
+				if !timer.Stop() {
+					<-timer.C
+				}
+				timer.Reset(compactDelay)
+				continue
+			case <-timer.C:
+			}
+
+			c.compactTimelines()
+			timer.Reset(compactInterval)
+		}
+	}()
+}
+
+func (c *Client) stopCompactor() {
+	c.compactor.mu.Lock()
+	defer c.compactor.mu.Unlock()
+
+	if c.compactor.stop != nil {
+		close(c.compactor.stop)
+		c.compactor.stop = nil
+	}
+}
+
+// compactTimelines compacts the timelines of all rooms.
+func (c *Client) compactTimelines() {
+	roomIDs, err := c.State.Rooms()
+	if err != nil {
+		log.Println("cannot get rooms to compact:", err)
+		return
+	}
+
+	now := time.Now()
+	var dropped int
+
+	for _, roomID := range roomIDs {
+		n, err := c.State.CompactTimeline(roomID, c.RoomRetention(roomID), now)
+		if err != nil {
+			log.Println("cannot compact timeline:", err)
+			continue
+		}
+		dropped += n
+	}
+
+	if dropped > 0 {
+		log.Printf("compacted timelines: dropped %d events from %d rooms", dropped, len(roomIDs))
+	}
+}
+
+// RoomPaginator is used to fetch older messages. Messages are read from the
+// state database, and only the messages that are missing from it are fetched
+// from the homeserver.
+type RoomPaginator struct {
+	c      *Client
+	roomID matrix.RoomID
+	limit  int
+
+	// before is the key of the oldest returned event.
+	before string
+	// gap is the gap right before the oldest returned event, if any.
+	gap *state.TimelineGap
+	// skipped has all gaps that were skipped because the homeserver wasn't
+	// reachable, oldest last, and skipErr is why the last one was skipped.
+	skipped []state.TimelineGap
+	skipErr error
+	// strict is true if gaps are never skipped.
+	strict bool
+	// onTop is true if we're out of events.
+	onTop bool
+}
+
+// IncompleteHistoryError is returned by Paginate instead of running out of
+// messages if messages had to be skipped because the homeserver wasn't
+// reachable.
+type IncompleteHistoryError struct {
+	// Gaps is the number of places where messages are missing.
+	Gaps int
+	// Err is why the last of them couldn't be fetched.
+	Err error
+}
+
+// Error implements error.
+func (err *IncompleteHistoryError) Error() string {
+	return fmt.Sprintf("messages are missing in %d places: %v", err.Gaps, err.Err)
+}
+
+// Unwrap returns err.Err.
+func (err *IncompleteHistoryError) Unwrap() error {
+	return err.Err
+}
+
+// RoomPaginator returns a new paginator that can fetch messages from the bottom
+// up.
+func (c *Client) RoomPaginator(roomID matrix.RoomID, limit int) *RoomPaginator {
+	if limit < 1 {
+		log.Panicln("gotktrix: RoomPaginator limit must be non-zero")
+	}
+
+	return &RoomPaginator{
+		c:      c,
+		limit:  limit,
+		roomID: roomID,
+	}
+}
+
+// SetStrict sets whether the paginator is strict. A strict paginator returns
+// the error of fetching missing messages instead of skipping them, so the
+// messages that it returns never have holes in between.
+func (p *RoomPaginator) SetStrict(strict bool) {
+	p.strict = strict
+}
+
+// Paginate returns the next older messages, latest last. If the homeserver
+// isn't reachable, then the missing messages are skipped, so that the stored
+// messages can still be scrolled through offline. Once the oldest stored
+// message is reached, an *IncompleteHistoryError is returned instead of no
+// messages if any were skipped.
+func (p *RoomPaginator) Paginate(ctx context.Context) ([]event.RoomEvent, error) {
+	var events []event.RoomEvent
+
+	for !p.onTop && len(events) < p.limit {
+		if p.gap != nil {
+			gap, err := p.fill(ctx, *p.gap)
+			if err != nil {
+				if len(events) > 0 {
+					// Return what we have. The gap is filled on the next call.
+					break
+				}
+				if p.strict || !IsOffline(err) {
+					return nil, err
+				}
+				p.skipped = append(p.skipped, *p.gap)
+				p.skipErr = err
+			}
+
+			p.gap = gap
+			if gap != nil {
+				// The homeserver gave nothing, but there are more events.
+				continue
+			}
+		}
+
+		chunk, err := p.c.State.RoomTimelineBefore(p.roomID, p.before, p.limit-len(events))
+		if err != nil {
+			if len(events) > 0 {
+				break
+			}
+			return nil, errors.Wrap(err, "failed to get stored timeline")
+		}
+
+		if len(chunk.Events) == 0 {
+			if n := len(p.skipped); n > 0 {
+				if last := p.skipped[n-1]; last.After == p.before {
+					// The oldest stored event is right after the last skipped
+					// gap, so try filling it again on the next call.
+					p.gap = &last
+					p.skipped = p.skipped[:n-1]
+					if len(events) == 0 {
+						return nil, p.skipErr
+					}
+					break
+				}
+
+				// Don't pretend that this is the whole history, since the
+				// skipped gaps are still missing.
+				if len(events) == 0 {
+					return nil, &IncompleteHistoryError{Gaps: n, Err: p.skipErr}
+				}
+				break
+			}
+
+			p.onTop = true
+			break
+		}
+
+		p.before = chunk.Oldest
+		p.gap = chunk.Gap
+		events = append(chunk.Events, events...)
+	}
+
+	p.c.decryptEvents(p.roomID, events)
+	return events, nil
+}
+
+// fill fetches the events that are missing in the given gap from the homeserver
+// and stores them. The gap that still remains at the same place is returned.
+func (p *RoomPaginator) fill(ctx context.Context, gap state.TimelineGap) (*state.TimelineGap, error) {
+	client := p.c.WithContext(ctx)
+
+	token := gap.Token
+	if token == "" {
+		// The token was lost when the timeline was compacted, so get it from
+		// the context of the event.
+		// https://spec.matrix.org/v1.4/client-server-api/#get_matrixclientv3roomsroomidcontexteventid
+		var resp struct {
+			Start string `json:"start"`
+		}
+
+		err := client.Request(
+			"GET", client.Endpoints.Room(p.roomID)+"/context/"+url.PathEscape(string(gap.EventID())),
+			&resp,
+			httputil.WithToken(), httputil.WithQuery(map[string]string{"limit": "0"}),
+		)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to get context of event %q", gap.EventID())
+		}
+
+		token = resp.Start
+	}
+
+	// https://spec.matrix.org/v1.1/client-server-api/#get_matrixclientv3roomsroomidmessages
+	r, err := client.RoomMessages(p.roomID, api.RoomMessagesQuery{
+		From:      token,
+		Direction: api.RoomMessagesBackward,
+		Limit:     100,
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to query messages for room %q", p.roomID)
+	}
+
+	remaining := p.c.State.AddRoomMessages(p.roomID, gap, &r)
+
+	events := sys.ParseAllTimeline(r.Chunk, p.roomID)
+	p.c.decryptEvents(p.roomID, events)
+	p.c.indexEvents(events)
+
+	return remaining, nil
+}
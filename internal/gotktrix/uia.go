@@ -0,0 +1,327 @@
+package gotktrix
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sort"
+
+	"github.com/diamondburned/gotrix"
+	"github.com/diamondburned/gotrix/api/httputil"
+	"github.com/diamondburned/gotrix/matrix"
+	"github.com/pkg/errors"
+)
+
+// LoginTerms is the stage that asks the user to accept the homeserver's terms
+// and policies. gotrix doesn't have it.
+const LoginTerms matrix.LoginMethod = "m.login.terms"
+
+// AuthFlow is a list of stages that complete an InteractiveAuth.
+type AuthFlow struct {
+	Stages []matrix.LoginMethod `json:"stages"`
+}
+
+// AuthState is the state of an InteractiveAuth as given by the homeserver.
+type AuthState struct {
+	Flows     []AuthFlow                 `json:"flows"`
+	Params    map[string]json.RawMessage `json:"params"`
+	Session   string                     `json:"session"`
+	Completed []matrix.LoginMethod       `json:"completed"`
+	// Error and ErrorCode describe why the last stage failed, if it did.
+	Error     string           `json:"error"`
+	ErrorCode matrix.ErrorCode `json:"errcode"`
+}
+
+// InteractiveAuth is a user-interactive authentication session. It is returned
+// by endpoints that need the user to authenticate again, and the request is
+// only done once all stages of one of the flows are completed.
+type InteractiveAuth struct {
+	AuthState
+
+	c       *gotrix.Client
+	request func(auth, to interface{}) error
+	result  json.RawMessage
+	done    bool
+
+	// emailTokenPath is the endpoint that sends the verification email for
+	// m.login.email.identity. It depends on what is being authenticated.
+	emailTokenPath string
+	email          emailSession
+}
+
+type emailSession struct {
+	secret  string
+	address string
+	sid     string
+	attempt int
+}
+
+// newInteractiveAuth makes the request without authentication. If the
+// homeserver asks for authentication, then an InteractiveAuth is returned;
+// otherwise, nil is returned.
+func (c *Client) newInteractiveAuth(request func(auth, to interface{}) error) (*InteractiveAuth, error) {
+	ia := &InteractiveAuth{
+		c:       c.Client,
+		request: request,
+	}
+
+	if err := ia.Auth(nil); err != nil {
+		return nil, err
+	}
+
+	if ia.done {
+		return nil, nil
+	}
+
+	return ia, nil
+}
+
+// Done returns true if the authentication is completed and the request has
+// gone through.
+func (ia *InteractiveAuth) Done() bool {
+	return ia.done
+}
+
+// NextStage returns the next stage to be completed. The first flow that
+// matches the completed stages is used.
+func (ia *InteractiveAuth) NextStage() (matrix.LoginMethod, bool) {
+flows:
+	for _, flow := range ia.Flows {
+		if len(flow.Stages) <= len(ia.Completed) {
+			continue
+		}
+
+		for i, stage := range ia.Completed {
+			if flow.Stages[i] != stage {
+				continue flows
+			}
+		}
+
+		return flow.Stages[len(ia.Completed)], true
+	}
+
+	return "", false
+}
+
+// Auth submits the given authentication data for the current stage. The
+// session is added into auth. A nil error is returned if the homeserver only
+// rejected the stage, in which case Error describes why.
+func (ia *InteractiveAuth) Auth(auth map[string]interface{}) error {
+	if auth != nil && ia.Session != "" {
+		auth["session"] = ia.Session
+	}
+
+	var raw json.RawMessage
+
+	err := ia.request(auth, &raw)
+	if err == nil {
+		ia.done = true
+		ia.result = raw
+		return nil
+	}
+
+	if matrix.StatusCode(err) != http.StatusUnauthorized || len(raw) == 0 {
+		return err
+	}
+
+	var state AuthState
+	if err := json.Unmarshal(raw, &state); err != nil {
+		return errors.Wrap(err, "failed to decode authentication state")
+	}
+
+	if len(state.Flows) == 0 {
+		// Not an authentication challenge; the request really failed.
+		return err
+	}
+
+	ia.AuthState = state
+	return nil
+}
+
+// AuthPassword completes the m.login.password stage using the user's password.
+func (ia *InteractiveAuth) AuthPassword(password string) error {
+	return ia.Auth(map[string]interface{}{
+		"type": matrix.LoginPassword,
+		"identifier": matrix.Identifier{
+			Type: matrix.IdentifierUser,
+			User: string(ia.c.UserID),
+		},
+		"password": password,
+	})
+}
+
+// AuthDummy completes the m.login.dummy stage.
+func (ia *InteractiveAuth) AuthDummy() error {
+	return ia.Auth(map[string]interface{}{
+		"type": matrix.LoginDummy,
+	})
+}
+
+// AuthTerms completes the m.login.terms stage. The user must have accepted the
+// policies returned by Policies.
+func (ia *InteractiveAuth) AuthTerms() error {
+	return ia.Auth(map[string]interface{}{
+		"type": LoginTerms,
+	})
+}
+
+// FallbackURL returns the URL of the web page that completes the given stage.
+// It's used for stages that can't be done natively, such as m.login.recaptcha.
+// Call AuthFallback once the user is done with the page.
+func (ia *InteractiveAuth) FallbackURL(stage matrix.LoginMethod) string {
+	path := ia.c.Endpoints.Base() + "/auth/" + url.PathEscape(string(stage)) + "/fallback/web"
+	return ia.c.FullRoute(path) + "?session=" + url.QueryEscape(ia.Session)
+}
+
+// AuthFallback continues the authentication after a stage is completed using
+// its FallbackURL.
+func (ia *InteractiveAuth) AuthFallback() error {
+	return ia.Auth(map[string]interface{}{})
+}
+
+// CanVerifyEmail returns true if the m.login.email.identity stage can be done.
+func (ia *InteractiveAuth) CanVerifyEmail() bool {
+	return ia.emailTokenPath != ""
+}
+
+// RequestEmailToken asks the homeserver to send a verification email to the
+// given address for the m.login.email.identity stage. Calling it again with
+// the same address sends the email again. Call AuthEmail once the user has
+// clicked the link in the email.
+func (ia *InteractiveAuth) RequestEmailToken(address string) error {
+	if !ia.CanVerifyEmail() {
+		return errors.New("email verification is not supported here")
+	}
+
+	if ia.email.secret == "" || ia.email.address != address {
+		secret, err := newClientSecret()
+		if err != nil {
+			return err
+		}
+		ia.email = emailSession{secret: secret, address: address}
+	}
+
+	ia.email.attempt++
+
+	request := map[string]interface{}{
+		"client_secret": ia.email.secret,
+		"email":         address,
+		"send_attempt":  ia.email.attempt,
+	}
+
+	var resp struct {
+		SID string `json:"sid"`
+	}
+
+	err := ia.c.Request(
+		"POST", ia.emailTokenPath, &resp,
+		httputil.WithJSONBody(request),
+	)
+	if err != nil {
+		return errors.Wrap(err, "failed to send verification email")
+	}
+
+	ia.email.sid = resp.SID
+	return nil
+}
+
+// AuthEmail completes the m.login.email.identity stage. RequestEmailToken must
+// be called first.
+func (ia *InteractiveAuth) AuthEmail() error {
+	if ia.email.sid == "" {
+		return errors.New("no verification email was sent")
+	}
+
+	return ia.Auth(map[string]interface{}{
+		"type": matrix.LoginEmail,
+		"threepid_creds": map[string]string{
+			"sid":           ia.email.sid,
+			"client_secret": ia.email.secret,
+		},
+	})
+}
+
+// newClientSecret generates a client secret for requesting verification
+// tokens.
+func newClientSecret() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "failed to generate client secret")
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Policy is a policy that the user must accept in the m.login.terms stage.
+type Policy struct {
+	ID      string
+	Version string
+	Name    string
+	URL     string
+}
+
+// Policies returns the policies of the m.login.terms stage. The English
+// version of each policy is preferred.
+func (ia *InteractiveAuth) Policies() []Policy {
+	raw, ok := ia.Params[string(LoginTerms)]
+	if !ok {
+		return nil
+	}
+
+	var params struct {
+		Policies map[string]map[string]json.RawMessage `json:"policies"`
+	}
+
+	if err := json.Unmarshal(raw, &params); err != nil {
+		return nil
+	}
+
+	policies := make([]Policy, 0, len(params.Policies))
+
+	for id, fields := range params.Policies {
+		policy := Policy{ID: id}
+		json.Unmarshal(fields["version"], &policy.Version)
+
+		type translation struct {
+			Name string `json:"name"`
+			URL  string `json:"url"`
+		}
+
+		var chosen *translation
+
+		langs := make([]string, 0, len(fields))
+		for lang := range fields {
+			if lang != "version" {
+				langs = append(langs, lang)
+			}
+		}
+		sort.Strings(langs)
+
+		for _, lang := range langs {
+			var t translation
+			if err := json.Unmarshal(fields[lang], &t); err != nil {
+				continue
+			}
+			if chosen == nil || lang == "en" {
+				chosen = &t
+			}
+		}
+
+		if chosen != nil {
+			policy.Name = chosen.Name
+			policy.URL = chosen.URL
+		}
+		if policy.Name == "" {
+			policy.Name = id
+		}
+
+		policies = append(policies, policy)
+	}
+
+	sort.Slice(policies, func(i, j int) bool {
+		return policies[i].ID < policies[j].ID
+	})
+
+	return policies
+}
@@ -25,6 +25,7 @@ var Parser = parser.NewParser(
 		markutil.Prioritized(parser.NewEmphasisParser(), 2),
 		markutil.Prioritized(parser.NewCodeSpanParser(), 3),
 		markutil.Prioritized(parser.NewRawHTMLParser(), 4),
+		markutil.Prioritized(NewSpoilerParser(), 5),
 	),
 	parser.WithBlockParsers(
 		markutil.Prioritized(parser.NewParagraphParser(), 0),
@@ -47,6 +48,7 @@ var Converter = goldmark.New(
 		renderer.NewRenderer(
 			renderer.WithNodeRenderers(
 				markutil.Prioritized(Renderer, 1000),
+				markutil.Prioritized(NewSpoilerRenderer(), 500),
 			),
 		),
 	),
@@ -108,6 +110,16 @@ var TextTags = textutil.TextTagsMap{
 	"_emoji":     {"scale": EmojiScale},
 	"_image":     {"rise": -2 * pango.SCALE},
 	"_nohyphens": {"insert-hyphens": false},
+	"_spoiler": {
+		"foreground": "#808080",
+		"background": "#808080",
+	},
+	"_math": {
+		"family":         "Monospace",
+		"style":          pango.StyleItalic,
+		"foreground":     "#6C71C4",
+		"insert-hyphens": false,
+	},
 }
 
 func htag(scale float64) textutil.TextTag {
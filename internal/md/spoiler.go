@@ -0,0 +1,87 @@
+package md
+
+import (
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// KindSpoiler is the NodeKind of Spoiler.
+var KindSpoiler = ast.NewNodeKind("Spoiler")
+
+// Spoiler is an inline node of text wrapped in double pipes, such as
+// "||secret||". It is rendered as a span with the data-mx-spoiler attribute.
+type Spoiler struct {
+	ast.BaseInline
+}
+
+// Kind implements ast.Node.
+func (n *Spoiler) Kind() ast.NodeKind { return KindSpoiler }
+
+// Dump implements ast.Node.
+func (n *Spoiler) Dump(src []byte, level int) {
+	ast.DumpHelper(n, src, level, nil, nil)
+}
+
+type spoilerDelimiterProcessor struct{}
+
+func (spoilerDelimiterProcessor) IsDelimiter(b byte) bool { return b == '|' }
+
+func (spoilerDelimiterProcessor) CanOpenCloser(opener, closer *parser.Delimiter) bool {
+	return opener.Char == closer.Char
+}
+
+func (spoilerDelimiterProcessor) OnMatch(consumes int) ast.Node {
+	return &Spoiler{}
+}
+
+type spoilerParser struct{}
+
+// NewSpoilerParser returns a new inline parser that parses "||spoiler||".
+func NewSpoilerParser() parser.InlineParser {
+	return spoilerParser{}
+}
+
+func (spoilerParser) Trigger() []byte {
+	return []byte{'|'}
+}
+
+func (spoilerParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	before := block.PrecendingCharacter()
+	line, segment := block.PeekLine()
+
+	node := parser.ScanDelimiter(line, before, 2, spoilerDelimiterProcessor{})
+	if node == nil || node.OriginalLength != 2 {
+		return nil
+	}
+
+	node.Segment = segment.WithStop(segment.Start + node.OriginalLength)
+	block.Advance(node.OriginalLength)
+	pc.PushDelimiter(node)
+	return node
+}
+
+func (spoilerParser) CloseBlock(parent ast.Node, pc parser.Context) {}
+
+type spoilerRenderer struct{}
+
+// NewSpoilerRenderer returns a new renderer that renders Spoiler nodes into
+// Matrix spoiler spans.
+func NewSpoilerRenderer() renderer.NodeRenderer {
+	return spoilerRenderer{}
+}
+
+func (r spoilerRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(KindSpoiler, r.renderSpoiler)
+}
+
+func (spoilerRenderer) renderSpoiler(w util.BufWriter, src []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+	if entering {
+		w.WriteString("<span data-mx-spoiler>")
+	} else {
+		w.WriteString("</span>")
+	}
+	return ast.WalkContinue, nil
+}
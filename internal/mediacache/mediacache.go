@@ -0,0 +1,287 @@
+// Package mediacache provides a disk-backed cache of Matrix media. Files are
+// content-addressed by their mxc URL and thumbnail parameters, and the least
+// recently used files are evicted once the cache grows over its size limit.
+package mediacache
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// tmpPrefix is the prefix of files that are still being written.
+const tmpPrefix = ".tmp."
+
+// Cache is a disk-backed LRU cache of media files.
+type Cache struct {
+	dir string
+
+	mut     sync.Mutex
+	entries map[string]*list.Element // T = *entry
+	lru     list.List                // front is the most recently used
+	size    int64
+	maxSize int64
+}
+
+type entry struct {
+	name string
+	size int64
+}
+
+// New opens the cache in the given directory. The files that are already in
+// the directory are kept, with their modification time as their last use. A
+// maxSize of 0 or less disables the cache.
+func New(dir string, maxSize int64) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Wrap(err, "failed to make cache directory")
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read cache directory")
+	}
+
+	type fileInfo struct {
+		name    string
+		size    int64
+		modTime time.Time
+	}
+
+	infos := make([]fileInfo, 0, len(files))
+
+	for _, file := range files {
+		if strings.HasPrefix(file.Name(), tmpPrefix) {
+			// Leftover from a crash.
+			os.Remove(filepath.Join(dir, file.Name()))
+			continue
+		}
+
+		s, err := file.Info()
+		if err != nil || !s.Mode().IsRegular() {
+			continue
+		}
+
+		infos = append(infos, fileInfo{file.Name(), s.Size(), s.ModTime()})
+	}
+
+	// Sort the newest first.
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].modTime.After(infos[j].modTime)
+	})
+
+	c := &Cache{
+		dir:     dir,
+		entries: make(map[string]*list.Element, len(infos)),
+		maxSize: maxSize,
+	}
+
+	for _, info := range infos {
+		c.entries[info.name] = c.lru.PushBack(&entry{info.name, info.size})
+		c.size += info.size
+	}
+
+	c.mut.Lock()
+	c.evict()
+	c.mut.Unlock()
+
+	return c, nil
+}
+
+// Dir returns the directory of the cache.
+func (c *Cache) Dir() string {
+	return c.dir
+}
+
+// Size returns the total size of all cached files in bytes.
+func (c *Cache) Size() int64 {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	return c.size
+}
+
+// SetMaxSize sets the size limit of the cache in bytes. Files are evicted
+// right away if the cache is over the new limit.
+func (c *Cache) SetMaxSize(maxSize int64) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	c.maxSize = maxSize
+	c.evict()
+}
+
+// Clear deletes all cached files.
+func (c *Cache) Clear() error {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	var firstErr error
+
+	for name := range c.entries {
+		if err := os.Remove(filepath.Join(c.dir, name)); err != nil && !os.IsNotExist(err) {
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+
+	c.entries = make(map[string]*list.Element)
+	c.lru.Init()
+	c.size = 0
+
+	if firstErr != nil {
+		return errors.Wrap(firstErr, "failed to delete cached file")
+	}
+
+	return nil
+}
+
+// Open opens the cached file with the given key. False is returned if the file
+// isn't cached.
+func (c *Cache) Open(key string) (*os.File, bool) {
+	name := fileName(key)
+
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	elem, ok := c.entries[name]
+	if !ok {
+		return nil, false
+	}
+
+	path := filepath.Join(c.dir, name)
+
+	f, err := os.Open(path)
+	if err != nil {
+		// The file is gone from under us.
+		c.remove(elem)
+		return nil, false
+	}
+
+	c.lru.MoveToFront(elem)
+
+	// Keep the modification time as the last use for the next startup.
+	now := time.Now()
+	os.Chtimes(path, now, now)
+
+	return f, true
+}
+
+// Store stores everything read from r as the file with the given key.
+func (c *Cache) Store(key string, r io.Reader) error {
+	w, err := c.Writer(key)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(w, r); err != nil {
+		w.Discard()
+		return errors.Wrap(err, "failed to write cached file")
+	}
+
+	return w.Commit()
+}
+
+// Writer creates a new writer that writes the file with the given key. The
+// file is only added into the cache once Commit is called.
+func (c *Cache) Writer(key string) (*Writer, error) {
+	f, err := os.CreateTemp(c.dir, tmpPrefix+"*")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create cached file")
+	}
+
+	return &Writer{f: f, c: c, name: fileName(key)}, nil
+}
+
+// add adds the file with the given name and size into the cache, replacing
+// the existing one, if any. It evicts older files if needed.
+func (c *Cache) add(name string, size int64) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	if elem, ok := c.entries[name]; ok {
+		c.size -= elem.Value.(*entry).size
+		c.lru.Remove(elem)
+	}
+
+	c.entries[name] = c.lru.PushFront(&entry{name, size})
+	c.size += size
+
+	c.evict()
+}
+
+// evict deletes the least recently used files until the cache is under its
+// size limit. c.mut must be acquired.
+func (c *Cache) evict() {
+	for c.size > c.maxSize && c.lru.Len() > 0 {
+		elem := c.lru.Back()
+		name := elem.Value.(*entry).name
+
+		if err := os.Remove(filepath.Join(c.dir, name)); err != nil && !os.IsNotExist(err) {
+			log.Printf("failed to evict cached media %q: %v", name, err)
+		}
+
+		c.remove(elem)
+	}
+}
+
+// remove removes the given entry from the index. c.mut must be acquired.
+func (c *Cache) remove(elem *list.Element) {
+	e := elem.Value.(*entry)
+	c.size -= e.size
+	c.lru.Remove(elem)
+	delete(c.entries, e.name)
+}
+
+// fileName returns the name of the file with the given key.
+func fileName(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Writer writes a file into the cache.
+type Writer struct {
+	f    *os.File
+	c    *Cache
+	name string
+	size int64
+}
+
+// Write implements io.Writer.
+func (w *Writer) Write(b []byte) (int, error) {
+	n, err := w.f.Write(b)
+	w.size += int64(n)
+	return n, err
+}
+
+// Commit adds the written file into the cache.
+func (w *Writer) Commit() error {
+	if err := w.f.Close(); err != nil {
+		os.Remove(w.f.Name())
+		return errors.Wrap(err, "failed to close cached file")
+	}
+
+	if err := os.Rename(w.f.Name(), filepath.Join(w.c.dir, w.name)); err != nil {
+		os.Remove(w.f.Name())
+		return errors.Wrap(err, "failed to move cached file")
+	}
+
+	w.c.add(w.name, w.size)
+	return nil
+}
+
+// Discard throws away the written file.
+func (w *Writer) Discard() {
+	w.f.Close()
+	os.Remove(w.f.Name())
+}
@@ -0,0 +1,173 @@
+package mediacache
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestMediaKey(t *testing.T) {
+	tests := []struct {
+		url string
+		key string
+		ok  bool
+	}{
+		{
+			url: "https://example.com/_matrix/media/r0/download/example.com/abc",
+			key: "mxc://example.com/abc",
+			ok:  true,
+		},
+		{
+			url: "https://example.com/_matrix/media/v3/download/example.com/abc/cat.png?allow_remote=true",
+			key: "mxc://example.com/abc",
+			ok:  true,
+		},
+		{
+			url: "https://example.com/_matrix/client/v1/media/download/example.com/abc",
+			key: "mxc://example.com/abc",
+			ok:  true,
+		},
+		{
+			url: "https://example.com/_matrix/media/r0/thumbnail/example.com/abc?method=crop&height=32&width=32",
+			key: "mxc://example.com/abc?height=32&method=crop&width=32",
+			ok:  true,
+		},
+		{
+			url: "https://example.com/_matrix/media/r0/thumbnail/example.com/abc?width=32&height=32&method=crop",
+			key: "mxc://example.com/abc?height=32&method=crop&width=32",
+			ok:  true,
+		},
+		{
+			url: "https://example.com/_matrix/client/r0/sync",
+			ok:  false,
+		},
+		{
+			url: "https://example.com/_matrix/media/r0/config",
+			ok:  false,
+		},
+	}
+
+	for _, test := range tests {
+		u, err := url.Parse(test.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		key, ok := MediaKey(u)
+		if ok != test.ok || key != test.key {
+			t.Errorf("MediaKey(%q) = (%q, %v), expected (%q, %v)", test.url, key, ok, test.key, test.ok)
+		}
+	}
+}
+
+func TestCacheEviction(t *testing.T) {
+	c, err := New(t.TempDir(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := func(key, data string) {
+		if err := c.Store(key, strings.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	store("a", "aaaa")
+	store("b", "bbbb")
+
+	// Use a, so b becomes the least recently used.
+	f, ok := c.Open("a")
+	if !ok {
+		t.Fatal("a is not cached")
+	}
+	f.Close()
+
+	store("c", "cccc")
+
+	if _, ok := c.Open("b"); ok {
+		t.Error("b is not evicted")
+	}
+	for _, key := range []string{"a", "c"} {
+		f, ok := c.Open(key)
+		if !ok {
+			t.Errorf("%s is evicted", key)
+			continue
+		}
+		f.Close()
+	}
+
+	if size := c.Size(); size != 8 {
+		t.Errorf("cache size is %d, expected 8", size)
+	}
+
+	// Reopening the cache should keep the files.
+	c, err = New(c.Dir(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := c.Size(); size != 8 {
+		t.Errorf("reopened cache size is %d, expected 8", size)
+	}
+
+	if err := c.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.Open("a"); ok {
+		t.Error("a is still cached after Clear")
+	}
+}
+
+func TestTransport(t *testing.T) {
+	var hits int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		io.WriteString(w, "media "+r.URL.Path)
+	}))
+	defer srv.Close()
+
+	c, err := New(t.TempDir(), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := &http.Client{Transport: c.Transport(srv.Client().Transport)}
+
+	get := func(path string) string {
+		r, err := client.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Body.Close()
+
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+
+	const path = "/_matrix/media/r0/download/example.com/abc"
+
+	first := get(path)
+	second := get(path + "/file.txt")
+
+	if first != second {
+		t.Errorf("cached body %q differs from %q", second, first)
+	}
+	if hits != 1 {
+		t.Errorf("server is hit %d times, expected once", hits)
+	}
+
+	// Non-media requests are never cached.
+	get("/_matrix/client/r0/sync")
+	get("/_matrix/client/r0/sync")
+
+	if hits != 3 {
+		t.Errorf("server is hit %d times, expected 3 times", hits)
+	}
+}
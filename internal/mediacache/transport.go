@@ -0,0 +1,187 @@
+package mediacache
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// MediaKey returns the cache key of the given media URL. The key is the mxc
+// URL of the media, plus the parameters if the URL is of a thumbnail. False is
+// returned if the URL isn't a Matrix media URL.
+func MediaKey(u *url.URL) (string, bool) {
+	// Media URLs look like these:
+	//
+	//    /_matrix/media/{version}/download/{server}/{mediaID}[/{fileName}]
+	//    /_matrix/media/{version}/thumbnail/{server}/{mediaID}
+	//    /_matrix/client/v1/media/download/{server}/{mediaID}[/{fileName}]
+	//
+	path := strings.TrimPrefix(u.EscapedPath(), "/")
+	parts := strings.Split(path, "/")
+
+	switch {
+	case len(parts) >= 6 && parts[0] == "_matrix" && parts[1] == "media":
+		parts = parts[3:]
+	case len(parts) >= 7 && parts[0] == "_matrix" && parts[1] == "client" && parts[3] == "media":
+		parts = parts[4:]
+	default:
+		return "", false
+	}
+
+	server, err1 := url.PathUnescape(parts[1])
+	mediaID, err2 := url.PathUnescape(parts[2])
+	if err1 != nil || err2 != nil || server == "" || mediaID == "" {
+		return "", false
+	}
+
+	mxc := "mxc://" + server + "/" + mediaID
+
+	switch parts[0] {
+	case "download":
+		return mxc, true
+	case "thumbnail":
+		q := u.Query()
+		// Normalize the parameters, so that the order doesn't matter.
+		params := url.Values{
+			"width":  {q.Get("width")},
+			"height": {q.Get("height")},
+			"method": {q.Get("method")},
+		}
+		if animated := q.Get("animated"); animated != "" {
+			params.Set("animated", animated)
+		}
+		return mxc + "?" + params.Encode(), true
+	default:
+		return "", false
+	}
+}
+
+// Transport wraps the given RoundTripper so that successful GET requests of
+// Matrix media are served from the cache. If rt is nil, then
+// http.DefaultTransport is used.
+func (c *Cache) Transport(rt http.RoundTripper) http.RoundTripper {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	return transport{c, rt}
+}
+
+// Client returns a new HTTP client that uses the cache.
+func (c *Cache) Client() *http.Client {
+	return &http.Client{Transport: c.Transport(nil)}
+}
+
+type transport struct {
+	c  *Cache
+	rt http.RoundTripper
+}
+
+func (t transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.Method != http.MethodGet || r.Header.Get("Range") != "" {
+		return t.rt.RoundTrip(r)
+	}
+
+	key, ok := MediaKey(r.URL)
+	if !ok {
+		return t.rt.RoundTrip(r)
+	}
+
+	if f, ok := t.c.Open(key); ok {
+		return cachedResponse(r, f), nil
+	}
+
+	resp, err := t.rt.RoundTrip(r)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return resp, err
+	}
+
+	t.c.mut.Lock()
+	disabled := t.c.maxSize <= 0
+	t.c.mut.Unlock()
+
+	if disabled {
+		return resp, nil
+	}
+
+	w, err := t.c.Writer(key)
+	if err != nil {
+		log.Println("media cache error:", err)
+		return resp, nil
+	}
+
+	resp.Body = &teeBody{body: resp.Body, w: w}
+	return resp, nil
+}
+
+func cachedResponse(r *http.Request, f *os.File) *http.Response {
+	resp := &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        make(http.Header),
+		ContentLength: -1,
+		Request:       r,
+	}
+
+	if info, err := f.Stat(); err == nil {
+		resp.ContentLength = info.Size()
+		resp.Header.Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+	}
+
+	// Sniff the content type, since it isn't stored.
+	buf := bufio.NewReader(f)
+	if head, _ := buf.Peek(512); len(head) > 0 {
+		resp.Header.Set("Content-Type", http.DetectContentType(head))
+	}
+
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{buf, f}
+
+	return resp
+}
+
+// teeBody writes everything read from the body into the cache. The file is
+// only committed if the whole body is read.
+type teeBody struct {
+	body io.ReadCloser
+	w    *Writer
+}
+
+func (b *teeBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+
+	if b.w != nil && n > 0 {
+		if _, werr := b.w.Write(p[:n]); werr != nil {
+			log.Println("media cache error:", werr)
+			b.w.Discard()
+			b.w = nil
+		}
+	}
+
+	if err == io.EOF && b.w != nil {
+		if cerr := b.w.Commit(); cerr != nil {
+			log.Println("media cache error:", cerr)
+		}
+		b.w = nil
+	}
+
+	return n, err
+}
+
+func (b *teeBody) Close() error {
+	if b.w != nil {
+		// The body isn't fully read, so the file is incomplete.
+		b.w.Discard()
+		b.w = nil
+	}
+	return b.body.Close()
+}
@@ -236,3 +236,17 @@ func (s *EncryptedFile) Get(key string) ([]byte, error) {
 
 	return value, nil
 }
+
+// Delete deletes the key. The passphrase isn't needed for this.
+func (s *EncryptedFile) Delete(key string) error {
+	file := base64.RawStdEncoding.EncodeToString([]byte(key))
+
+	if err := os.Remove(filepath.Join(s.path, file)); err != nil {
+		if os.IsNotExist(err) {
+			return ErrNotFound
+		}
+		return errors.Wrap(err, "failed to delete key")
+	}
+
+	return nil
+}
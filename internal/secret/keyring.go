@@ -26,6 +26,17 @@ func (k *Keyring) Set(key string, value []byte) error {
 	return keyring.Set(k.id, key, string(value))
 }
 
+// Delete deletes the key.
+func (k *Keyring) Delete(key string) error {
+	if err := keyring.Delete(k.id, key); err != nil {
+		if errors.Is(err, keyring.ErrNotFound) {
+			return ErrNotFound
+		}
+		return err
+	}
+	return nil
+}
+
 // Get gets the key.
 func (k *Keyring) Get(key string) ([]byte, error) {
 	v, err := keyring.Get(k.id, key)
@@ -13,6 +13,8 @@ var ErrNotFound = errors.New("key not found")
 type Driver interface {
 	Get(string) ([]byte, error)
 	Set(string, []byte) error
+	// Delete deletes the key. ErrNotFound is returned if there's no such key.
+	Delete(string) error
 }
 
 // Service wraps multiple drivers to provide fallbacks.
@@ -68,3 +70,26 @@ func (s Service) Set(k string, v []byte) error {
 
 	return firstErr
 }
+
+// Delete deletes the given key from all drivers. ErrNotFound is only returned
+// if none of the drivers have the key.
+func (s Service) Delete(k string) error {
+	var firstErr error
+	var deleted bool
+
+	for _, driver := range s.drivers {
+		if err := driver.Delete(k); err != nil {
+			if firstErr == nil && !errors.Is(err, ErrNotFound) {
+				firstErr = err
+			}
+			continue
+		}
+		deleted = true
+	}
+
+	if firstErr == nil && !deleted {
+		return ErrNotFound
+	}
+
+	return firstErr
+}
@@ -15,11 +15,7 @@ import (
 	"github.com/diamondburned/gotkit/gtkutil"
 	"github.com/diamondburned/gotkit/gtkutil/cssutil"
 	"github.com/diamondburned/gotktrix/internal/app/about"
-	"github.com/diamondburned/gotktrix/internal/app/auth"
-	"github.com/diamondburned/gotktrix/internal/app/auth/syncbox"
-	"github.com/diamondburned/gotktrix/internal/app/blinker"
 	"github.com/diamondburned/gotktrix/internal/app/messageview/msgnotify"
-	"github.com/diamondburned/gotktrix/internal/gotktrix"
 	"github.com/diamondburned/gotrix/matrix"
 	"github.com/pkg/errors"
 	"golang.org/x/text/message"
@@ -133,14 +129,17 @@ var initialized bool
 // managers keeps track of all manager instances that are unique to each user.
 var managers = map[matrix.UserID]*manager{}
 
-// openRoom opens the room using the manager with the given user ID. If no user
-// ID is given or if the user ID is not found, then the command is dropped.
+// openRoom opens the room using the manager with the given user ID. The
+// manager's window is switched to that account. If no user ID is given or if
+// the user ID is not found, then the command is dropped.
 func openRoom(cmd msgnotify.OpenRoomCommand) {
 	manager, ok := managers[cmd.UserID]
 	if !ok {
 		log.Println("user ID", cmd.UserID, "not found")
 		return
 	}
+	manager.win.switchTo(manager)
+	manager.win.Present()
 	manager.OpenRoom(cmd.RoomID)
 }
 
@@ -151,6 +150,7 @@ func activate(ctx context.Context) {
 		initialized = true
 
 		adaptive.Init()
+		openMediaCache(ctx)
 
 		// Load saved preferences.
 		gtkutil.Async(ctx, func() func() {
@@ -172,6 +172,8 @@ func activate(ctx context.Context) {
 			"app.about":       func() { about.Show(ctx) },
 			"app.logs":        func() { logui.ShowDefaultViewer(ctx) },
 			"app.quit":        func() { a.Quit() },
+
+			"app.clear-media-cache": func() { clearMediaCache(ctx) },
 		})
 
 		a.AddActionCallbacks(map[string]gtkutil.ActionCallback{
@@ -179,29 +181,8 @@ func activate(ctx context.Context) {
 		})
 	}
 
-	w := a.NewWindow()
-	w.SetDefaultSize(700, 600)
-	w.SetTitle("gotktrix")
-
-	ctx = app.WithWindow(ctx, w)
-
-	authAssistant := auth.Show(ctx)
-	authAssistant.OnConnect(func(client *gotktrix.Client, acc *auth.Account) {
-		ctx := gotktrix.WithClient(ctx, client)
-		client.Interceptor.AddIntercept(interceptHTTPLog)
-
-		// Making the blinker right here. We don't want to miss the first sync
-		// once the screen becomes visible.
-		m := manager{ctx: ctx}
-		m.header.blinker = blinker.New(ctx)
-
-		managers[client.UserID] = &m
-		w.ConnectDestroy(func() { delete(managers, client.UserID) })
-
-		// Open the sync loop.
-		w.SetLoading()
-		syncbox.OpenThen(ctx, acc, func() { m.ready() })
-	})
+	w := newWindow(ctx)
+	w.addAccount()
 }
 
 func interceptHTTPLog(r *http.Request, next func() error) error {
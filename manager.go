@@ -2,8 +2,10 @@ package main
 
 import (
 	"context"
+	"html"
 
 	"github.com/diamondburned/adaptive"
+	"github.com/diamondburned/gotk4/pkg/glib/v2"
 	"github.com/diamondburned/gotk4/pkg/gtk/v4"
 	"github.com/diamondburned/gotk4/pkg/pango"
 	"github.com/diamondburned/gotkit/app"
@@ -11,19 +13,24 @@ import (
 	"github.com/diamondburned/gotkit/app/prefs"
 	"github.com/diamondburned/gotkit/components/title"
 	"github.com/diamondburned/gotkit/gtkutil"
+	"github.com/diamondburned/gotktrix/internal/app/auth"
 	"github.com/diamondburned/gotktrix/internal/app/blinker"
 	"github.com/diamondburned/gotktrix/internal/app/emojiview"
 	"github.com/diamondburned/gotktrix/internal/app/messageview"
 	"github.com/diamondburned/gotktrix/internal/app/messageview/msgnotify"
+	"github.com/diamondburned/gotktrix/internal/app/roomdialog"
 	"github.com/diamondburned/gotktrix/internal/app/roomlist"
 	"github.com/diamondburned/gotktrix/internal/app/roomlist/room"
+	"github.com/diamondburned/gotktrix/internal/app/sessions"
 	"github.com/diamondburned/gotktrix/internal/app/userbutton"
+	"github.com/diamondburned/gotktrix/internal/app/userview"
 	"github.com/diamondburned/gotktrix/internal/gotktrix"
 	"github.com/diamondburned/gotrix/matrix"
 )
 
 type manager struct {
 	ctx context.Context
+	win *window
 
 	header struct {
 		*gtk.WindowHandle
@@ -32,6 +39,7 @@ type manager struct {
 		ltext *gtk.Label
 		right *gtk.Box
 		rtext *title.Subtitle
+		user  *userbutton.Toggle
 
 		blinker *blinker.Blinker
 	}
@@ -40,6 +48,12 @@ type manager struct {
 	roomList *roomlist.Browser
 	msgView  *messageview.View
 
+	// title is the window title to be restored when switching to this
+	// manager.
+	title string
+	// unread is the number of notifications in all rooms.
+	unread int
+
 	unbindLastRoom func()
 }
 
@@ -55,7 +69,6 @@ var foldWidth = prefs.NewInt(275, prefs.IntMeta{
 
 func (m *manager) ready() {
 	w := app.WindowFromContext(m.ctx)
-	w.SetTitle("")
 
 	m.roomList = roomlist.New(m.ctx, m)
 	m.roomList.SetVExpand(true)
@@ -75,8 +88,6 @@ func (m *manager) ready() {
 	m.fold.SetSideChild(m.roomList)
 	m.fold.SetChild(m.msgView)
 
-	w.SetChild(m.fold)
-
 	userID := gotktrix.FromContext(m.ctx).UserID
 	username, _, _ := userID.Parse()
 
@@ -103,32 +114,43 @@ func (m *manager) ready() {
 		roomSearchBar.SetSearchMode(roomSearch.Active())
 	})
 
-	user := userbutton.NewToggle(m.ctx)
-	user.SetTooltipText(locale.S(m.ctx, "Menu"))
-	user.SetVAlign(gtk.AlignCenter)
-	user.SetPopoverFunc(func(popover *gtk.PopoverMenu) {
+	m.header.user = userbutton.NewToggle(m.ctx)
+	m.header.user.SetTooltipText(locale.S(m.ctx, "Menu"))
+	m.header.user.SetVAlign(gtk.AlignCenter)
+	m.header.user.SetPopoverFunc(func(popover *gtk.PopoverMenu) {
 		popover.SetParent(m.header.left)
 		popover.SetPosition(gtk.PosBottom)
 		popover.SetHasArrow(false)
 		popover.SetSizeRequest(m.header.left.AllocatedWidth()-20, -1)
 	})
-	user.SetMenuFunc(func() []gtkutil.PopoverMenuItem {
-		return []gtkutil.PopoverMenuItem{
+	m.header.user.SetMenuFunc(func() []gtkutil.PopoverMenuItem {
+		items := []gtkutil.PopoverMenuItem{
 			gtkutil.MenuSeparator(locale.S(m.ctx, "Me")),
-			gtkutil.MenuItem(locale.S(m.ctx, "Custom _Emojis"), "win.user-emojis"),
+			gtkutil.MenuItem(locale.S(m.ctx, "Custom _Emojis"), "account.user-emojis"),
+			gtkutil.MenuItem(locale.S(m.ctx, "_Ignored Users"), "account.ignored-users"),
+			gtkutil.MenuItem(locale.S(m.ctx, "_Sessions"), "account.sessions"),
+			gtkutil.MenuItem(locale.S(m.ctx, "_Log Out"), "account.logout"),
+		}
+		items = append(items, m.win.accountMenu(m)...)
+		return append(items,
+			gtkutil.MenuSeparator(locale.S(m.ctx, "Rooms")),
+			gtkutil.MenuItem(locale.S(m.ctx, "_New Room"), "account.new-room"),
+			gtkutil.MenuItem(locale.S(m.ctx, "_Join Room"), "account.join-room"),
+			gtkutil.MenuItem(locale.S(m.ctx, "Room _Directory"), "account.room-directory"),
 			gtkutil.MenuSeparator(""),
 			gtkutil.MenuItem(locale.S(m.ctx, "_Preferences"), "app.preferences"),
 			gtkutil.MenuItem(locale.S(m.ctx, "_About"), "app.about"),
 			gtkutil.MenuItem(locale.S(m.ctx, "_Logs"), "app.logs"),
+			gtkutil.MenuItem(locale.S(m.ctx, "_Clear Media Cache"), "app.clear-media-cache"),
 			gtkutil.MenuItem(locale.S(m.ctx, "_Quit"), "app.quit"),
-		}
+		)
 	})
 
 	m.header.left = gtk.NewBox(gtk.OrientationHorizontal, 0)
 	m.header.left.AddCSSClass("left-header")
 	m.header.left.AddCSSClass("titlebar")
 	m.header.left.Append(gtk.NewWindowControls(gtk.PackStart))
-	m.header.left.Append(user)
+	m.header.left.Append(m.header.user)
 	m.header.left.Append(m.header.ltext)
 	m.header.left.Append(roomSearch)
 
@@ -141,11 +163,23 @@ func (m *manager) ready() {
 	m.header.rtext.SetXAlign(0)
 	m.header.rtext.SetHExpand(true)
 
+	msgSearch := gtk.NewButtonFromIconName("system-search-symbolic")
+	msgSearch.SetTooltipText(locale.S(m.ctx, "Search Messages"))
+	msgSearch.SetVAlign(gtk.AlignCenter)
+	msgSearch.SetActionName("account.search-messages")
+
+	members := gtk.NewButtonFromIconName("system-users-symbolic")
+	members.SetTooltipText(locale.S(m.ctx, "Members"))
+	members.SetVAlign(gtk.AlignCenter)
+	members.SetActionName("account.toggle-members")
+
 	m.header.right = gtk.NewBox(gtk.OrientationHorizontal, 0)
 	m.header.right.AddCSSClass("right-header")
 	m.header.right.AddCSSClass("titlebar")
 	m.header.right.Append(unfold)
 	m.header.right.Append(m.header.rtext)
+	m.header.right.Append(msgSearch)
+	m.header.right.Append(members)
 	m.header.right.Append(m.header.blinker)
 	m.header.right.Append(gtk.NewWindowControls(gtk.PackEnd))
 
@@ -168,18 +202,83 @@ func (m *manager) ready() {
 	unfold.ConnectFold(m.header.fold)
 	adaptive.BindFolds(m.fold, m.header.fold)
 
-	m.header.WindowHandle = w.NewWindowHandle()
+	m.header.WindowHandle = gtk.NewWindowHandle()
 	m.header.SetChild(m.header.fold)
 
-	gtkutil.BindActionMap(w, map[string]func(){
-		"win.user-emojis": func() { emojiview.ForUser(m.ctx) },
-	})
+	// The actions are bound to the header and the content instead of the
+	// window, since the window may be holding several accounts.
+	actions := map[string]func(){
+		"account.user-emojis":    func() { emojiview.ForUser(m.ctx) },
+		"account.ignored-users":  func() { userview.ShowIgnored(m.ctx) },
+		"account.sessions":       func() { sessions.Show(m.ctx) },
+		"account.logout":         m.askLogout,
+		"account.new-room":       func() { roomdialog.ShowCreate(m.ctx, m.OpenJoinedRoom) },
+		"account.join-room":      func() { roomdialog.ShowJoin(m.ctx, m.OpenJoinedRoom) },
+		"account.room-directory": func() { roomdialog.ShowDirectory(m.ctx, m.OpenJoinedRoom) },
+		"account.search-messages": func() {
+			if current := m.msgView.Current(); current != nil {
+				current.ShowSearch()
+			}
+		},
+		"account.toggle-members": func() {
+			if current := m.msgView.Current(); current != nil {
+				current.ToggleMembers()
+			}
+		},
+	}
+	gtkutil.BindActionMap(m.header, actions)
+	gtkutil.BindActionMap(m.fold, actions)
 
 	gtkutil.BindSubscribe(w, func() func() {
 		return msgnotify.StartNotify(m.ctx, "app.open-room")
 	})
 }
 
+// askLogout asks the user before logging out of the account.
+func (m *manager) askLogout() {
+	userID := gotktrix.FromContext(m.ctx).UserID
+
+	dialog := gtk.NewMessageDialog(
+		app.GTKWindowFromContext(m.ctx),
+		gtk.DialogModal|gtk.DialogDestroyWithParent,
+		gtk.MessageQuestion,
+		gtk.ButtonsNone,
+	)
+	dialog.SetMarkup(locale.Sprintf(m.ctx, "Log out of %s?", html.EscapeString(string(userID))))
+	dialog.SetObjectProperty("secondary-text", locale.S(m.ctx,
+		"This session and everything stored locally for it will be deleted."))
+	dialog.AddButton(locale.S(m.ctx, "Cancel"), int(gtk.ResponseCancel))
+	dialog.AddButton(locale.S(m.ctx, "Log Out"), int(gtk.ResponseAccept))
+	dialog.ConnectResponse(func(id int) {
+		dialog.Close()
+		if id == int(gtk.ResponseAccept) {
+			m.logout()
+		}
+	})
+	dialog.Show()
+}
+
+// logout removes the account from the window, then logs out and forgets it.
+func (m *manager) logout() {
+	client := gotktrix.FromContext(m.ctx)
+	ctx := m.win.ctx
+
+	m.win.removeManager(m)
+
+	go func() {
+		var errs []error
+		if err := client.Logout(); err != nil {
+			errs = append(errs, err)
+		}
+		if err := auth.ForgetAccount(ctx, client.UserID); err != nil {
+			errs = append(errs, err)
+		}
+		if len(errs) > 0 {
+			glib.IdleAdd(func() { app.Error(ctx, errs...) })
+		}
+	}()
+}
+
 func (m *manager) SearchRoom(name string) {
 	m.roomList.Search(name)
 }
@@ -200,7 +299,10 @@ func (m *manager) OpenRoom(id matrix.RoomID) {
 	// revealed.
 	m.unbindLastRoom = gtkutil.FuncBatcher(
 		rm.NotifyName(func(_ context.Context, state room.State) {
-			app.SetTitle(m.ctx, state.Name)
+			m.title = state.Name
+			if m.win.active == m {
+				m.win.SetTitle(state.Name)
+			}
 			m.header.rtext.SetTitle(state.Name)
 		}),
 		rm.NotifyTopic(func(_ context.Context, state room.State) {
@@ -209,6 +311,13 @@ func (m *manager) OpenRoom(id matrix.RoomID) {
 	)
 }
 
+// OpenJoinedRoom opens the room that the user has just joined. The room is added
+// into the room list first, since it might not have been synced yet.
+func (m *manager) OpenJoinedRoom(id matrix.RoomID) {
+	m.roomList.AddRoom(id)
+	m.OpenRoom(id)
+}
+
 // SetSelectedRoom sets the given room ID as the selected room row. It does not
 // activate the room. It exists solely as a callback for tabs.
 func (m *manager) SetSelectedRoom(id matrix.RoomID) {
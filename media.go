@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+
+	"github.com/diamondburned/gotk4/pkg/core/glib"
+	"github.com/diamondburned/gotkit/app"
+	"github.com/diamondburned/gotkit/app/prefs"
+	"github.com/diamondburned/gotkit/gtkutil/httputil"
+	"github.com/diamondburned/gotktrix/internal/mediacache"
+	"github.com/pkg/errors"
+)
+
+var mediaCacheSize = prefs.NewInt(512, prefs.IntMeta{
+	Name:    "Media Cache Size",
+	Section: "Application",
+	Description: "The maximum size of the cache of avatars, emojis, images" +
+		" and downloaded files in megabytes. 0 disables the cache.",
+	Min: 0,
+	Max: 1 << 20,
+})
+
+// mediaClient is the HTTP client that fetches all media through the media
+// cache. It is nil if the cache can't be opened.
+var mediaClient *http.Client
+
+// mediaCache is the media cache shared by all accounts.
+var mediaCache *mediacache.Cache
+
+// openMediaCache opens the media cache. It must be called once.
+func openMediaCache(ctx context.Context) {
+	a := app.FromContext(ctx)
+
+	cache, err := mediacache.New(a.CachePath("media"), mediaCacheBytes())
+	if err != nil {
+		a.Error(errors.Wrap(err, "cannot open media cache"))
+		return
+	}
+
+	mediaCache = cache
+	mediaClient = cache.Client()
+
+	mediaCacheSize.Subscribe(func() { cache.SetMaxSize(mediaCacheBytes()) })
+}
+
+func mediaCacheBytes() int64 {
+	return int64(mediaCacheSize.Value()) << 20
+}
+
+// withMediaCache makes all media fetched using the returned context go through
+// the media cache.
+func withMediaCache(ctx context.Context) context.Context {
+	if mediaClient == nil {
+		return ctx
+	}
+	return httputil.WithClient(ctx, mediaClient)
+}
+
+// clearMediaCache deletes all cached media, including the images that are
+// cached by imgutil.
+func clearMediaCache(ctx context.Context) {
+	a := app.FromContext(ctx)
+
+	go func() {
+		if mediaCache != nil {
+			if err := mediaCache.Clear(); err != nil {
+				glib.IdleAdd(func() { a.Error(errors.Wrap(err, "cannot clear media cache")) })
+			}
+		}
+
+		if err := os.RemoveAll(a.CachePath("img2")); err != nil {
+			glib.IdleAdd(func() { a.Error(errors.Wrap(err, "cannot clear image cache")) })
+		}
+	}()
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"log"
+
+	"github.com/diamondburned/gotkit/app/prefs"
+	"github.com/diamondburned/gotktrix/internal/gotktrix"
+)
+
+var timelineRetention = prefs.NewString(gotktrix.DefaultRetention.String(), prefs.StringMeta{
+	Name:    "Message History",
+	Section: "Rooms",
+	Description: "How much message history of each room is kept for offline use:" +
+		" a number of messages such as 1000, a number of days such as 30d," +
+		" or unlimited.",
+	Validate: func(s string) error {
+		_, err := gotktrix.ParseRetention(s)
+		return err
+	},
+})
+
+var timelineRetentionOverrides = prefs.NewString("", prefs.StringMeta{
+	Name:    "Message History Overrides",
+	Section: "Rooms",
+	Description: "The message history to keep for specific rooms or room tags," +
+		" one per line, such as <tt>m.favourite = unlimited</tt> or" +
+		" <tt>!room:example.com = 30d</tt>.",
+	Multiline: true,
+	Validate: func(s string) error {
+		_, err := gotktrix.ParseRetentionOverrides(s)
+		return err
+	},
+})
+
+// bindRetention keeps the client's timeline retention policy in sync with the
+// preferences until the returned function is called.
+func bindRetention(client *gotktrix.Client) (unbind func()) {
+	update := func() {
+		policy := gotktrix.RetentionPolicy{Default: gotktrix.DefaultRetention}
+
+		if r, err := gotktrix.ParseRetention(timelineRetention.Value()); err == nil {
+			policy.Default = r
+		} else {
+			log.Println("invalid message history preference:", err)
+		}
+
+		if overrides, err := gotktrix.ParseRetentionOverrides(timelineRetentionOverrides.Value()); err == nil {
+			policy.Overrides = overrides
+		} else {
+			log.Println("invalid message history overrides preference:", err)
+		}
+
+		client.SetRetention(policy)
+	}
+
+	unsub1 := timelineRetention.Subscribe(update)
+	unsub2 := timelineRetentionOverrides.Subscribe(update)
+
+	return func() {
+		unsub1()
+		unsub2()
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"github.com/diamondburned/gotkit/app/prefs"
+	"github.com/diamondburned/gotktrix/internal/gotktrix"
+)
+
+var syncProfiles = map[string]gotktrix.SyncProfile{
+	"Full":          gotktrix.FullSync,
+	"Low Bandwidth": gotktrix.LowBandwidthSync,
+}
+
+var syncProfile = prefs.NewEnumList("Full", prefs.EnumListMeta{
+	PropMeta: prefs.PropMeta{
+		Name:    "Sync Profile",
+		Section: "Rooms",
+		Description: "How much is synced from the homeserver. Low Bandwidth" +
+			" skips presence and typing, and fetches fewer messages of each" +
+			" room, which makes the first sync of large accounts faster." +
+			" Takes effect the next time the account is opened.",
+	},
+	Options: []string{"Full", "Low Bandwidth"},
+})
+
+var slidingSync = prefs.NewBool(false, prefs.PropMeta{
+	Name:    "Sliding Sync",
+	Section: "Rooms",
+	Description: "Sync using sliding sync (MSC3575), which loads the latest" +
+		" rooms first. The homeserver, or a proxy in front of it, must" +
+		" support it. Takes effect the next time the account is opened.",
+})
+
+// bindSyncProfile keeps the client's sync profile and whether it uses sliding
+// sync in sync with the preferences until the returned function is called.
+func bindSyncProfile(client *gotktrix.Client) (unbind func()) {
+	unsub1 := syncProfile.Subscribe(func() {
+		client.SetSyncProfile(syncProfiles[syncProfile.Value()])
+	})
+	unsub2 := slidingSync.Subscribe(func() {
+		client.SetSlidingSync(slidingSync.Value())
+	})
+
+	return func() {
+		unsub1()
+		unsub2()
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/diamondburned/gotk4/pkg/glib/v2"
+	"github.com/diamondburned/gotk4/pkg/gtk/v4"
+	"github.com/diamondburned/gotkit/app"
+	"github.com/diamondburned/gotkit/app/locale"
+	"github.com/diamondburned/gotkit/gtkutil"
+	"github.com/diamondburned/gotktrix/internal/app/auth"
+	"github.com/diamondburned/gotktrix/internal/app/auth/syncbox"
+	"github.com/diamondburned/gotktrix/internal/app/blinker"
+	"github.com/diamondburned/gotktrix/internal/gotktrix"
+	"github.com/diamondburned/gotrix/api"
+	"github.com/diamondburned/gotrix/matrix"
+)
+
+// window is a main window that holds the managers of one or more accounts.
+// Only one account is shown at a time, but all of them keep syncing in the
+// background.
+type window struct {
+	*app.Window
+	ctx context.Context
+
+	header  *gtk.Stack
+	content *gtk.Stack
+
+	managers []*manager
+	active   *manager
+}
+
+func newWindow(ctx context.Context) *window {
+	w := window{Window: app.FromContext(ctx).NewWindow()}
+	w.SetDefaultSize(700, 600)
+	w.SetTitle("gotktrix")
+
+	w.ctx = app.WithWindow(ctx, w.Window)
+
+	w.header = gtk.NewStack()
+	w.header.SetHhomogeneous(false)
+
+	w.content = gtk.NewStack()
+	w.content.SetTransitionType(gtk.StackTransitionTypeCrossfade)
+
+	gtkutil.BindActionMap(w.Window, map[string]func(){
+		"win.add-account": w.addAccount,
+	})
+	gtkutil.BindActionCallbackMap(w.Window, map[string]gtkutil.ActionCallback{
+		"win.switch-account": {
+			ArgType: glib.NewVariantType("s"),
+			Func: func(v *glib.Variant) {
+				if m, ok := managers[matrix.UserID(v.String())]; ok && m.win == &w {
+					w.switchTo(m)
+				}
+			},
+		},
+	})
+
+	return &w
+}
+
+// switchAccountAction returns the detailed action name that switches the window
+// to the given account.
+func switchAccountAction(userID matrix.UserID) string {
+	return fmt.Sprintf("win.switch-account(%q)", string(userID))
+}
+
+// addAccount shows the authentication assistant. The assistant takes over the
+// window if it has no accounts yet.
+func (w *window) addAccount() {
+	var assistant *auth.Assistant
+	if w.content.Parent() == nil {
+		assistant = auth.Show(w.ctx)
+	} else {
+		assistant = auth.ShowDialog(w.ctx)
+	}
+
+	assistant.DisableOpenAccounts(func(userID matrix.UserID) bool {
+		_, ok := managers[userID]
+		return ok
+	})
+	assistant.OnConnect(w.connect)
+}
+
+// connect adds the given client into the window and starts syncing it.
+func (w *window) connect(client *gotktrix.Client, acc *auth.Account) {
+	if _, ok := managers[client.UserID]; ok {
+		// This shouldn't happen, since the account's database is already in
+		// use, but we don't want to override the existing manager.
+		log.Println("account", client.UserID, "is already open")
+		return
+	}
+
+	ctx := gotktrix.WithClient(w.ctx, client)
+	ctx = withMediaCache(ctx)
+	client.Interceptor.AddIntercept(interceptHTTPLog)
+
+	// Making the blinker right here. We don't want to miss the first sync
+	// once the screen becomes visible.
+	m := &manager{ctx: ctx, win: w}
+	m.header.blinker = blinker.New(ctx)
+
+	managers[client.UserID] = m
+
+	unbindRetention := bindRetention(client)
+	unbindSyncProfile := bindSyncProfile(client)
+	w.ConnectDestroy(func() {
+		delete(managers, client.UserID)
+		unbindRetention()
+		unbindSyncProfile()
+	})
+
+	// Keep the header and content stacks in the window, since the
+	// authentication assistant might have replaced them.
+	w.SetTitlebar(w.header)
+	w.SetChild(w.content)
+
+	loadingHeader := gtk.NewHeaderBar()
+	w.header.AddChild(loadingHeader)
+	w.header.SetVisibleChild(loadingHeader)
+
+	var loading *syncbox.Popup
+
+	attach := func(popup *syncbox.Popup) {
+		loading = popup
+		w.content.AddChild(popup)
+		w.content.SetVisibleChild(popup)
+	}
+
+	// Open the sync loop.
+	syncbox.OpenInThen(ctx, acc, attach, func() {
+		m.ready()
+		w.addManager(m)
+
+		w.header.Remove(loadingHeader)
+		if loading != nil {
+			w.content.Remove(loading)
+		}
+	})
+}
+
+// addManager adds the ready manager into the window and switches to it.
+func (w *window) addManager(m *manager) {
+	w.managers = append(w.managers, m)
+	w.header.AddChild(m.header)
+	w.content.AddChild(m.fold)
+	w.switchTo(m)
+
+	client := gotktrix.FromContext(m.ctx)
+	gtkutil.BindSubscribe(w.Window, func() func() {
+		return client.OnSync(func(*api.SyncResponse) {
+			count := client.NotificationCount()
+			glib.IdleAdd(func() {
+				m.unread = count.Notification
+				w.updateBadges()
+			})
+		})
+	})
+
+	m.unread = client.Offline().NotificationCount().Notification
+	w.updateBadges()
+}
+
+// removeManager removes the given manager from the window. The window shows
+// the authentication assistant if there are no accounts left.
+func (w *window) removeManager(m *manager) {
+	for i, other := range w.managers {
+		if other == m {
+			w.managers = append(w.managers[:i], w.managers[i+1:]...)
+			break
+		}
+	}
+
+	delete(managers, gotktrix.FromContext(m.ctx).UserID)
+
+	w.header.Remove(m.header)
+	w.content.Remove(m.fold)
+
+	if len(w.managers) == 0 {
+		w.active = nil
+		w.SetTitle("gotktrix")
+		w.addAccount()
+		return
+	}
+
+	if w.active == m {
+		w.switchTo(w.managers[0])
+	}
+
+	w.updateBadges()
+}
+
+// switchTo shows the given manager in the window.
+func (w *window) switchTo(m *manager) {
+	w.active = m
+	w.header.SetVisibleChild(m.header)
+	w.content.SetVisibleChild(m.fold)
+	w.SetTitle(m.title)
+}
+
+// updateBadges updates the unread badge of each account. An account's badge
+// shows the notifications of all the other accounts in the window, since its
+// own are already visible in the room list.
+func (w *window) updateBadges() {
+	var total int
+	for _, m := range w.managers {
+		total += m.unread
+	}
+
+	for _, m := range w.managers {
+		m.header.user.SetBadge(total - m.unread)
+	}
+}
+
+// accountMenu returns the menu items for switching to the other accounts in the
+// window and for adding a new one.
+func (w *window) accountMenu(current *manager) []gtkutil.PopoverMenuItem {
+	items := []gtkutil.PopoverMenuItem{
+		gtkutil.MenuSeparator(locale.S(w.ctx, "Accounts")),
+	}
+
+	for _, m := range w.managers {
+		if m == current {
+			continue
+		}
+
+		userID := gotktrix.FromContext(m.ctx).UserID
+
+		// Escape the underscores so they're not taken as mnemonics.
+		label := strings.ReplaceAll(string(userID), "_", "__")
+		if m.unread > 0 {
+			label = fmt.Sprintf("%s (%d)", label, m.unread)
+		}
+
+		items = append(items, gtkutil.MenuItem(label, switchAccountAction(userID)))
+	}
+
+	return append(items,
+		gtkutil.MenuItem(locale.S(w.ctx, "_Add Account..."), "win.add-account"),
+	)
+}